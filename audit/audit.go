@@ -0,0 +1,242 @@
+/*
+Package audit is an append-only log of administrative and security actions,
+such as role changes, disabled and deleted users, password resets, logins
+and published posts.
+
+Every entry holds the hash of the entry before it, so a change or a removal
+of an entry at the storage breaks the chain, and is found by Verify.
+*/
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/ik5/go-into/pagination"
+)
+
+// Actions that are recorded
+const (
+	ActionUserCreate     = "user.create"
+	ActionRolesChange    = "user.roles"
+	ActionUserEnable     = "user.enable"
+	ActionUserDisable    = "user.disable"
+	ActionUserDelete     = "user.delete"
+	ActionUserRestore    = "user.restore"
+	ActionPasswordReset  = "user.password_reset"
+	ActionPasswordChange = "user.password_change"
+	ActionLoginSuccess   = "auth.login"
+	ActionLoginFailure   = "auth.login_failed"
+	ActionPostPublish    = "post.publish"
+	ActionPostDelete     = "post.delete"
+)
+
+// Types of the targets of the actions
+const (
+	TargetUser = "user"
+	TargetPost = "post"
+)
+
+const entryColumns = `id, created_at, actor_id, actor, action, target_type, target_id,
+	diff, ip, request_id, prev_hash, hash`
+
+// entriesKeyset is the order of a list of entries, latest first
+var entriesKeyset = pagination.Keyset{KeyColumn: "created_at", IDColumn: "id", Descending: true}
+
+// Entry is a row of the audit log
+type Entry struct {
+	ID        uint64    `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// ActorID is the user that acted, 0 for anonymous and system actions
+	ActorID uint64 `json:"actor_id" db:"actor_id"`
+	// Actor is the username of the actor, or the username that was tried
+	// by a failed login
+	Actor      string `json:"actor" db:"actor"`
+	Action     string `json:"action" db:"action"`
+	TargetType string `json:"target_type" db:"target_type"`
+	TargetID   string `json:"target_id" db:"target_id"`
+	// Diff holds the fields that the action changed, see Diff
+	Diff      json.RawMessage `json:"diff" db:"diff"`
+	IP        string          `json:"ip" db:"ip"`
+	RequestID string          `json:"request_id" db:"request_id"`
+	PrevHash  string          `json:"prev_hash" db:"prev_hash"`
+	Hash      string          `json:"hash" db:"hash"`
+}
+
+// Filter narrows down a list of entries. Zero values are ignored.
+type Filter struct {
+	ActorID    uint64
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	// Cursor lists the entries after a position, see Entry.Cursor
+	Cursor *pagination.Cursor
+}
+
+// Log is the storage of the audit entries
+type Log interface {
+	// Record appends entry, and fills its id, time and hashes. The IP and
+	// request id are taken from the Source of ctx when they are empty.
+	Record(ctx context.Context, entry *Entry) error
+	// List returns the entries of filter, latest first
+	List(ctx context.Context, filter Filter) ([]Entry, error)
+	// Verify walks the whole chain, and returns a *ChainError at the first
+	// entry that does not match it
+	Verify(ctx context.Context) error
+}
+
+// ChainError is returned by Verify for an entry that was tampered with, or
+// that follows entries that were removed
+type ChainError struct {
+	ID     uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit entry %d breaks the chain: %s", e.ID, e.Reason)
+}
+
+// Source is where a request came from
+type Source struct {
+	IP        string
+	RequestID string
+}
+
+type sourceContextKey struct{}
+
+// WithSource returns a copy of ctx that holds the source of the request
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceContextKey{}, source)
+}
+
+// SourceFrom returns the source of ctx, or an empty one
+func SourceFrom(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceContextKey{}).(Source)
+	return source
+}
+
+// Change is the value of a field before and after an action
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff returns the JSON of the fields that are different between the JSON
+// encodings of before and after, as a Change per field. Either of them may
+// be nil, for a target that was created or removed.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for name, value := range beforeFields {
+		if other, ok := afterFields[name]; !ok || !reflect.DeepEqual(value, other) {
+			changes[name] = Change{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			changes[name] = Change{After: value}
+		}
+	}
+	// the keys of a map are encoded sorted, so the diff is stable
+	return json.Marshal(changes)
+}
+
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// Cursor returns the position of the entry at a list of entries
+func (e Entry) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.TimeKey(e.CreatedAt), ID: e.ID}
+}
+
+// prepare fills the fields of a new entry, except for its hashes
+func (e *Entry) prepare(ctx context.Context, now time.Time) {
+	// the database keeps microseconds, the hash must match what is read back
+	e.CreatedAt = now.UTC().Truncate(time.Microsecond)
+
+	source := SourceFrom(ctx)
+	if e.IP == "" {
+		e.IP = source.IP
+	}
+	if e.RequestID == "" {
+		e.RequestID = source.RequestID
+	}
+	if len(e.Diff) == 0 {
+		e.Diff = json.RawMessage(`{}`)
+	}
+}
+
+// chain links the entry after the entry with the hash prev
+func (e *Entry) chain(prev string) {
+	e.PrevHash = prev
+	e.Hash = e.computeHash()
+}
+
+// computeHash returns the hash of the fields and the previous hash of the
+// entry, every field is prefixed with its length so fields cannot be
+// shifted into each other
+func (e Entry) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		strconv.FormatUint(e.ActorID, 10),
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		string(e.Diff),
+		e.IP,
+		e.RequestID,
+	} {
+		writeField(h, field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeField(h hash.Hash, field string) {
+	_, _ = fmt.Fprintf(h, "%d:%s", len(field), field)
+}
+
+// verifyChain checks entries in the order of the log, after the entry with
+// the hash prev, and returns the hash of the last one
+func verifyChain(prev string, entries []Entry) (string, error) {
+	for _, entry := range entries {
+		if entry.PrevHash != prev {
+			return prev, &ChainError{ID: entry.ID, Reason: "the previous entry is missing or was changed"}
+		}
+		if entry.computeHash() != entry.Hash {
+			return prev, &ChainError{ID: entry.ID, Reason: "the entry was changed"}
+		}
+		prev = entry.Hash
+	}
+	return prev, nil
+}
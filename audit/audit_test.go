@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+type user struct {
+	Name    string `json:"name"`
+	Roles   int    `json:"roles"`
+	Enabled bool   `json:"enabled"`
+}
+
+func TestDiff(t *testing.T) {
+	diff, err := Diff(user{"root", 1, true}, user{"root", 3, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"enabled":{"before":true,"after":false},"roles":{"before":1,"after":3}}`
+	if string(diff) != expected {
+		t.Errorf("Expected %s, got %s", expected, diff)
+	}
+
+	diff, err = Diff(nil, user{Name: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes map[string]Change
+	if err := json.Unmarshal(diff, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 || changes["name"].After != "new" || changes["name"].Before != nil {
+		t.Errorf("Expected all of the fields as created, got %s", diff)
+	}
+}
+
+func recordEntries(t *testing.T, log *MemoryLog, actions ...string) {
+	ctx := WithSource(context.Background(), Source{IP: "10.0.0.1", RequestID: "req"})
+	for _, action := range actions {
+		entry := Entry{ActorID: 1, Actor: "root", Action: action, TargetType: TargetUser, TargetID: "2"}
+		if err := log.Record(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryLogChain(t *testing.T) {
+	log := NewMemoryLog()
+	recordEntries(t, log, ActionRolesChange, ActionUserDisable, ActionUserDelete)
+
+	if err := log.Verify(context.Background()); err != nil {
+		t.Fatalf("Expected a valid chain, got %s", err)
+	}
+	if log.entries[0].IP != "10.0.0.1" || log.entries[0].RequestID != "req" {
+		t.Errorf("Expected the source of the context, got %+v", log.entries[0])
+	}
+	if log.entries[1].PrevHash != log.entries[0].Hash {
+		t.Errorf("Expected entry 2 to follow entry 1")
+	}
+
+	log.entries[1].Action = ActionUserEnable
+	err := log.Verify(context.Background())
+	if chainErr, ok := err.(*ChainError); !ok || chainErr.ID != 2 {
+		t.Errorf("Expected the chain to break at entry 2, got %v", err)
+	}
+
+	log.entries = append(log.entries[:1], log.entries[2:]...)
+	err = log.Verify(context.Background())
+	if chainErr, ok := err.(*ChainError); !ok || chainErr.ID != 3 {
+		t.Errorf("Expected the chain to break at entry 3, got %v", err)
+	}
+}
+
+func TestMemoryLogList(t *testing.T) {
+	log := NewMemoryLog()
+	recordEntries(t, log, ActionRolesChange, ActionLoginFailure, ActionRolesChange, ActionUserDelete)
+
+	list, err := log.List(context.Background(), Filter{Action: ActionRolesChange})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != 3 || list[1].ID != 1 {
+		t.Errorf("Expected entries 3 and 1, got %v", list)
+	}
+
+	list, err = log.List(context.Background(), Filter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := list[1].Cursor()
+	list, err = log.List(context.Background(), Filter{Limit: 2, Cursor: &cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != 2 || list[1].ID != 1 {
+		t.Errorf("Expected entries 2 and 1 after the cursor, got %v", list)
+	}
+}
+
+func TestPostgresLogRecordChains(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	log := NewPostgresLog(db.NewFromDB(sqlDB))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock($1)").WithArgs(chainLockKey)
+	mock.ExpectQuery("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").
+		WillReturnRows(dbtest.NewRows("hash").AddRow("previous"))
+	mock.ExpectQuery("INSERT INTO audit_log").
+		WillReturnRows(dbtest.NewRows("id").AddRow(8))
+	mock.ExpectCommit()
+
+	entry := Entry{ActorID: 1, Actor: "root", Action: ActionPasswordReset, TargetType: TargetUser, TargetID: "2"}
+	if err := log.Record(context.Background(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != 8 || entry.PrevHash != "previous" {
+		t.Errorf("Expected entry 8 after previous, got %d after %s", entry.ID, entry.PrevHash)
+	}
+	if entry.Hash == "" || entry.Hash != entry.computeHash() {
+		t.Errorf("Expected the hash of the entry, got %q", entry.Hash)
+	}
+}
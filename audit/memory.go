@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLog is a Log that is kept in memory, for tests and development
+type MemoryLog struct {
+	mtx     sync.Mutex
+	entries []Entry
+}
+
+// NewMemoryLog creates an empty MemoryLog
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+// Record implements Log
+func (log *MemoryLog) Record(ctx context.Context, entry *Entry) error {
+	defer log.mtx.Unlock()
+	log.mtx.Lock()
+
+	entry.prepare(ctx, time.Now())
+	prev := ""
+	if n := len(log.entries); n > 0 {
+		prev = log.entries[n-1].Hash
+	}
+	entry.ID = uint64(len(log.entries)) + 1
+	entry.chain(prev)
+
+	log.entries = append(log.entries, *entry)
+	return nil
+}
+
+// List implements Log
+func (log *MemoryLog) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	defer log.mtx.Unlock()
+	log.mtx.Lock()
+
+	var after time.Time
+	if filter.Cursor != nil {
+		var err error
+		if after, err = filter.Cursor.Time(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the entries are stored oldest first
+	list := make([]Entry, 0)
+	for i := len(log.entries) - 1; i >= 0; i-- {
+		entry := log.entries[i]
+		if !filter.matches(entry) {
+			continue
+		}
+		if filter.Cursor != nil &&
+			!entriesKeyset.Follows(*filter.Cursor, compareTime(entry.CreatedAt, after), entry.ID) {
+			continue
+		}
+		list = append(list, entry)
+	}
+
+	if filter.Limit > 0 && filter.Limit < len(list) {
+		// a backward cursor takes the entries right before it
+		if filter.Cursor != nil && filter.Cursor.Backward {
+			return list[len(list)-filter.Limit:], nil
+		}
+		return list[:filter.Limit], nil
+	}
+	return list, nil
+}
+
+// Verify implements Log
+func (log *MemoryLog) Verify(ctx context.Context) error {
+	defer log.mtx.Unlock()
+	log.mtx.Lock()
+
+	_, err := verifyChain("", log.entries)
+	return err
+}
+
+// matches returns true if entry passes the fields of the filter
+func (filter Filter) matches(entry Entry) bool {
+	switch {
+	case filter.ActorID != 0 && entry.ActorID != filter.ActorID,
+		filter.Action != "" && entry.Action != filter.Action,
+		filter.TargetType != "" && entry.TargetType != filter.TargetType,
+		filter.TargetID != "" && entry.TargetID != filter.TargetID,
+		!filter.Since.IsZero() && entry.CreatedAt.Before(filter.Since),
+		!filter.Until.IsZero() && !entry.CreatedAt.Before(filter.Until):
+		return false
+	}
+	return true
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/pagination"
+)
+
+// verifyBatch is the number of entries that Verify reads at a time
+const verifyBatch = 1000
+
+// chainLockKey serializes the writers of the chain
+var chainLockKey = db.LockKey("go-into/audit")
+
+// PostgresLog is a Log that is stored at the audit_log table
+type PostgresLog struct {
+	conn *db.Conn
+}
+
+// NewPostgresLog creates a Log over conn
+func NewPostgresLog(conn *db.Conn) *PostgresLog {
+	return &PostgresLog{conn: conn}
+}
+
+// Record implements Log
+func (log *PostgresLog) Record(ctx context.Context, entry *Entry) error {
+	ctx = db.WithQueryLabel(ctx, "audit.record")
+	entry.prepare(ctx, time.Now())
+
+	return log.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		// the lock is held until the commit, so the entries are chained one
+		// after the other
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, chainLockKey); err != nil {
+			return err
+		}
+
+		var prev string
+		err := tx.Get(ctx, &prev, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`)
+		if err != nil && !db.IsNoRows(err) {
+			return err
+		}
+		entry.chain(prev)
+
+		return tx.NamedGet(ctx, entry,
+			db.InsertQuery("audit_log", entry, "id")+` RETURNING id`,
+			entry,
+		)
+	})
+}
+
+// List implements Log
+func (log *PostgresLog) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	ctx = db.WithQueryLabel(ctx, "audit.list")
+
+	where := make([]string, 0, 6)
+	args := make([]interface{}, 0, 8)
+
+	if filter.ActorID != 0 {
+		args = append(args, filter.ActorID)
+		where = append(where, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		where = append(where, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if filter.TargetType != "" {
+		args = append(args, filter.TargetType)
+		where = append(where, fmt.Sprintf("target_type = $%d", len(args)))
+	}
+	if filter.TargetID != "" {
+		args = append(args, filter.TargetID)
+		where = append(where, fmt.Sprintf("target_id = $%d", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if filter.Cursor != nil {
+		clause, cursorArgs := entriesKeyset.Where(*filter.Cursor, len(args)+1)
+		args = append(args, cursorArgs...)
+		where = append(where, clause)
+	}
+
+	query := `SELECT ` + entryColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY ` + entriesKeyset.OrderBy(filter.Cursor)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	list := make([]Entry, 0)
+	if err := log.conn.Select(ctx, &list, query, args...); err != nil {
+		return list, err
+	}
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		pagination.Reverse(list)
+	}
+	return list, nil
+}
+
+// Verify implements Log, the entries are read from the primary in batches
+func (log *PostgresLog) Verify(ctx context.Context) error {
+	ctx = db.WithPrimary(db.WithQueryLabel(ctx, "audit.verify"))
+
+	var (
+		prev  string
+		after uint64
+	)
+	for {
+		entries := make([]Entry, 0, verifyBatch)
+		err := log.conn.Select(ctx, &entries,
+			`SELECT `+entryColumns+` FROM audit_log WHERE id > $1 ORDER BY id LIMIT $2`,
+			after, verifyBatch,
+		)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		if prev, err = verifyChain(prev, entries); err != nil {
+			return err
+		}
+		after = entries[len(entries)-1].ID
+	}
+}
@@ -1,60 +1,54 @@
 package main
 
 import (
-	"bytes"
-	"html/template"
 	"net/http"
 	"time"
-)
 
-const (
-	// Do not work with hardcoded templates unless it is a must!
-	indexPageTemplate = `<!doctype html>
-<html>
-	<head>
-		<meta charset="UTF-8">
-		<title>Welcome {{ .Guest }}</title>
-		<meta http-equiv="X-UA-Compatible" content="IE=edge,chrome=1">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-		<link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/foundation/6.5.3/css/foundation.min.css">
-	</head>
-	<body>
-		<div class="grid-x">
-			<div class="cell"><h1>Hello From Index</h1></div>
-			<div class="cell">{{ .CurrentTime }}</div>
-		</div>
-	</body>
-</html>`
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/templates"
 )
 
+// indexPostsLimit is the number of the latest posts at the index page
+const indexPostsLimit = 10
+
 type indexTemplateArgs struct {
 	Guest       string
-	CurrentTime string
+	CurrentTime time.Time
+	Posts       []models.Post
 }
 
-func indexPage(w http.ResponseWriter, r *http.Request) {
-	t := template.New("index")
-	currentTime := time.Now()
-	params := indexTemplateArgs{
-		Guest:       r.RemoteAddr,
-		CurrentTime: currentTime.Local().Format("02-01-2006 15:04:05 PM MST"),
+// newIndexPage returns the handler of the index page, that lists the latest
+// published posts
+func newIndexPage(pages *templates.Manager, posts models.PostRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		indexPage(w, r, pages, posts)
 	}
+}
 
-	t, err := t.Parse(indexPageTemplate)
+func indexPage(w http.ResponseWriter, r *http.Request, pages *templates.Manager, posts models.PostRepository) {
+	latest, err := posts.List(r.Context(), models.PostFilter{
+		Status: models.PostPublished,
+		Limit:  indexPostsLimit,
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
-	buff := make([]byte, 0)
-	out := bytes.NewBuffer(buff)
-	err = t.Execute(out, params)
-	if err != nil {
+
+	params := indexTemplateArgs{
+		Guest:       r.RemoteAddr,
+		CurrentTime: time.Now(),
+		Posts:       latest,
+	}
+	renderPage(w, pages, "index", params)
+}
+
+// renderPage writes a page, or the error of rendering it
+func renderPage(w http.ResponseWriter, pages *templates.Manager, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.Render(w, name, data); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(err.Error()))
-		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(out.Bytes())
 }
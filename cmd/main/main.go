@@ -1,17 +1,35 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/jobs"
+	"github.com/ik5/go-into/markup"
+	"github.com/ik5/go-into/metrics"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/outbox"
 	// Alias package name to be used with different name on import
 	restPackage "github.com/ik5/go-into/rest"
 	"github.com/ik5/go-into/signals"
+	"github.com/ik5/go-into/templates"
 )
 
-func handleSignals(quit chan<- bool) {
+// backgroundLock is the name of the leader election of the background jobs,
+// such as scheduled publishing, cleanup and digest emails
+const backgroundLock = "go-into/background"
+
+// handleSignals quits on the termination signals, and calls reload on SIGHUP
+func handleSignals(quit chan<- bool, reload func()) {
 	quitSigs := make(chan os.Signal, 1)
 	hupSig := make(chan os.Signal, 1)
 	infoSig := make(chan os.Signal, 1)
@@ -30,6 +48,7 @@ func handleSignals(quit chan<- bool) {
 		case <-hupSig:
 			// TODO: rotate logs
 			fmt.Println("Going to rotate logs... ")
+			reload()
 		case <-infoSig:
 			// TODO: print debug info...
 			fmt.Println("Debug information: ")
@@ -44,22 +63,170 @@ func handleSignals(quit chan<- bool) {
 	}
 }
 
-func initialize() {
-	// TODO: Add settings, initialize of logging systems etc...
+// newAdminServer creates the server of the operational endpoints, that is
+// kept apart from the public listener
+func newAdminServer(address string, registry *metrics.Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	return &http.Server{Addr: address, Handler: mux}
+}
+
+func initialize() settings {
+	// TODO: initialize of logging systems etc...
+	return loadSettings()
 }
 
 func main() {
-	initialize()
+	config := initialize()
+
+	// the pages and the posts API share the cache of rendered posts
+	postMarkup := markup.New(nil)
+	pageLog := log.New(os.Stderr, "templates: ", log.LstdFlags)
+	pages, err := loadTemplates(config, postMarkup, pageLog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load templates: %s\n", err)
+		os.Exit(1)
+	}
+
+	dbConfig, err := config.dbConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid database settings: %s\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.dbTimeout)
+	conn, err := db.Open(ctx, dbConfig)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	queryLog := log.New(os.Stderr, "db: ", log.LstdFlags)
+	if config.dbLogQueries {
+		conn.AddHook(db.NewLogHook(queryLog))
+	}
+	if config.dbSlowQuery > 0 {
+		conn.AddHook(db.NewSlowQueryHook(queryLog, config.dbSlowQuery))
+	}
+
+	if flag.Arg(0) == "migrate" {
+		err := runMigrate(context.Background(), conn, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			conn.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
-	rest := restPackage.InitREST("", uint16(3000))
-	rest.RegisterUserRoute("/", "GET", indexPage)
+	registry := metrics.NewRegistry()
+	db.RegisterMetrics(conn, registry)
+
+	// only the leader among the instances runs the background jobs
+	leaderLog := log.New(os.Stderr, "leader: ", log.LstdFlags)
+	elector := conn.Elect(backgroundLock, &db.ElectorOptions{
+		OnError: func(err error) { leaderLog.Printf("election error: %s", err) },
+	})
+	defer elector.Close()
+	go func() {
+		for leader := range elector.Changes() {
+			if leader {
+				leaderLog.Println("this instance runs the background jobs")
+				continue
+			}
+			leaderLog.Println("this instance stopped running the background jobs")
+		}
+	}()
+
+	jobLog := log.New(os.Stderr, "jobs: ", log.LstdFlags)
+	queue := jobs.NewQueue(conn, &jobs.Options{
+		Workers: config.jobWorkers,
+		OnError: func(job *jobs.Job, err error) {
+			if job != nil {
+				jobLog.Printf("job %d (%s) attempt %d failed: %s", job.ID, job.Kind, job.Attempts, err)
+				return
+			}
+			jobLog.Printf("queue error: %s", err)
+		},
+	})
+	// the events of the outbox are dispatched by a single instance at a
+	// time, to in-process subscribers or as jobs with relay.Forward
+	relay := outbox.NewRelay(conn, &outbox.RelayOptions{
+		OnError: func(err error) { jobLog.Printf("outbox error: %s", err) },
+	})
+
+	users := models.NewPostgresUserRepository(conn)
+	posts := models.NewPostgresPostRepository(conn)
+	comments := models.NewPostgresCommentRepository(conn)
+	auditLog := audit.NewPostgresLog(conn)
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		_ = queue.Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		_ = relay.Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		runScheduler(workersCtx, posts, auditLog, elector, config.publishInterval, jobLog)
+	}()
+	if config.dev {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			_ = pages.Watch(workersCtx, templates.DefaultWatchInterval)
+		}()
+	}
+	// the running jobs are waited for before the connection is closed
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+	}()
+
+	rest := restPackage.InitREST(config.address, uint16(config.port))
+	rest.SetMarkup(postMarkup, nil)
+	rest.RegisterUserRoute("/", "GET", newIndexPage(pages, posts))
 	rest.SetUserRouting()
+	if config.cursorSecret != "" {
+		rest.SetCursorSecret([]byte(config.cursorSecret))
+	}
+	rest.SetAuditLog(auditLog)
+	rest.RegisterAdminAuditRoutes(auditLog)
+	rest.RegisterAdminUserRoutes(users)
+	rest.RegisterPostRoutes(posts)
+	rest.RegisterCommentRoutes(comments, posts)
+	rest.SetAdminRouting(users.GetByUsername)
+	rest.SetPostRouting(users.GetByUsername)
+	rest.EnableMetrics(registry)
 	defer rest.Stop()
 
+	if config.metricsAddress != "" {
+		adminServer := newAdminServer(config.metricsAddress, registry)
+		defer adminServer.Close()
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Admin listener stopped: %s\n", err)
+			}
+		}()
+	}
+
 	quit := make(chan bool, 1)
 	defer close(quit)
 
-	go handleSignals(quit)
+	go handleSignals(quit, func() {
+		if err := pages.Reload(); err != nil {
+			pageLog.Printf("templates reload failed: %s", err)
+			return
+		}
+		pageLog.Println("templates were reloaded")
+	})
 
 	go rest.Serve()
 
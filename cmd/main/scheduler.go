@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/models"
+)
+
+// schedulerActor is the actor of the audit entries of the scheduler
+const schedulerActor = "scheduler"
+
+// runScheduler publishes the scheduled posts that are due every interval,
+// while this instance is the leader, until ctx is done. The publishes are
+// recorded at auditLog as system actions.
+func runScheduler(ctx context.Context, posts models.PostRepository, auditLog audit.Log,
+	elector *db.Elector, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	onPublish := func(before, after models.Post) {
+		diff, err := audit.Diff(before, after)
+		if err != nil {
+			diff, _ = json.Marshal(map[string]string{"error": err.Error()})
+		}
+		entry := audit.Entry{
+			Actor:      schedulerActor,
+			Action:     audit.ActionPostPublish,
+			TargetType: audit.TargetPost,
+			TargetID:   strconv.FormatUint(after.ID, 10),
+			Diff:       diff,
+		}
+		if err := auditLog.Record(ctx, &entry); err != nil {
+			logger.Printf("audit: unable to record %s of post %d: %s", entry.Action, after.ID, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !elector.IsLeader() {
+			continue
+		}
+
+		published, err := models.PublishDue(ctx, posts, time.Now(), onPublish)
+		if err != nil {
+			logger.Printf("scheduled publishing failed: %s", err)
+		}
+		if published > 0 {
+			logger.Printf("published %d scheduled posts", published)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/jobs"
+)
+
+// settings holds the configuration of the application
+type settings struct {
+	address string
+	port    uint
+	// metricsAddress is the admin listener of /metrics, empty disables it
+	metricsAddress string
+
+	// dbURL is a postgres:// URL of the database. When it is empty, the
+	// standard PG* environment variables are used. It defaults to the
+	// DATABASE_URL environment variable, so the password will not be visible
+	// at the process list.
+	dbURL string
+	// dbTimeout is how long to wait for the database at startup
+	dbTimeout time.Duration
+	dbMaxOpen int
+	dbMaxIdle int
+	// dbReplicas are comma separated postgres:// URLs of read replicas
+	dbReplicas string
+	dbMaxLag   time.Duration
+	// dbSlowQuery is the duration that a query is logged as slow from
+	dbSlowQuery time.Duration
+	// dbLogQueries logs every query
+	dbLogQueries bool
+
+	// cursorSecret signs the pagination cursors, all instances must share
+	// it. A random secret is used when it is empty.
+	cursorSecret string
+
+	// jobWorkers is the number of background jobs that run at once
+	jobWorkers int
+	// publishInterval is how often the scheduled posts that are due are
+	// published
+	publishInterval time.Duration
+
+	// templatesDir is the directory of the templates of the pages, the
+	// embedded templates are used when it is empty
+	templatesDir string
+	// dev is the development mode, the templates are reloaded when their
+	// files change
+	dev bool
+}
+
+func loadSettings() settings {
+	config := settings{}
+
+	flag.StringVar(&config.address, "address", "", "address to listen on")
+	flag.UintVar(&config.port, "port", 3000, "port to listen on")
+	flag.StringVar(&config.metricsAddress, "metrics-address", "127.0.0.1:9100",
+		"address of the admin listener that serves /metrics, empty disables it")
+	flag.StringVar(&config.dbURL, "db-url", os.Getenv("DATABASE_URL"),
+		"postgres:// URL of the database, PG* environment variables are used when empty")
+	flag.DurationVar(&config.dbTimeout, "db-timeout", 30*time.Second,
+		"how long to wait for the database at startup")
+	flag.IntVar(&config.dbMaxOpen, "db-max-open", db.DefaultMaxOpenConns,
+		"maximum number of open database connections")
+	flag.IntVar(&config.dbMaxIdle, "db-max-idle", db.DefaultMaxIdleConns,
+		"maximum number of idle database connections")
+	flag.StringVar(&config.dbReplicas, "db-replicas", os.Getenv("DATABASE_REPLICA_URLS"),
+		"comma separated postgres:// URLs of read replicas")
+	flag.DurationVar(&config.dbMaxLag, "db-max-lag", db.DefaultMaxReplicaLag,
+		"replication lag that takes a replica out of the reads")
+	flag.DurationVar(&config.dbSlowQuery, "db-slow-query", 200*time.Millisecond,
+		"log queries that take at least this long, 0 disables it")
+	flag.BoolVar(&config.dbLogQueries, "db-log-queries", false, "log every query")
+	flag.StringVar(&config.cursorSecret, "cursor-secret", os.Getenv("CURSOR_SECRET"),
+		"secret that signs the pagination cursors, shared by all instances")
+	flag.IntVar(&config.jobWorkers, "job-workers", jobs.DefaultWorkers,
+		"number of background jobs that run at once")
+	flag.DurationVar(&config.publishInterval, "publish-interval", 30*time.Second,
+		"how often the scheduled posts that are due are published")
+	flag.StringVar(&config.templatesDir, "templates-dir", "",
+		"directory of the templates of the pages, the embedded templates are used when empty")
+	flag.BoolVar(&config.dev, "dev", false,
+		"development mode, reloads the templates when their files change")
+	flag.Parse()
+
+	return config
+}
+
+// dbConfig returns the database configuration of the settings
+func (config settings) dbConfig() (db.Config, error) {
+	var (
+		dbConfig db.Config
+		err      error
+	)
+	if config.dbURL != "" {
+		dbConfig, err = db.ParseURL(config.dbURL)
+	} else {
+		dbConfig, err = db.ConfigFromEnv()
+	}
+	if err != nil {
+		return dbConfig, err
+	}
+
+	dbConfig.MaxOpenConns = config.dbMaxOpen
+	dbConfig.MaxIdleConns = config.dbMaxIdle
+	dbConfig.MaxReplicaLag = config.dbMaxLag
+
+	for _, replicaURL := range strings.Split(config.dbReplicas, ",") {
+		replicaURL = strings.TrimSpace(replicaURL)
+		if replicaURL == "" {
+			continue
+		}
+		replica, err := db.ParseURL(replicaURL)
+		if err != nil {
+			return dbConfig, err
+		}
+		dbConfig.Replicas = append(dbConfig.Replicas, replica)
+	}
+	return dbConfig, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/ik5/go-into/markup"
+	"github.com/ik5/go-into/templates"
+)
+
+// siteTemplates are the templates of the pages, that are embedded at the
+// binary
+//
+//go:embed templates
+var siteTemplates embed.FS
+
+// loadTemplates parses the templates of the pages from the templates
+// directory of the settings, or from the embedded ones when it is empty
+func loadTemplates(config settings, renderer *markup.Renderer, logger *log.Logger) (*templates.Manager, error) {
+	var fsys fs.FS = os.DirFS(config.templatesDir)
+	if config.templatesDir == "" {
+		var err error
+		fsys, err = fs.Sub(siteTemplates, "templates")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templates.New(fsys, &templates.Options{
+		Markup:  renderer,
+		OnError: func(err error) { logger.Printf("templates reload failed: %s", err) },
+	})
+}
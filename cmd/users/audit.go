@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/db"
+)
+
+// runAudit executes the audit subcommand
+func runAudit(ctx context.Context, conn *db.Conn, args []string) error {
+	var (
+		filter       audit.Filter
+		since, until string
+		asJSON       bool
+		verify       bool
+	)
+
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.Uint64Var(&filter.ActorID, "actor-id", 0, "only the actions of the user id")
+	flags.StringVar(&filter.Action, "action", "", "only the action, such as user.roles or auth.login_failed")
+	flags.StringVar(&filter.TargetType, "target-type", "", "only targets of the type, user or post")
+	flags.StringVar(&filter.TargetID, "target-id", "", "only the target id")
+	flags.StringVar(&since, "since", "", "only entries since an RFC 3339 time, or a duration ago such as 24h")
+	flags.StringVar(&until, "until", "", "only entries before an RFC 3339 time, or a duration ago")
+	flags.IntVar(&filter.Limit, "limit", 50, "maximum number of entries, 0 lists all of them")
+	flags.BoolVar(&asJSON, "json", false, "print the entries as JSON lines")
+	flags.BoolVar(&verify, "verify", false, "verify the hash chain of the whole log instead of listing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	auditLog := audit.NewPostgresLog(conn)
+	if verify {
+		if err := auditLog.Verify(ctx); err != nil {
+			return err
+		}
+		fmt.Println("The audit log chain is valid")
+		return nil
+	}
+
+	now := time.Now()
+	var err error
+	if filter.Since, err = parseAuditTime(since, now); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if filter.Until, err = parseAuditTime(until, now); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	entries, err := auditLog.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tACTOR\tACTION\tTARGET\tIP\tREQUEST\tDIFF")
+	for _, entry := range entries {
+		target := ""
+		if entry.TargetType != "" {
+			target = entry.TargetType + ":" + entry.TargetID
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.ID, entry.CreatedAt.Local().Format("02-01-2006 15:04:05 MST"),
+			auditActor(entry), entry.Action, target, entry.IP, entry.RequestID, entry.Diff)
+	}
+	return w.Flush()
+}
+
+// parseAuditTime parses an RFC 3339 time, or a duration before now
+func parseAuditTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func auditActor(entry audit.Entry) string {
+	if entry.ActorID == 0 {
+		if entry.Actor == "" {
+			return "-"
+		}
+		return entry.Actor
+	}
+	return fmt.Sprintf("%s (%d)", entry.Actor, entry.ActorID)
+}
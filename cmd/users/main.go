@@ -1,5 +1,65 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ik5/go-into/db"
+)
+
+const usage = `usage: users [flags] <command> [args]
+
+commands:
+  audit     list and verify the audit log, see users audit -h
+
+flags:`
+
+// TODO: create tool to generate users for the systems
+
 func main() {
-	// TODO: create tool to generate users for the systems
+	dbURL := flag.String("db-url", os.Getenv("DATABASE_URL"),
+		"postgres:// URL of the database, the PG* environment variables are used when empty")
+	dbTimeout := flag.Duration("db-timeout", 30*time.Second, "how long to wait for the database")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) != "audit" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		dbConfig db.Config
+		err      error
+	)
+	if *dbURL != "" {
+		dbConfig, err = db.ParseURL(*dbURL)
+	} else {
+		dbConfig, err = db.ConfigFromEnv()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid database settings: %s\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *dbTimeout)
+	conn, err := db.Open(ctx, dbConfig)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = runAudit(context.Background(), conn, flag.Args()[1:])
+	conn.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// OpCopy is the operation of a batch of CopyIn at a QueryEvent
+const OpCopy = "copy"
+
+// DefaultCopyBatchSize is the number of rows of a batch of CopyIn
+const DefaultCopyBatchSize = 5000
+
+// RowSource iterates over the rows that CopyIn loads
+type RowSource interface {
+	// Next returns the values of the next row, at the order of the columns,
+	// or io.EOF after the last row
+	Next() ([]interface{}, error)
+}
+
+// RowSourceFunc is a function that implements RowSource
+type RowSourceFunc func() ([]interface{}, error)
+
+// Next implements RowSource
+func (fn RowSourceFunc) Next() ([]interface{}, error) {
+	return fn()
+}
+
+// SliceRows returns a RowSource of rows
+func SliceRows(rows [][]interface{}) RowSource {
+	i := 0
+	return RowSourceFunc(func() ([]interface{}, error) {
+		if i >= len(rows) {
+			return nil, io.EOF
+		}
+		i++
+		return rows[i-1], nil
+	})
+}
+
+// CopyOptions are the settings of CopyIn, nil uses the defaults
+type CopyOptions struct {
+	// BatchSize is the number of rows of a batch, DefaultCopyBatchSize when
+	// it is not positive
+	BatchSize int
+	// OnProgress is called after every batch
+	OnProgress func(progress CopyProgress)
+	// ContinueOnError loads the next batches after a batch failed, instead
+	// of stopping
+	ContinueOnError bool
+}
+
+// CopyProgress reports a batch of CopyIn
+type CopyProgress struct {
+	// Batch is the number of the batch, starting at 1
+	Batch int
+	// Rows is the number of rows of the batch
+	Rows int
+	// Copied is the number of rows of all of the batches that were
+	// committed so far
+	Copied int64
+	// Err is the error of the batch, its rows were not copied
+	Err error
+}
+
+// BatchError is the error of a batch of CopyIn
+type BatchError struct {
+	Batch int
+	// FirstRow is the index of the first row of the batch at the source
+	FirstRow int64
+	Rows     int
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("copy batch %d (rows %d-%d): %s", e.Batch, e.FirstRow, e.FirstRow+int64(e.Rows)-1, e.Err)
+}
+
+// Unwrap returns the error of the database
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// CopyError holds the failed batches of a CopyIn with ContinueOnError
+type CopyError struct {
+	Batches []*BatchError
+}
+
+func (e *CopyError) Error() string {
+	messages := make([]string, len(e.Batches))
+	for i, batch := range e.Batches {
+		messages[i] = batch.Error()
+	}
+	return fmt.Sprintf("%d copy batches failed: %s", len(e.Batches), strings.Join(messages, "; "))
+}
+
+// CopyIn loads the rows of source into the columns of table with
+// COPY FROM STDIN, and returns the number of rows that were copied.
+//
+// The rows are streamed in batches, every batch is copied at its own
+// transaction at the primary, so a failed batch is rolled back while the
+// batches before it stay. The failure is returned as a *BatchError, or with
+// ContinueOnError, all failures are returned together as a *CopyError after
+// the source is exhausted. An error of source stops the copy.
+func (conn *Conn) CopyIn(ctx context.Context, table string, columns []string, source RowSource, opts *CopyOptions) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	options := CopyOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultCopyBatchSize
+	}
+
+	var (
+		copied    int64
+		read      int64
+		failed    []*BatchError
+		batch     = make([][]interface{}, 0, options.BatchSize)
+		number    = 0
+		sourceErr error
+	)
+
+	for {
+		batch = batch[:0]
+		for len(batch) < options.BatchSize {
+			row, err := source.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				sourceErr = err
+				break
+			}
+			batch = append(batch, row)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		number++
+		err := conn.copyBatch(ctx, table, columns, batch)
+		if err == nil {
+			copied += int64(len(batch))
+		}
+		if options.OnProgress != nil {
+			options.OnProgress(CopyProgress{Batch: number, Rows: len(batch), Copied: copied, Err: err})
+		}
+		if err != nil {
+			batchErr := &BatchError{Batch: number, FirstRow: read, Rows: len(batch), Err: err}
+			if !options.ContinueOnError || ctx.Err() != nil {
+				return copied, batchErr
+			}
+			failed = append(failed, batchErr)
+		}
+		read += int64(len(batch))
+
+		if sourceErr != nil || len(batch) < options.BatchSize {
+			break
+		}
+	}
+
+	if sourceErr != nil {
+		return copied, fmt.Errorf("copy source failed after %d rows: %w", read, sourceErr)
+	}
+	if len(failed) > 0 {
+		return copied, &CopyError{Batches: failed}
+	}
+	return copied, nil
+}
+
+// copyBatch copies rows in a transaction
+func (conn *Conn) copyBatch(ctx context.Context, table string, columns []string, rows [][]interface{}) (err error) {
+	query := pq.CopyIn(table, columns...)
+	ctx, after := conn.before(ctx, OpCopy, query, nil)
+	defer func() { after(err) }()
+
+	tx, err := conn.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			_ = stmt.Close()
+			return err
+		}
+	}
+	// an Exec without arguments flushes the rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+const copyPostsQuery = `COPY "posts" ("title", "body") FROM STDIN`
+
+func copyRows(n int) [][]interface{} {
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{"title", "body"}
+	}
+	return rows
+}
+
+// expectCopyBatch expects a batch of rows that is committed
+func expectCopyBatch(mock *dbtest.Mock, rows int) {
+	mock.ExpectBegin()
+	for i := 0; i < rows; i++ {
+		mock.ExpectExec(copyPostsQuery).WithArgs("title", "body")
+	}
+	mock.ExpectExec(copyPostsQuery).WithArgs()
+	mock.ExpectCommit()
+}
+
+func TestCopyInBatches(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	expectCopyBatch(mock, 2)
+	expectCopyBatch(mock, 1)
+
+	progress := make([]CopyProgress, 0)
+	copied, err := conn.CopyIn(context.Background(), "posts", []string{"title", "body"},
+		SliceRows(copyRows(3)), &CopyOptions{
+			BatchSize:  2,
+			OnProgress: func(p CopyProgress) { progress = append(progress, p) },
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 3 {
+		t.Errorf("Expected 3 rows, got %d", copied)
+	}
+	if len(progress) != 2 || progress[1].Batch != 2 || progress[1].Rows != 1 || progress[1].Copied != 3 {
+		t.Errorf("Expected the progress of 2 batches, got %+v", progress)
+	}
+}
+
+func TestCopyInBatchError(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+	failure := errors.New("invalid input syntax")
+
+	expectCopyBatch(mock, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec(copyPostsQuery).WillReturnError(failure)
+	mock.ExpectRollback()
+
+	copied, err := conn.CopyIn(context.Background(), "posts", []string{"title", "body"},
+		SliceRows(copyRows(5)), &CopyOptions{BatchSize: 2},
+	)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected a BatchError, got %v", err)
+	}
+	if batchErr.Batch != 2 || batchErr.FirstRow != 2 || !errors.Is(err, failure) {
+		t.Errorf("Expected batch 2 from row 2 to fail, got %s", batchErr)
+	}
+	if copied != 2 {
+		t.Errorf("Expected the 2 rows of the first batch, got %d", copied)
+	}
+}
+
+func TestCopyInContinueOnError(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(copyPostsQuery).WillReturnError(errors.New("duplicate key"))
+	mock.ExpectRollback()
+	expectCopyBatch(mock, 1)
+
+	copied, err := conn.CopyIn(context.Background(), "posts", []string{"title", "body"},
+		SliceRows(copyRows(2)), &CopyOptions{BatchSize: 1, ContinueOnError: true},
+	)
+	var copyErr *CopyError
+	if !errors.As(err, &copyErr) || len(copyErr.Batches) != 1 || copyErr.Batches[0].Batch != 1 {
+		t.Fatalf("Expected the first batch to fail, got %v", err)
+	}
+	if copied != 1 {
+		t.Errorf("Expected the row of the second batch, got %d", copied)
+	}
+}
+
+func TestCopyInSourceError(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+	failure := errors.New("corrupt archive")
+
+	expectCopyBatch(mock, 1)
+
+	sent := false
+	source := RowSourceFunc(func() ([]interface{}, error) {
+		if sent {
+			return nil, failure
+		}
+		sent = true
+		return []interface{}{"title", "body"}, nil
+	})
+
+	copied, err := conn.CopyIn(context.Background(), "posts", []string{"title", "body"}, source, nil)
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the source error, got %v", err)
+	}
+	if copied != 1 {
+		t.Errorf("Expected the rows before the error to be copied, got %d", copied)
+	}
+}
+
+func TestSliceRows(t *testing.T) {
+	source := SliceRows(copyRows(1))
+	if _, err := source.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,440 @@
+/*
+Package dbtest provides a scripted database/sql driver for unit tests, that
+requires no database server.
+
+The test declares the statements it expects, in the order they must arrive,
+with the rows, results or errors to answer with:
+
+	sqlDB, mock := dbtest.New(t)
+	conn := db.NewFromDB(sqlDB)
+
+	mock.ExpectQuery("FROM users WHERE id = $1").WithArgs(1).
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(1, "root"))
+
+A statement that does not match the next expectation fails with an error.
+New registers a cleanup that fails the test when an expectation was not
+met.
+
+Queries are matched by containment, after collapsing all white space, so an
+expectation may hold only the distinctive part of a statement.
+*/
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Kinds of expectations
+const (
+	kindQuery    = "query"
+	kindExec     = "exec"
+	kindBegin    = "begin"
+	kindCommit   = "commit"
+	kindRollback = "rollback"
+)
+
+// Argument matches an argument in a custom way, see AnyArg
+type Argument interface {
+	Match(value driver.Value) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(driver.Value) bool { return true }
+
+// AnyArg matches any argument
+var AnyArg Argument = anyArg{}
+
+// Mock holds the expectations of a fake database
+type Mock struct {
+	mutex        sync.Mutex
+	expectations []*Expectation
+	next         int
+	failures     []error
+}
+
+// Expectation is an expected call, and the way to answer it
+type Expectation struct {
+	kind  string
+	query string
+	args  []interface{}
+	// checkArgs is set by WithArgs, otherwise the arguments are ignored
+	checkArgs bool
+
+	rows   *Rows
+	result driver.Result
+	err    error
+}
+
+// New returns a *sql.DB over a new Mock. The expectations are verified at
+// the end of the test.
+func New(t testing.TB) (*sql.DB, *Mock) {
+	mock := &Mock{}
+	sqlDB := sql.OpenDB(connector{mock: mock})
+
+	t.Cleanup(func() {
+		_ = sqlDB.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return sqlDB, mock
+}
+
+func (mock *Mock) expect(kind, query string) *Expectation {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	expectation := &Expectation{kind: kind, query: normalize(query)}
+	mock.expectations = append(mock.expectations, expectation)
+	return expectation
+}
+
+// ExpectQuery expects a statement that returns rows
+func (mock *Mock) ExpectQuery(query string) *Expectation {
+	return mock.expect(kindQuery, query).WillReturnRows(NewRows())
+}
+
+// ExpectExec expects a statement that does not return rows
+func (mock *Mock) ExpectExec(query string) *Expectation {
+	return mock.expect(kindExec, query).WillReturnResult(NewResult(0, 0))
+}
+
+// ExpectBegin expects the start of a transaction
+func (mock *Mock) ExpectBegin() *Expectation {
+	return mock.expect(kindBegin, "")
+}
+
+// ExpectCommit expects the commit of a transaction
+func (mock *Mock) ExpectCommit() *Expectation {
+	return mock.expect(kindCommit, "")
+}
+
+// ExpectRollback expects the roll back of a transaction
+func (mock *Mock) ExpectRollback() *Expectation {
+	return mock.expect(kindRollback, "")
+}
+
+// WithArgs sets the expected arguments of the statement, either values that
+// are compared after the driver conversion, or Argument matchers
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.checkArgs = true
+	return e
+}
+
+// WillReturnRows answers a query with rows
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult answers an exec with result, see NewResult
+func (e *Expectation) WillReturnResult(result driver.Result) *Expectation {
+	e.result = result
+	return e
+}
+
+// WillReturnError answers the call with err
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.query == "" {
+		return e.kind
+	}
+	return fmt.Sprintf("%s %q", e.kind, e.query)
+}
+
+// ExpectationsWereMet returns an error if a call was unexpected, or if an
+// expectation was not called
+func (mock *Mock) ExpectationsWereMet() error {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	if len(mock.failures) > 0 {
+		return mock.failures[0]
+	}
+	if mock.next < len(mock.expectations) {
+		remaining := make([]string, 0, len(mock.expectations)-mock.next)
+		for _, e := range mock.expectations[mock.next:] {
+			remaining = append(remaining, e.String())
+		}
+		return fmt.Errorf("dbtest: expectations were not met: %s", strings.Join(remaining, ", "))
+	}
+	return nil
+}
+
+// call matches a call to the next expectation
+func (mock *Mock) call(kind, query string, args []driver.NamedValue) (*Expectation, error) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	fail := func(format string, a ...interface{}) (*Expectation, error) {
+		err := fmt.Errorf("dbtest: "+format, a...)
+		mock.failures = append(mock.failures, err)
+		return nil, err
+	}
+
+	got := kind
+	if query != "" {
+		got = fmt.Sprintf("%s %q", kind, normalize(query))
+	}
+	if mock.next >= len(mock.expectations) {
+		return fail("unexpected %s, no more calls are expected", got)
+	}
+
+	expectation := mock.expectations[mock.next]
+	if expectation.kind != kind || !strings.Contains(normalize(query), expectation.query) {
+		return fail("unexpected %s, expected %s", got, expectation)
+	}
+	if expectation.checkArgs {
+		if err := matchArgs(expectation.args, args); err != nil {
+			return fail("%s: %s", expectation, err)
+		}
+	}
+
+	mock.next++
+	return expectation, nil
+}
+
+func matchArgs(expected []interface{}, args []driver.NamedValue) error {
+	if len(expected) != len(args) {
+		return fmt.Errorf("expected %d arguments, got %d", len(expected), len(args))
+	}
+
+	for i, want := range expected {
+		got := args[i].Value
+		if matcher, ok := want.(Argument); ok {
+			if !matcher.Match(got) {
+				return fmt.Errorf("argument %d (%v) does not match", i+1, got)
+			}
+			continue
+		}
+
+		converted, err := driver.DefaultParameterConverter.ConvertValue(want)
+		if err != nil {
+			return fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		if !reflect.DeepEqual(converted, got) {
+			return fmt.Errorf("argument %d: expected %v (%T), got %v (%T)", i+1, converted, converted, got, got)
+		}
+	}
+	return nil
+}
+
+// normalize collapses all white space into single spaces
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Rows are the canned rows of a query
+type Rows struct {
+	columns []string
+	values  [][]driver.Value
+	err     error
+}
+
+// NewRows creates Rows with columns
+func NewRows(columns ...string) *Rows {
+	return &Rows{columns: columns}
+}
+
+// AddRow adds a row, values are converted as query arguments are
+func (rows *Rows) AddRow(values ...interface{}) *Rows {
+	if len(values) != len(rows.columns) {
+		panic(fmt.Sprintf("dbtest: expected %d values, got %d", len(rows.columns), len(values)))
+	}
+
+	row := make([]driver.Value, len(values))
+	for i, value := range values {
+		converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			panic(fmt.Sprintf("dbtest: value %d: %s", i+1, err))
+		}
+		row[i] = converted
+	}
+	rows.values = append(rows.values, row)
+	return rows
+}
+
+// CloseError sets the error that ends the iteration of the rows, after all
+// rows were read
+func (rows *Rows) CloseError(err error) *Rows {
+	rows.err = err
+	return rows
+}
+
+// result is the canned result of an exec
+type result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+// NewResult creates the result of an exec
+func NewResult(lastInsertID, rowsAffected int64) driver.Result {
+	return result{lastInsertID: lastInsertID, rowsAffected: rowsAffected}
+}
+
+func (r result) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// connector creates the connections of a Mock
+type connector struct {
+	mock *Mock
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{mock: c.mock}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: use dbtest.New")
+}
+
+// conn is a connection that answers by the expectations of its Mock
+type conn struct {
+	mock *Mock
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	expectation, err := c.mock.call(kindBegin, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if expectation.err != nil {
+		return nil, expectation.err
+	}
+	return &tx{mock: c.mock}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	expectation, err := c.mock.call(kindQuery, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if expectation.err != nil {
+		return nil, expectation.err
+	}
+	return &rows{rows: expectation.rows}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	expectation, err := c.mock.call(kindExec, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if expectation.err != nil {
+		return nil, expectation.err
+	}
+	return expectation.result, nil
+}
+
+// stmt is a prepared statement, that is matched when it is executed
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type tx struct {
+	mock *Mock
+}
+
+func (t *tx) Commit() error {
+	expectation, err := t.mock.call(kindCommit, "", nil)
+	if err != nil {
+		return err
+	}
+	return expectation.err
+}
+
+func (t *tx) Rollback() error {
+	expectation, err := t.mock.call(kindRollback, "", nil)
+	if err != nil {
+		return err
+	}
+	return expectation.err
+}
+
+// rows iterates over canned Rows
+type rows struct {
+	rows *Rows
+	next int
+}
+
+func (r *rows) Columns() []string {
+	return r.rows.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.values) {
+		if r.rows.err != nil {
+			return r.rows.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.rows.values[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,91 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryRows(t *testing.T) {
+	sqlDB, mock := New(t)
+
+	created := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, name, created_at FROM users WHERE id = $1").WithArgs(7).
+		WillReturnRows(NewRows("id", "name", "created_at").AddRow(7, "root", created))
+
+	var (
+		id      int
+		name    string
+		scanned time.Time
+	)
+	err := sqlDB.QueryRowContext(context.Background(),
+		`SELECT id, name, created_at
+		FROM users WHERE id = $1`, 7,
+	).Scan(&id, &name, &scanned)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if id != 7 || name != "root" || !scanned.Equal(created) {
+		t.Errorf("Expected 7 root %s, got %d %s %s", created, id, name, scanned)
+	}
+}
+
+func TestExecResultAndError(t *testing.T) {
+	sqlDB, mock := New(t)
+
+	failed := errors.New("disk full")
+	mock.ExpectExec("DELETE FROM posts").WithArgs(AnyArg).WillReturnResult(NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM posts").WillReturnError(failed)
+
+	result, err := sqlDB.Exec(`DELETE FROM posts WHERE author_id = $1`, 3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 2 {
+		t.Errorf("Expected 2 rows affected, got %d", affected)
+	}
+
+	if _, err := sqlDB.Exec(`DELETE FROM posts`); err != failed {
+		t.Errorf("Expected %s, got %v", failed, err)
+	}
+}
+
+func TestUnexpectedCalls(t *testing.T) {
+	mock := &Mock{}
+	c := &conn{mock: mock}
+
+	mock.ExpectExec("UPDATE users").WithArgs("root")
+
+	if _, err := c.QueryContext(context.Background(), "SELECT 1", nil); err == nil {
+		t.Error("Expected an error for a query out of order")
+	}
+	if err := mock.ExpectationsWereMet(); err == nil || !strings.Contains(err.Error(), "unexpected query") {
+		t.Errorf("Expected the unexpected query to be reported, got %v", err)
+	}
+}
+
+func TestWrongArgs(t *testing.T) {
+	mock := &Mock{}
+	c := &conn{mock: mock}
+
+	mock.ExpectExec("UPDATE users").WithArgs("root")
+
+	_, err := c.ExecContext(context.Background(), "UPDATE users SET name = $1", namedValues([]driver.Value{"admin"}))
+	if err == nil || !strings.Contains(err.Error(), "argument 1") {
+		t.Errorf("Expected an argument mismatch, got %v", err)
+	}
+}
+
+func TestUnmetExpectations(t *testing.T) {
+	mock := &Mock{}
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := mock.ExpectationsWereMet()
+	if err == nil || !strings.Contains(err.Error(), "begin, commit") {
+		t.Errorf("Expected the begin and commit to be reported, got %v", err)
+	}
+}
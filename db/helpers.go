@@ -1,8 +1,28 @@
 package db
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// PostgreSQL error codes that are handled by the package
+const (
+	codeUniqueViolation = "23505"
+)
 
 // IsNoRows return true if a given err is about no rows were returned
 func IsNoRows(err error) bool {
 	return err == sql.ErrNoRows
 }
+
+// UniqueViolation returns the name of the violated constraint if err is a
+// unique violation reported by PostgreSQL
+func UniqueViolation(err error) (constraint string, ok bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != codeUniqueViolation {
+		return "", false
+	}
+	return pqErr.Constraint, true
+}
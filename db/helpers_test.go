@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"testing"
+
+	"github.com/lib/pq"
 )
 
 func TestIsNoRowsReturned(t *testing.T) {
@@ -29,3 +31,27 @@ func TestIsNoRowsErrNil(t *testing.T) {
 		t.Errorf("Gave err of %T but got true on IsNoRows", err)
 	}
 }
+
+func TestUniqueViolation(t *testing.T) {
+	err := &pq.Error{Code: "23505", Constraint: "users_username_key"}
+
+	constraint, ok := UniqueViolation(err)
+	if !ok {
+		t.Errorf("Gave err of %s but got false on UniqueViolation", err)
+	}
+	if constraint != "users_username_key" {
+		t.Errorf("Expected users_username_key constraint, got %s", constraint)
+	}
+}
+
+func TestUniqueViolationOtherError(t *testing.T) {
+	for _, err := range []error{
+		errors.New("Just an error"),
+		&pq.Error{Code: "23503"},
+		nil,
+	} {
+		if _, ok := UniqueViolation(err); ok {
+			t.Errorf("Gave err of %v but got true on UniqueViolation", err)
+		}
+	}
+}
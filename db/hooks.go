@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/tracing"
+)
+
+// Operations of a QueryEvent
+const (
+	OpExec     = "exec"
+	OpQuery    = "query"
+	OpQueryRow = "query_row"
+	OpPrepare  = "prepare"
+	OpBegin    = "begin"
+	OpCommit   = "commit"
+	OpRollback = "rollback"
+)
+
+// redactedArg replaces the redacted arguments at a QueryEvent
+const redactedArg = "[REDACTED]"
+
+// maxLoggedArg is the length that a logged argument is truncated to
+const maxLoggedArg = 64
+
+// QueryEvent describes a database operation for the hooks
+type QueryEvent struct {
+	Op    string
+	Query string
+	// Args are the arguments of the query, where Secret values (or all
+	// values, see Conn.SetRedactArgs) are replaced by [REDACTED]
+	Args []interface{}
+	// Start is set before Hook.Before is called
+	Start time.Time
+	// Duration and Err are set before Hook.After is called
+	Duration time.Duration
+	Err      error
+}
+
+// Hook is called around every operation of Conn and of its transactions.
+//
+// Before may return a derived context (for example with a span), that is
+// used for the operation and passed to After.
+type Hook interface {
+	Before(ctx context.Context, event *QueryEvent) context.Context
+	After(ctx context.Context, event *QueryEvent)
+}
+
+// Secret is a query argument that is passed to the database as is, but is
+// redacted at the hooks
+type Secret struct {
+	Arg interface{}
+}
+
+// Value implements driver.Valuer
+func (secret Secret) Value() (driver.Value, error) {
+	return driver.DefaultParameterConverter.ConvertValue(secret.Arg)
+}
+
+// String hides the value from fmt
+func (secret Secret) String() string {
+	return redactedArg
+}
+
+// AddHook adds a hook to the connection, hooks are called in the order they
+// were added. It must be called before the connection is used.
+func (conn *Conn) AddHook(hook Hook) {
+	conn.hooks = append(conn.hooks, hook)
+}
+
+// SetRedactArgs sets whether all the arguments are redacted at the hooks,
+// and not only Secret values
+func (conn *Conn) SetRedactArgs(redact bool) {
+	conn.redactArgs = redact
+}
+
+// queryer returns q wrapped with the hooks of the connection
+func (conn *Conn) queryer(q queryer) queryer {
+	if len(conn.hooks) == 0 {
+		return q
+	}
+	return hookedQueryer{q: q, conn: conn}
+}
+
+// before calls the Before of the hooks, and returns the context for the
+// operation, and a function that calls the After of the hooks
+func (conn *Conn) before(ctx context.Context, op, query string, args []interface{}) (context.Context, func(err error)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(conn.hooks) == 0 {
+		return ctx, func(error) {}
+	}
+
+	event := &QueryEvent{
+		Op:    op,
+		Query: query,
+		Args:  conn.redact(args),
+		Start: time.Now(),
+	}
+	for _, hook := range conn.hooks {
+		ctx = hook.Before(ctx, event)
+	}
+
+	return ctx, func(err error) {
+		event.Duration = time.Since(event.Start)
+		event.Err = err
+		for _, hook := range conn.hooks {
+			hook.After(ctx, event)
+		}
+	}
+}
+
+// redact returns a copy of args for the hooks
+func (conn *Conn) redact(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if _, secret := arg.(Secret); secret || conn.redactArgs {
+			redacted[i] = redactedArg
+			continue
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// hookedQueryer calls the hooks of conn around a queryer
+type hookedQueryer struct {
+	q    queryer
+	conn *Conn
+}
+
+func (h hookedQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, after := h.conn.before(ctx, OpExec, query, args)
+	result, err := h.q.ExecContext(ctx, query, args...)
+	after(err)
+	return result, err
+}
+
+func (h hookedQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, after := h.conn.before(ctx, OpQuery, query, args)
+	rows, err := h.q.QueryContext(ctx, query, args...)
+	after(err)
+	return rows, err
+}
+
+// LogHook logs every operation as key=value pairs
+type LogHook struct {
+	logger *log.Logger
+}
+
+// NewLogHook creates a LogHook that writes to logger
+func NewLogHook(logger *log.Logger) *LogHook {
+	return &LogHook{logger: logger}
+}
+
+// Before implements Hook
+func (hook *LogHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// After implements Hook
+func (hook *LogHook) After(ctx context.Context, event *QueryEvent) {
+	hook.logger.Print(formatEvent(event))
+}
+
+// SlowQueryHook logs the operations that took at least a threshold
+type SlowQueryHook struct {
+	logger    *log.Logger
+	threshold time.Duration
+}
+
+// NewSlowQueryHook creates a SlowQueryHook that writes to logger
+func NewSlowQueryHook(logger *log.Logger, threshold time.Duration) *SlowQueryHook {
+	return &SlowQueryHook{logger: logger, threshold: threshold}
+}
+
+// Before implements Hook
+func (hook *SlowQueryHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// After implements Hook
+func (hook *SlowQueryHook) After(ctx context.Context, event *QueryEvent) {
+	if event.Duration >= hook.threshold {
+		hook.logger.Print("slow_query=true " + formatEvent(event))
+	}
+}
+
+// formatEvent formats an event as key=value pairs
+func formatEvent(event *QueryEvent) string {
+	var b strings.Builder
+	b.WriteString("op=" + event.Op)
+	b.WriteString(" duration=" + event.Duration.String())
+	if event.Query != "" {
+		b.WriteString(" query=" + strconv.Quote(strings.Join(strings.Fields(event.Query), " ")))
+	}
+	if len(event.Args) > 0 {
+		args := make([]string, len(event.Args))
+		for i, arg := range event.Args {
+			s := fmt.Sprint(arg)
+			if len(s) > maxLoggedArg {
+				s = s[:maxLoggedArg] + "..."
+			}
+			args[i] = s
+		}
+		b.WriteString(" args=" + strconv.Quote(strings.Join(args, ", ")))
+	}
+	if event.Err != nil {
+		b.WriteString(" error=" + strconv.Quote(event.Err.Error()))
+	}
+	return b.String()
+}
+
+// TraceHook records every operation as a span, under the span of the
+// context if there is one
+type TraceHook struct {
+	tracer *tracing.Tracer
+}
+
+// NewTraceHook creates a TraceHook over tracer
+func NewTraceHook(tracer *tracing.Tracer) *TraceHook {
+	return &TraceHook{tracer: tracer}
+}
+
+// Before implements Hook
+func (hook *TraceHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	ctx, span := hook.tracer.Start(ctx, "db."+event.Op)
+	span.SetAttribute("db.system", "postgresql")
+	if event.Query != "" {
+		span.SetAttribute("db.statement", event.Query)
+	}
+	return ctx
+}
+
+// After implements Hook
+func (hook *TraceHook) After(ctx context.Context, event *QueryEvent) {
+	span := tracing.FromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.SetError(event.Err)
+	span.Finish()
+}
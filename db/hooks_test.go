@@ -0,0 +1,142 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/tracing"
+)
+
+// recordHook records the events it was called with
+type recordHook struct {
+	before []QueryEvent
+	after  []QueryEvent
+}
+
+func (hook *recordHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	hook.before = append(hook.before, *event)
+	return ctx
+}
+
+func (hook *recordHook) After(ctx context.Context, event *QueryEvent) {
+	hook.after = append(hook.after, *event)
+}
+
+func TestHooksBeforeAfter(t *testing.T) {
+	conn := &Conn{}
+	hook := &recordHook{}
+	conn.AddHook(hook)
+
+	queryErr := errors.New("relation does not exist")
+	_, after := conn.before(nil, OpExec, "DELETE FROM users WHERE id = $1", []interface{}{7})
+	after(queryErr)
+
+	if len(hook.before) != 1 || len(hook.after) != 1 {
+		t.Fatalf("Expected one call of each, got %d and %d", len(hook.before), len(hook.after))
+	}
+	event := hook.after[0]
+	if event.Op != OpExec || event.Query != "DELETE FROM users WHERE id = $1" {
+		t.Errorf("Expected the exec query, got %s %s", event.Op, event.Query)
+	}
+	if event.Err != queryErr {
+		t.Errorf("Expected %s, got %v", queryErr, event.Err)
+	}
+	if hook.before[0].Err != nil || hook.before[0].Start.IsZero() {
+		t.Errorf("Expected only the start before the query, got %+v", hook.before[0])
+	}
+}
+
+func TestHooksRedaction(t *testing.T) {
+	conn := &Conn{}
+	hook := &recordHook{}
+	conn.AddHook(hook)
+
+	args := []interface{}{"root", Secret{Arg: "hunter2"}}
+	_, after := conn.before(context.Background(), OpQuery, "SELECT 1", args)
+	after(nil)
+
+	got := hook.after[0].Args
+	if got[0] != "root" || got[1] != redactedArg {
+		t.Errorf("Expected the secret to be redacted, got %v", got)
+	}
+	if _, ok := args[1].(Secret); !ok {
+		t.Error("Expected the query arguments to stay untouched")
+	}
+
+	conn.SetRedactArgs(true)
+	_, after = conn.before(context.Background(), OpQuery, "SELECT 1", args)
+	after(nil)
+
+	if got := hook.after[1].Args; got[0] != redactedArg {
+		t.Errorf("Expected all arguments to be redacted, got %v", got)
+	}
+}
+
+func TestSecretValue(t *testing.T) {
+	secret := Secret{Arg: "hunter2"}
+
+	value, err := secret.Value()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("Expected hunter2, got %v", value)
+	}
+	if s := secret.String(); s != redactedArg {
+		t.Errorf("Expected %s, got %s", redactedArg, s)
+	}
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	var buf bytes.Buffer
+	hook := NewSlowQueryHook(log.New(&buf, "", 0), 100*time.Millisecond)
+
+	hook.After(context.Background(), &QueryEvent{Op: OpQuery, Query: "SELECT 1", Duration: time.Millisecond})
+	if buf.Len() != 0 {
+		t.Errorf("Expected a fast query not to be logged, got %s", buf.String())
+	}
+
+	hook.After(context.Background(), &QueryEvent{
+		Op:       OpQuery,
+		Query:    "SELECT *\n\tFROM posts",
+		Args:     []interface{}{1, redactedArg},
+		Duration: 150 * time.Millisecond,
+	})
+	expected := `slow_query=true op=query duration=150ms query="SELECT * FROM posts" args="1, [REDACTED]"`
+	if got := strings.TrimSpace(buf.String()); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestTraceHook(t *testing.T) {
+	exporter := tracing.NewMemoryExporter(0)
+	tracer := tracing.NewTracer(exporter)
+
+	conn := &Conn{}
+	conn.AddHook(NewTraceHook(tracer))
+
+	ctx, parent := tracer.Start(context.Background(), "GET /posts/")
+	_, after := conn.before(ctx, OpQuery, "SELECT 1", nil)
+	after(errors.New("canceled"))
+	parent.Finish()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	query := spans[0]
+	if query.Name != "db.query" || query.Attributes["db.statement"] != "SELECT 1" {
+		t.Errorf("Expected a db.query span, got %s %v", query.Name, query.Attributes)
+	}
+	if query.ParentID != parent.SpanID || query.TraceID != parent.TraceID {
+		t.Error("Expected the query span to be a child of the request span")
+	}
+	if query.Err != "canceled" {
+		t.Errorf("Expected the error of the query, got %q", query.Err)
+	}
+}
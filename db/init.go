@@ -5,12 +5,9 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
-	"reflect"
-	"strings"
 	"time"
 
-	// Loading postgresql driver into local package
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Conn os the database connection
@@ -18,61 +15,220 @@ type Conn struct {
 	db         *sql.DB
 	ctx        context.Context
 	cancelFunc context.CancelFunc
-}
+	config     Config
+	// dsn is the connection string of the primary, for listeners
+	dsn string
+	// dialer dials the primary for listeners, nil for the default dialer
+	dialer pq.Dialer
+
+	// replicas serve the reads, see Conn.Query
+	replicas    []*replica
+	nextReplica uint32
+
+	// keys are the decrypted SSL keys, that are removed on Close
+	keys []*sslKey
 
-// PGSSLFields passing information for
-type PGSSLFields struct {
-	SSLMode     string `ssl:"sslmode"`
-	SSLCert     string `ssl:"sslcert"`
-	SSLKey      string `ssl:"sslkey"`
-	SSLRootCert string `ssl:"sslrootcert"`
+	hooks      []Hook
+	redactArgs bool
+
+	// txAttempts is the number of times WithTx executes a transaction
+	txAttempts int
 }
 
-// String returns the fields settings for using them as connections
-func (sslFields *PGSSLFields) String() string {
-	structElements := reflect.ValueOf(sslFields).Elem()
-	fieldsLen := structElements.NumField()
+// Defaults of the connectivity check of Open
+const (
+	openBackoffBase = 100 * time.Millisecond
+	openBackoffMax  = 5 * time.Second
+)
 
-	// generate a tmp slice size 0, initialize it memory capable of out of
-	// n bytes to caps
-	tmp := make([]string, 0, fieldsLen)
+// Open creates a new database connection from config, and waits until the
+// database answers a ping.
+//
+// The ping is retried with an exponential backoff until it succeeds or ctx
+// is done, so ctx should have a deadline. A nil ctx tries only once.
+//
+// The replicas of config are not waited for, they enter the rotation of the
+// reads once their health check passes.
+func Open(ctx context.Context, config Config) (*Conn, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
-	// Go over all fields of the struct
-	for i := 0; i < fieldsLen; i++ {
-		fieldValue := structElements.Field(i)       // get current field value
-		fieldType := structElements.Type().Field(i) // get the field type
-		tag := fieldType.Tag                        // get the field tag
+	conn := &Conn{config: config}
+	primary, dsn, dialer, err := conn.openDB(config)
+	if err != nil {
+		conn.removeKeys()
+		return nil, err
+	}
+
+	attrs := ""
+	if config.Options != nil {
+		attrs = config.Options.TargetSessionAttrs
+	}
 
-		// cast interface{} to string can you guess what might go be wrong here?
-		value := fieldValue.Interface().(string)
-		if value != "" {
-			tmpStr := fmt.Sprintf("%s=%s", tag.Get("ssl"), value)
-			tmp = append(tmp, tmpStr) // add the content to the slice
+	ping := func(ctx context.Context) error {
+		if err := primary.PingContext(ctx); err != nil {
+			return err
 		}
+		return checkSessionAttrs(ctx, primary, attrs)
+	}
+	if ctx == nil {
+		err = ping(context.Background())
+	} else {
+		err = waitForDB(ctx, ping)
 	}
+	if err != nil {
+		_ = primary.Close()
+		conn.removeKeys()
+		return nil, err
+	}
+	conn.db = primary
+	conn.dsn = dsn
+	conn.dialer = dialer
 
-	return strings.Join(tmp, " ") // return a space separated fields
+	for _, replicaConfig := range config.Replicas {
+		if replicaConfig.MaxOpenConns <= 0 {
+			replicaConfig.MaxOpenConns = config.MaxOpenConns
+		}
+		if replicaConfig.MaxIdleConns <= 0 {
+			replicaConfig.MaxIdleConns = config.MaxIdleConns
+		}
+		if replicaConfig.ConnMaxLifetime <= 0 {
+			replicaConfig.ConnMaxLifetime = config.ConnMaxLifetime
+		}
+
+		replicaDB, _, _, err := conn.openDB(replicaConfig)
+		if err != nil {
+			conn.closeDBs()
+			return nil, fmt.Errorf("replica %s: %w", replicaConfig.address(), err)
+		}
+		conn.replicas = append(conn.replicas, &replica{
+			db:   replicaDB,
+			name: replicaConfig.address(),
+		})
+	}
+
+	conn.ctx, conn.cancelFunc = context.WithCancel(context.Background())
+	if len(conn.replicas) > 0 {
+		checkCtx := ctx
+		if checkCtx == nil {
+			checkCtx = conn.ctx
+		}
+		conn.checkReplicas(checkCtx)
+		go conn.monitorReplicas()
+	}
+	return conn, nil
+}
+
+// NewFromDB creates a connection over an open sql.DB, without replicas. It
+// is meant for tests, with the driver of dbtest.
+func NewFromDB(sqlDB *sql.DB) *Conn {
+	conn := &Conn{db: sqlDB}
+	conn.ctx, conn.cancelFunc = context.WithCancel(context.Background())
+	return conn
 }
 
-// Open creates a new database connection, and if fails, return an error instead
-func Open(address, db, username, password string, port int, sslFields *PGSSLFields) (conn *Conn, err error) {
-	sslString := ""
-	if sslFields != nil {
-		sslString = sslFields.String()
+// openDB opens the pool of config, without connecting to it, and returns it
+// with the connection string and the dialer that were given to the driver.
+//
+// sslpassword and target_session_attrs are handled here, the rest of the
+// options are passed to the driver. The target_session_attrs is left for the
+// caller to check.
+func (conn *Conn) openDB(config Config) (*sql.DB, string, pq.Dialer, error) {
+	var dialer pq.Dialer
+	if config.Options != nil {
+		options := *config.Options
+		options.TargetSessionAttrs = ""
+		if options.SSLPassword != "" {
+			key, err := decryptKey(options.SSLKey, options.SSLPassword)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			conn.keys = append(conn.keys, key)
+			dialer = &keyDialer{key: key}
+			options.SSLKey, options.SSLPassword = key.path, ""
+		}
+		config.Options = &options
 	}
-	connStr := fmt.Sprintf("user=%s password=%s host=%s port=%d dbname=%s %s",
-		username, password, address, port, db, sslString,
-	)
-	conn = &Conn{}
-	conn.db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		conn = nil
+
+	dsn := config.DSN()
+	var sqlDB *sql.DB
+	if dialer != nil {
+		sqlDB = sql.OpenDB(dialConnector{dialer: dialer, dsn: dsn})
+	} else {
+		var err error
+		sqlDB, err = sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, "", nil, err
+		}
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	conn.ctx = ctx
-	conn.cancelFunc = cancelFunc
-	return
+	maxOpen, maxIdle, maxLifetime := config.MaxOpenConns, config.MaxIdleConns, config.ConnMaxLifetime
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenConns
+	}
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultConnMaxLifetime
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(maxLifetime)
+
+	return sqlDB, dsn, dialer, nil
+}
+
+// closeDBs closes the primary and the replicas, and removes the decrypted
+// keys
+func (conn *Conn) closeDBs() error {
+	var err error
+	if conn.db != nil {
+		err = conn.db.Close()
+	}
+	for _, r := range conn.replicas {
+		if closeErr := r.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	conn.removeKeys()
+	return err
+}
+
+func (conn *Conn) removeKeys() {
+	for _, key := range conn.keys {
+		key.remove()
+	}
+	conn.keys = nil
+}
+
+// waitForDB calls ping until it succeeds, waiting an exponential backoff
+// between the attempts. When ctx is done, the last error of ping is returned.
+func waitForDB(ctx context.Context, ping func(ctx context.Context) error) error {
+	delay := openBackoffBase
+	for {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("unable to connect to the database: %w", err)
+		}
+
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return fmt.Errorf("unable to connect to the database: %w", err)
+		}
+		delay *= 2
+		if delay > openBackoffMax {
+			delay = openBackoffMax
+		}
+	}
+}
+
+// Config returns the configuration the connection was opened with
+func (conn *Conn) Config() Config {
+	return conn.config
 }
 
 // Begin  starts a transaction.
@@ -86,10 +242,10 @@ func Open(address, db, username, password string, port int, sslFields *PGSSLFiel
 // If a non-default isolation level is used that the driver doesn't support, an
 // error will be returned.
 func (conn *Conn) Begin(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	if ctx != nil {
-		return conn.db.BeginTx(ctx, opts)
-	}
-	return conn.db.Begin()
+	ctx, after := conn.before(ctx, OpBegin, "", nil)
+	tx, err := conn.db.BeginTx(ctx, opts)
+	after(err)
+	return tx, err
 }
 
 // Close closes the database and prevents new queries from starting.
@@ -100,7 +256,7 @@ func (conn *Conn) Begin(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, erro
 // shared between many goroutines.
 func (conn *Conn) Close() error {
 	conn.cancelFunc()
-	return conn.db.Close()
+	return conn.closeDBs()
 }
 
 // Driver returns the database's underlying driver.
@@ -111,10 +267,10 @@ func (conn *Conn) Driver() driver.Driver {
 // Exec executes a query without returning any rows. The args are for any
 // placeholder parameters in the query.
 func (conn *Conn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	if ctx != nil {
-		return conn.db.ExecContext(ctx, query, args...)
-	}
-	return conn.db.Exec(query, args...)
+	ctx, after := conn.before(ctx, OpExec, query, args)
+	result, err := conn.db.ExecContext(ctx, query, args...)
+	after(err)
+	return result, err
 }
 
 // Ping verifies a connection to the database is still alive, establishing a
@@ -134,19 +290,23 @@ func (conn *Conn) Ping(ctx context.Context) error {
 // The provided context is used for the preparation of the statement, not for
 // the execution of the statement.
 func (conn *Conn) Prepare(ctx context.Context, query string) (*sql.Stmt, error) {
-	if ctx != nil {
-		return conn.db.PrepareContext(ctx, query)
-	}
-	return conn.db.Prepare(query)
+	ctx, after := conn.before(ctx, OpPrepare, query, nil)
+	stmt, err := conn.db.PrepareContext(ctx, query)
+	after(err)
+	return stmt, err
 }
 
 // Query executes a query that returns rows, typically a SELECT. The args are
 // for any placeholder parameters in the query
+//
+// The query is sent to a healthy replica, unless ctx was returned by
+// WithPrimary, so a query that writes must use WithPrimary or Exec.
 func (conn *Conn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	if ctx != nil {
-		return conn.db.QueryContext(ctx, query, args...)
-	}
-	return conn.db.Query(query, args...)
+	reader := conn.reader(ctx)
+	ctx, after := conn.before(ctx, OpQuery, query, args)
+	rows, err := reader.QueryContext(ctx, query, args...)
+	after(err)
+	return rows, err
 }
 
 // QueryRow executes a query that is expected to return at most one row.
@@ -154,11 +314,15 @@ func (conn *Conn) Query(ctx context.Context, query string, args ...interface{})
 // method is called. If the query selects no rows, the *Row's Scan will return
 // ErrNoRows. Otherwise, the *Row's Scan scans the first selected row and
 // discards the rest.
+//
+// As Query, the query is sent to a healthy replica unless ctx was returned by
+// WithPrimary.
 func (conn *Conn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	if ctx != nil {
-		return conn.db.QueryRowContext(ctx, query, args...)
-	}
-	return conn.db.QueryRow(query, args...)
+	reader := conn.reader(ctx)
+	ctx, after := conn.before(ctx, OpQueryRow, query, args)
+	row := reader.QueryRowContext(ctx, query, args...)
+	after(row.Err())
+	return row
 }
 
 // SetConnMaxLifetime sets the maximum amount of time a connection may be
@@ -197,7 +361,7 @@ func (conn *Conn) SetMaxOpenConns(n int) {
 	conn.db.SetMaxOpenConns(n)
 }
 
-// Stats returns database statistics.
+// Stats returns database statistics of the primary.
 func (conn *Conn) Stats() sql.DBStats {
 	return conn.db.Stats()
 }
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultElectorInterval is how often an Elector tries to take the lock, and
+// checks that it still holds it
+const DefaultElectorInterval = 5 * time.Second
+
+// ElectorOptions are the settings of Elect, nil uses the defaults
+type ElectorOptions struct {
+	// Interval between the attempts to take the lock and the heartbeats of
+	// the leader
+	Interval time.Duration
+	// OnError is called with the errors of the connection, it must not block
+	OnError func(err error)
+}
+
+// Elector elects a single leader among the instances that use the same
+// lock name, with a session level advisory lock on a dedicated connection.
+//
+// The lock is held as long as the connection is alive, so a leader whose
+// connection breaks loses the leadership, and another instance takes it on
+// its next attempt.
+type Elector struct {
+	conn     *Conn
+	key      int64
+	interval time.Duration
+	onError  func(err error)
+
+	// session holds the lock when the instance is the leader
+	session *sql.Conn
+	leader  int32
+	changes chan bool
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+}
+
+// LockKey returns the advisory lock key of name
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Elect starts to campaign for the leadership of name. The leader keeps a
+// connection of the pool for the lock. The leadership is released when the
+// Elector or the connection are closed.
+func (conn *Conn) Elect(name string, opts *ElectorOptions) *Elector {
+	elector := conn.newElector(name, opts)
+	go elector.run()
+	return elector
+}
+
+func (conn *Conn) newElector(name string, opts *ElectorOptions) *Elector {
+	options := ElectorOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultElectorInterval
+	}
+
+	elector := &Elector{
+		conn:     conn,
+		key:      LockKey(name),
+		interval: options.Interval,
+		onError:  options.OnError,
+		changes:  make(chan bool, 1),
+		done:     make(chan struct{}),
+	}
+	elector.ctx, elector.cancelFunc = context.WithCancel(conn.ctx)
+	return elector
+}
+
+// IsLeader returns true while the instance holds the leadership
+func (elector *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&elector.leader) == 1
+}
+
+// Changes returns a channel that receives true when the leadership is gained
+// and false when it is lost. Only the latest change is kept for a slow
+// receiver. The channel is closed when the Elector stops.
+func (elector *Elector) Changes() <-chan bool {
+	return elector.changes
+}
+
+// Close stops the campaign and releases the leadership
+func (elector *Elector) Close() error {
+	elector.cancelFunc()
+	<-elector.done
+	return nil
+}
+
+func (elector *Elector) run() {
+	defer close(elector.done)
+	defer close(elector.changes)
+
+	for {
+		elector.tick()
+		if err := sleepContext(elector.ctx, elector.interval); err != nil {
+			elector.release()
+			return
+		}
+	}
+}
+
+// tick tries to take the lock, or checks that the leader still holds it
+func (elector *Elector) tick() {
+	ctx, cancel := context.WithTimeout(elector.ctx, elector.interval)
+	defer cancel()
+
+	if elector.IsLeader() {
+		// the lock lives as long as the session
+		if err := elector.session.PingContext(ctx); err != nil {
+			elector.reportError(err)
+			elector.lose()
+		}
+		return
+	}
+
+	session, err := elector.conn.db.Conn(ctx)
+	if err != nil {
+		elector.reportError(err)
+		return
+	}
+
+	var locked bool
+	err = session.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, elector.key).Scan(&locked)
+	if err != nil || !locked {
+		if err != nil {
+			elector.reportError(err)
+		}
+		_ = session.Close()
+		return
+	}
+
+	elector.session = session
+	atomic.StoreInt32(&elector.leader, 1)
+	elector.notify(true)
+}
+
+// lose gives up the leadership after the session was lost
+func (elector *Elector) lose() {
+	// the connection is dropped instead of returning to the pool, as it may
+	// still hold the lock
+	_ = elector.session.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = elector.session.Close()
+	elector.session = nil
+	atomic.StoreInt32(&elector.leader, 0)
+	elector.notify(false)
+}
+
+// release unlocks the leadership on shutdown
+func (elector *Elector) release() {
+	if !elector.IsLeader() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), elector.interval)
+	defer cancel()
+	if _, err := elector.session.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, elector.key); err != nil {
+		elector.reportError(err)
+	}
+	_ = elector.session.Close()
+	elector.session = nil
+	atomic.StoreInt32(&elector.leader, 0)
+	elector.notify(false)
+}
+
+// notify replaces a change that was not received yet with the latest one
+func (elector *Elector) notify(leader bool) {
+	select {
+	case <-elector.changes:
+	default:
+	}
+	elector.changes <- leader
+}
+
+func (elector *Elector) reportError(err error) {
+	if elector.onError != nil {
+		elector.onError(err)
+	}
+}
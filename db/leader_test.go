@@ -0,0 +1,99 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+func expectLock(mock *dbtest.Mock, name string, locked bool) {
+	mock.ExpectQuery("SELECT pg_try_advisory_lock($1)").WithArgs(LockKey(name)).
+		WillReturnRows(dbtest.NewRows("pg_try_advisory_lock").AddRow(locked))
+}
+
+func TestLockKey(t *testing.T) {
+	if LockKey("scheduler") != LockKey("scheduler") {
+		t.Error("Expected the key of a name to be stable")
+	}
+	if LockKey("scheduler") == LockKey("digest") {
+		t.Error("Expected different names to have different keys")
+	}
+}
+
+func TestElectorTick(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+	elector := conn.newElector("scheduler", nil)
+
+	expectLock(mock, "scheduler", false)
+	elector.tick()
+	if elector.IsLeader() {
+		t.Fatal("Expected not to lead when the lock is taken")
+	}
+
+	expectLock(mock, "scheduler", true)
+	elector.tick()
+	if !elector.IsLeader() {
+		t.Fatal("Expected to lead after taking the lock")
+	}
+	if leader := <-elector.Changes(); !leader {
+		t.Error("Expected a change to leader")
+	}
+
+	// the leader only checks its session
+	elector.tick()
+	if !elector.IsLeader() {
+		t.Error("Expected to keep leading")
+	}
+
+	mock.ExpectExec("SELECT pg_advisory_unlock($1)").WithArgs(LockKey("scheduler"))
+	elector.release()
+	if elector.IsLeader() {
+		t.Error("Expected not to lead after the release")
+	}
+	if leader := <-elector.Changes(); leader {
+		t.Error("Expected a change to follower")
+	}
+}
+
+func TestElectorReleasesWithConn(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	expectLock(mock, "scheduler", true)
+	mock.ExpectExec("SELECT pg_advisory_unlock($1)").WithArgs(LockKey("scheduler"))
+
+	elector := conn.Elect("scheduler", &ElectorOptions{Interval: time.Hour})
+	select {
+	case leader := <-elector.Changes():
+		if !leader {
+			t.Fatal("Expected to become the leader")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a change of leadership")
+	}
+
+	// stopping the connection context stops the elector
+	conn.cancelFunc()
+	changes := make([]bool, 0)
+	for leader := range elector.Changes() {
+		changes = append(changes, leader)
+	}
+	if len(changes) != 1 || changes[0] {
+		t.Errorf("Expected to lose the leadership on shutdown, got %v", changes)
+	}
+	if err := elector.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestElectorNotifyKeepsLatest(t *testing.T) {
+	elector := &Elector{changes: make(chan bool, 1)}
+	elector.notify(true)
+	elector.notify(false)
+
+	if leader := <-elector.Changes(); leader {
+		t.Error("Expected only the latest change to be kept")
+	}
+}
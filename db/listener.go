@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Defaults of a Listener
+const (
+	DefaultListenerMinReconnect = 100 * time.Millisecond
+	DefaultListenerMaxReconnect = 30 * time.Second
+	// listenerPingInterval checks the connection when no notification
+	// arrives for a while, as a silent connection may be a dead one
+	listenerPingInterval = 90 * time.Second
+	listenerBuffer       = 64
+)
+
+// ErrNoDSN is returned by Listen on a connection that was not created by Open
+var ErrNoDSN = errors.New("the connection has no connection string to listen with")
+
+// Notification is a NOTIFY that arrived at a Listener
+type Notification struct {
+	Channel string
+	Payload string
+	// PID is the process id of the backend that sent the notification
+	PID int
+	// Reconnected marks the first value after the connection was lost and
+	// established again. The channels are subscribed again, but the
+	// notifications that were sent in between are lost, so caches should
+	// be dropped.
+	Reconnected bool
+}
+
+// Decode decodes the JSON payload of the notification into v
+func (n Notification) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(n.Payload), v)
+}
+
+// ListenerOptions are the settings of Listen, nil uses the defaults
+type ListenerOptions struct {
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+	// OnError is called with the connection errors, it must not block
+	OnError func(err error)
+}
+
+// Listener delivers notifications of the subscribed channels. It reconnects
+// by itself, and subscribes again to its channels.
+type Listener struct {
+	listener      *pq.Listener
+	notifications chan Notification
+	onError       func(err error)
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Listen creates a Listener on a dedicated connection of the primary, that
+// is subscribed to channels. The listener is closed with the connection.
+func (conn *Conn) Listen(opts *ListenerOptions, channels ...string) (*Listener, error) {
+	if conn.dsn == "" {
+		return nil, ErrNoDSN
+	}
+
+	options := ListenerOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.MinReconnect <= 0 {
+		options.MinReconnect = DefaultListenerMinReconnect
+	}
+	if options.MaxReconnect <= 0 {
+		options.MaxReconnect = DefaultListenerMaxReconnect
+	}
+
+	l := &Listener{
+		notifications: make(chan Notification, listenerBuffer),
+		onError:       options.OnError,
+		done:          make(chan struct{}),
+	}
+	l.ctx, l.cancelFunc = context.WithCancel(conn.ctx)
+	onEvent := func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			l.reportError(err)
+		}
+	}
+	if conn.dialer != nil {
+		l.listener = pq.NewDialListener(conn.dialer, conn.dsn,
+			options.MinReconnect, options.MaxReconnect, onEvent)
+	} else {
+		l.listener = pq.NewListener(conn.dsn, options.MinReconnect, options.MaxReconnect, onEvent)
+	}
+
+	for _, channel := range channels {
+		if err := l.listener.Listen(channel); err != nil {
+			_ = l.listener.Close()
+			l.cancelFunc()
+			return nil, err
+		}
+	}
+
+	go l.run(l.listener.Notify, l.listener.Ping)
+	return l, nil
+}
+
+// Notifications returns the channel of the notifications, that is closed
+// when the listener is closed
+func (l *Listener) Notifications() <-chan Notification {
+	return l.notifications
+}
+
+// Subscribe starts listening to channel
+func (l *Listener) Subscribe(channel string) error {
+	return l.listener.Listen(channel)
+}
+
+// Unsubscribe stops listening to channel
+func (l *Listener) Unsubscribe(channel string) error {
+	return l.listener.Unlisten(channel)
+}
+
+// Close stops the listener and closes its connection
+func (l *Listener) Close() error {
+	l.cancelFunc()
+	<-l.done
+
+	var err error
+	l.closeOnce.Do(func() {
+		err = l.listener.Close()
+	})
+	return err
+}
+
+func (l *Listener) reportError(err error) {
+	if l.onError != nil {
+		l.onError(err)
+	}
+}
+
+// run forwards the notifications until the context of the listener is done
+func (l *Listener) run(notify <-chan *pq.Notification, ping func() error) {
+	defer close(l.done)
+	defer close(l.notifications)
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			// the listener is closed here when the connection was closed
+			// first, Close closes it otherwise
+			l.closeOnce.Do(func() {
+				_ = l.listener.Close()
+			})
+			return
+
+		case n, ok := <-notify:
+			if !ok {
+				return
+			}
+			// pq sends nil after the connection was established again
+			notification := Notification{Reconnected: true}
+			if n != nil {
+				notification = Notification{Channel: n.Channel, Payload: n.Extra, PID: n.BePid}
+			}
+
+			select {
+			case l.notifications <- notification:
+			case <-l.ctx.Done():
+			}
+
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				l.reportError(err)
+			}
+		}
+	}
+}
+
+// Notify sends payload to channel. A payload that is not a string or a
+// []byte is encoded as JSON, to be read by Notification.Decode.
+func (conn *Conn) Notify(ctx context.Context, channel string, payload interface{}) error {
+	var text string
+	switch p := payload.(type) {
+	case string:
+		text = p
+	case []byte:
+		text = string(p)
+	default:
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		text = string(encoded)
+	}
+
+	_, err := conn.Exec(ctx, `SELECT pg_notify($1, $2)`, channel, text)
+	return err
+}
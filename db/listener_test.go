@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db/dbtest"
+	"github.com/lib/pq"
+)
+
+// newTestListener creates a Listener without a pq listener, that run
+// forwards notify to
+func newTestListener(ctx context.Context, notify <-chan *pq.Notification) *Listener {
+	l := &Listener{
+		notifications: make(chan Notification, listenerBuffer),
+		done:          make(chan struct{}),
+	}
+	l.ctx, l.cancelFunc = context.WithCancel(ctx)
+	// there is no pq listener to close
+	l.closeOnce.Do(func() {})
+
+	go l.run(notify, func() error { return nil })
+	return l
+}
+
+func receive(t *testing.T, l *Listener) Notification {
+	t.Helper()
+
+	select {
+	case n, ok := <-l.Notifications():
+		if !ok {
+			t.Fatal("Expected a notification, got a closed channel")
+		}
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("Expected a notification, got none")
+	}
+	return Notification{}
+}
+
+func TestNotificationDecode(t *testing.T) {
+	n := Notification{Channel: "posts", Payload: `{"id":7,"status":"published"}`}
+
+	var payload struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := n.Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != 7 || payload.Status != "published" {
+		t.Errorf("Expected id 7 and status published, got %+v", payload)
+	}
+
+	if err := (Notification{Payload: "not json"}).Decode(&payload); err == nil {
+		t.Error("Expected an error for a payload that is not JSON")
+	}
+}
+
+func TestListenWithoutDSN(t *testing.T) {
+	sqlDB, _ := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	if _, err := conn.Listen(nil, "posts"); !errors.Is(err, ErrNoDSN) {
+		t.Errorf("Expected ErrNoDSN, got %v", err)
+	}
+}
+
+func TestListenerForwards(t *testing.T) {
+	notify := make(chan *pq.Notification, 2)
+	l := newTestListener(context.Background(), notify)
+	defer l.Close()
+
+	notify <- &pq.Notification{BePid: 42, Channel: "posts", Extra: "7"}
+	n := receive(t, l)
+	if n.Channel != "posts" || n.Payload != "7" || n.PID != 42 || n.Reconnected {
+		t.Errorf("Expected the posts notification, got %+v", n)
+	}
+
+	notify <- nil
+	if n := receive(t, l); !n.Reconnected {
+		t.Errorf("Expected a reconnect notification, got %+v", n)
+	}
+}
+
+func TestListenerStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := newTestListener(ctx, make(chan *pq.Notification))
+
+	cancel()
+	select {
+	case _, ok := <-l.Notifications():
+		if ok {
+			t.Error("Expected the notifications to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the listener to stop with its context")
+	}
+
+	if err := l.Close(); err != nil {
+		t.Errorf("Expected no error from Close, got %s", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectExec("SELECT pg_notify($1, $2)").WithArgs("posts", `{"id":7}`)
+	mock.ExpectExec("SELECT pg_notify($1, $2)").WithArgs("posts", "plain")
+
+	if err := conn.Notify(context.Background(), "posts", map[string]int{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Notify(context.Background(), "posts", "plain"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ik5/go-into/metrics"
+)
+
+// unlabeledQuery is the label of queries whose context has no label
+const unlabeledQuery = "unlabeled"
+
+// queryLabelKey is the context key of WithQueryLabel
+type queryLabelKey struct{}
+
+// WithQueryLabel returns a copy of ctx that labels the queries at the
+// metrics, for example "users.get_by_id"
+func WithQueryLabel(ctx context.Context, label string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, queryLabelKey{}, label)
+}
+
+// QueryLabel returns the label of ctx, or "unlabeled"
+func QueryLabel(ctx context.Context) string {
+	if ctx != nil {
+		if label, ok := ctx.Value(queryLabelKey{}).(string); ok && label != "" {
+			return label
+		}
+	}
+	return unlabeledQuery
+}
+
+// MetricsHook records the latency of the operations by their query label
+type MetricsHook struct {
+	duration *metrics.HistogramVec
+}
+
+// NewMetricsHook creates a MetricsHook
+func NewMetricsHook() *MetricsHook {
+	return &MetricsHook{
+		duration: metrics.NewHistogramVec("db_query_duration_seconds",
+			"Latency of the database operations, by query label.",
+			nil, "label", "op", "status"),
+	}
+}
+
+// Before implements Hook
+func (hook *MetricsHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+// After implements Hook
+func (hook *MetricsHook) After(ctx context.Context, event *QueryEvent) {
+	status := "ok"
+	if event.Err != nil {
+		status = "error"
+	}
+	hook.duration.With(QueryLabel(ctx), event.Op, status).Observe(event.Duration.Seconds())
+}
+
+// Collect implements metrics.Collector
+func (hook *MetricsHook) Collect() []metrics.Family {
+	return hook.duration.Collect()
+}
+
+// RegisterMetrics exports the pool statistics of conn and the latency of its
+// queries at registry
+func RegisterMetrics(conn *Conn, registry *metrics.Registry) {
+	hook := NewMetricsHook()
+	conn.AddHook(hook)
+	registry.MustRegister(hook, metrics.CollectorFunc(conn.collectPoolMetrics))
+}
+
+// collectPoolMetrics returns the pool statistics of the primary and of the
+// replicas, and the state of the replicas
+func (conn *Conn) collectPoolMetrics() []metrics.Family {
+	type pool struct {
+		name  string
+		stats sql.DBStats
+	}
+	pools := []pool{{"primary", conn.db.Stats()}}
+	for _, r := range conn.replicas {
+		pools = append(pools, pool{r.name, r.db.Stats()})
+	}
+
+	family := func(name, help string, typ metrics.Type, value func(sql.DBStats) float64) metrics.Family {
+		f := metrics.Family{Name: name, Help: help, Type: typ}
+		for _, pool := range pools {
+			f.Samples = append(f.Samples, metrics.Sample{
+				Name:   name,
+				Labels: []metrics.Label{{Name: "pool", Value: pool.name}},
+				Value:  value(pool.stats),
+			})
+		}
+		return f
+	}
+
+	families := []metrics.Family{
+		family("db_pool_max_open_connections", "Maximum number of open connections.", metrics.Gauge,
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }),
+		family("db_pool_open_connections", "Number of open connections.", metrics.Gauge,
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }),
+		family("db_pool_in_use_connections", "Number of connections in use.", metrics.Gauge,
+			func(s sql.DBStats) float64 { return float64(s.InUse) }),
+		family("db_pool_idle_connections", "Number of idle connections.", metrics.Gauge,
+			func(s sql.DBStats) float64 { return float64(s.Idle) }),
+		family("db_pool_wait_count_total", "Number of waits for a connection.", metrics.Counter,
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }),
+		family("db_pool_wait_duration_seconds_total", "Time spent waiting for a connection.", metrics.Counter,
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }),
+		family("db_pool_max_idle_closed_total", "Connections closed by the idle limit.", metrics.Counter,
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }),
+		family("db_pool_max_lifetime_closed_total", "Connections closed by the lifetime limit.", metrics.Counter,
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }),
+	}
+
+	if len(conn.replicas) > 0 {
+		healthy := metrics.Family{Name: "db_replica_healthy", Help: "Whether a replica serves reads.", Type: metrics.Gauge}
+		lag := metrics.Family{Name: "db_replica_lag_seconds", Help: "Replay lag of a replica.", Type: metrics.Gauge}
+		for _, status := range conn.Replicas() {
+			labels := []metrics.Label{{Name: "pool", Value: status.Name}}
+			value := 0.0
+			if status.Healthy {
+				value = 1
+			}
+			healthy.Samples = append(healthy.Samples, metrics.Sample{Name: healthy.Name, Labels: labels, Value: value})
+			lag.Samples = append(lag.Samples, metrics.Sample{Name: lag.Name, Labels: labels, Value: status.Lag.Seconds()})
+		}
+		families = append(families, healthy, lag)
+	}
+	return families
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/metrics"
+)
+
+func TestQueryLabel(t *testing.T) {
+	if label := QueryLabel(nil); label != unlabeledQuery {
+		t.Errorf("Expected %s, got %s", unlabeledQuery, label)
+	}
+
+	ctx := WithQueryLabel(context.Background(), "users.get_by_id")
+	if label := QueryLabel(ctx); label != "users.get_by_id" {
+		t.Errorf("Expected users.get_by_id, got %s", label)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	conn := newReplicaTestConn(t, 1)
+	conn.replicas[0].name = "replica:5432"
+
+	registry := metrics.NewRegistry()
+	RegisterMetrics(conn, registry)
+
+	ctx, after := conn.before(WithQueryLabel(nil, "posts.list"), OpQuery, "SELECT 1", nil)
+	after(nil)
+	_, after = conn.before(ctx, OpQuery, "SELECT 1", nil)
+	after(errors.New("timeout"))
+
+	var b strings.Builder
+	if err := registry.WriteText(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	text := b.String()
+
+	for _, line := range []string{
+		`db_query_duration_seconds_count{label="posts.list",op="query",status="ok"} 1`,
+		`db_query_duration_seconds_count{label="posts.list",op="query",status="error"} 1`,
+		`db_pool_open_connections{pool="primary"} 0`,
+		`db_pool_open_connections{pool="replica:5432"} 0`,
+		`db_replica_healthy{pool="replica:5432"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Expected the line %s", line)
+		}
+	}
+}
+
+func TestMetricsHookDuration(t *testing.T) {
+	hook := NewMetricsHook()
+	hook.After(context.Background(), &QueryEvent{Op: OpExec, Duration: 20 * time.Millisecond})
+
+	families := hook.Collect()
+	if len(families) != 1 || families[0].Name != "db_query_duration_seconds" {
+		t.Fatalf("Expected the duration histogram, got %+v", families)
+	}
+	for _, sample := range families[0].Samples {
+		if strings.HasSuffix(sample.Name, "_sum") && sample.Value != 0.02 {
+			t.Errorf("Expected a sum of 0.02, got %v", sample.Value)
+		}
+	}
+}
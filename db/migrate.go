@@ -0,0 +1,309 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationLockKey is the advisory lock that is held while migrating, so only
+// one instance at a time changes the schema
+const migrationLockKey int64 = 0x676f696e746f // "gointo"
+
+// migrationFileName is <version>_<name>.<up|down>.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//go:embed migrations/*.sql
+var schemaFiles embed.FS
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is the state of a migration at the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown is true for a migration that was applied by a newer binary
+	Unknown bool
+}
+
+// Migrator applies migrations to the database through a Conn
+type Migrator struct {
+	conn       *Conn
+	migrations []Migration
+}
+
+// SchemaMigrations returns the migrations of the application schema, that are
+// embedded at the binary
+func SchemaMigrations() ([]Migration, error) {
+	return LoadMigrations(schemaFiles, "migrations")
+}
+
+// LoadMigrations reads the migrations at dir of fsys, ordered by version.
+//
+// Every version requires an up file, while a down file is optional.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		parts := migrationFileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		version, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s",
+				version, migration.Name, parts[2])
+		}
+
+		if parts[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file",
+				migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// NewMigrator creates a Migrator for the given migrations
+func NewMigrator(conn *Conn, migrations []Migration) *Migrator {
+	return &Migrator{conn: conn, migrations: migrations}
+}
+
+// migrationSession is the single connection of a Migrator. Its statements
+// go through the hooks of the Conn, as the ones of Conn.Exec do.
+type migrationSession struct {
+	conn    *Conn
+	session *sql.Conn
+}
+
+func (s migrationSession) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.conn.queryer(s.session).ExecContext(ctx, query, args...)
+}
+
+func (s migrationSession) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.conn.queryer(s.session).QueryContext(ctx, query, args...)
+}
+
+// withLock runs fn on a single connection that holds the migration lock. The
+// lock belongs to the session, so the connection is taken from the pool of
+// the primary rather than used through Conn.Exec.
+func (m *Migrator) withLock(ctx context.Context, fn func(migrationSession) error) (err error) {
+	conn, err := m.conn.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	session := migrationSession{conn: m.conn, session: conn}
+
+	if _, err := session.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return err
+	}
+	defer func() {
+		// the lock is released by the end of the session if unlock fails
+		_, unlockErr := session.ExecContext(WithQueryLabel(context.Background(), QueryLabel(ctx)),
+			`SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	_, err = session.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(session)
+}
+
+// applied returns the applied versions and their time
+func applied(ctx context.Context, session migrationSession) (map[uint64]MigrationStatus, error) {
+	rows, err := session.QueryContext(ctx,
+		`SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[uint64]MigrationStatus)
+	for rows.Next() {
+		status := MigrationStatus{Applied: true}
+		err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[status.Version] = status
+	}
+	return result, rows.Err()
+}
+
+// inTx executes the statements of a migration and the change of its record
+// at a single transaction
+func inTx(ctx context.Context, session migrationSession, statements string, record string, args ...interface{}) error {
+	beginCtx, after := session.conn.before(ctx, OpBegin, "", nil)
+	sqlTx, err := session.session.BeginTx(beginCtx, nil)
+	after(err)
+	if err != nil {
+		return err
+	}
+
+	tx := &Tx{tx: sqlTx, conn: session.conn}
+	if _, err := tx.Exec(ctx, statements); err != nil {
+		_ = tx.end(ctx, OpRollback, sqlTx.Rollback)
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		_ = tx.end(ctx, OpRollback, sqlTx.Rollback)
+		return err
+	}
+	return tx.end(ctx, OpCommit, sqlTx.Commit)
+}
+
+// Up applies all pending migrations by their order, and returns the ones that
+// were applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	ctx = WithQueryLabel(ctx, "migrations.up")
+
+	done := make([]Migration, 0)
+	err := m.withLock(ctx, func(session migrationSession) error {
+		current, err := applied(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := current[migration.Version]; ok {
+				continue
+			}
+
+			err := inTx(ctx, session, migration.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+				migration.Version, migration.Name,
+			)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts up to steps of the latest applied migrations, and returns the
+// ones that were reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	ctx = WithQueryLabel(ctx, "migrations.down")
+
+	done := make([]Migration, 0, steps)
+	err := m.withLock(ctx, func(session migrationSession) error {
+		current, err := applied(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := current[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s is irreversible", migration.Version, migration.Name)
+			}
+
+			err := inTx(ctx, session, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`,
+				migration.Version,
+			)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status returns all known migrations with their state, followed by applied
+// migrations that are unknown to the Migrator
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	ctx = WithQueryLabel(ctx, "migrations.status")
+
+	list := make([]MigrationStatus, 0, len(m.migrations))
+	err := m.withLock(ctx, func(session migrationSession) error {
+		current, err := applied(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := current[migration.Version]
+			status.Migration = migration
+			list = append(list, status)
+			delete(current, migration.Version)
+		}
+
+		unknown := make([]MigrationStatus, 0, len(current))
+		for _, status := range current {
+			status.Unknown = true
+			unknown = append(unknown, status)
+		}
+		sort.Slice(unknown, func(i, j int) bool {
+			return unknown[i].Version < unknown[j].Version
+		})
+		list = append(list, unknown...)
+		return nil
+	})
+	return list, err
+}
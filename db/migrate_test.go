@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+func TestLoadMigrationsOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0010_second.up.sql":  {Data: []byte("CREATE TABLE b ();")},
+		"m/0002_first.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+		"m/0002_first.down.sql": {Data: []byte("DROP TABLE a;")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+	}
+
+	first, second := migrations[0], migrations[1]
+	if first.Version != 2 || first.Name != "first" || first.Down != "DROP TABLE a;" {
+		t.Errorf("Unexpected first migration %+v", first)
+	}
+	if second.Version != 10 || second.Down != "" {
+		t.Errorf("Unexpected second migration %+v", second)
+	}
+}
+
+func TestLoadMigrationsInvalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"invalid name": {"m/create.sql": {Data: []byte("")}},
+		"missing up":   {"m/0001_a.down.sql": {Data: []byte("")}},
+		"two names": {
+			"m/0001_a.up.sql": {Data: []byte("")},
+			"m/0001_b.up.sql": {Data: []byte("")},
+		},
+	}
+
+	for name, fsys := range tests {
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	migrations, err := SchemaMigrations()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != uint64(i+1) {
+			t.Errorf("Expected version %d, got %d", i+1, migration.Version)
+		}
+		if migration.Down == "" {
+			t.Errorf("Migration %d_%s has no down", migration.Version, migration.Name)
+		}
+	}
+}
+
+// labelHook records the label and the operation of every event
+type labelHook struct {
+	events []string
+}
+
+func (hook *labelHook) Before(ctx context.Context, event *QueryEvent) context.Context {
+	return ctx
+}
+
+func (hook *labelHook) After(ctx context.Context, event *QueryEvent) {
+	hook.events = append(hook.events, QueryLabel(ctx)+" "+event.Op)
+}
+
+func TestMigratorHooks(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+	hook := &labelHook{}
+	conn.AddHook(hook)
+
+	mock.ExpectExec("SELECT pg_advisory_lock($1)").WithArgs(migrationLockKey)
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations")
+	mock.ExpectQuery("SELECT version, name, applied_at FROM schema_migrations").
+		WillReturnRows(dbtest.NewRows("version", "name", "applied_at"))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE a ()")
+	mock.ExpectExec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)").WithArgs(1, "first")
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock($1)").WithArgs(migrationLockKey)
+
+	migrator := NewMigrator(conn, []Migration{{Version: 1, Name: "first", Up: "CREATE TABLE a ();"}})
+	done, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if len(done) != 1 {
+		t.Errorf("Expected 1 applied migration, got %d", len(done))
+	}
+
+	expected := []string{
+		"migrations.up exec", "migrations.up exec", "migrations.up query", "migrations.up begin",
+		"migrations.up exec", "migrations.up exec", "migrations.up commit", "migrations.up exec",
+	}
+	if !reflect.DeepEqual(hook.events, expected) {
+		t.Errorf("Expected %v, got %v", expected, hook.events)
+	}
+}
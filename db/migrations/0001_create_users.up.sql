@@ -0,0 +1,18 @@
+CREATE TABLE users (
+	id                  BIGSERIAL PRIMARY KEY,
+	roles               BIGINT NOT NULL DEFAULT 1,
+	username            TEXT NOT NULL,
+	password            TEXT NOT NULL,
+	email               TEXT NOT NULL,
+	name                TEXT,
+	icon_address        TEXT,
+	enabled             BOOLEAN NOT NULL DEFAULT TRUE,
+	deleted             BOOLEAN NOT NULL DEFAULT FALSE,
+	must_reset_password BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- usernames and emails are unique regardless of their case
+CREATE UNIQUE INDEX users_username_key ON users (lower(username));
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
@@ -0,0 +1,17 @@
+CREATE TABLE posts (
+	id           BIGSERIAL PRIMARY KEY,
+	author_id    BIGINT NOT NULL REFERENCES users (id),
+	title        TEXT NOT NULL,
+	slug         TEXT NOT NULL CONSTRAINT posts_slug_key UNIQUE,
+	body         TEXT NOT NULL DEFAULT '',
+	excerpt      TEXT NOT NULL DEFAULT '',
+	status       TEXT NOT NULL DEFAULT 'draft'
+		CONSTRAINT posts_status_check CHECK (status IN ('draft', 'published', 'archived')),
+	published_at TIMESTAMPTZ,
+	version      BIGINT NOT NULL DEFAULT 1,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX posts_author_id_idx ON posts (author_id);
+CREATE INDEX posts_status_created_at_idx ON posts (status, created_at DESC, id DESC);
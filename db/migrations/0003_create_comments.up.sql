@@ -0,0 +1,19 @@
+CREATE TABLE comments (
+	id           BIGSERIAL PRIMARY KEY,
+	post_id      BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	parent_id    BIGINT REFERENCES comments (id) ON DELETE CASCADE,
+	-- the materialised path is compared byte by byte, so the dots between
+	-- the ids are not ignored by the collation of the database
+	path         TEXT COLLATE "C" NOT NULL,
+	author_id    BIGINT REFERENCES users (id),
+	author_name  TEXT NOT NULL,
+	author_email TEXT NOT NULL DEFAULT '',
+	body         TEXT NOT NULL,
+	state        TEXT NOT NULL DEFAULT 'pending'
+		CONSTRAINT comments_state_check CHECK (state IN ('pending', 'approved', 'spam', 'deleted')),
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX comments_post_id_path_idx ON comments (post_id, path);
+CREATE INDEX comments_state_created_at_idx ON comments (state, created_at DESC, id DESC);
@@ -0,0 +1,22 @@
+CREATE TABLE jobs (
+	id           BIGSERIAL PRIMARY KEY,
+	kind         TEXT NOT NULL,
+	payload      JSONB NOT NULL DEFAULT '{}',
+	-- done jobs are deleted, dead jobs wait to be retried or deleted by hand
+	state        TEXT NOT NULL DEFAULT 'pending'
+		CONSTRAINT jobs_state_check CHECK (state IN ('pending', 'running', 'dead')),
+	unique_key   TEXT,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 10,
+	run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	locked_at    TIMESTAMPTZ,
+	last_error   TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX jobs_ready_idx ON jobs (run_at, id) WHERE state = 'pending';
+CREATE INDEX jobs_running_idx ON jobs (locked_at) WHERE state = 'running';
+-- a unique key is taken only while its job waits or runs
+CREATE UNIQUE INDEX jobs_kind_unique_key_idx ON jobs (kind, unique_key)
+	WHERE unique_key IS NOT NULL AND state IN ('pending', 'running');
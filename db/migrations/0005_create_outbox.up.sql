@@ -0,0 +1,25 @@
+CREATE TABLE outbox (
+	id            BIGSERIAL PRIMARY KEY,
+	topic         TEXT NOT NULL,
+	-- key is the idempotency key of the event, a second write is ignored
+	key           TEXT NOT NULL CONSTRAINT outbox_key_key UNIQUE,
+	payload       JSONB NOT NULL DEFAULT '{}',
+	attempts      INTEGER NOT NULL DEFAULT 0,
+	last_error    TEXT NOT NULL DEFAULT '',
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+	dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE dispatched_at IS NULL;
+CREATE INDEX outbox_dispatched_idx ON outbox (dispatched_at) WHERE dispatched_at IS NOT NULL;
+
+-- wakes up the relay when the transaction that wrote events commits
+CREATE FUNCTION outbox_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('outbox', '');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_notify AFTER INSERT ON outbox
+	FOR EACH STATEMENT EXECUTE PROCEDURE outbox_notify();
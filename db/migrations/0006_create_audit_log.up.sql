@@ -0,0 +1,30 @@
+CREATE TABLE audit_log (
+	id          BIGSERIAL PRIMARY KEY,
+	created_at  TIMESTAMPTZ NOT NULL,
+	-- actor_id is 0 for anonymous and system actions
+	actor_id    BIGINT NOT NULL DEFAULT 0,
+	actor       TEXT NOT NULL DEFAULT '',
+	action      TEXT NOT NULL,
+	target_type TEXT NOT NULL DEFAULT '',
+	target_id   TEXT NOT NULL DEFAULT '',
+	-- JSON keeps the text that was hashed, JSONB would normalize it
+	diff        JSON NOT NULL DEFAULT '{}',
+	ip          TEXT NOT NULL DEFAULT '',
+	request_id  TEXT NOT NULL DEFAULT '',
+	prev_hash   TEXT NOT NULL DEFAULT '',
+	hash        TEXT NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at, id);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id, created_at);
+CREATE INDEX audit_log_action_idx ON audit_log (action, created_at);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id, created_at);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
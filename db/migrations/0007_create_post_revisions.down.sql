@@ -0,0 +1,2 @@
+DROP TABLE post_revisions;
+DROP FUNCTION post_revisions_immutable();
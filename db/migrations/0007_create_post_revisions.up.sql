@@ -0,0 +1,22 @@
+CREATE TABLE post_revisions (
+	id         BIGSERIAL PRIMARY KEY,
+	post_id    BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	-- version is the version of the post that the revision was saved as
+	version    BIGINT NOT NULL,
+	title      TEXT NOT NULL,
+	body       TEXT NOT NULL,
+	-- editor_id is the user that saved the revision
+	editor_id  BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CONSTRAINT post_revisions_post_version_key UNIQUE (post_id, version)
+);
+
+-- revisions are immutable
+CREATE FUNCTION post_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'post revisions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_revisions_immutable BEFORE UPDATE ON post_revisions
+	FOR EACH ROW EXECUTE PROCEDURE post_revisions_immutable();
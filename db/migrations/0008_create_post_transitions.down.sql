@@ -0,0 +1,10 @@
+DROP TABLE post_transitions;
+
+DROP INDEX posts_scheduled_publish_at_idx;
+ALTER TABLE posts DROP COLUMN publish_at;
+
+UPDATE posts SET status = 'draft'
+	WHERE status IN ('in_review', 'changes_requested', 'approved', 'scheduled');
+ALTER TABLE posts DROP CONSTRAINT posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+	CHECK (status IN ('draft', 'published', 'archived'));
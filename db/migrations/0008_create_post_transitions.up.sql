@@ -0,0 +1,27 @@
+ALTER TABLE posts DROP CONSTRAINT posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check CHECK (status IN (
+	'draft', 'in_review', 'changes_requested', 'approved', 'scheduled',
+	'published', 'archived'
+));
+
+-- publish_at is the time that a scheduled post is published at
+ALTER TABLE posts ADD COLUMN publish_at TIMESTAMPTZ;
+
+CREATE INDEX posts_scheduled_publish_at_idx ON posts (publish_at)
+	WHERE status = 'scheduled';
+
+-- post_transitions is the history of the workflow of the posts
+CREATE TABLE post_transitions (
+	id          BIGSERIAL PRIMARY KEY,
+	post_id     BIGINT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+	action      TEXT NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status   TEXT NOT NULL,
+	-- actor_id is 0 for transitions of the scheduler
+	actor_id    BIGINT NOT NULL DEFAULT 0,
+	comment     TEXT NOT NULL DEFAULT '',
+	publish_at  TIMESTAMPTZ,
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX post_transitions_post_id_idx ON post_transitions (post_id, id);
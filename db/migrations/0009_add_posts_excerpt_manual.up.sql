@@ -0,0 +1,10 @@
+-- excerpt_manual marks an excerpt that was written by hand, any other excerpt
+-- is generated from the body whenever the post is saved
+ALTER TABLE posts ADD COLUMN excerpt_manual BOOLEAN NOT NULL DEFAULT false;
+
+-- an existing excerpt is kept when it is not the one that the body generates
+UPDATE posts SET excerpt_manual = true
+WHERE excerpt <> ''
+	AND excerpt <> regexp_replace(btrim(body, E' \t\r\n'), '\s+', ' ', 'g')
+	AND NOT (right(excerpt, 1) = '…'
+		AND position(left(excerpt, -1) IN regexp_replace(btrim(body, E' \t\r\n'), '\s+', ' ', 'g')) = 1);
@@ -0,0 +1,4 @@
+DROP INDEX outbox_pending_idx;
+ALTER TABLE outbox DROP COLUMN parked_at;
+ALTER TABLE outbox DROP COLUMN txid;
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE dispatched_at IS NULL;
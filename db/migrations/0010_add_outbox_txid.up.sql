@@ -0,0 +1,12 @@
+-- txid is the transaction that wrote the event. The ids of concurrent
+-- transactions are not assigned in the order they commit, so the relay orders
+-- the events by txid, and reads only those of transactions that are older than
+-- every transaction in progress, where no event may be added before them.
+ALTER TABLE outbox ADD COLUMN txid BIGINT NOT NULL DEFAULT txid_current();
+
+-- parked_at marks an event that failed MaxAttempts times, that is no longer
+-- delivered nor blocks the events after it
+ALTER TABLE outbox ADD COLUMN parked_at TIMESTAMPTZ;
+
+DROP INDEX outbox_pending_idx;
+CREATE INDEX outbox_pending_idx ON outbox (txid, id) WHERE dispatched_at IS NULL AND parked_at IS NULL;
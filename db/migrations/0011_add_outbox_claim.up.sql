@@ -0,0 +1,4 @@
+-- claimed_until marks the events of a batch that a relay delivers outside of a
+-- transaction. No other relay dispatches while a claim holds, and an expired
+-- claim of a relay that stopped is delivered again.
+ALTER TABLE outbox ADD COLUMN claimed_until TIMESTAMPTZ;
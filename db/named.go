@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// BindNamed converts a query with named parameters, such as :username, to a
+// PostgreSQL positional query, and returns the arguments by their position.
+//
+// arg is a struct (or a pointer to one) whose fields are matched by their db
+// tag, or a map[string]interface{}. A parameter that is used more than once
+// is passed once. Casts (::) and quoted strings are left as is.
+func BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	lookup, err := namedLookup(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	args := make([]interface{}, 0)
+	positions := make(map[string]int)
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		// copy quoted strings and identifiers as is
+		if r == '\'' || r == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				end = len(runes) - 1
+			}
+			b.WriteString(string(runes[i : end+1]))
+			i = end
+			continue
+		}
+
+		if r != ':' {
+			b.WriteRune(r)
+			continue
+		}
+		// a cast
+		if i+1 < len(runes) && runes[i+1] == ':' {
+			b.WriteString("::")
+			i++
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isNameRune(runes[end], end == i+1) {
+			end++
+		}
+		if end == i+1 {
+			b.WriteRune(r)
+			continue
+		}
+
+		name := string(runes[i+1 : end])
+		position, ok := positions[name]
+		if !ok {
+			value, found := lookup(name)
+			if !found {
+				return "", nil, fmt.Errorf("missing value for named parameter :%s", name)
+			}
+			args = append(args, value)
+			position = len(args)
+			positions[name] = position
+		}
+		fmt.Fprintf(&b, "$%d", position)
+		i = end - 1
+	}
+
+	return b.String(), args, nil
+}
+
+func isNameRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+	return !first && r >= '0' && r <= '9'
+}
+
+// namedLookup returns a function that finds a named value at arg
+func namedLookup(arg interface{}) (func(string) (interface{}, bool), error) {
+	if m, ok := arg.(map[string]interface{}); ok {
+		return func(name string) (interface{}, bool) {
+			value, found := m[name]
+			return value, found
+		}, nil
+	}
+
+	value := reflect.ValueOf(arg)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("named arguments must be a struct or a map, got %T", arg)
+	}
+
+	fields := fieldsOf(value.Type())
+	return func(name string) (interface{}, bool) {
+		index, ok := fields[name]
+		if !ok {
+			return nil, false
+		}
+		field, ok := readFieldByIndex(value, index)
+		if !ok {
+			return nil, true
+		}
+		return field.Interface(), true
+	}, nil
+}
+
+// readFieldByIndex returns a nested field, or false if a nil embedded pointer
+// is on the way
+func readFieldByIndex(value reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return value, false
+			}
+			value = value.Elem()
+		}
+		value = value.Field(x)
+	}
+	return value, true
+}
+
+// InsertQuery builds an INSERT statement with named parameters for all the db
+// tagged fields of arg, except for the skipped columns, such as generated ids.
+// The columns are ordered as the fields of the struct.
+func InsertQuery(table string, arg interface{}, skip ...string) string {
+	t := reflect.TypeOf(arg)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, column := range skip {
+		skipped[column] = true
+	}
+
+	fields := fieldsOf(t)
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		if !skipped[column] {
+			columns = append(columns, column)
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		a, b := fields[columns[i]], fields[columns[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (:%s)", table,
+		strings.Join(columns, ", "), strings.Join(columns, ", :"))
+}
+
+// NamedExec executes a query with named parameters that are taken from arg.
+//
+// The named queries are always sent to the primary, as they are mostly
+// writes with a RETURNING clause.
+func (conn *Conn) NamedExec(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	return namedExec(ctx, conn.queryer(conn.db), query, arg)
+}
+
+// NamedGet works as Get for a query with named parameters that are taken from
+// arg, arg and dest may be the same struct
+func (conn *Conn) NamedGet(ctx context.Context, dest interface{}, query string, arg interface{}) error {
+	return namedGet(ctx, conn.queryer(conn.db), dest, query, arg)
+}
+
+// NamedSelect works as Select for a query with named parameters that are taken
+// from arg
+func (conn *Conn) NamedSelect(ctx context.Context, dest interface{}, query string, arg interface{}) error {
+	return namedSelect(ctx, conn.queryer(conn.db), dest, query, arg)
+}
+
+func namedExec(ctx context.Context, q queryer, query string, arg interface{}) (sql.Result, error) {
+	bound, args, err := BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return q.ExecContext(ctx, bound, args...)
+}
+
+func namedGet(ctx context.Context, q queryer, dest interface{}, query string, arg interface{}) error {
+	bound, args, err := BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return get(ctx, q, dest, bound, args...)
+}
+
+func namedSelect(ctx context.Context, q queryer, dest interface{}, query string, arg interface{}) error {
+	bound, args, err := BindNamed(query, arg)
+	if err != nil {
+		return err
+	}
+	return selectRows(ctx, q, dest, bound, args...)
+}
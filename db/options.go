@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSL modes that are supported by the driver
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Values of target_session_attrs
+var targetSessionAttrs = []string{"any", "read-write", "read-only", "primary", "standby"}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ConnOptions holds the optional settings of a connection, each field is
+// named by its libpq keyword at the pg tag. Zero values are not sent.
+type ConnOptions struct {
+	SSLMode     string `pg:"sslmode"`
+	SSLCert     string `pg:"sslcert"`
+	SSLKey      string `pg:"sslkey"`
+	SSLRootCert string `pg:"sslrootcert"`
+	// SSLPassword decrypts an encrypted (PEM) SSLKey
+	SSLPassword string `pg:"sslpassword"`
+
+	// ConnectTimeout is sent as whole seconds, rounded up
+	ConnectTimeout  time.Duration `pg:"connect_timeout" unit:"s"`
+	ApplicationName string        `pg:"application_name"`
+	// TargetSessionAttrs is one of any, read-write, read-only, primary or
+	// standby, and it is checked by Open
+	TargetSessionAttrs string `pg:"target_session_attrs"`
+
+	// StatementTimeout is sent as milliseconds
+	StatementTimeout time.Duration `pg:"statement_timeout" unit:"ms"`
+	SearchPath       []string      `pg:"search_path"`
+	BinaryParameters bool          `pg:"binary_parameters"`
+}
+
+// PGSSLFields is the former name of ConnOptions
+type PGSSLFields = ConnOptions
+
+// optionEnvVars maps the libpq environment variables to the pg tags of
+// ConnOptions
+var optionEnvVars = map[string]string{
+	"PGSSLMODE":            "sslmode",
+	"PGSSLCERT":            "sslcert",
+	"PGSSLKEY":             "sslkey",
+	"PGSSLROOTCERT":        "sslrootcert",
+	"PGCONNECT_TIMEOUT":    "connect_timeout",
+	"PGAPPNAME":            "application_name",
+	"PGTARGETSESSIONATTRS": "target_session_attrs",
+}
+
+// String returns the fields settings for using them as connections
+func (options *ConnOptions) String() string {
+	structElements := reflect.ValueOf(options).Elem()
+	fieldsLen := structElements.NumField()
+
+	tmp := make([]string, 0, fieldsLen)
+	for i := 0; i < fieldsLen; i++ {
+		fieldValue := structElements.Field(i)
+		fieldType := structElements.Type().Field(i)
+
+		if fieldValue.IsZero() {
+			continue
+		}
+		value := formatOption(fieldValue, fieldType.Tag.Get("unit"))
+		tmp = append(tmp, fieldType.Tag.Get("pg")+"="+quoteDSNValue(value))
+	}
+
+	return strings.Join(tmp, " ") // return a space separated fields
+}
+
+// formatOption returns the connection string value of a field
+func formatOption(value reflect.Value, unit string) string {
+	if value.Type() == durationType {
+		d := time.Duration(value.Int())
+		if unit == "s" {
+			return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+		}
+		return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Bool:
+		if value.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = formatOption(value.Index(i), unit)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// set sets a field of the options by its pg tag, parsing value by the type
+// of the field
+func (options *ConnOptions) set(key, value string) error {
+	structElements := reflect.ValueOf(options).Elem()
+	for i := 0; i < structElements.NumField(); i++ {
+		fieldType := structElements.Type().Field(i)
+		if fieldType.Tag.Get("pg") != key {
+			continue
+		}
+
+		if err := parseOption(structElements.Field(i), value, fieldType.Tag.Get("unit")); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		return nil
+	}
+	return errors.New("unsupported connection option " + key)
+}
+
+// parseOption sets field from its connection string value. Durations accept
+// either a Go duration ("5s") or a number in the unit of the field.
+func parseOption(field reflect.Value, value, unit string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			n, numErr := strconv.ParseInt(value, 10, 64)
+			if numErr != nil {
+				return err
+			}
+			d = time.Duration(n) * time.Millisecond
+			if unit == "s" {
+				d = time.Duration(n) * time.Second
+			}
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(field.Type(), 0, len(items))
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := parseOption(elem, item, unit); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// parseBool parses the boolean values that PostgreSQL accepts
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, errors.New("not a boolean")
+}
+
+// Validate checks the values of the options, and that the referenced
+// certificate and key files exist
+func (options *ConnOptions) Validate() error {
+	if options.SSLMode != "" && !contains(sslModes, options.SSLMode) {
+		return fmt.Errorf("unsupported sslmode %q, expected one of %s",
+			options.SSLMode, strings.Join(sslModes, ", "))
+	}
+	if options.TargetSessionAttrs != "" && !contains(targetSessionAttrs, options.TargetSessionAttrs) {
+		return fmt.Errorf("unsupported target_session_attrs %q, expected one of %s",
+			options.TargetSessionAttrs, strings.Join(targetSessionAttrs, ", "))
+	}
+
+	files := []struct{ key, path string }{
+		{"sslcert", options.SSLCert},
+		{"sslkey", options.SSLKey},
+		{"sslrootcert", options.SSLRootCert},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.key, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s: %s is a directory", file.key, file.path)
+		}
+	}
+	if options.SSLPassword != "" && options.SSLKey == "" {
+		return errors.New("sslpassword is set without sslkey")
+	}
+
+	if options.ConnectTimeout < 0 {
+		return errors.New("connect_timeout must not be negative")
+	}
+	if options.StatementTimeout < 0 {
+		return errors.New("statement_timeout must not be negative")
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSessionAttrs verifies that the server matches target_session_attrs
+func checkSessionAttrs(ctx context.Context, sqlDB *sql.DB, attrs string) error {
+	if attrs == "" || attrs == "any" {
+		return nil
+	}
+
+	var inRecovery, readOnly bool
+	err := sqlDB.QueryRowContext(ctx,
+		`SELECT pg_is_in_recovery(), current_setting('transaction_read_only') = 'on'`,
+	).Scan(&inRecovery, &readOnly)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	switch attrs {
+	case "read-write":
+		ok = !readOnly
+	case "read-only":
+		ok = readOnly
+	case "primary":
+		ok = !inRecovery
+	case "standby":
+		ok = inRecovery
+	}
+	if !ok {
+		return fmt.Errorf("server does not match target_session_attrs %s", attrs)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnOptionsString(t *testing.T) {
+	options := ConnOptions{
+		SSLMode:          "require",
+		ConnectTimeout:   1500 * time.Millisecond,
+		ApplicationName:  "go into",
+		StatementTimeout: 30 * time.Second,
+		SearchPath:       []string{"blog", "public"},
+		BinaryParameters: true,
+	}
+
+	expected := `sslmode=require connect_timeout=2 application_name='go into' ` +
+		`statement_timeout=30000 search_path=blog,public binary_parameters=yes`
+	if got := options.String(); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestConnOptionsStringEmpty(t *testing.T) {
+	options := ConnOptions{}
+
+	if got := options.String(); got != "" {
+		t.Errorf("Expected an empty string, got %s", got)
+	}
+}
+
+func TestConnOptionsSet(t *testing.T) {
+	config, err := ParseURL("postgres://localhost/blog?connect_timeout=10" +
+		"&statement_timeout=1m&search_path=blog,%20public&binary_parameters=on" +
+		"&application_name=go-into")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	options := config.Options
+	if options.ConnectTimeout != 10*time.Second {
+		t.Errorf("Expected connect_timeout of 10s, got %s", options.ConnectTimeout)
+	}
+	if options.StatementTimeout != time.Minute {
+		t.Errorf("Expected statement_timeout of 1m, got %s", options.StatementTimeout)
+	}
+	if strings.Join(options.SearchPath, "|") != "blog|public" {
+		t.Errorf("Expected search_path of blog and public, got %v", options.SearchPath)
+	}
+	if !options.BinaryParameters {
+		t.Error("Expected binary_parameters to be true")
+	}
+	if options.ApplicationName != "go-into" {
+		t.Errorf("Expected application_name go-into, got %s", options.ApplicationName)
+	}
+}
+
+func TestConnOptionsSetInvalid(t *testing.T) {
+	options := ConnOptions{}
+
+	if err := options.set("connect_timeout", "soon"); err == nil {
+		t.Error("Expected an error for an invalid duration")
+	}
+	if err := options.set("binary_parameters", "maybe"); err == nil {
+		t.Error("Expected an error for an invalid boolean")
+	}
+	if err := options.set("no_such_option", "1"); err == nil {
+		t.Error("Expected an error for an unknown option")
+	}
+}
+
+func TestConnOptionsValidate(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "client.crt")
+	if err := os.WriteFile(cert, []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := ConnOptions{SSLMode: "verify-full", SSLCert: cert, TargetSessionAttrs: "read-write"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	tests := map[string]ConnOptions{
+		"sslmode":              {SSLMode: "prefer"},
+		"target_session_attrs": {TargetSessionAttrs: "writable"},
+		"sslrootcert":          {SSLRootCert: filepath.Join(dir, "missing.crt")},
+		"sslkey":               {SSLKey: dir},
+		"sslpassword":          {SSLPassword: "secret"},
+		"statement_timeout":    {StatementTimeout: -time.Second},
+	}
+	for key, options := range tests {
+		err := options.Validate()
+		if err == nil {
+			t.Errorf("Expected an error for %s", key)
+			continue
+		}
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected the error to name %s, got %s", key, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// primaryKey is the context key of WithPrimary
+type primaryKey struct{}
+
+// replica is a pool of a streaming replica
+type replica struct {
+	db   *sql.DB
+	name string
+
+	// healthy is 1 while the replica is in the rotation
+	healthy int32
+	// lag is the last replay lag that was measured, in nanoseconds
+	lag int64
+}
+
+// ReplicaStatus is the state of a replica at the last health check
+type ReplicaStatus struct {
+	Name    string
+	Healthy bool
+	Lag     time.Duration
+}
+
+// WithPrimary returns a copy of ctx that sends the reads of Conn to the
+// primary, for reading a write that the replicas may not have replayed yet
+func WithPrimary(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary returns true if ctx was returned by WithPrimary
+func UsesPrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// reader returns the pool for a read: the next healthy replica, or the
+// primary if ctx asks for it or no replica is healthy
+func (conn *Conn) reader(ctx context.Context) *sql.DB {
+	if len(conn.replicas) == 0 || UsesPrimary(ctx) {
+		return conn.db
+	}
+
+	count := uint32(len(conn.replicas))
+	start := atomic.AddUint32(&conn.nextReplica, 1)
+	for i := uint32(0); i < count; i++ {
+		r := conn.replicas[(start+i)%count]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.db
+		}
+	}
+	return conn.db
+}
+
+// Replicas returns the state of the replicas
+func (conn *Conn) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, len(conn.replicas))
+	for i, r := range conn.replicas {
+		statuses[i] = ReplicaStatus{
+			Name:    r.name,
+			Healthy: atomic.LoadInt32(&r.healthy) == 1,
+			Lag:     time.Duration(atomic.LoadInt64(&r.lag)),
+		}
+	}
+	return statuses
+}
+
+// monitorReplicas checks the replicas until the connection is closed
+func (conn *Conn) monitorReplicas() {
+	interval := conn.config.ReplicaCheckInterval
+	if interval <= 0 {
+		interval = DefaultReplicaCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			return
+		case <-ticker.C:
+			conn.checkReplicas(conn.ctx)
+		}
+	}
+}
+
+// checkReplicas updates the health of all replicas
+func (conn *Conn) checkReplicas(ctx context.Context) {
+	maxLag := conn.config.MaxReplicaLag
+	if maxLag <= 0 {
+		maxLag = DefaultMaxReplicaLag
+	}
+	timeout := conn.config.ReplicaCheckInterval
+	if timeout <= 0 {
+		timeout = DefaultReplicaCheckInterval
+	}
+
+	for _, r := range conn.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		lag, err := replicaLag(checkCtx, r.db)
+		cancel()
+
+		healthy := int32(0)
+		if err == nil && lag <= maxLag {
+			healthy = 1
+		}
+		atomic.StoreInt64(&r.lag, int64(lag))
+		atomic.StoreInt32(&r.healthy, healthy)
+	}
+}
+
+// replicaLag returns the replay lag of a replica. A replica that replayed
+// everything it received has no lag, even if the primary was idle since.
+func replicaLag(ctx context.Context, sqlDB *sql.DB) (time.Duration, error) {
+	var seconds float64
+	err := sqlDB.QueryRowContext(ctx,
+		`SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`,
+	).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
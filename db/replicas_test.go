@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func newReplicaTestConn(t *testing.T, replicas int) *Conn {
+	t.Helper()
+
+	open := func() *sql.DB {
+		// sql.Open does not connect, so no server is required
+		sqlDB, err := sql.Open("postgres", "host=localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sqlDB.Close() })
+		return sqlDB
+	}
+
+	conn := &Conn{db: open()}
+	for i := 0; i < replicas; i++ {
+		conn.replicas = append(conn.replicas, &replica{db: open(), healthy: 1})
+	}
+	return conn
+}
+
+func TestReaderRoundRobin(t *testing.T) {
+	conn := newReplicaTestConn(t, 2)
+
+	first := conn.reader(context.Background())
+	second := conn.reader(context.Background())
+	third := conn.reader(context.Background())
+
+	if first == conn.db || second == conn.db {
+		t.Error("Expected the reads to go to the replicas")
+	}
+	if first == second {
+		t.Error("Expected the replicas to be used in turns")
+	}
+	if third != first {
+		t.Error("Expected the rotation to start over")
+	}
+}
+
+func TestReaderSkipsUnhealthy(t *testing.T) {
+	conn := newReplicaTestConn(t, 2)
+	conn.replicas[0].healthy = 0
+
+	for i := 0; i < 4; i++ {
+		if got := conn.reader(context.Background()); got != conn.replicas[1].db {
+			t.Errorf("Expected the healthy replica on read %d", i)
+		}
+	}
+
+	conn.replicas[1].healthy = 0
+	if conn.reader(context.Background()) != conn.db {
+		t.Error("Expected the primary when no replica is healthy")
+	}
+}
+
+func TestReaderWithPrimary(t *testing.T) {
+	conn := newReplicaTestConn(t, 2)
+
+	if conn.reader(WithPrimary(context.Background())) != conn.db {
+		t.Error("Expected the primary for a WithPrimary context")
+	}
+	if conn.reader(nil) == conn.db {
+		t.Error("Expected a replica for a nil context")
+	}
+}
+
+func TestUsesPrimary(t *testing.T) {
+	if UsesPrimary(context.Background()) {
+		t.Error("Expected false for a plain context")
+	}
+	if !UsesPrimary(WithPrimary(nil)) {
+		t.Error("Expected true for WithPrimary of a nil context")
+	}
+}
+
+func TestReaderWithoutReplicas(t *testing.T) {
+	conn := newReplicaTestConn(t, 0)
+
+	if conn.reader(context.Background()) != conn.db {
+		t.Error("Expected the primary without replicas")
+	}
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// queryer is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// fieldMap maps a column name to the index of the struct field that holds it
+type fieldMap map[string][]int
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+	// fieldMaps caches the fieldMap of every struct type that was used
+	fieldMaps sync.Map
+)
+
+// Get executes a query that returns a single row, and scans it into dest.
+//
+// dest is a pointer to a struct, whose fields are matched to the columns by
+// their db tag, or a pointer to a single value for a single column. If there
+// are no rows, sql.ErrNoRows is returned.
+//
+// As Conn.Query, the query is sent to a replica unless ctx was returned by
+// WithPrimary.
+func (conn *Conn) Get(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return get(ctx, conn.queryer(conn.reader(ctx)), dest, query, args...)
+}
+
+// Select executes a query, and appends all rows into dest, a pointer to a
+// slice of structs (or pointers to structs) or of single values.
+//
+// As Conn.Query, the query is sent to a replica unless ctx was returned by
+// WithPrimary.
+func (conn *Conn) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return selectRows(ctx, conn.queryer(conn.reader(ctx)), dest, query, args...)
+}
+
+func get(ctx context.Context, q queryer, dest interface{}, query string, args ...interface{}) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("destination must be a non nil pointer")
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := scanRow(rows, value.Elem()); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+func selectRows(ctx context.Context, q queryer, dest interface{}, query string, args ...interface{}) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice {
+		return errors.New("destination must be a non nil pointer to a slice")
+	}
+	slice := value.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := scanRow(rows, elem.Elem()); err != nil {
+			return err
+		}
+		if !isPtr {
+			elem = elem.Elem()
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	return rows.Err()
+}
+
+// scanRow scans the current row into value
+func scanRow(rows *sql.Rows, value reflect.Value) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if !isStruct(value.Type()) {
+		if len(columns) != 1 {
+			return fmt.Errorf("scanning %d columns into a single %s", len(columns), value.Type())
+		}
+		return rows.Scan(value.Addr().Interface())
+	}
+
+	fields := fieldsOf(value.Type())
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		index, ok := fields[column]
+		if !ok {
+			return fmt.Errorf("missing destination for column %s at %s", column, value.Type())
+		}
+		targets[i] = fieldByIndex(value, index).Addr().Interface()
+	}
+	return rows.Scan(targets...)
+}
+
+// isStruct returns true for structs that are scanned field by field, and not
+// as a single value such as time.Time or sql.NullString
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(scannerType) &&
+		t.PkgPath() != "time"
+}
+
+// fieldsOf returns the cached fieldMap of a struct type
+func fieldsOf(t reflect.Type) fieldMap {
+	if fields, ok := fieldMaps.Load(t); ok {
+		return fields.(fieldMap)
+	}
+
+	fields := make(fieldMap)
+	mapFields(t, nil, fields)
+	fieldMaps.Store(t, fields)
+	return fields
+}
+
+// mapFields adds the fields of t to fields. Embedded structs are flattened,
+// and fields of the outer struct win over the embedded ones.
+func mapFields(t reflect.Type, parent []int, fields fieldMap) {
+	embedded := make([]reflect.StructField, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && tag == "" && isStruct(fieldType) {
+			embedded = append(embedded, field)
+			continue
+		}
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = append(append([]int{}, parent...), i)
+	}
+
+	for _, field := range embedded {
+		inner := make(fieldMap)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		mapFields(fieldType, append(append([]int{}, parent...), field.Index...), inner)
+		for name, index := range inner {
+			if _, ok := fields[name]; !ok {
+				fields[name] = index
+			}
+		}
+	}
+}
+
+// fieldByIndex returns a nested field, allocating nil embedded pointers on
+// the way
+func fieldByIndex(value reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(x)
+	}
+	return value
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+type scanBase struct {
+	ID        uint64    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type scanUser struct {
+	scanBase
+	Username string         `db:"username"`
+	Name     sql.NullString `db:"name"`
+	Ignored  string         `db:"-"`
+	Email    string
+	secret   string
+}
+
+func TestFieldsOf(t *testing.T) {
+	fields := fieldsOf(reflect.TypeOf(scanUser{}))
+
+	expected := fieldMap{
+		"id":         {0, 0},
+		"created_at": {0, 1},
+		"username":   {1},
+		"name":       {2},
+		"email":      {4},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+
+	if cached := fieldsOf(reflect.TypeOf(scanUser{})); reflect.ValueOf(cached).Pointer() != reflect.ValueOf(fields).Pointer() {
+		t.Error("Expected field map to be cached")
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	user := scanUser{Username: "admin"}
+	user.ID = 7
+
+	query, args, err := BindNamed(
+		`SELECT ':skip', "col:x", $1::text FROM users WHERE id = :id OR (username = :username AND id <> :id)`,
+		&user,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	expectedQuery := `SELECT ':skip', "col:x", $1::text FROM users WHERE id = $1 OR (username = $2 AND id <> $1)`
+	if query != expectedQuery {
+		t.Errorf("Expected %s, got %s", expectedQuery, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{uint64(7), "admin"}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
+
+func TestBindNamedMap(t *testing.T) {
+	query, args, err := BindNamed(`SELECT :a, :b`, map[string]interface{}{"a": 1, "b": "2"})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if query != `SELECT $1, $2` || !reflect.DeepEqual(args, []interface{}{1, "2"}) {
+		t.Errorf("Unexpected query %s with %v", query, args)
+	}
+}
+
+func TestBindNamedMissing(t *testing.T) {
+	if _, _, err := BindNamed(`SELECT :unknown`, scanUser{}); err == nil {
+		t.Error("Expected an error for an unknown parameter")
+	}
+	if _, _, err := BindNamed(`SELECT :a`, 7); err == nil {
+		t.Error("Expected an error for an invalid argument")
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	query := InsertQuery("users", &scanUser{}, "id", "created_at")
+
+	expected := `INSERT INTO users (username, name, email) VALUES (:username, :name, :email)`
+	if query != expected {
+		t.Errorf("Expected %s, got %s", expected, query)
+	}
+}
+
+func TestScanNilContext(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectQuery("SELECT id, username FROM users WHERE id").WithArgs(7).
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(7, "admin"))
+	mock.ExpectQuery("SELECT id, username FROM users").
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(7, "admin"))
+	mock.ExpectExec("UPDATE users SET username").WithArgs("admin", uint64(7)).
+		WillReturnResult(dbtest.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, username FROM users WHERE id").WithArgs(uint64(7)).
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(7, "admin"))
+	mock.ExpectQuery("SELECT id, username FROM users WHERE username").WithArgs("admin").
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(7, "admin"))
+
+	var user scanUser
+	if err := conn.Get(nil, &user, `SELECT id, username FROM users WHERE id = $1`, 7); err != nil {
+		t.Errorf("Unexpected error of Get: %s", err)
+	}
+	var users []scanUser
+	if err := conn.Select(nil, &users, `SELECT id, username FROM users`); err != nil {
+		t.Errorf("Unexpected error of Select: %s", err)
+	}
+	if _, err := conn.NamedExec(nil, `UPDATE users SET username = :username WHERE id = :id`, &user); err != nil {
+		t.Errorf("Unexpected error of NamedExec: %s", err)
+	}
+	if err := conn.NamedGet(nil, &user, `SELECT id, username FROM users WHERE id = :id`, &user); err != nil {
+		t.Errorf("Unexpected error of NamedGet: %s", err)
+	}
+	if err := conn.NamedSelect(nil, &users, `SELECT id, username FROM users WHERE username = :username`, &user); err != nil {
+		t.Errorf("Unexpected error of NamedSelect: %s", err)
+	}
+	if user.ID != 7 || len(users) != 2 {
+		t.Errorf("Expected the rows to be scanned, got %+v and %d users", user, len(users))
+	}
+}
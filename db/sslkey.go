@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// sslKey is a decrypted SSL key, that is kept in memory and written to a
+// private directory only while the driver dials and loads it.
+//
+// The driver reads the key from the sslkey file before it writes the first
+// packet to a new connection, so the file is removed on that write.
+type sslKey struct {
+	mutex sync.Mutex
+	dir   string
+	path  string
+	pem   []byte
+	// dials is the number of dials that may still read the file
+	dials int
+}
+
+// decryptKey decrypts the PEM key at path with password. The decrypted key
+// is written to a directory that only the current user can access, and only
+// while a connection is dialed.
+func decryptKey(path, password string) (*sslKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sslkey: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("sslkey: %s is not a PEM file", path)
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, fmt.Errorf("sslkey: %s is an encrypted PKCS#8 key, which is not supported, "+
+			"decrypt it with openssl or encrypt it as a PKCS#1 or SEC 1 key", path)
+	}
+	// the legacy PEM encryption of PKCS#1 and SEC 1 keys is the only one
+	// that libpq and the standard library both support
+	if !x509.IsEncryptedPEMBlock(block) {
+		return nil, fmt.Errorf("sslkey: %s is not encrypted, or uses an unsupported encryption", path)
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("sslkey: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "sslkey-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &sslKey{
+		dir:  dir,
+		path: filepath.Join(dir, "client.key"),
+		pem:  pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}),
+	}, nil
+}
+
+// acquire writes the key file for a dial, unless another dial already did
+func (k *sslKey) acquire() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.dials == 0 {
+		if err := os.WriteFile(k.path, k.pem, 0600); err != nil {
+			return fmt.Errorf("sslkey: %w", err)
+		}
+	}
+	k.dials++
+	return nil
+}
+
+// release removes the key file once no dial may read it
+func (k *sslKey) release() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.dials--
+	if k.dials == 0 {
+		_ = os.Remove(k.path)
+	}
+}
+
+// remove removes the directory of the key
+func (k *sslKey) remove() {
+	_ = os.RemoveAll(k.dir)
+}
+
+// keyDialer is a pq.Dialer that writes the key file for every dial
+type keyDialer struct {
+	key    *sslKey
+	dialer net.Dialer
+}
+
+func (d *keyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *keyDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *keyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := d.key.acquire(); err != nil {
+		return nil, err
+	}
+	c, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		d.key.release()
+		return nil, err
+	}
+	return &keyConn{Conn: c, key: d.key}, nil
+}
+
+// keyConn releases the key file on its first write, or when it is closed
+// before writing
+type keyConn struct {
+	net.Conn
+	key  *sslKey
+	once sync.Once
+}
+
+func (c *keyConn) Write(b []byte) (int, error) {
+	c.once.Do(c.key.release)
+	return c.Conn.Write(b)
+}
+
+func (c *keyConn) Close() error {
+	c.once.Do(c.key.release)
+	return c.Conn.Close()
+}
+
+// dialConnector is a driver.Connector of pq that dials with a dialer
+type dialConnector struct {
+	dialer pq.Dialer
+	dsn    string
+}
+
+func (c dialConnector) Connect(context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+func (c dialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKey(t *testing.T, block *pem.Block) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "client.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecryptKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der,
+		[]byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := decryptKey(writeKey(t, block), "secret")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer decrypted.remove()
+
+	info, err := os.Stat(decrypted.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected mode 0700, got %s", info.Mode().Perm())
+	}
+	if _, err := os.Stat(decrypted.path); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file before a dial, got %v", err)
+	}
+
+	plain, _ := pem.Decode(decrypted.pem)
+	if plain == nil || x509.IsEncryptedPEMBlock(plain) {
+		t.Fatal("Expected a decrypted PEM block")
+	}
+	if _, err := x509.ParseECPrivateKey(plain.Bytes); err != nil {
+		t.Errorf("Unexpected error parsing the decrypted key: %s", err)
+	}
+}
+
+func TestDecryptKeyPKCS8(t *testing.T) {
+	path := writeKey(t, &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte("key")})
+	_, err := decryptKey(path, "secret")
+	if err == nil || !strings.Contains(err.Error(), "PKCS#8") {
+		t.Errorf("Expected an error of an unsupported PKCS#8 key, got %v", err)
+	}
+}
+
+func TestKeyDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	dir := t.TempDir()
+	key := &sslKey{dir: dir, path: filepath.Join(dir, "client.key"), pem: []byte("key")}
+	dialer := &keyDialer{key: key}
+
+	first, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(key.path)
+	if err != nil || string(data) != "key" {
+		t.Fatalf("Expected the key file while dialing, got %q, %v", data, err)
+	}
+
+	if _, err := first.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(key.path); err != nil {
+		t.Errorf("Expected the key file while another dial may read it, got %v", err)
+	}
+
+	second.Close()
+	if _, err := os.Stat(key.path); !os.IsNotExist(err) {
+		t.Errorf("Expected the key file to be removed, got %v", err)
+	}
+	first.Close()
+
+	if _, err := dialer.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Fatal("Expected a dial error")
+	}
+	if _, err := os.Stat(key.path); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file after a failed dial, got %v", err)
+	}
+}
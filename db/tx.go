@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgreSQL error codes of transactions that may succeed if they are retried
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// Defaults of the transaction retries
+const (
+	DefaultTxAttempts = 5
+	txBackoffBase     = 10 * time.Millisecond
+	txBackoffMax      = time.Second
+)
+
+// Tx is a transaction that was started by Conn.WithTx. Nested transactions
+// are created as savepoints by Tx.WithTx.
+type Tx struct {
+	tx         *sql.Tx
+	conn       *Conn
+	savepoints int
+}
+
+// Querier is implemented by Conn and Tx, for code that runs either inside a
+// transaction or on its own.
+//
+// Through a Conn, Query, QueryRow, Get and Select are sent to a replica by
+// default, so a write that returns rows through them (such as Get of an
+// INSERT ... RETURNING) requires a ctx of WithPrimary. The other methods, and
+// all the methods of a Tx, always use the primary.
+type Querier interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Get(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExec(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	NamedGet(ctx context.Context, dest interface{}, query string, arg interface{}) error
+	NamedSelect(ctx context.Context, dest interface{}, query string, arg interface{}) error
+}
+
+var (
+	_ Querier = (*Conn)(nil)
+	_ Querier = (*Tx)(nil)
+)
+
+// IsRetryable returns true if err is a serialization failure or a deadlock,
+// that may pass if the transaction is executed again
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == codeSerializationFailure || pqErr.Code == codeDeadlockDetected
+}
+
+// SetTxAttempts sets how many times WithTx executes a transaction that fails
+// on a serialization failure or a deadlock.
+//
+// If n <= 0, DefaultTxAttempts is used.
+func (conn *Conn) SetTxAttempts(n int) {
+	conn.txAttempts = n
+}
+
+// WithTx executes fn inside a transaction. The transaction is committed if fn
+// returns nil, and rolled back if fn returns an error or panics (the panic is
+// raised again after the rollback).
+//
+// On a serialization failure or a deadlock, the whole transaction is executed
+// again after a jittered exponential backoff, so fn must not have side effects
+// outside of the transaction.
+func (conn *Conn) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	attempts := conn.txAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, txBackoff(attempt)); err != nil {
+				return err
+			}
+		}
+
+		err = conn.runTx(ctx, opts, fn)
+		if !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (conn *Conn) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := conn.Begin(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	tx := &Tx{tx: sqlTx, conn: conn}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.end(ctx, OpRollback, sqlTx.Rollback)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		_ = tx.end(ctx, OpRollback, sqlTx.Rollback)
+		return err
+	}
+	return tx.end(ctx, OpCommit, sqlTx.Commit)
+}
+
+// end commits or rolls back the transaction inside the hooks
+func (tx *Tx) end(ctx context.Context, op string, end func() error) error {
+	_, after := tx.conn.before(ctx, op, "", nil)
+	err := end()
+	after(err)
+	return err
+}
+
+// txBackoff returns a random duration up to an exponential limit of attempt
+func txBackoff(attempt int) time.Duration {
+	limit := txBackoffBase << uint(attempt)
+	if limit <= 0 || limit > txBackoffMax {
+		limit = txBackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(limit))) + 1
+}
+
+// sleepContext waits for d, or returns the error of ctx if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithTx executes fn inside a savepoint of the transaction. The savepoint is
+// released if fn returns nil, and rolled back if fn returns an error or
+// panics, while the outer transaction stays usable.
+func (tx *Tx) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx.savepoints++
+	name := fmt.Sprintf("sp_%d", tx.savepoints)
+
+	if _, err := tx.Exec(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if _, rollbackErr := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %s)", err, rollbackErr)
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// Exec executes a query without returning any rows inside the transaction
+func (tx *Tx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tx.conn.queryer(tx.tx).ExecContext(ctx, query, args...)
+}
+
+// Query executes a query that returns rows inside the transaction
+func (tx *Tx) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tx.conn.queryer(tx.tx).QueryContext(ctx, query, args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row
+// inside the transaction
+func (tx *Tx) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, after := tx.conn.before(ctx, OpQueryRow, query, args)
+	row := tx.tx.QueryRowContext(ctx, query, args...)
+	after(row.Err())
+	return row
+}
+
+// Prepare creates a prepared statement for use within the transaction
+func (tx *Tx) Prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, after := tx.conn.before(ctx, OpPrepare, query, nil)
+	stmt, err := tx.tx.PrepareContext(ctx, query)
+	after(err)
+	return stmt, err
+}
+
+// Get works as Conn.Get inside the transaction
+func (tx *Tx) Get(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return get(ctx, tx.conn.queryer(tx.tx), dest, query, args...)
+}
+
+// Select works as Conn.Select inside the transaction
+func (tx *Tx) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return selectRows(ctx, tx.conn.queryer(tx.tx), dest, query, args...)
+}
+
+// NamedExec works as Conn.NamedExec inside the transaction
+func (tx *Tx) NamedExec(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	return namedExec(ctx, tx.conn.queryer(tx.tx), query, arg)
+}
+
+// NamedGet works as Conn.NamedGet inside the transaction
+func (tx *Tx) NamedGet(ctx context.Context, dest interface{}, query string, arg interface{}) error {
+	return namedGet(ctx, tx.conn.queryer(tx.tx), dest, query, arg)
+}
+
+// NamedSelect works as Conn.NamedSelect inside the transaction
+func (tx *Tx) NamedSelect(ctx context.Context, dest interface{}, query string, arg interface{}) error {
+	return namedSelect(ctx, tx.conn.queryer(tx.tx), dest, query, arg)
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ik5/go-into/db/dbtest"
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := map[error]bool{
+		&pq.Error{Code: "40001"}:                            true,
+		&pq.Error{Code: "40P01"}:                            true,
+		fmt.Errorf("wrapped: %w", &pq.Error{Code: "40001"}): true,
+		&pq.Error{Code: "23505"}:                            false,
+		errors.New("Just an error"):                         false,
+	}
+
+	for err, expected := range tests {
+		if IsRetryable(err) != expected {
+			t.Errorf("Expected IsRetryable(%v) to be %t", err, expected)
+		}
+	}
+
+	if IsRetryable(nil) {
+		t.Error("Expected nil not to be retryable")
+	}
+}
+
+func TestTxBackoff(t *testing.T) {
+	for attempt := 1; attempt < 64; attempt++ {
+		d := txBackoff(attempt)
+		if d <= 0 || d > txBackoffMax {
+			t.Errorf("Backoff of attempt %d out of range: %s", attempt, d)
+		}
+	}
+
+	if d := txBackoff(1); d > 2*txBackoffBase {
+		t.Errorf("Expected the first backoff to be at most %s, got %s", 2*txBackoffBase, d)
+	}
+}
+
+func TestWithTxCommit(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE posts SET status").WithArgs("archived", 3).
+		WillReturnResult(dbtest.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := conn.WithTx(context.Background(), nil, func(tx *Tx) error {
+		_, err := tx.Exec(context.Background(), `UPDATE posts SET status = $1 WHERE id = $2`, "archived", 3)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestWithTxRollbackOnError(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	failed := errors.New("failed")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := conn.WithTx(context.Background(), nil, func(tx *Tx) error {
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Expected %s, got %v", failed, err)
+	}
+}
+
+func TestWithTxRetriesSerializationFailure(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE counters").WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE counters")
+	mock.ExpectCommit()
+
+	calls := 0
+	err := conn.WithTx(context.Background(), nil, func(tx *Tx) error {
+		calls++
+		_, err := tx.Exec(context.Background(), `UPDATE counters SET value = value + 1`)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	failed := errors.New("failed")
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT sp_1")
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1")
+	mock.ExpectExec("SAVEPOINT sp_2")
+	mock.ExpectExec("RELEASE SAVEPOINT sp_2")
+	mock.ExpectCommit()
+
+	err := conn.WithTx(context.Background(), nil, func(tx *Tx) error {
+		if err := tx.WithTx(context.Background(), func(*Tx) error { return failed }); err != failed {
+			t.Errorf("Expected %s, got %v", failed, err)
+		}
+		return tx.WithTx(context.Background(), func(*Tx) error { return nil })
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestWithTxRollbackOnPanic(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected the panic to be raised again")
+		}
+	}()
+	_ = conn.WithTx(context.Background(), nil, func(tx *Tx) error {
+		panic("boom")
+	})
+}
+
+func TestTxNilContext(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT sp_1")
+	mock.ExpectExec("UPDATE posts SET status").WithArgs("archived", 3).
+		WillReturnResult(dbtest.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM posts").
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectQuery("SELECT id FROM posts WHERE id").WithArgs(3).
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectQuery("SELECT id FROM posts").
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectExec("UPDATE posts SET status").WithArgs("archived", 3).
+		WillReturnResult(dbtest.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id FROM posts WHERE id").WithArgs(3).
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectQuery("SELECT id FROM posts WHERE status").WithArgs("archived").
+		WillReturnRows(dbtest.NewRows("id").AddRow(3))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_1")
+	mock.ExpectCommit()
+
+	arg := map[string]interface{}{"id": 3, "status": "archived"}
+	err := conn.WithTx(nil, nil, func(tx *Tx) error {
+		return tx.WithTx(nil, func(tx *Tx) error {
+			if _, err := tx.Exec(nil, `UPDATE posts SET status = $1 WHERE id = $2`, "archived", 3); err != nil {
+				return fmt.Errorf("Exec: %w", err)
+			}
+			rows, err := tx.Query(nil, `SELECT id FROM posts`)
+			if err != nil {
+				return fmt.Errorf("Query: %w", err)
+			}
+			rows.Close()
+
+			var id int
+			if err := tx.Get(nil, &id, `SELECT id FROM posts WHERE id = $1`, 3); err != nil {
+				return fmt.Errorf("Get: %w", err)
+			}
+			var ids []int
+			if err := tx.Select(nil, &ids, `SELECT id FROM posts`); err != nil {
+				return fmt.Errorf("Select: %w", err)
+			}
+			if _, err := tx.NamedExec(nil, `UPDATE posts SET status = :status WHERE id = :id`, arg); err != nil {
+				return fmt.Errorf("NamedExec: %w", err)
+			}
+			if err := tx.NamedGet(nil, &id, `SELECT id FROM posts WHERE id = :id`, arg); err != nil {
+				return fmt.Errorf("NamedGet: %w", err)
+			}
+			if err := tx.NamedSelect(nil, &ids, `SELECT id FROM posts WHERE status = :status`, arg); err != nil {
+				return fmt.Errorf("NamedSelect: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
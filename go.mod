@@ -1,6 +1,6 @@
 module github.com/ik5/go-into
 
-go 1.12
+go 1.16
 
 require (
 	github.com/lib/pq v1.2.0
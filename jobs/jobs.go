@@ -0,0 +1,174 @@
+/*
+Package jobs is a durable queue of background jobs that is stored at the jobs
+table.
+
+Work that should not run inside an HTTP request, such as sending emails,
+generating thumbnails or delivering webhooks, is enqueued as a job of a kind,
+and is executed by the handler that is registered for the kind:
+
+	queue := jobs.NewQueue(conn, nil)
+	queue.Register("email.welcome", jobs.HandlerFunc(sendWelcome))
+	go queue.Run(ctx)
+
+	queue.Enqueue(ctx, "email.welcome", welcome{UserID: user.ID}, nil)
+
+Workers claim jobs with FOR UPDATE SKIP LOCKED, so any number of instances
+may run workers over the same table. A job that fails is retried after an
+exponential backoff, and after its last attempt it is moved to the dead
+state, where it waits to be retried by hand.
+*/
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ik5/go-into/db"
+)
+
+// States of a job
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDead    = "dead"
+)
+
+// DefaultMaxAttempts is the number of times a job is executed before it is
+// dead
+const DefaultMaxAttempts = 10
+
+// Errors of the queue
+var (
+	ErrDuplicateJob = errors.New("a job with the same unique key is already queued")
+	ErrJobNotFound  = errors.New("job not found")
+	// ErrLeaseLost is returned when a job finished after its lease ended, and
+	// it was already handed to another worker
+	ErrLeaseLost = errors.New("the lease of the job was lost")
+)
+
+const jobColumns = `id, kind, payload, state, unique_key, attempts, max_attempts,
+	run_at, locked_at, last_error, created_at, updated_at`
+
+// Job is a row of the jobs table
+type Job struct {
+	ID      uint64          `json:"id" db:"id"`
+	Kind    string          `json:"kind" db:"kind"`
+	Payload json.RawMessage `json:"payload" db:"payload"`
+	State   string          `json:"state" db:"state"`
+	// UniqueKey prevents a second job of the same kind and key while the
+	// first one is pending or running
+	UniqueKey   sql.NullString `json:"unique_key" db:"unique_key"`
+	Attempts    int            `json:"attempts" db:"attempts"`
+	MaxAttempts int            `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time      `json:"run_at" db:"run_at"`
+	LockedAt    sql.NullTime   `json:"locked_at" db:"locked_at"`
+	LastError   string         `json:"last_error" db:"last_error"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// Decode decodes the payload of the job into v
+func (job Job) Decode(v interface{}) error {
+	return json.Unmarshal(job.Payload, v)
+}
+
+// EnqueueOptions are the settings of a job, nil uses the defaults
+type EnqueueOptions struct {
+	// RunAt schedules the job, it runs as soon as possible when zero
+	RunAt time.Time
+	// UniqueKey makes Enqueue return ErrDuplicateJob while another job of
+	// the kind and key is pending or running
+	UniqueKey string
+	// MaxAttempts is DefaultMaxAttempts when it is not positive
+	MaxAttempts int
+}
+
+// Enqueue adds a job of kind, payload is encoded as JSON
+func (queue *Queue) Enqueue(ctx context.Context, kind string, payload interface{}, opts *EnqueueOptions) (Job, error) {
+	job, err := enqueue(db.WithPrimary(ctx), queue.conn, kind, payload, opts)
+	if err == nil {
+		queue.wakeUp()
+	}
+	return job, err
+}
+
+// EnqueueTx adds a job inside tx, so the job exists only if tx is committed
+func (queue *Queue) EnqueueTx(ctx context.Context, tx *db.Tx, kind string, payload interface{}, opts *EnqueueOptions) (Job, error) {
+	return enqueue(ctx, tx, kind, payload, opts)
+}
+
+func enqueue(ctx context.Context, q db.Querier, kind string, payload interface{}, opts *EnqueueOptions) (Job, error) {
+	options := EnqueueOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	uniqueKey := sql.NullString{String: options.UniqueKey, Valid: options.UniqueKey != ""}
+	runAt := sql.NullTime{Time: options.RunAt, Valid: !options.RunAt.IsZero()}
+
+	var job Job
+	err = q.Get(ctx, &job,
+		`INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, now()))
+		ON CONFLICT (kind, unique_key)
+			WHERE unique_key IS NOT NULL AND state IN ('pending', 'running')
+			DO NOTHING
+		RETURNING `+jobColumns,
+		kind, string(data), uniqueKey, options.MaxAttempts, runAt,
+	)
+	if db.IsNoRows(err) {
+		return Job{}, ErrDuplicateJob
+	}
+	return job, err
+}
+
+// Get returns a job by its id
+func (queue *Queue) Get(ctx context.Context, id uint64) (Job, error) {
+	var job Job
+	err := queue.conn.Get(db.WithPrimary(ctx), &job, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id)
+	if db.IsNoRows(err) {
+		return job, ErrJobNotFound
+	}
+	return job, err
+}
+
+// Dead returns the dead jobs, latest first
+func (queue *Queue) Dead(ctx context.Context, limit int) ([]Job, error) {
+	list := make([]Job, 0)
+	err := queue.conn.Select(ctx, &list,
+		`SELECT `+jobColumns+` FROM jobs WHERE state = 'dead' ORDER BY updated_at DESC, id DESC LIMIT $1`,
+		limit,
+	)
+	return list, err
+}
+
+// Retry moves a dead job back to the queue, with a new set of attempts
+func (queue *Queue) Retry(ctx context.Context, id uint64) error {
+	result, err := queue.conn.Exec(ctx,
+		`UPDATE jobs SET state = 'pending', attempts = 0, run_at = now(), updated_at = now()
+		WHERE id = $1 AND state = 'dead'`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrJobNotFound
+	}
+	queue.wakeUp()
+	return nil
+}
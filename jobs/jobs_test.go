@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/db/dbtest"
+)
+
+func newTestQueue(t *testing.T) (*Queue, *dbtest.Mock) {
+	sqlDB, mock := dbtest.New(t)
+	return NewQueue(db.NewFromDB(sqlDB), nil), mock
+}
+
+// claimedAt is the locked_at of the jobs of expectClaim
+var claimedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// expectClaim expects a claim that returns a job of kind
+func expectClaim(mock *dbtest.Mock, kind string, attempts, maxAttempts int) {
+	mock.ExpectQuery("UPDATE jobs SET state = 'running'").WithArgs(dbtest.AnyArg).
+		WillReturnRows(dbtest.NewRows("id", "kind", "payload", "attempts", "max_attempts", "locked_at").
+			AddRow(1, kind, []byte(`{"user_id":7}`), attempts, maxAttempts, claimedAt))
+}
+
+func TestEnqueue(t *testing.T) {
+	queue, mock := newTestQueue(t)
+
+	runAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("INSERT INTO jobs").
+		WithArgs("email", `{"user_id":7}`, "welcome-7", 3, runAt).
+		WillReturnRows(dbtest.NewRows("id", "kind", "state").AddRow(1, "email", StatePending))
+
+	job, err := queue.Enqueue(context.Background(), "email", map[string]int{"user_id": 7},
+		&EnqueueOptions{RunAt: runAt, UniqueKey: "welcome-7", MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != 1 || job.State != StatePending {
+		t.Errorf("Expected pending job 1, got %+v", job)
+	}
+}
+
+func TestEnqueueDuplicate(t *testing.T) {
+	queue, mock := newTestQueue(t)
+
+	mock.ExpectQuery("ON CONFLICT (kind, unique_key)").
+		WithArgs("email", `{}`, "welcome-7", DefaultMaxAttempts, nil).
+		WillReturnRows(dbtest.NewRows("id"))
+
+	_, err := queue.Enqueue(context.Background(), "email", struct{}{}, &EnqueueOptions{UniqueKey: "welcome-7"})
+	if err != ErrDuplicateJob {
+		t.Errorf("Expected ErrDuplicateJob, got %v", err)
+	}
+}
+
+func TestRunNextCompletes(t *testing.T) {
+	queue, mock := newTestQueue(t)
+
+	var userID int
+	queue.Register("email", HandlerFunc(func(ctx context.Context, job Job) error {
+		var payload struct {
+			UserID int `json:"user_id"`
+		}
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		userID = payload.UserID
+		return nil
+	}))
+
+	expectClaim(mock, "email", 1, DefaultMaxAttempts)
+	mock.ExpectExec("DELETE FROM jobs WHERE id = $1 AND state = 'running' AND locked_at = $2").
+		WithArgs(1, claimedAt).WillReturnResult(dbtest.NewResult(0, 1))
+
+	found, err := queue.RunNext(context.Background())
+	if err != nil || !found {
+		t.Fatalf("Expected a job to run, got %t and %v", found, err)
+	}
+	if userID != 7 {
+		t.Errorf("Expected the payload of user 7, got %d", userID)
+	}
+}
+
+func TestRunNextEmpty(t *testing.T) {
+	queue, mock := newTestQueue(t)
+	queue.Register("email", HandlerFunc(func(context.Context, Job) error { return nil }))
+
+	mock.ExpectQuery("UPDATE jobs SET state = 'running'").WillReturnRows(dbtest.NewRows("id"))
+
+	if found, err := queue.RunNext(context.Background()); found || err != nil {
+		t.Errorf("Expected no job, got %t and %v", found, err)
+	}
+}
+
+func TestRunNextFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		panics   bool
+		attempts int
+		dead     bool
+	}{
+		{"retried", errors.New("smtp timeout"), false, 1, false},
+		{"last attempt", errors.New("smtp timeout"), false, 3, true},
+		{"permanent", Permanent(errors.New("no such user")), false, 1, true},
+		{"panic", nil, true, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue, mock := newTestQueue(t)
+			queue.Register("email", HandlerFunc(func(context.Context, Job) error {
+				if test.panics {
+					panic("nil map")
+				}
+				return test.err
+			}))
+
+			expectClaim(mock, "email", test.attempts, 3)
+			if test.dead {
+				mock.ExpectExec("SET state = 'dead'").WithArgs(1, claimedAt, dbtest.AnyArg).
+					WillReturnResult(dbtest.NewResult(0, 1))
+			} else {
+				mock.ExpectExec("SET state = 'pending'").WithArgs(1, claimedAt, dbtest.AnyArg, dbtest.AnyArg).
+					WillReturnResult(dbtest.NewResult(0, 1))
+			}
+
+			found, err := queue.RunNext(context.Background())
+			if !found || err != nil {
+				t.Errorf("Expected the job to fail and be stored, got %t and %v", found, err)
+			}
+		})
+	}
+}
+
+func TestRunNextLeaseLost(t *testing.T) {
+	queue, mock := newTestQueue(t)
+	queue.Register("email", HandlerFunc(func(context.Context, Job) error { return nil }))
+
+	expectClaim(mock, "email", 1, DefaultMaxAttempts)
+	// the job was rescued and claimed again while it ran
+	mock.ExpectExec("DELETE FROM jobs").WithArgs(1, claimedAt).WillReturnResult(dbtest.NewResult(0, 0))
+
+	found, err := queue.RunNext(context.Background())
+	if !found || !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %t and %v", found, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	queue := NewQueue(nil, &Options{BackoffBase: time.Second, BackoffMax: time.Minute})
+
+	for attempt := 0; attempt < 70; attempt++ {
+		d := queue.backoff(attempt)
+		if d <= 0 || d > time.Minute {
+			t.Errorf("Backoff of attempt %d out of range: %s", attempt, d)
+		}
+	}
+	if d := queue.backoff(3); d < 3200*time.Millisecond || d > 4*time.Second {
+		t.Errorf("Expected about 4s for the third attempt, got %s", d)
+	}
+}
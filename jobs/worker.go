@@ -0,0 +1,336 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/lib/pq"
+)
+
+// Defaults of a Queue
+const (
+	DefaultWorkers      = 4
+	DefaultPollInterval = time.Second
+	// DefaultLease is how long a job may run before it is considered lost,
+	// and is handed to another worker
+	DefaultLease       = 5 * time.Minute
+	DefaultBackoffBase = 5 * time.Second
+	DefaultBackoffMax  = time.Hour
+	// finishTimeout bounds the update of a job that finished, which is
+	// stored even when the worker is stopping
+	finishTimeout = 30 * time.Second
+)
+
+// Handler executes the jobs of a kind. The ctx of Handle is canceled when the
+// lease of the job ends.
+type Handler interface {
+	Handle(ctx context.Context, job Job) error
+}
+
+// HandlerFunc is a function that implements Handler
+type HandlerFunc func(ctx context.Context, job Job) error
+
+// Handle implements Handler
+func (fn HandlerFunc) Handle(ctx context.Context, job Job) error {
+	return fn(ctx, job)
+}
+
+// permanentError marks an error that is not retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job is dead right away, without retries
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Options are the settings of a Queue, nil uses the defaults
+type Options struct {
+	// Workers is the number of jobs that run at once
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+	// OnError is called with the errors of the jobs and of the queue
+	OnError func(job *Job, err error)
+}
+
+// Queue executes the jobs of the registered kinds
+type Queue struct {
+	conn    *db.Conn
+	options Options
+
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+
+	wake chan struct{}
+}
+
+// NewQueue creates a Queue over conn
+func NewQueue(conn *db.Conn, opts *Options) *Queue {
+	options := Options{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+	if options.Lease <= 0 {
+		options.Lease = DefaultLease
+	}
+	if options.BackoffBase <= 0 {
+		options.BackoffBase = DefaultBackoffBase
+	}
+	if options.BackoffMax <= 0 {
+		options.BackoffMax = DefaultBackoffMax
+	}
+
+	return &Queue{
+		conn:     conn,
+		options:  options,
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler of kind, it must be called before Run
+func (queue *Queue) Register(kind string, handler Handler) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.handlers[kind] = handler
+}
+
+// kinds returns the kinds that have a handler
+func (queue *Queue) kinds() []string {
+	queue.mutex.RLock()
+	defer queue.mutex.RUnlock()
+
+	kinds := make([]string, 0, len(queue.handlers))
+	for kind := range queue.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func (queue *Queue) handler(kind string) Handler {
+	queue.mutex.RLock()
+	defer queue.mutex.RUnlock()
+
+	return queue.handlers[kind]
+}
+
+// wakeUp lets a waiting worker look for jobs before its poll interval
+func (queue *Queue) wakeUp() {
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run executes jobs with the workers until ctx is done, and waits for the
+// running jobs to finish
+func (queue *Queue) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < queue.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.work(ctx)
+		}()
+	}
+
+	// jobs of workers that died are handed to the others
+	ticker := time.NewTicker(queue.options.Lease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+			if _, err := queue.RescueExpired(ctx); err != nil && ctx.Err() == nil {
+				queue.reportError(nil, err)
+			}
+		}
+	}
+}
+
+// work executes jobs until ctx is done, and waits when there are none
+func (queue *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		found, err := queue.RunNext(ctx)
+		if err != nil && ctx.Err() == nil {
+			queue.reportError(nil, err)
+		}
+		if found {
+			continue
+		}
+
+		timer := time.NewTimer(queue.options.PollInterval)
+		select {
+		case <-ctx.Done():
+		case <-queue.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// RunNext claims and executes a single job that is due, and returns false
+// when there is none
+func (queue *Queue) RunNext(ctx context.Context) (bool, error) {
+	job, err := queue.claim(ctx)
+	if db.IsNoRows(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	handler := queue.handler(job.Kind)
+	if handler == nil {
+		// the handler was unregistered meanwhile
+		return true, queue.fail(job, Permanent(fmt.Errorf("no handler of kind %s", job.Kind)))
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, queue.options.Lease)
+	err = runHandler(runCtx, handler, job)
+	cancel()
+
+	if err != nil {
+		queue.reportError(&job, err)
+		return true, queue.fail(job, err)
+	}
+	return true, queue.complete(job)
+}
+
+// runHandler executes the job, a panic fails the job
+func runHandler(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return handler.Handle(ctx, job)
+}
+
+// claim locks the next job that is due, and leases it to the worker
+func (queue *Queue) claim(ctx context.Context) (Job, error) {
+	var job Job
+	err := queue.conn.Get(db.WithPrimary(ctx), &job,
+		`UPDATE jobs SET state = 'running', attempts = attempts + 1,
+			locked_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE state = 'pending' AND run_at <= now() AND kind = ANY($1)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		pq.Array(queue.kinds()),
+	)
+	return job, err
+}
+
+// complete removes a job that succeeded
+func (queue *Queue) complete(job Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), finishTimeout)
+	defer cancel()
+
+	result, err := queue.conn.Exec(ctx,
+		`DELETE FROM jobs WHERE id = $1 AND state = 'running' AND locked_at = $2`,
+		job.ID, job.LockedAt,
+	)
+	return leased(job, result, err)
+}
+
+// fail schedules the next attempt of a job, or moves it to the dead state
+func (queue *Queue) fail(job Job, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), finishTimeout)
+	defer cancel()
+
+	var permanent permanentError
+	if errors.As(jobErr, &permanent) || job.Attempts >= job.MaxAttempts {
+		result, err := queue.conn.Exec(ctx,
+			`UPDATE jobs SET state = 'dead', locked_at = NULL, last_error = $3, updated_at = now()
+			WHERE id = $1 AND state = 'running' AND locked_at = $2`,
+			job.ID, job.LockedAt, jobErr.Error(),
+		)
+		return leased(job, result, err)
+	}
+
+	result, err := queue.conn.Exec(ctx,
+		`UPDATE jobs SET state = 'pending', locked_at = NULL, last_error = $3,
+			run_at = now() + $4::float8 * interval '1 millisecond', updated_at = now()
+		WHERE id = $1 AND state = 'running' AND locked_at = $2`,
+		job.ID, job.LockedAt, jobErr.Error(), queue.backoff(job.Attempts).Milliseconds(),
+	)
+	return leased(job, result, err)
+}
+
+// leased returns ErrLeaseLost when the update of a job that finished matched
+// no row, as the job was rescued and claimed again since it was claimed
+func leased(job Job, result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("job %d: %w", job.ID, ErrLeaseLost)
+	}
+	return nil
+}
+
+// RescueExpired returns the running jobs whose lease ended to the queue, as
+// their worker is gone, and returns their number
+func (queue *Queue) RescueExpired(ctx context.Context) (int64, error) {
+	result, err := queue.conn.Exec(ctx,
+		`UPDATE jobs SET
+			state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+			locked_at = NULL, last_error = 'lease expired', updated_at = now()
+		WHERE state = 'running' AND locked_at < now() - $1::float8 * interval '1 millisecond'`,
+		queue.options.Lease.Milliseconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// backoff returns the delay before the attempt after attempt, an exponential
+// delay with up to 20% of jitter
+func (queue *Queue) backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := queue.options.BackoffBase << uint(attempt-1)
+	if delay <= 0 || delay > queue.options.BackoffMax {
+		delay = queue.options.BackoffMax
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+func (queue *Queue) reportError(job *Job, err error) {
+	if queue.options.OnError != nil {
+		queue.options.OnError(job, err)
+	}
+}
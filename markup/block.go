@@ -0,0 +1,606 @@
+package markup
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// blockKind is the type of a block at the document tree
+type blockKind int
+
+// A list of block kinds
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	thematicBreakBlock
+	codeBlock
+	quoteBlock
+	listBlock
+	itemBlock
+	tableBlock
+)
+
+// taskState is the checkbox of a GFM task list item
+type taskState int
+
+// A list of task states
+const (
+	noTask taskState = iota
+	openTask
+	doneTask
+)
+
+// block is a node of the document tree. Paragraphs, headings and table
+// cells keep their inline content as source text, that is parsed when the
+// block is rendered.
+type block struct {
+	kind  blockKind
+	level int
+	// text is the inline content, or the code of a code block
+	text string
+	// info is the language of a fenced code block
+	info    string
+	ordered bool
+	start   int
+	tight   bool
+	task    taskState
+	// align and rows are the alignment of the columns and the cells of a
+	// table, the header is the first row
+	align    []string
+	rows     [][]string
+	children []*block
+}
+
+// reference is a link reference definition
+type reference struct {
+	dest, title string
+}
+
+var (
+	atxHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})([ \t].*)?$`)
+	thematicBreak = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	fenceOpen     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})(.*)$")
+	setextLine    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	bulletItem    = regexp.MustCompile(`^( {0,3})([-+*])( *)(.*)$`)
+	orderedItem   = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( *)(.*)$`)
+	taskMarker    = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	tableDelim    = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	referenceDef  = regexp.MustCompile(`^ {0,3}\[((?:[^\[\]\\]|\\.){1,999})\]:[ \t]*\n?[ \t]*` +
+		`(<[^<>\n]*>|[^<\s]\S*)` +
+		`(?:(?:[ \t]+|[ \t]*\n[ \t]*)("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?` +
+		`[ \t]*(?:\n|$)`)
+)
+
+// maxNesting is the depth of block quotes and lists, that deeper markers
+// are kept as text
+const maxNesting = 32
+
+// blockParser splits a document to blocks, and collects its link reference
+// definitions
+type blockParser struct {
+	refs  map[string]reference
+	depth int
+}
+
+// splitLines normalises the line endings of src, and expands the tabs at
+// the indentation of every line to spaces
+func splitLines(src string) []string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return lines
+}
+
+// expandTabs replaces the tabs of the leading white space of line with
+// spaces, up to the next multiple of 4 columns
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\t':
+			spaces := 4 - column%4
+			b.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		case ' ':
+			b.WriteByte(' ')
+			column++
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentOf returns the number of leading spaces of line
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// unindent removes up to n leading spaces from line
+func unindent(line string, n int) string {
+	if indent := indentOf(line); indent < n {
+		n = indent
+	}
+	return line[n:]
+}
+
+// normalizeLabel returns the key of a link reference label
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// parse returns the blocks of lines
+func (p *blockParser) parse(lines []string) []*block {
+	blocks := make([]*block, 0)
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+
+		if b, n := p.parseFence(lines[i:]); n > 0 {
+			blocks = append(blocks, b)
+			i += n
+			continue
+		}
+		if indentOf(line) >= 4 {
+			b, n := parseIndentedCode(lines[i:])
+			blocks = append(blocks, b)
+			i += n
+			continue
+		}
+		if b, ok := parseATXHeading(line); ok {
+			blocks = append(blocks, b)
+			i++
+			continue
+		}
+		if thematicBreak.MatchString(line) {
+			blocks = append(blocks, &block{kind: thematicBreakBlock})
+			i++
+			continue
+		}
+		if _, ok := quoteLine(line); ok && p.depth < maxNesting {
+			b, n := p.parseQuote(lines[i:])
+			blocks = append(blocks, b)
+			i += n
+			continue
+		}
+		if _, ok := parseListMarker(line); ok && p.depth < maxNesting {
+			b, n := p.parseList(lines[i:])
+			blocks = append(blocks, b)
+			i += n
+			continue
+		}
+		if b, n := parseTable(lines[i:]); n > 0 {
+			blocks = append(blocks, b)
+			i += n
+			continue
+		}
+
+		b, n := p.parseParagraph(lines[i:])
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+		i += n
+	}
+	return blocks
+}
+
+// interrupts returns true if line starts a block that ends a paragraph
+func interrupts(line string) bool {
+	if indentOf(line) >= 4 {
+		return false
+	}
+	if _, ok := parseATXHeading(line); ok {
+		return true
+	}
+	if fenceOpen.MatchString(line) || thematicBreak.MatchString(line) {
+		return true
+	}
+	if _, ok := quoteLine(line); ok {
+		return true
+	}
+	// only a list item with content, that starts from 1 when it is
+	// ordered, interrupts a paragraph
+	marker, ok := parseListMarker(line)
+	return ok && !marker.empty && (!marker.ordered || marker.start == 1)
+}
+
+// parseParagraph returns a paragraph, or a setext heading. A paragraph that
+// contains only link reference definitions returns no block.
+func (p *blockParser) parseParagraph(lines []string) (*block, int) {
+	text := []string{strings.TrimLeft(lines[0], " ")}
+	n := 1
+	level := 0
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if isBlank(line) {
+			break
+		}
+		if m := setextLine.FindStringSubmatch(line); m != nil {
+			level = 2
+			if m[1][0] == '=' {
+				level = 1
+			}
+			n++
+			break
+		}
+		if interrupts(line) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+
+	content := p.extractReferences(strings.Join(text, "\n"))
+	content = strings.TrimRight(content, " ")
+	if content == "" {
+		return nil, n
+	}
+	if level > 0 {
+		return &block{kind: headingBlock, level: level, text: content}, n
+	}
+	return &block{kind: paragraphBlock, text: content}, n
+}
+
+// extractReferences removes the link reference definitions from the start
+// of the text of a paragraph, and keeps them. The first definition of a
+// label wins.
+func (p *blockParser) extractReferences(text string) string {
+	for {
+		m := referenceDef.FindStringSubmatch(text)
+		if m == nil {
+			return text
+		}
+		label := normalizeLabel(m[1])
+		if label == "" {
+			return text
+		}
+
+		dest := m[2]
+		if strings.HasPrefix(dest, "<") {
+			dest = dest[1 : len(dest)-1]
+		}
+		title := m[3]
+		if title != "" {
+			title = title[1 : len(title)-1]
+		}
+		if _, ok := p.refs[label]; !ok {
+			p.refs[label] = reference{dest: unescape(dest), title: unescape(title)}
+		}
+		text = text[len(m[0]):]
+	}
+}
+
+// parseATXHeading parses a heading that starts with hashes
+func parseATXHeading(line string) (*block, bool) {
+	m := atxHeading.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	text := strings.TrimSpace(m[2])
+	// the optional closing sequence of hashes
+	if trimmed := strings.TrimRight(text, "#"); trimmed == "" {
+		text = ""
+	} else if strings.HasSuffix(trimmed, " ") || strings.HasSuffix(trimmed, "\t") {
+		text = strings.TrimSpace(trimmed)
+	}
+	return &block{kind: headingBlock, level: len(m[1]), text: text}, true
+}
+
+// parseFence parses a fenced code block, it returns 0 lines when lines does
+// not start with a fence
+func (p *blockParser) parseFence(lines []string) (*block, int) {
+	m := fenceOpen.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil, 0
+	}
+	indent, fence, info := len(m[1]), m[2], strings.TrimSpace(m[3])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return nil, 0
+	}
+
+	code := make([]string, 0)
+	n := 1
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if closesFence(line, fence) {
+			n++
+			break
+		}
+		code = append(code, unindent(line, indent))
+	}
+
+	b := &block{kind: codeBlock}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		b.info = unescape(fields[0])
+	}
+	if len(code) > 0 {
+		b.text = strings.Join(code, "\n") + "\n"
+	}
+	return b, n
+}
+
+// closesFence returns true if line is a closing fence of fence
+func closesFence(line, fence string) bool {
+	if indentOf(line) >= 4 {
+		return false
+	}
+	trimmed := strings.TrimSpace(line)
+	run := strings.TrimLeft(trimmed, fence[:1])
+	return run == "" && len(trimmed) >= len(fence)
+}
+
+// parseIndentedCode parses a code block of lines that are indented by 4
+// spaces
+func parseIndentedCode(lines []string) (*block, int) {
+	code := make([]string, 0)
+	n := 0
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if isBlank(line) {
+			code = append(code, unindent(line, 4))
+			continue
+		}
+		if indentOf(line) < 4 {
+			break
+		}
+		code = append(code, line[4:])
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+	return &block{kind: codeBlock, text: strings.Join(code, "\n") + "\n"}, n
+}
+
+// quoteLine returns the content of a block quote line
+func quoteLine(line string) (string, bool) {
+	if indentOf(line) >= 4 {
+		return "", false
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if !strings.HasPrefix(trimmed, ">") {
+		return "", false
+	}
+	return strings.TrimPrefix(trimmed[1:], " "), true
+}
+
+// parseQuote parses a block quote, with lazy continuation lines of its
+// paragraphs
+func (p *blockParser) parseQuote(lines []string) (*block, int) {
+	inner := make([]string, 0)
+	n := 0
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if content, ok := quoteLine(line); ok {
+			inner = append(inner, content)
+			continue
+		}
+		if isBlank(line) || isBlank(inner[len(inner)-1]) || interrupts(line) {
+			break
+		}
+		inner = append(inner, line)
+	}
+	return &block{kind: quoteBlock, children: p.nested(inner)}, n
+}
+
+// nested parses the content of a block quote or a list item
+func (p *blockParser) nested(lines []string) []*block {
+	p.depth++
+	defer func() { p.depth-- }()
+	return p.parse(lines)
+}
+
+// listMarker is the marker of a list item
+type listMarker struct {
+	ordered bool
+	// char is the bullet, or the delimiter of an ordered item
+	char  byte
+	start int
+	// width is the indentation of the content of the item
+	width   int
+	empty   bool
+	content string
+}
+
+// parseListMarker parses the marker of a list item
+func parseListMarker(line string) (listMarker, bool) {
+	if thematicBreak.MatchString(line) {
+		return listMarker{}, false
+	}
+
+	var marker listMarker
+	var spaces, content string
+	if m := bulletItem.FindStringSubmatch(line); m != nil {
+		marker.char = m[2][0]
+		marker.width = len(m[1]) + 1
+		spaces, content = m[3], m[4]
+	} else if m := orderedItem.FindStringSubmatch(line); m != nil {
+		marker.ordered = true
+		marker.char = m[3][0]
+		marker.start, _ = strconv.Atoi(m[2])
+		marker.width = len(m[1]) + len(m[2]) + 1
+		spaces, content = m[4], m[5]
+	} else {
+		return listMarker{}, false
+	}
+
+	switch {
+	case content == "":
+		marker.empty = true
+		marker.width++
+	case spaces == "":
+		// the marker must be followed by a space
+		return listMarker{}, false
+	case len(spaces) > 4:
+		// the content is an indented code block
+		marker.width++
+		content = strings.Repeat(" ", len(spaces)-1) + content
+	default:
+		marker.width += len(spaces)
+	}
+	marker.content = content
+	return marker, true
+}
+
+// parseList parses the items of a list that share the type of the marker of
+// the first item
+func (p *blockParser) parseList(lines []string) (*block, int) {
+	first, _ := parseListMarker(lines[0])
+	list := &block{kind: listBlock, ordered: first.ordered, start: first.start, tight: true}
+
+	n := 0
+	for n < len(lines) {
+		marker, ok := parseListMarker(lines[n])
+		if !ok || marker.ordered != first.ordered || marker.char != first.char {
+			break
+		}
+
+		item := []string{marker.content}
+		n++
+		for n < len(lines) {
+			line := lines[n]
+			if isBlank(line) {
+				item = append(item, "")
+				n++
+				continue
+			}
+			if indentOf(line) >= marker.width {
+				item = append(item, line[marker.width:])
+				n++
+				continue
+			}
+			// a lazy continuation line of a paragraph
+			_, isItem := parseListMarker(line)
+			if isBlank(item[len(item)-1]) || isItem || interrupts(line) {
+				break
+			}
+			item = append(item, line)
+			n++
+		}
+
+		trailing := 0
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+
+		child := p.parseItem(item)
+		if len(child.children) > 1 && hasInnerBlank(item) {
+			list.tight = false
+		}
+		list.children = append(list.children, child)
+
+		if trailing == 0 || n >= len(lines) {
+			continue
+		}
+		next, ok := parseListMarker(lines[n])
+		if ok && next.ordered == first.ordered && next.char == first.char {
+			// blank lines between the items make the list loose
+			list.tight = false
+		}
+	}
+	return list, n
+}
+
+// hasInnerBlank returns true if there is a blank line between the lines of
+// an item
+func hasInnerBlank(lines []string) bool {
+	for _, line := range lines[1:] {
+		if isBlank(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseItem parses the content of a list item, and its task marker
+func (p *blockParser) parseItem(lines []string) *block {
+	item := &block{kind: itemBlock}
+	if m := taskMarker.FindStringSubmatch(lines[0]); m != nil {
+		item.task = openTask
+		if m[1] != " " {
+			item.task = doneTask
+		}
+		lines[0] = lines[0][len(m[0]):]
+	}
+	item.children = p.nested(lines)
+	return item
+}
+
+// parseTable parses a GFM table, it returns 0 lines when lines does not
+// start with a table
+func parseTable(lines []string) (*block, int) {
+	if len(lines) < 2 || !strings.Contains(lines[0], "|") || !tableDelim.MatchString(lines[1]) {
+		return nil, 0
+	}
+	header := splitCells(lines[0])
+	delims := splitCells(lines[1])
+	if len(header) != len(delims) {
+		return nil, 0
+	}
+
+	table := &block{kind: tableBlock, rows: [][]string{header}}
+	for _, delim := range delims {
+		left, right := strings.HasPrefix(delim, ":"), strings.HasSuffix(delim, ":")
+		switch {
+		case left && right:
+			table.align = append(table.align, "center")
+		case left:
+			table.align = append(table.align, "left")
+		case right:
+			table.align = append(table.align, "right")
+		default:
+			table.align = append(table.align, "")
+		}
+	}
+
+	n := 2
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if isBlank(line) || interrupts(line) {
+			break
+		}
+		cells := splitCells(line)
+		row := make([]string, len(header))
+		copy(row, cells)
+		table.rows = append(table.rows, row)
+	}
+	return table, n
+}
+
+// splitCells splits a table row on pipes that are not escaped
+func splitCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	cells := make([]string, 0)
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[start:]))
+}
@@ -0,0 +1,68 @@
+package markup
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps the latest used rendered documents by key, and drops the
+// least recently used ones above its size. It is safe for concurrent use.
+type Cache struct {
+	mtx   sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key, html string
+}
+
+// NewCache creates a Cache of up to size documents
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the document of key
+func (c *Cache) Get(key string) (string, bool) {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).html, true
+}
+
+// Put stores the document of key
+func (c *Cache) Put(key, html string) {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*cacheEntry).html = html
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, html: html})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of documents at the cache
+func (c *Cache) Len() int {
+	defer c.mtx.Unlock()
+	c.mtx.Lock()
+
+	return c.order.Len()
+}
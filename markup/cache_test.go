@@ -0,0 +1,41 @@
+package markup
+
+import (
+	"testing"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("a", "1")
+	cache.Put("b", "2")
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	cache.Put("c", "3")
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if html, ok := cache.Get("a"); !ok || html != "1" {
+		t.Errorf("Expected a to stay, got %q", html)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 documents, got %d", cache.Len())
+	}
+}
+
+func TestRenderCached(t *testing.T) {
+	r := New(&Options{CacheSize: 10})
+
+	first := r.RenderCached("post:1:1", "*one*")
+	if first != "<p><em>one</em></p>\n" {
+		t.Fatalf("Unexpected html: %s", first)
+	}
+	// the same key is not rendered again, a new version has a new key
+	if html := r.RenderCached("post:1:1", "*two*"); html != first {
+		t.Errorf("Expected the cached html, got %s", html)
+	}
+	if html := r.RenderCached("post:1:2", "*two*"); html != "<p><em>two</em></p>\n" {
+		t.Errorf("Expected the html of the new version, got %s", html)
+	}
+}
@@ -0,0 +1,232 @@
+package markup
+
+import (
+	"strings"
+)
+
+// token is a span of highlighted code, an empty class is plain text
+type token struct {
+	class string
+	text  string
+}
+
+// A list of the classes of highlighted tokens
+const (
+	classKeyword = "hl-keyword"
+	classBuiltin = "hl-builtin"
+	classString  = "hl-string"
+	classNumber  = "hl-number"
+	classComment = "hl-comment"
+)
+
+// language is the lexical syntax of a language that is highlighted
+type language struct {
+	keywords     map[string]bool
+	builtins     map[string]bool
+	ignoreCase   bool
+	lineComments []string
+	// blockComment is the start and the end of a comment, when the
+	// language has one
+	blockComment [2]string
+	// quotes are the string delimiters, and whether a backslash escapes at
+	// the string
+	quotes map[byte]bool
+}
+
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(s) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	goLanguage = &language{
+		keywords: words(`break case chan const continue default defer else
+			fallthrough for func go goto if import interface map package range
+			return select struct switch type var`),
+		builtins: words(`append bool byte cap close complex copy delete error
+			false float32 float64 int int8 int16 int32 int64 iota len make new
+			nil panic print println real recover rune string true uint uint8
+			uint16 uint32 uint64 uintptr`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       map[byte]bool{'"': true, '\'': true, '`': false},
+	}
+	jsLanguage = &language{
+		keywords: words(`async await break case catch class const continue
+			debugger default delete do else export extends finally for from
+			function if import in instanceof let new of return static super
+			switch this throw try typeof var void while with yield`),
+		builtins:     words(`false null true undefined NaN Infinity console`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       map[byte]bool{'"': true, '\'': true, '`': true},
+	}
+	pythonLanguage = &language{
+		keywords: words(`and as assert async await break class continue def
+			del elif else except finally for from global if import in is
+			lambda nonlocal not or pass raise return try while with yield`),
+		builtins:     words(`False None True print len range self`),
+		lineComments: []string{"#"},
+		quotes:       map[byte]bool{'"': true, '\'': true},
+	}
+	sqlLanguage = &language{
+		keywords: words(`add all alter and as asc begin between by case check
+			commit constraint create default delete desc distinct drop else end
+			exists foreign from group having if in index inner insert into is
+			join key left limit not null offset on or order outer primary
+			references returning right rollback select set table then union
+			unique update using values when where with`),
+		builtins:     words(`bigint bigserial boolean count integer jsonb now text timestamptz true false`),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       map[byte]bool{'\'': false},
+	}
+	shellLanguage = &language{
+		keywords: words(`case do done elif else esac export fi for function if
+			in local return then until while`),
+		builtins:     words(`cd echo exit printf read set shift source test`),
+		lineComments: []string{"#"},
+		quotes:       map[byte]bool{'"': true, '\'': false},
+	}
+	jsonLanguage = &language{
+		builtins: words(`false null true`),
+		quotes:   map[byte]bool{'"': true},
+	}
+)
+
+// languages are the highlighted languages by the info string of a fenced
+// code block
+var languages = map[string]*language{
+	"go":         goLanguage,
+	"golang":     goLanguage,
+	"js":         jsLanguage,
+	"javascript": jsLanguage,
+	"ts":         jsLanguage,
+	"typescript": jsLanguage,
+	"py":         pythonLanguage,
+	"python":     pythonLanguage,
+	"sql":        sqlLanguage,
+	"sh":         shellLanguage,
+	"bash":       shellLanguage,
+	"shell":      shellLanguage,
+	"json":       jsonLanguage,
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// highlight splits code to tokens, or returns false for a language that is
+// not known
+func highlight(code, name string) ([]token, bool) {
+	lang, ok := languages[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+
+	tokens := make([]token, 0)
+	plain := 0
+	emit := func(start, end int, class string) {
+		if plain < start {
+			tokens = append(tokens, token{text: code[plain:start]})
+		}
+		tokens = append(tokens, token{class: class, text: code[start:end]})
+		plain = end
+	}
+
+	for i := 0; i < len(code); {
+		if end := lang.comment(code, i); end > i {
+			emit(i, end, classComment)
+			i = end
+			continue
+		}
+
+		c := code[i]
+		if escapes, ok := lang.quotes[c]; ok {
+			end := stringEnd(code, i, escapes)
+			emit(i, end, classString)
+			i = end
+			continue
+		}
+		if isDigit(c) {
+			end := i + 1
+			for end < len(code) && (isIdentPart(code[end]) || code[end] == '.') {
+				end++
+			}
+			emit(i, end, classNumber)
+			i = end
+			continue
+		}
+		if isIdentStart(c) {
+			end := i + 1
+			for end < len(code) && isIdentPart(code[end]) {
+				end++
+			}
+			word := code[i:end]
+			if lang.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			switch {
+			case lang.keywords[word]:
+				emit(i, end, classKeyword)
+			case lang.builtins[word]:
+				emit(i, end, classBuiltin)
+			}
+			i = end
+			continue
+		}
+		i++
+	}
+	if plain < len(code) {
+		tokens = append(tokens, token{text: code[plain:]})
+	}
+	return tokens, true
+}
+
+// comment returns the end of a comment that starts at i, or i
+func (lang *language) comment(code string, i int) int {
+	rest := code[i:]
+	for _, prefix := range lang.lineComments {
+		if strings.HasPrefix(rest, prefix) {
+			if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				return i + end
+			}
+			return len(code)
+		}
+	}
+	if start := lang.blockComment[0]; start != "" && strings.HasPrefix(rest, start) {
+		if end := strings.Index(rest[len(start):], lang.blockComment[1]); end >= 0 {
+			return i + len(start) + end + len(lang.blockComment[1])
+		}
+		return len(code)
+	}
+	return i
+}
+
+// stringEnd returns the end of a string literal that starts at i
+func stringEnd(code string, i int, escapes bool) int {
+	quote := code[i]
+	for end := i + 1; end < len(code); end++ {
+		switch code[end] {
+		case '\\':
+			if escapes {
+				end++
+			}
+		case quote:
+			return end + 1
+		}
+	}
+	return len(code)
+}
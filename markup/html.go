@@ -0,0 +1,320 @@
+package markup
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// writer writes the HTML of a document through a policy
+type writer struct {
+	b         strings.Builder
+	policy    *Policy
+	refs      map[string]reference
+	highlight bool
+	// anchors are the ids of the headings that were written, nil when
+	// headings have no anchors
+	anchors map[string]bool
+}
+
+// open writes the start tag of an element if the policy allows it
+func (w *writer) open(element string, attrs ...attr) bool {
+	attrs, ok := w.policy.filter(element, attrs)
+	if !ok {
+		return false
+	}
+	w.b.WriteByte('<')
+	w.b.WriteString(element)
+	for _, a := range attrs {
+		fmt.Fprintf(&w.b, ` %s="%s"`, a.name, html.EscapeString(a.value))
+	}
+	w.b.WriteByte('>')
+	return true
+}
+
+// close writes the end tag of an element that was opened
+func (w *writer) close(element string, opened bool) {
+	if opened {
+		w.b.WriteString("</" + element + ">")
+	}
+}
+
+// void writes an element without content if the policy allows it
+func (w *writer) void(element string, attrs ...attr) {
+	if attrs, ok := w.policy.filter(element, attrs); ok {
+		w.b.WriteByte('<')
+		w.b.WriteString(element)
+		for _, a := range attrs {
+			fmt.Fprintf(&w.b, ` %s="%s"`, a.name, html.EscapeString(a.value))
+		}
+		w.b.WriteString(" />")
+	}
+}
+
+func (w *writer) text(s string) {
+	w.b.WriteString(html.EscapeString(s))
+}
+
+func (w *writer) newline() {
+	w.b.WriteByte('\n')
+}
+
+// blocks writes a list of blocks, the paragraphs of a tight list are
+// written without their element
+func (w *writer) blocks(blocks []*block, tight bool) {
+	for _, b := range blocks {
+		w.block(b, tight)
+	}
+}
+
+func (w *writer) block(b *block, tight bool) {
+	switch b.kind {
+	case paragraphBlock:
+		if tight {
+			w.inlines(parseInlines(b.text, w.refs), false)
+			return
+		}
+		opened := w.open("p")
+		w.inlines(parseInlines(b.text, w.refs), false)
+		w.close("p", opened)
+		w.newline()
+	case headingBlock:
+		w.heading(b)
+	case thematicBreakBlock:
+		w.void("hr")
+		w.newline()
+	case codeBlock:
+		w.code(b)
+	case quoteBlock:
+		opened := w.open("blockquote")
+		w.newline()
+		w.blocks(b.children, false)
+		w.close("blockquote", opened)
+		w.newline()
+	case listBlock:
+		w.list(b)
+	case tableBlock:
+		w.table(b)
+	}
+}
+
+// heading writes a heading with an anchor, or a paragraph when the policy
+// does not allow headings
+func (w *writer) heading(b *block) {
+	content := parseInlines(b.text, w.refs)
+	element := "h" + strconv.Itoa(b.level)
+	if !w.policy.allows(element) {
+		element = "p"
+	}
+
+	var attrs []attr
+	if w.anchors != nil && w.policy.allowsAttr(element, "id") {
+		attrs = append(attrs, attr{name: "id", value: w.anchor(plainText(content))})
+	}
+	opened := w.open(element, attrs...)
+	w.inlines(content, false)
+	w.close(element, opened)
+	w.newline()
+}
+
+// anchor returns a unique id of a heading text
+func (w *writer) anchor(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteByte('-')
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "section"
+	}
+
+	id := base
+	for i := 1; w.anchors[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	w.anchors[id] = true
+	return id
+}
+
+// code writes a code block, highlighted when its language is known
+func (w *writer) code(b *block) {
+	pre := w.open("pre")
+	var attrs []attr
+	if b.info != "" {
+		attrs = append(attrs, attr{name: "class", value: "language-" + b.info})
+	}
+	code := w.open("code", attrs...)
+
+	tokens, ok := highlight(b.text, b.info)
+	if !w.highlight || !ok {
+		tokens = []token{{text: b.text}}
+	}
+	for _, t := range tokens {
+		if t.class == "" {
+			w.text(t.text)
+			continue
+		}
+		opened := w.open("span", attr{name: "class", value: t.class})
+		w.text(t.text)
+		w.close("span", opened)
+	}
+
+	w.close("code", code)
+	w.close("pre", pre)
+	w.newline()
+}
+
+func (w *writer) list(b *block) {
+	element := "ul"
+	var attrs []attr
+	if b.ordered {
+		element = "ol"
+		if b.start != 1 {
+			attrs = append(attrs, attr{name: "start", value: strconv.Itoa(b.start)})
+		}
+	}
+
+	opened := w.open(element, attrs...)
+	w.newline()
+	for _, item := range b.children {
+		li := w.open("li")
+		switch item.task {
+		case openTask:
+			w.void("input", attr{name: "type", value: "checkbox"}, attr{name: "disabled"})
+			w.b.WriteByte(' ')
+		case doneTask:
+			w.void("input", attr{name: "type", value: "checkbox"}, attr{name: "checked"}, attr{name: "disabled"})
+			w.b.WriteByte(' ')
+		}
+		if !b.tight && len(item.children) > 0 {
+			w.newline()
+		}
+		w.blocks(item.children, b.tight)
+		w.close("li", li)
+		w.newline()
+	}
+	w.close(element, opened)
+	w.newline()
+}
+
+func (w *writer) table(b *block) {
+	table := w.open("table")
+	w.newline()
+	thead := w.open("thead")
+	w.newline()
+	w.row(b.rows[0], "th", b.align)
+	w.close("thead", thead)
+	w.newline()
+	if len(b.rows) > 1 {
+		tbody := w.open("tbody")
+		w.newline()
+		for _, row := range b.rows[1:] {
+			w.row(row, "td", b.align)
+		}
+		w.close("tbody", tbody)
+		w.newline()
+	}
+	w.close("table", table)
+	w.newline()
+}
+
+func (w *writer) row(cells []string, element string, align []string) {
+	tr := w.open("tr")
+	w.newline()
+	for i, cell := range cells {
+		var attrs []attr
+		if align[i] != "" {
+			attrs = append(attrs, attr{name: "align", value: align[i]})
+		}
+		opened := w.open(element, attrs...)
+		w.inlines(parseInlines(cell, w.refs), false)
+		w.close(element, opened)
+		w.newline()
+	}
+	w.close("tr", tr)
+	w.newline()
+}
+
+// inlines writes inline nodes. Links inside of links are written as their
+// text.
+func (w *writer) inlines(nodes []*inline, inLink bool) {
+	for _, node := range nodes {
+		switch node.kind {
+		case textInline:
+			if node.delim != nil {
+				w.text(strings.Repeat(string(node.delim.char), node.delim.count))
+				continue
+			}
+			w.text(node.text)
+		case codeInline:
+			opened := w.open("code")
+			w.text(node.text)
+			w.close("code", opened)
+		case softBreakInline:
+			w.newline()
+		case hardBreakInline:
+			w.void("br")
+			w.newline()
+		case emphasisInline:
+			w.wrap("em", node.children, inLink)
+		case strongInline:
+			w.wrap("strong", node.children, inLink)
+		case strikeInline:
+			w.wrap("del", node.children, inLink)
+		case linkInline:
+			w.link(node, inLink)
+		case imageInline:
+			w.image(node, inLink)
+		}
+	}
+}
+
+func (w *writer) wrap(element string, children []*inline, inLink bool) {
+	opened := w.open(element)
+	w.inlines(children, inLink)
+	w.close(element, opened)
+}
+
+// link writes a link, or its text when its URL is not allowed
+func (w *writer) link(node *inline, inLink bool) {
+	if inLink || !w.policy.allowsURL(node.dest) {
+		w.inlines(node.children, inLink)
+		return
+	}
+
+	attrs := []attr{{name: "href", value: node.dest}}
+	if node.title != "" {
+		attrs = append(attrs, attr{name: "title", value: node.title})
+	}
+	opened := w.open("a", attrs...)
+	w.inlines(node.children, opened || inLink)
+	w.close("a", opened)
+}
+
+// image writes an image, or a link to it when the policy does not allow
+// images
+func (w *writer) image(node *inline, inLink bool) {
+	alt := plainText(node.children)
+	if !w.policy.allowsURL(node.dest) {
+		w.text(alt)
+		return
+	}
+	if !w.policy.allows("img") {
+		w.link(&inline{kind: linkInline, dest: node.dest, title: node.title,
+			children: []*inline{{kind: textInline, text: alt}}}, inLink)
+		return
+	}
+
+	attrs := []attr{{name: "src", value: node.dest}, {name: "alt", value: alt}}
+	if node.title != "" {
+		attrs = append(attrs, attr{name: "title", value: node.title})
+	}
+	w.void("img", attrs...)
+}
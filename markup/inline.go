@@ -0,0 +1,696 @@
+package markup
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// inlineKind is the type of an inline node
+type inlineKind int
+
+// A list of inline kinds
+const (
+	textInline inlineKind = iota
+	codeInline
+	softBreakInline
+	hardBreakInline
+	linkInline
+	imageInline
+	emphasisInline
+	strongInline
+	strikeInline
+)
+
+// inline is a node of the inline content of a block
+type inline struct {
+	kind inlineKind
+	// text is the literal text, or the code of a code span
+	text        string
+	dest, title string
+	children    []*inline
+	// delim is set while the text is a run of emphasis delimiters
+	delim *delimiter
+}
+
+// delimiter is a run of the characters that open or close emphasis
+type delimiter struct {
+	char byte
+	// count is the number of characters that were not used yet
+	count    int
+	original int
+	canOpen  bool
+	canClose bool
+}
+
+// bracket is an opening bracket of a link or an image
+type bracket struct {
+	// node is the index of the bracket text at the nodes
+	node   int
+	image  bool
+	active bool
+	// start is the position of the link text at the source
+	start int
+}
+
+var (
+	entity       = regexp.MustCompile(`^&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	autolinkURL  = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.\-]{1,31}:[^<>\x00-\x20]*)>`)
+	autolinkMail = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+)
+
+// inlineParser parses the inline content of a block
+type inlineParser struct {
+	src      string
+	pos      int
+	refs     map[string]reference
+	nodes    []*inline
+	brackets []bracket
+	text     strings.Builder
+}
+
+// parseInlines returns the inline nodes of src
+func parseInlines(src string, refs map[string]reference) []*inline {
+	p := &inlineParser{src: src, refs: refs}
+	for p.pos < len(p.src) {
+		p.next()
+	}
+	p.flush()
+	processEmphasis(p.nodes)
+	return compact(p.nodes)
+}
+
+// isASCIIPunct returns true for the characters that a backslash escapes
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// unescape resolves the backslash escapes and the entities of a link
+// destination or title
+func unescape(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i] == '&' {
+			if m := entity.FindString(s[i:]); m != "" {
+				b.WriteString(html.UnescapeString(m))
+				i += len(m) - 1
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// flush adds the pending text as a node
+func (p *inlineParser) flush() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.nodes = append(p.nodes, &inline{kind: textInline, text: p.text.String()})
+	p.text.Reset()
+}
+
+// add adds a node after the pending text
+func (p *inlineParser) add(node *inline) {
+	p.flush()
+	p.nodes = append(p.nodes, node)
+}
+
+// next parses the node at the current position
+func (p *inlineParser) next() {
+	c := p.src[p.pos]
+	switch c {
+	case '\\':
+		p.parseEscape()
+	case '`':
+		p.parseCodeSpan()
+	case '*', '_', '~':
+		p.parseDelimiters()
+	case '[':
+		p.add(&inline{kind: textInline, text: "["})
+		p.brackets = append(p.brackets, bracket{node: len(p.nodes) - 1, active: true, start: p.pos + 1})
+		p.pos++
+	case '!':
+		if strings.HasPrefix(p.src[p.pos:], "![") {
+			p.add(&inline{kind: textInline, text: "!["})
+			p.brackets = append(p.brackets, bracket{node: len(p.nodes) - 1, image: true, active: true, start: p.pos + 2})
+			p.pos += 2
+			return
+		}
+		p.text.WriteByte(c)
+		p.pos++
+	case ']':
+		p.closeBracket()
+	case '<':
+		p.parseAutolink()
+	case '&':
+		p.parseEntity()
+	case '\n':
+		p.parseLineBreak()
+	default:
+		if p.parseExtendedAutolink() {
+			return
+		}
+		// the text up to the next character that may start a node
+		end := p.pos + 1
+		for end < len(p.src) && !mayStartInline(p.src, end) {
+			end++
+		}
+		p.text.WriteString(p.src[p.pos:end])
+		p.pos = end
+	}
+}
+
+// mayStartInline returns true if the character at i may start a node
+func mayStartInline(src string, i int) bool {
+	switch src[i] {
+	case '\\', '`', '*', '_', '~', '[', '!', ']', '<', '&', '\n':
+		return true
+	case 'h', 'H', 'w', 'W':
+		return autolinkBoundary(src, i)
+	}
+	return false
+}
+
+func (p *inlineParser) parseEscape() {
+	if p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if next == '\n' {
+			p.trimTrailingSpaces()
+			p.add(&inline{kind: hardBreakInline})
+			p.pos += 2
+			p.skipSpaces()
+			return
+		}
+		if isASCIIPunct(next) {
+			p.text.WriteByte(next)
+			p.pos += 2
+			return
+		}
+	}
+	p.text.WriteByte('\\')
+	p.pos++
+}
+
+// runLength returns the number of times that the character at i repeats
+func runLength(src string, i int) int {
+	n := 1
+	for i+n < len(src) && src[i+n] == src[i] {
+		n++
+	}
+	return n
+}
+
+// parseCodeSpan parses a code span, or a literal run of backticks that is
+// not closed
+func (p *inlineParser) parseCodeSpan() {
+	n := runLength(p.src, p.pos)
+	start := p.pos + n
+	for i := start; i < len(p.src); {
+		if p.src[i] != '`' {
+			i++
+			continue
+		}
+		closing := runLength(p.src, i)
+		if closing != n {
+			i += closing
+			continue
+		}
+
+		code := strings.ReplaceAll(p.src[start:i], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		p.add(&inline{kind: codeInline, text: code})
+		p.pos = i + n
+		return
+	}
+
+	p.text.WriteString(p.src[p.pos:start])
+	p.pos = start
+}
+
+func isSpaceRune(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+func isPunctRune(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// parseDelimiters parses a run of emphasis or strikethrough delimiters, and
+// whether it may open or close emphasis
+func (p *inlineParser) parseDelimiters() {
+	c := p.src[p.pos]
+	n := runLength(p.src, p.pos)
+	end := p.pos + n
+	if c == '~' && n > 2 {
+		p.text.WriteString(p.src[p.pos:end])
+		p.pos = end
+		return
+	}
+
+	before, after := ' ', ' '
+	if p.pos > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.src[:p.pos])
+	}
+	if end < len(p.src) {
+		after, _ = utf8.DecodeRuneInString(p.src[end:])
+	}
+
+	leftFlanking := !isSpaceRune(after) &&
+		(!isPunctRune(after) || isSpaceRune(before) || isPunctRune(before))
+	rightFlanking := !isSpaceRune(before) &&
+		(!isPunctRune(before) || isSpaceRune(after) || isPunctRune(after))
+
+	d := &delimiter{char: c, count: n, original: n, canOpen: leftFlanking, canClose: rightFlanking}
+	if c == '_' {
+		d.canOpen = leftFlanking && (!rightFlanking || isPunctRune(before))
+		d.canClose = rightFlanking && (!leftFlanking || isPunctRune(after))
+	}
+
+	p.add(&inline{kind: textInline, text: p.src[p.pos:end], delim: d})
+	p.pos = end
+}
+
+// closeBracket makes a link or an image of the nodes since the last opening
+// bracket, when a destination follows it
+func (p *inlineParser) closeBracket() {
+	p.pos++
+	if len(p.brackets) == 0 {
+		p.text.WriteByte(']')
+		return
+	}
+	last := p.brackets[len(p.brackets)-1]
+	p.brackets = p.brackets[:len(p.brackets)-1]
+	if !last.active {
+		p.text.WriteByte(']')
+		return
+	}
+
+	label := p.src[last.start : p.pos-1]
+	dest, title, end, ok := p.parseLinkTail(p.pos, label)
+	if !ok {
+		p.text.WriteByte(']')
+		return
+	}
+
+	p.flush()
+	children := p.nodes[last.node+1:]
+	processEmphasis(children)
+	kind := linkInline
+	if last.image {
+		kind = imageInline
+	}
+	node := &inline{kind: kind, dest: dest, title: title, children: compact(children)}
+	p.nodes = append(p.nodes[:last.node], node)
+	p.pos = end
+
+	// links may not contain other links
+	if !last.image {
+		for i := range p.brackets {
+			if !p.brackets[i].image {
+				p.brackets[i].active = false
+			}
+		}
+	}
+}
+
+// parseLinkTail parses the destination of a link that its text ends at i,
+// either inline or a reference
+func (p *inlineParser) parseLinkTail(i int, label string) (string, string, int, bool) {
+	if i < len(p.src) && p.src[i] == '(' {
+		if dest, title, end, ok := p.parseInlineDest(i + 1); ok {
+			return dest, title, end, true
+		}
+	}
+
+	end := i
+	if i < len(p.src) && p.src[i] == '[' {
+		if closing := strings.IndexByte(p.src[i+1:], ']'); closing >= 0 {
+			if full := p.src[i+1 : i+1+closing]; strings.TrimSpace(full) != "" {
+				label = full
+			}
+			end = i + closing + 2
+		}
+	}
+	if len(label) > 999 || strings.ContainsAny(label, "[]") && !strings.Contains(label, `\`) {
+		return "", "", 0, false
+	}
+	ref, ok := p.refs[normalizeLabel(label)]
+	if !ok {
+		return "", "", 0, false
+	}
+	return ref.dest, ref.title, end, true
+}
+
+// skipWhitespace returns the position after the white space at i, with up
+// to one line ending
+func skipWhitespace(src string, i int) int {
+	newline := false
+	for i < len(src) {
+		switch src[i] {
+		case ' ', '\t':
+		case '\n':
+			if newline {
+				return i
+			}
+			newline = true
+		default:
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// parseInlineDest parses the destination and the title of an inline link,
+// from the position after its opening parenthesis
+func (p *inlineParser) parseInlineDest(i int) (string, string, int, bool) {
+	src := p.src
+	i = skipWhitespace(src, i)
+
+	var dest string
+	switch {
+	case i < len(src) && src[i] == '<':
+		end := i + 1
+		for end < len(src) && src[end] != '>' && src[end] != '<' && src[end] != '\n' {
+			if src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(src) || src[end] != '>' {
+			return "", "", 0, false
+		}
+		dest = src[i+1 : end]
+		i = end + 1
+	default:
+		start, depth := i, 0
+		for i < len(src) {
+			c := src[i]
+			if c == '\\' && i+1 < len(src) && isASCIIPunct(src[i+1]) {
+				i += 2
+				continue
+			}
+			if c <= ' ' || (c == ')' && depth == 0) {
+				break
+			}
+			if c == '(' {
+				// CommonMark limits the nesting of the parentheses
+				if depth++; depth > 32 {
+					return "", "", 0, false
+				}
+			} else if c == ')' {
+				depth--
+			}
+			i++
+		}
+		if depth != 0 {
+			return "", "", 0, false
+		}
+		dest = src[start:i]
+	}
+
+	afterDest := i
+	i = skipWhitespace(src, i)
+	var title string
+	if i > afterDest && i < len(src) && strings.IndexByte(`"'(`, src[i]) >= 0 {
+		closer := src[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := i + 1
+		for end < len(src) && src[end] != closer {
+			if src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(src) {
+			return "", "", 0, false
+		}
+		title = src[i+1 : end]
+		i = skipWhitespace(src, end+1)
+	}
+
+	if i >= len(src) || src[i] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(dest), unescape(title), i + 1, true
+}
+
+// parseAutolink parses a URL or an email address between angle brackets
+func (p *inlineParser) parseAutolink() {
+	rest := p.src[p.pos:]
+	if m := autolinkURL.FindStringSubmatch(rest); m != nil {
+		p.add(&inline{kind: linkInline, dest: m[1], children: []*inline{{kind: textInline, text: m[1]}}})
+		p.pos += len(m[0])
+		return
+	}
+	if m := autolinkMail.FindStringSubmatch(rest); m != nil {
+		p.add(&inline{kind: linkInline, dest: "mailto:" + m[1], children: []*inline{{kind: textInline, text: m[1]}}})
+		p.pos += len(m[0])
+		return
+	}
+	p.text.WriteByte('<')
+	p.pos++
+}
+
+// autolinkBoundary returns true if an extended autolink may start at i
+func autolinkBoundary(src string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	return strings.IndexByte(" \t\n*_~(", src[i-1]) >= 0
+}
+
+// parseExtendedAutolink parses a GFM autolink of a www. or an http(s):// URL
+// that is not between angle brackets
+func (p *inlineParser) parseExtendedAutolink() bool {
+	if !autolinkBoundary(p.src, p.pos) {
+		return false
+	}
+	rest := p.src[p.pos:]
+	lower := strings.ToLower(rest[:min(len(rest), 8)])
+	prefix := ""
+	for _, candidate := range []string{"https://", "http://", "www."} {
+		if strings.HasPrefix(lower, candidate) {
+			prefix = candidate
+			break
+		}
+	}
+	if prefix == "" {
+		return false
+	}
+
+	end := strings.IndexAny(rest, " \t\n<")
+	if end < 0 {
+		end = len(rest)
+	}
+	link := trimAutolink(rest[:end])
+	if len(link) <= len(prefix) || !strings.Contains(link[len(prefix):], ".") && prefix == "www." {
+		return false
+	}
+
+	dest := link
+	if prefix == "www." {
+		dest = "http://" + link
+	}
+	p.add(&inline{kind: linkInline, dest: dest, children: []*inline{{kind: textInline, text: link}}})
+	p.pos += len(link)
+	return true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// trimAutolink removes the trailing punctuation of an extended autolink,
+// and closing parentheses that were not opened at the link
+func trimAutolink(link string) string {
+	for len(link) > 0 {
+		last := link[len(link)-1]
+		switch {
+		case strings.IndexByte("?!.,:*_~'\"", last) >= 0:
+			link = link[:len(link)-1]
+		case last == ')' && strings.Count(link, ")") > strings.Count(link, "("):
+			link = link[:len(link)-1]
+		default:
+			return link
+		}
+	}
+	return link
+}
+
+// parseEntity parses an HTML entity, an unknown entity stays as text
+func (p *inlineParser) parseEntity() {
+	if m := entity.FindString(p.src[p.pos:]); m != "" {
+		if decoded := html.UnescapeString(m); decoded != m {
+			p.text.WriteString(decoded)
+			p.pos += len(m)
+			return
+		}
+	}
+	p.text.WriteByte('&')
+	p.pos++
+}
+
+// trimTrailingSpaces removes the spaces at the end of the pending text, and
+// returns how many there were
+func (p *inlineParser) trimTrailingSpaces() int {
+	text := p.text.String()
+	trimmed := strings.TrimRight(text, " ")
+	if len(trimmed) != len(text) {
+		p.text.Reset()
+		p.text.WriteString(trimmed)
+	}
+	return len(text) - len(trimmed)
+}
+
+func (p *inlineParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseLineBreak parses a line ending, that is a hard break after 2 spaces
+func (p *inlineParser) parseLineBreak() {
+	kind := softBreakInline
+	if p.trimTrailingSpaces() >= 2 {
+		kind = hardBreakInline
+	}
+	p.add(&inline{kind: kind})
+	p.pos++
+	p.skipSpaces()
+}
+
+// compact returns the nodes that were not removed
+func compact(nodes []*inline) []*inline {
+	list := make([]*inline, 0, len(nodes))
+	for _, node := range nodes {
+		if node != nil {
+			list = append(list, node)
+		}
+	}
+	return list
+}
+
+// bottomKey is the kind of a closing delimiter, that shares the lowest
+// position that an opener may be found at
+type bottomKey struct {
+	char    byte
+	canOpen bool
+	mod     int
+}
+
+// matches returns true if opener and closer may form emphasis, following the
+// rule of 3 of CommonMark
+func matches(opener, closer *delimiter) bool {
+	if closer.char == '~' {
+		return opener.count == closer.count
+	}
+	if (opener.canClose || closer.canOpen) && (opener.original+closer.original)%3 == 0 &&
+		!(opener.original%3 == 0 && closer.original%3 == 0) {
+		return false
+	}
+	return true
+}
+
+// processEmphasis pairs the delimiter runs of nodes to emphasis, strong
+// emphasis and strikethrough. Nodes that are wrapped are replaced with nil,
+// so the positions of the others stay.
+func processEmphasis(nodes []*inline) {
+	bottoms := make(map[bottomKey]int)
+	for c := 0; c < len(nodes); c++ {
+		closer := nodes[c]
+		if closer == nil || closer.delim == nil || !closer.delim.canClose || closer.delim.count == 0 {
+			continue
+		}
+		cd := closer.delim
+		key := bottomKey{char: cd.char, canOpen: cd.canOpen, mod: cd.original % 3}
+		bottom, ok := bottoms[key]
+		if !ok {
+			bottom = -1
+		}
+
+		o := c - 1
+		for ; o > bottom; o-- {
+			opener := nodes[o]
+			if opener == nil || opener.delim == nil {
+				continue
+			}
+			od := opener.delim
+			if od.char == cd.char && od.canOpen && od.count > 0 && matches(od, cd) {
+				break
+			}
+		}
+		if o <= bottom || c-o < 2 {
+			bottoms[key] = c - 1
+			continue
+		}
+
+		od := nodes[o].delim
+		kind, use := emphasisInline, 1
+		switch {
+		case cd.char == '~':
+			kind, use = strikeInline, cd.count
+		case cd.count >= 2 && od.count >= 2:
+			kind, use = strongInline, 2
+		}
+		od.count -= use
+		cd.count -= use
+
+		children := compact(nodes[o+1 : c])
+		for k := o + 1; k < c; k++ {
+			nodes[k] = nil
+		}
+		nodes[c-1] = &inline{kind: kind, children: children}
+		if od.count == 0 {
+			nodes[o] = nil
+		}
+		if cd.count == 0 {
+			nodes[c] = nil
+			continue
+		}
+		// the rest of the closer may close another opener
+		c--
+	}
+}
+
+// plainText returns the text of nodes without formatting, for the alternate
+// text of images and the anchors of headings
+func plainText(nodes []*inline) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		switch node.kind {
+		case textInline:
+			if node.delim != nil {
+				b.WriteString(strings.Repeat(string(node.delim.char), node.delim.count))
+				continue
+			}
+			b.WriteString(node.text)
+		case codeInline:
+			b.WriteString(node.text)
+		case softBreakInline, hardBreakInline:
+			b.WriteByte(' ')
+		default:
+			b.WriteString(plainText(node.children))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+// Package markup renders the markdown of posts and comments to HTML.
+//
+// The source is CommonMark with the GFM extensions of tables, strikethrough,
+// task lists and autolinks. Raw HTML at the source is escaped, and every
+// element of the output passes through the allowlist of a Policy, so the
+// output is safe to embed in a page. Fenced code of the known languages is
+// highlighted with spans of hl-* classes, and headings get anchor ids.
+package markup
+
+// DefaultCacheSize is the number of rendered documents that a Renderer keeps
+const DefaultCacheSize = 1024
+
+// Options of a Renderer
+type Options struct {
+	// Policy is the allowlist of the output, PostPolicy by default
+	Policy *Policy
+	// CacheSize is the number of rendered documents that are kept,
+	// DefaultCacheSize by default
+	CacheSize int
+	// NoHighlight disables the highlighting of fenced code
+	NoHighlight bool
+	// NoAnchors disables the anchor ids of headings
+	NoAnchors bool
+}
+
+// Renderer renders markdown to HTML. It is safe for concurrent use.
+type Renderer struct {
+	options Options
+	cache   *Cache
+}
+
+// New creates a Renderer, nil options uses the defaults
+func New(options *Options) *Renderer {
+	r := &Renderer{}
+	if options != nil {
+		r.options = *options
+	}
+	if r.options.Policy == nil {
+		r.options.Policy = PostPolicy()
+	}
+	if r.options.CacheSize <= 0 {
+		r.options.CacheSize = DefaultCacheSize
+	}
+	r.cache = NewCache(r.options.CacheSize)
+	return r
+}
+
+// Render returns the HTML of src
+func (r *Renderer) Render(src string) string {
+	parser := &blockParser{refs: make(map[string]reference)}
+	blocks := parser.parse(splitLines(src))
+
+	w := &writer{
+		policy:    r.options.Policy,
+		refs:      parser.refs,
+		highlight: !r.options.NoHighlight,
+	}
+	if !r.options.NoAnchors {
+		w.anchors = make(map[string]bool)
+	}
+	w.blocks(blocks, false)
+	return w.b.String()
+}
+
+// RenderCached returns the HTML of src, that is rendered once per key. The
+// key must change whenever src does, such as the id and the version of a
+// post.
+func (r *Renderer) RenderCached(key, src string) string {
+	if html, ok := r.cache.Get(key); ok {
+		return html
+	}
+	html := r.Render(src)
+	r.cache.Put(key, html)
+	return html
+}
@@ -0,0 +1,114 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		src, html string
+	}{
+		{"Some **bold**, _em_ and ~~gone~~", "<p>Some <strong>bold</strong>, <em>em</em> and <del>gone</del></p>\n"},
+		{"***both*** foo*bar* _foo_bar", "<p><em><strong>both</strong></em> foo<em>bar</em> _foo_bar</p>\n"},
+		{"Title\n=====\n\n## Sub *title* ##", "<h1 id=\"title\">Title</h1>\n<h2 id=\"sub-title\">Sub <em>title</em></h2>\n"},
+		{"line  \nnext\\\nlast", "<p>line<br />\nnext<br />\nlast</p>\n"},
+		{"> quote\nlazy", "<blockquote>\n<p>quote\nlazy</p>\n</blockquote>\n"},
+		{"- a\n- b\n  - c", "<ul>\n<li>a</li>\n<li>b<ul>\n<li>c</li>\n</ul>\n</li>\n</ul>\n"},
+		{"3. a\n\n4. b", "<ol start=\"3\">\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ol>\n"},
+		{"- [x] done\n- [ ] todo", "<ul>\n<li><input type=\"checkbox\" checked=\"\" disabled=\"\" /> done</li>\n" +
+			"<li><input type=\"checkbox\" disabled=\"\" /> todo</li>\n</ul>\n"},
+		{"    code\n\n***", "<pre><code>code\n</code></pre>\n<hr />\n"},
+		{"```\n<b>\n```", "<pre><code>&lt;b&gt;\n</code></pre>\n"},
+		{"| a | b |\n|:-|-:|\n| `1` | \\| |", "<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td align=\"left\"><code>1</code></td>\n<td align=\"right\">|</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"[a](/x \"t\") ![b](/y.png)", "<p><a href=\"/x\" title=\"t\">a</a> <img src=\"/y.png\" alt=\"b\" /></p>\n"},
+		{"[r]: http://r.com\n\n[r] [text][r]", "<p><a href=\"http://r.com\">r</a> <a href=\"http://r.com\">text</a></p>\n"},
+		{"<http://a.com> www.b.com/c. (https://d.com)", "<p><a href=\"http://a.com\">http://a.com</a> " +
+			"<a href=\"http://www.b.com/c\">www.b.com/c</a>. (<a href=\"https://d.com\">https://d.com</a>)</p>\n"},
+		{"[a [b](c) d](e)", "<p>[a <a href=\"c\">b</a> d](e)</p>\n"},
+		{"&copy; &nope; `a  b`", "<p>© &amp;nope; <code>a  b</code></p>\n"},
+	}
+
+	r := New(nil)
+	for _, test := range tests {
+		if html := r.Render(test.src); html != test.html {
+			t.Errorf("Expected for %q:\n%s\ngot:\n%s", test.src, test.html, html)
+		}
+	}
+}
+
+func TestRenderAnchors(t *testing.T) {
+	html := New(nil).Render("# Same\n# Same\n# Héllo wörld!")
+	expected := "<h1 id=\"same\">Same</h1>\n<h1 id=\"same-1\">Same</h1>\n<h1 id=\"héllo-wörld\">Héllo wörld!</h1>\n"
+	if html != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, html)
+	}
+
+	html = New(&Options{NoAnchors: true}).Render("# Same")
+	if html != "<h1>Same</h1>\n" {
+		t.Errorf("Expected a heading without an anchor, got %s", html)
+	}
+}
+
+func TestRenderHighlight(t *testing.T) {
+	html := New(nil).Render("```go\nfunc f() int { return 42 } // \"x\"\n```")
+	expected := `<pre><code class="language-go"><span class="hl-keyword">func</span> f() ` +
+		`<span class="hl-builtin">int</span> { <span class="hl-keyword">return</span> ` +
+		`<span class="hl-number">42</span> } <span class="hl-comment">// &#34;x&#34;</span>` + "\n</code></pre>\n"
+	if html != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, html)
+	}
+
+	html = New(&Options{NoHighlight: true}).Render("```go\nfunc f()\n```")
+	if html != "<pre><code class=\"language-go\">func f()\n</code></pre>\n" {
+		t.Errorf("Expected code without highlighting, got %s", html)
+	}
+}
+
+func TestRenderSanitises(t *testing.T) {
+	tests := []string{
+		`<script>alert(1)</script>`,
+		`<img src=x onerror=alert(1)>`,
+		`[x](javascript:alert(1))`,
+		`[x](JaVaScRiPt:alert(1))`,
+		`[x](java&#x09;script:alert(1))`,
+		`[x](data:text/html;base64,PHNjcmlwdD4=)`,
+		`<javascript:alert(1)>`,
+		`![x](javascript:alert(1))`,
+		`[x](/a" onmouseover="alert(1))`,
+		`![a" onerror="alert(1)](/x.png)`,
+		`[x](/a "t\" onmouseover=\"alert(1)")`,
+		// destinations of a bare angle bracket
+		"[a]: <",
+		"[a]:<",
+		"x\n\n[a]: <",
+		"[a]: <\n\n[a]",
+		"[x](<)",
+		"[x](<",
+	}
+
+	for _, policy := range []*Policy{PostPolicy(), CommentPolicy()} {
+		r := New(&Options{Policy: policy})
+		for _, src := range tests {
+			html := r.Render(src)
+			lower := strings.ToLower(html)
+			for _, bad := range []string{"<script", "<img src=x", "=\"javascript:", "=\"data:", "\" on"} {
+				if strings.Contains(lower, bad) {
+					t.Errorf("Expected %q to be removed from %q, got %s", bad, src, html)
+				}
+			}
+		}
+	}
+}
+
+func TestCommentPolicy(t *testing.T) {
+	r := New(&Options{Policy: CommentPolicy()})
+
+	html := r.Render("# Title\n\n[a](http://a.com) ![b](http://b.com/b.png)")
+	expected := "<p>Title</p>\n<p><a href=\"http://a.com\" rel=\"nofollow ugc noopener\">a</a> " +
+		"<a href=\"http://b.com/b.png\" rel=\"nofollow ugc noopener\">b</a></p>\n"
+	if html != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, html)
+	}
+}
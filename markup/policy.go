@@ -0,0 +1,165 @@
+package markup
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// attr is an attribute of an HTML element
+type attr struct {
+	name, value string
+}
+
+// Policy is the allowlist of the HTML that a Renderer writes. Elements that
+// are not allowed are dropped while their content is kept, links and images
+// of URLs that are not allowed are written as text, and attributes that are
+// not allowed are dropped.
+type Policy struct {
+	// elements are the allowed attributes of every allowed element, a nil
+	// pattern allows any value
+	elements map[string]map[string]*regexp.Regexp
+	schemes  map[string]bool
+	nofollow bool
+}
+
+var (
+	languageClass  = regexp.MustCompile(`^language-[A-Za-z0-9_+#.-]+$`)
+	highlightClass = regexp.MustCompile(`^hl-[a-z]+$`)
+	alignment      = regexp.MustCompile(`^(left|center|right)$`)
+	anchorID       = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+	number         = regexp.MustCompile(`^[0-9]{1,9}$`)
+	checkbox       = regexp.MustCompile(`^checkbox$`)
+	empty          = regexp.MustCompile(`^$`)
+)
+
+// NewPolicy creates a policy that allows nothing
+func NewPolicy() *Policy {
+	return &Policy{
+		elements: make(map[string]map[string]*regexp.Regexp),
+		schemes:  make(map[string]bool),
+	}
+}
+
+// AllowElements allows elements without attributes
+func (p *Policy) AllowElements(names ...string) *Policy {
+	for _, name := range names {
+		if _, ok := p.elements[name]; !ok {
+			p.elements[name] = make(map[string]*regexp.Regexp)
+		}
+	}
+	return p
+}
+
+// AllowAttr allows an attribute of an element, and the element. A nil
+// pattern allows any value.
+func (p *Policy) AllowAttr(element, name string, pattern *regexp.Regexp) *Policy {
+	p.AllowElements(element)
+	p.elements[element][name] = pattern
+	return p
+}
+
+// AllowSchemes allows absolute URLs of the schemes at links and images,
+// relative URLs are always allowed
+func (p *Policy) AllowSchemes(schemes ...string) *Policy {
+	for _, scheme := range schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+// RequireNofollow marks all links as user generated, so search engines do
+// not follow them
+func (p *Policy) RequireNofollow() *Policy {
+	p.nofollow = true
+	return p
+}
+
+// basePolicy allows the formatting that posts and comments share
+func basePolicy() *Policy {
+	return NewPolicy().
+		AllowElements("p", "br", "em", "strong", "del", "blockquote", "hr",
+			"ul", "li", "pre", "table", "thead", "tbody", "tr").
+		AllowAttr("ol", "start", number).
+		AllowAttr("code", "class", languageClass).
+		AllowAttr("span", "class", highlightClass).
+		AllowAttr("th", "align", alignment).
+		AllowAttr("td", "align", alignment).
+		AllowAttr("input", "type", checkbox).
+		AllowAttr("input", "checked", empty).
+		AllowAttr("input", "disabled", empty).
+		AllowAttr("a", "href", nil).
+		AllowAttr("a", "title", nil).
+		AllowSchemes("http", "https", "mailto")
+}
+
+// PostPolicy returns the policy of posts, that are written by the staff. It
+// allows headings with anchors and images.
+func PostPolicy() *Policy {
+	p := basePolicy()
+	for _, heading := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+		p.AllowAttr(heading, "id", anchorID)
+	}
+	return p.
+		AllowAttr("img", "src", nil).
+		AllowAttr("img", "alt", nil).
+		AllowAttr("img", "title", nil)
+}
+
+// CommentPolicy returns the policy of comments, that are written by anyone.
+// Headings are written as their text, images as links, and all links are
+// marked as nofollow.
+func CommentPolicy() *Policy {
+	return basePolicy().RequireNofollow()
+}
+
+// allows returns true if element is allowed
+func (p *Policy) allows(element string) bool {
+	_, ok := p.elements[element]
+	return ok
+}
+
+// allowsAttr returns true if the attribute of element is allowed
+func (p *Policy) allowsAttr(element, name string) bool {
+	_, ok := p.elements[element][name]
+	return ok
+}
+
+// allowsURL returns true if a link or an image may point at raw
+func (p *Policy) allowsURL(raw string) bool {
+	for _, r := range raw {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || p.schemes[strings.ToLower(u.Scheme)]
+}
+
+// filter returns the allowed attributes of an element, or false when the
+// element is not allowed
+func (p *Policy) filter(element string, attrs []attr) ([]attr, bool) {
+	allowed, ok := p.elements[element]
+	if !ok {
+		return nil, false
+	}
+
+	filtered := make([]attr, 0, len(attrs)+1)
+	for _, a := range attrs {
+		pattern, ok := allowed[a.name]
+		if !ok || pattern != nil && !pattern.MatchString(a.value) {
+			continue
+		}
+		if (a.name == "href" || a.name == "src") && !p.allowsURL(a.value) {
+			continue
+		}
+		filtered = append(filtered, a)
+	}
+	if element == "a" && p.nofollow {
+		filtered = append(filtered, attr{name: "rel", value: "nofollow ugc noopener"})
+	}
+	return filtered, true
+}
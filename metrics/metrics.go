@@ -0,0 +1,335 @@
+/*
+Package metrics collects counters, gauges and histograms, and serves them in
+the text format of Prometheus.
+
+Metrics are created with their label names, and every combination of label
+values is a separate series:
+
+	requests := metrics.NewCounterVec("http_requests_total", "HTTP requests.", "code")
+	registry.MustRegister(requests)
+	requests.With("200").Inc()
+
+Values that already exist elsewhere (such as sql.DBStats) are exported by a
+CollectorFunc, that is called on every scrape.
+*/
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is the type of a metric family
+type Type string
+
+// Types of the metric families
+const (
+	Counter   Type = "counter"
+	Gauge     Type = "gauge"
+	Histogram Type = "histogram"
+)
+
+// DefBuckets are the default histogram buckets, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label is a name and a value of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single value of a family. Name is the family name, with a
+// suffix for histograms (_bucket, _sum and _count).
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Family is a metric with all of its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Collector returns the families of metrics it holds
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc is a function that is used as a Collector
+type CollectorFunc func() []Family
+
+// Collect implements Collector
+func (fn CollectorFunc) Collect() []Family {
+	return fn()
+}
+
+// atomicFloat is a float64 that is updated atomically
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// vec holds the series of a metric by their label values
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mutex  sync.RWMutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     make(map[string]interface{}),
+		values:     make(map[string][]string),
+	}
+}
+
+// get returns the series of labelValues, creating it with create
+func (v *vec) get(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d",
+			v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.RLock()
+	series, ok := v.series[key]
+	v.mutex.RUnlock()
+	if ok {
+		return series
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if series, ok := v.series[key]; ok {
+		return series
+	}
+	series = create()
+	v.series[key] = series
+	v.values[key] = append([]string(nil), labelValues...)
+	return series
+}
+
+// each calls fn for every series, ordered by the label values
+func (v *vec) each(fn func(labels []Label, series interface{})) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := v.values[key]
+		labels := make([]Label, len(values))
+		for i, value := range values {
+			labels[i] = Label{Name: v.labelNames[i], Value: value}
+		}
+		fn(labels, v.series[key])
+	}
+}
+
+// CounterVec is a counter that is partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// CounterValue is a single series of a CounterVec
+type CounterValue struct {
+	value atomicFloat
+}
+
+// NewCounterVec creates a CounterVec
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: newVec(name, help, labelNames)}
+}
+
+// With returns the series of the label values, in the order of the label
+// names
+func (c *CounterVec) With(labelValues ...string) *CounterValue {
+	return c.get(labelValues, func() interface{} { return &CounterValue{} }).(*CounterValue)
+}
+
+// Inc adds 1 to the counter
+func (c *CounterValue) Inc() {
+	c.value.add(1)
+}
+
+// Add adds v to the counter, v must not be negative
+func (c *CounterValue) Add(v float64) {
+	if v < 0 {
+		panic("metrics: a counter cannot decrease")
+	}
+	c.value.add(v)
+}
+
+// Collect implements Collector
+func (c *CounterVec) Collect() []Family {
+	family := Family{Name: c.name, Help: c.help, Type: Counter}
+	c.each(func(labels []Label, series interface{}) {
+		family.Samples = append(family.Samples, Sample{
+			Name: c.name, Labels: labels, Value: series.(*CounterValue).value.load(),
+		})
+	})
+	return []Family{family}
+}
+
+// GaugeVec is a gauge that is partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// GaugeValue is a single series of a GaugeVec
+type GaugeValue struct {
+	value atomicFloat
+}
+
+// NewGaugeVec creates a GaugeVec
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec(name, help, labelNames)}
+}
+
+// With returns the series of the label values, in the order of the label
+// names
+func (g *GaugeVec) With(labelValues ...string) *GaugeValue {
+	return g.get(labelValues, func() interface{} { return &GaugeValue{} }).(*GaugeValue)
+}
+
+// Set sets the gauge to v
+func (g *GaugeValue) Set(v float64) {
+	g.value.set(v)
+}
+
+// Add adds v to the gauge, v may be negative
+func (g *GaugeValue) Add(v float64) {
+	g.value.add(v)
+}
+
+// Inc adds 1 to the gauge
+func (g *GaugeValue) Inc() {
+	g.value.add(1)
+}
+
+// Dec subtracts 1 from the gauge
+func (g *GaugeValue) Dec() {
+	g.value.add(-1)
+}
+
+// Collect implements Collector
+func (g *GaugeVec) Collect() []Family {
+	family := Family{Name: g.name, Help: g.help, Type: Gauge}
+	g.each(func(labels []Label, series interface{}) {
+		family.Samples = append(family.Samples, Sample{
+			Name: g.name, Labels: labels, Value: series.(*GaugeValue).value.load(),
+		})
+	})
+	return []Family{family}
+}
+
+// HistogramVec is a histogram that is partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// HistogramValue is a single series of a HistogramVec
+type HistogramValue struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     atomicFloat
+}
+
+// NewHistogramVec creates a HistogramVec with the upper bounds of buckets,
+// DefBuckets is used when buckets is nil
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{vec: newVec(name, help, labelNames), buckets: sorted}
+}
+
+// With returns the series of the label values, in the order of the label
+// names
+func (h *HistogramVec) With(labelValues ...string) *HistogramValue {
+	return h.get(labelValues, func() interface{} {
+		return &HistogramValue{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*HistogramValue)
+}
+
+// Observe adds v to the histogram
+func (h *HistogramValue) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// Collect implements Collector
+func (h *HistogramVec) Collect() []Family {
+	family := Family{Name: h.name, Help: h.help, Type: Histogram}
+	h.each(func(labels []Label, series interface{}) {
+		value := series.(*HistogramValue)
+
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += atomic.LoadUint64(&value.counts[i])
+			family.Samples = append(family.Samples, Sample{
+				Name:   h.name + "_bucket",
+				Labels: withLabel(labels, "le", formatFloat(bound)),
+				Value:  float64(cumulative),
+			})
+		}
+		count := float64(atomic.LoadUint64(&value.count))
+		family.Samples = append(family.Samples,
+			Sample{Name: h.name + "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: count},
+			Sample{Name: h.name + "_sum", Labels: labels, Value: value.sum.load()},
+			Sample{Name: h.name + "_count", Labels: labels, Value: count},
+		)
+	})
+	return []Family{family}
+}
+
+// withLabel returns a copy of labels with another label
+func withLabel(labels []Label, name, value string) []Label {
+	result := make([]Label, len(labels), len(labels)+1)
+	copy(result, labels)
+	return append(result, Label{Name: name, Value: value})
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterText(t *testing.T) {
+	registry := NewRegistry()
+	requests := NewCounterVec("requests_total", "Requests.", "code", "path")
+	registry.MustRegister(requests)
+
+	requests.With("200", "/").Inc()
+	requests.With("200", "/").Add(2)
+	requests.With("404", `/a"b`).Inc()
+
+	var b strings.Builder
+	if err := registry.WriteText(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{code="200",path="/"} 3
+requests_total{code="404",path="/a\"b"} 1
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	registry := NewRegistry()
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	registry.MustRegister(latency)
+
+	latency.With("get").Observe(0.05)
+	latency.With("get").Observe(0.1)
+	latency.With("get").Observe(3)
+
+	var b strings.Builder
+	if err := registry.WriteText(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 2
+latency_seconds_bucket{op="get",le="1"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 3.15
+latency_seconds_count{op="get"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestGaugeAndCollectorFunc(t *testing.T) {
+	registry := NewRegistry()
+	inFlight := NewGaugeVec("in_flight", "")
+	registry.MustRegister(inFlight, CollectorFunc(func() []Family {
+		return []Family{{Name: "answer", Type: Gauge, Samples: []Sample{{Name: "answer", Value: 42}}}}
+	}))
+
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+
+	var b strings.Builder
+	if err := registry.WriteText(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := "# TYPE answer gauge\nanswer 42\n# TYPE in_flight gauge\nin_flight 1\n"
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestWithWrongLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a wrong number of label values")
+		}
+	}()
+
+	NewCounterVec("requests_total", "", "code").With("200", "/")
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustRegister(NewCounterVec("requests_total", ""))
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected %s, got %s", ContentType, ct)
+	}
+
+	w = httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds the collectors that are exported together
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// MustRegister adds collectors to the registry
+func (registry *Registry) MustRegister(collectors ...Collector) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.collectors = append(registry.collectors, collectors...)
+}
+
+// Gather returns the families of all collectors ordered by name. Families
+// of the same name are merged.
+func (registry *Registry) Gather() []Family {
+	registry.mutex.RLock()
+	collectors := make([]Collector, len(registry.collectors))
+	copy(collectors, registry.collectors)
+	registry.mutex.RUnlock()
+
+	byName := make(map[string]*Family)
+	names := make([]string, 0)
+	for _, collector := range collectors {
+		for _, family := range collector.Collect() {
+			existing, ok := byName[family.Name]
+			if !ok {
+				f := family
+				byName[family.Name] = &f
+				names = append(names, family.Name)
+				continue
+			}
+			existing.Samples = append(existing.Samples, family.Samples...)
+		}
+	}
+	sort.Strings(names)
+
+	families := make([]Family, len(names))
+	for i, name := range names {
+		families[i] = *byName[name]
+	}
+	return families
+}
+
+// WriteText writes the families of the registry in the text format
+func (registry *Registry) WriteText(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, family := range registry.Gather() {
+		if family.Help != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		}
+		fmt.Fprintf(buf, "# TYPE %s %s\n", family.Name, family.Type)
+
+		for _, sample := range family.Samples {
+			buf.WriteString(sample.Name)
+			if len(sample.Labels) > 0 {
+				pairs := make([]string, len(sample.Labels))
+				for i, label := range sample.Labels {
+					pairs[i] = label.Name + `="` + escapeLabel(label.Value) + `"`
+				}
+				buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			buf.WriteString(" " + formatFloat(sample.Value) + "\n")
+		}
+	}
+	return buf.Flush()
+}
+
+// Handler returns an http.Handler that serves the registry
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		_ = registry.WriteText(w)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -1,3 +1,167 @@
 package models
 
-// TODO: Create Comments model
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/pagination"
+)
+
+// CommentState is the moderation state of a comment
+type CommentState string
+
+// A list of comment states
+const (
+	CommentPending  CommentState = "pending"
+	CommentApproved CommentState = "approved"
+	CommentSpam     CommentState = "spam"
+	CommentDeleted  CommentState = "deleted"
+)
+
+// pathSegmentWidth is the zero padded width of every id at a comment path, so
+// ordering by the path keeps replies right after their parent
+const pathSegmentWidth = 10
+
+// Errors that are returned by a CommentRepository
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrInvalidState    = errors.New("invalid comment state")
+	ErrInvalidParent   = errors.New("parent comment belongs to another post")
+	ErrMissingAuthor   = errors.New("comment author is required")
+)
+
+// Comment data structure.
+//
+// A comment is written either by an authenticated user (AuthorID is valid), or
+// by an anonymous author that is known only by name.
+type Comment struct {
+	ID       uint64        `json:"id" db:"id"`
+	PostID   uint64        `json:"post_id" db:"post_id"`
+	ParentID sql.NullInt64 `json:"parent_id" db:"parent_id"`
+	// Path is the materialised path of the comment: the ids of all of its
+	// ancestors and itself, separated by dots
+	Path        string        `json:"-" db:"path"`
+	AuthorID    sql.NullInt64 `json:"author_id" db:"author_id"`
+	AuthorName  string        `json:"author_name" db:"author_name"`
+	AuthorEmail string        `json:"-" db:"author_email"`
+	Body        string        `json:"body" db:"body"`
+	// BodyHTML is the rendered markdown of Body, it is filled only for
+	// display
+	BodyHTML  string       `json:"body_html,omitempty" db:"-"`
+	State     CommentState `json:"state" db:"state"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+
+	Replies []*Comment `json:"replies,omitempty" db:"-"`
+}
+
+// CommentFilter narrows down a list of comments. Zero values are ignored.
+type CommentFilter struct {
+	PostID uint64
+	State  CommentState
+	Limit  int
+	Offset int
+	// Cursor lists the comments after a position instead of Offset, see
+	// Comment.Cursor
+	Cursor *pagination.Cursor
+}
+
+// CommentRepository is the persistence layer of comments
+type CommentRepository interface {
+	// Create stores a new comment, a reply must belong to the same post as
+	// its parent
+	Create(ctx context.Context, comment *Comment) error
+	GetByID(ctx context.Context, id uint64) (Comment, error)
+	// Thread returns all comments of a post at the given states, ordered so
+	// every reply comes after its parent
+	Thread(ctx context.Context, postID uint64, states ...CommentState) ([]Comment, error)
+	SetState(ctx context.Context, id uint64, state CommentState) error
+	// List returns comments newest first, for moderation
+	List(ctx context.Context, filter CommentFilter) ([]Comment, error)
+}
+
+// commentsKeyset is the order of a list of comments, newest first
+var commentsKeyset = pagination.Keyset{KeyColumn: "created_at", IDColumn: "id", Descending: true}
+
+// CacheKey identifies the content of the comment as of its last update, for
+// caches of its rendered body
+func (c Comment) CacheKey() string {
+	return fmt.Sprintf("comment:%d:%d", c.ID, c.UpdatedAt.UnixNano())
+}
+
+// IsValid returns true if state is one of the known states
+func (state CommentState) IsValid() bool {
+	switch state {
+	case CommentPending, CommentApproved, CommentSpam, CommentDeleted:
+		return true
+	}
+	return false
+}
+
+// Depth returns how deep the comment is nested, 0 for a top level comment
+func (c Comment) Depth() int {
+	if c.Path == "" {
+		return 0
+	}
+	return strings.Count(c.Path, ".")
+}
+
+// Cursor returns the position of the comment at a list of comments
+func (c Comment) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.TimeKey(c.CreatedAt), ID: c.ID}
+}
+
+// validate checks the fields that are provided by the author
+func (c *Comment) validate() error {
+	if c.State == "" {
+		c.State = CommentPending
+	}
+	if !c.State.IsValid() {
+		return ErrInvalidState
+	}
+	if !c.AuthorID.Valid && strings.TrimSpace(c.AuthorName) == "" {
+		return ErrMissingAuthor
+	}
+	return nil
+}
+
+// commentPath returns the path of a comment with id under parentPath
+func commentPath(parentPath string, id uint64) string {
+	segment := fmt.Sprintf("%0*d", pathSegmentWidth, id)
+	if parentPath == "" {
+		return segment
+	}
+	return parentPath + "." + segment
+}
+
+// BuildThread nests a flat thread, as returned by CommentRepository.Thread,
+// into a tree of top level comments with their replies.
+//
+// Replies whose parent is not part of comments are dropped, as their parent is
+// not visible.
+func BuildThread(comments []Comment) []*Comment {
+	byID := make(map[uint64]*Comment, len(comments))
+	roots := make([]*Comment, 0)
+
+	for i := range comments {
+		comment := &comments[i]
+		comment.Replies = nil
+		byID[comment.ID] = comment
+
+		if !comment.ParentID.Valid {
+			roots = append(roots, comment)
+			continue
+		}
+		parent, ok := byID[uint64(comment.ParentID.Int64)]
+		if !ok {
+			continue
+		}
+		parent.Replies = append(parent.Replies, comment)
+	}
+
+	return roots
+}
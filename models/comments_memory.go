@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryCommentRepository is a CommentRepository that keeps the comments at
+// memory.
+//
+// It is meant for tests of code that depends on a CommentRepository.
+type MemoryCommentRepository struct {
+	mtx      sync.Mutex
+	comments map[uint64]Comment
+	nextID   uint64
+}
+
+// NewMemoryCommentRepository creates an empty MemoryCommentRepository
+func NewMemoryCommentRepository() *MemoryCommentRepository {
+	return &MemoryCommentRepository{
+		comments: make(map[uint64]Comment),
+		nextID:   1,
+	}
+}
+
+// Create implements CommentRepository
+func (repo *MemoryCommentRepository) Create(ctx context.Context, comment *Comment) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if err := comment.validate(); err != nil {
+		return err
+	}
+
+	parentPath := ""
+	if comment.ParentID.Valid {
+		parent, ok := repo.comments[uint64(comment.ParentID.Int64)]
+		if !ok {
+			return ErrCommentNotFound
+		}
+		if parent.PostID != comment.PostID {
+			return ErrInvalidParent
+		}
+		parentPath = parent.Path
+	}
+
+	now := time.Now()
+	comment.ID = repo.nextID
+	comment.Path = commentPath(parentPath, comment.ID)
+	comment.CreatedAt, comment.UpdatedAt = now, now
+
+	repo.nextID++
+	repo.comments[comment.ID] = *comment
+	return nil
+}
+
+// GetByID implements CommentRepository
+func (repo *MemoryCommentRepository) GetByID(ctx context.Context, id uint64) (Comment, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	comment, ok := repo.comments[id]
+	if !ok {
+		return Comment{}, ErrCommentNotFound
+	}
+	return comment, nil
+}
+
+// Thread implements CommentRepository
+func (repo *MemoryCommentRepository) Thread(ctx context.Context, postID uint64, states ...CommentState) ([]Comment, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	wanted := make(map[CommentState]bool, len(states))
+	for _, state := range states {
+		wanted[state] = true
+	}
+
+	list := make([]Comment, 0)
+	for _, comment := range repo.comments {
+		if comment.PostID != postID {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[comment.State] {
+			continue
+		}
+		list = append(list, comment)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	return list, nil
+}
+
+// SetState implements CommentRepository
+func (repo *MemoryCommentRepository) SetState(ctx context.Context, id uint64, state CommentState) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if !state.IsValid() {
+		return ErrInvalidState
+	}
+	comment, ok := repo.comments[id]
+	if !ok {
+		return ErrCommentNotFound
+	}
+
+	comment.State = state
+	comment.UpdatedAt = time.Now()
+	repo.comments[id] = comment
+	return nil
+}
+
+// List implements CommentRepository
+func (repo *MemoryCommentRepository) List(ctx context.Context, filter CommentFilter) ([]Comment, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	var after time.Time
+	if filter.Cursor != nil {
+		var err error
+		if after, err = filter.Cursor.Time(); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]Comment, 0)
+	for _, comment := range repo.comments {
+		if filter.PostID != 0 && comment.PostID != filter.PostID {
+			continue
+		}
+		if filter.State != "" && comment.State != filter.State {
+			continue
+		}
+		if filter.Cursor != nil &&
+			!commentsKeyset.Follows(*filter.Cursor, compareTime(comment.CreatedAt, after), comment.ID) {
+			continue
+		}
+		list = append(list, comment)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+
+	start, end := memoryWindow(len(list), filter.Offset, filter.Limit, filter.Cursor)
+	return list[start:end], nil
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/pagination"
+	"github.com/lib/pq"
+)
+
+const commentColumns = `id, post_id, parent_id, path, author_id, author_name,
+	author_email, body, state, created_at, updated_at`
+
+// PostgresCommentRepository is a CommentRepository that is stored at
+// PostgreSQL, using a materialised path for the threads
+type PostgresCommentRepository struct {
+	conn *db.Conn
+}
+
+// NewPostgresCommentRepository creates a CommentRepository over conn
+func NewPostgresCommentRepository(conn *db.Conn) *PostgresCommentRepository {
+	return &PostgresCommentRepository{conn: conn}
+}
+
+// Create implements CommentRepository.
+//
+// The id is taken from the sequence ahead of the insert, so the path can be
+// built in the same statement.
+func (repo *PostgresCommentRepository) Create(ctx context.Context, comment *Comment) error {
+	ctx = db.WithQueryLabel(ctx, "comments.create")
+
+	if err := comment.validate(); err != nil {
+		return err
+	}
+
+	// the parent may have just been created
+	parentPath := ""
+	if comment.ParentID.Valid {
+		parent, err := repo.GetByID(db.WithPrimary(ctx), uint64(comment.ParentID.Int64))
+		if err != nil {
+			return err
+		}
+		if parent.PostID != comment.PostID {
+			return ErrInvalidParent
+		}
+		parentPath = parent.Path
+	}
+
+	return repo.conn.NamedGet(ctx, comment,
+		`WITH next AS (SELECT nextval('comments_id_seq') AS id)
+		INSERT INTO comments (id, post_id, parent_id, path, author_id,
+			author_name, author_email, body, state)
+		SELECT next.id, :post_id, :parent_id,
+			CASE WHEN :path = '' THEN lpad(next.id::text, :width, '0')
+			ELSE :path || '.' || lpad(next.id::text, :width, '0') END,
+			:author_id, :author_name, :author_email, :body, :state
+		FROM next
+		RETURNING id, path, created_at, updated_at`,
+		map[string]interface{}{
+			"post_id":      comment.PostID,
+			"parent_id":    comment.ParentID,
+			"path":         parentPath,
+			"width":        pathSegmentWidth,
+			"author_id":    comment.AuthorID,
+			"author_name":  comment.AuthorName,
+			"author_email": comment.AuthorEmail,
+			"body":         comment.Body,
+			"state":        comment.State,
+		},
+	)
+}
+
+// GetByID implements CommentRepository
+func (repo *PostgresCommentRepository) GetByID(ctx context.Context, id uint64) (Comment, error) {
+	ctx = db.WithQueryLabel(ctx, "comments.get_by_id")
+
+	var comment Comment
+	err := repo.conn.Get(ctx, &comment, `SELECT `+commentColumns+` FROM comments WHERE id = $1`, id)
+	return comment, notFound(err, ErrCommentNotFound)
+}
+
+// Thread implements CommentRepository with a single query
+func (repo *PostgresCommentRepository) Thread(ctx context.Context, postID uint64, states ...CommentState) ([]Comment, error) {
+	ctx = db.WithQueryLabel(ctx, "comments.thread")
+
+	stateNames := make([]string, len(states))
+	for i, state := range states {
+		stateNames[i] = string(state)
+	}
+
+	list := make([]Comment, 0)
+	err := repo.conn.Select(ctx, &list,
+		`SELECT `+commentColumns+` FROM comments
+		WHERE post_id = $1 AND (cardinality($2::text[]) = 0 OR state = ANY($2))
+		ORDER BY path`,
+		postID, pq.Array(stateNames),
+	)
+	return list, err
+}
+
+// SetState implements CommentRepository
+func (repo *PostgresCommentRepository) SetState(ctx context.Context, id uint64, state CommentState) error {
+	ctx = db.WithQueryLabel(ctx, "comments.set_state")
+
+	if !state.IsValid() {
+		return ErrInvalidState
+	}
+
+	result, err := repo.conn.Exec(ctx,
+		`UPDATE comments SET state = $1, updated_at = now() WHERE id = $2`,
+		state, id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+// List implements CommentRepository
+func (repo *PostgresCommentRepository) List(ctx context.Context, filter CommentFilter) ([]Comment, error) {
+	ctx = db.WithQueryLabel(ctx, "comments.list")
+
+	where := make([]string, 0, 2)
+	args := make([]interface{}, 0, 4)
+	if filter.PostID != 0 {
+		args = append(args, filter.PostID)
+		where = append(where, fmt.Sprintf("post_id = $%d", len(args)))
+	}
+	if filter.State != "" {
+		args = append(args, filter.State)
+		where = append(where, fmt.Sprintf("state = $%d", len(args)))
+	}
+
+	if filter.Cursor != nil {
+		clause, cursorArgs := commentsKeyset.Where(*filter.Cursor, len(args)+1)
+		args = append(args, cursorArgs...)
+		where = append(where, clause)
+	}
+
+	query := `SELECT ` + commentColumns + ` FROM comments`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY ` + commentsKeyset.OrderBy(filter.Cursor)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	list := make([]Comment, 0)
+	if err := repo.conn.Select(ctx, &list, query, args...); err != nil {
+		return list, err
+	}
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		pagination.Reverse(list)
+	}
+	return list, nil
+}
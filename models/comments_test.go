@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func reply(id uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: true}
+}
+
+func TestCommentPath(t *testing.T) {
+	path := commentPath(commentPath("", 1), 25)
+	if path != "0000000001.0000000025" {
+		t.Errorf("Unexpected path %s", path)
+	}
+
+	comment := Comment{Path: path}
+	if comment.Depth() != 1 {
+		t.Errorf("Expected depth of 1, got %d", comment.Depth())
+	}
+}
+
+func TestMemoryCommentThread(t *testing.T) {
+	repo := NewMemoryCommentRepository()
+	ctx := context.Background()
+
+	comments := []Comment{
+		{PostID: 1, AuthorName: "a", Body: "first", State: CommentApproved},
+		{PostID: 1, AuthorName: "b", Body: "second", State: CommentApproved},
+		{PostID: 1, AuthorName: "c", Body: "reply to first", ParentID: reply(1), State: CommentApproved},
+		{PostID: 1, AuthorName: "d", Body: "spam reply", ParentID: reply(3), State: CommentSpam},
+		{PostID: 2, AuthorName: "e", Body: "other post", State: CommentApproved},
+	}
+	for i := range comments {
+		if err := repo.Create(ctx, &comments[i]); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+	}
+
+	thread, err := repo.Thread(ctx, 1, CommentApproved)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	expected := []uint64{1, 3, 2}
+	if len(thread) != len(expected) {
+		t.Fatalf("Expected %d comments, got %d", len(expected), len(thread))
+	}
+	for i, id := range expected {
+		if thread[i].ID != id {
+			t.Errorf("Expected comment %d at %d, got %d", id, i, thread[i].ID)
+		}
+	}
+
+	tree := BuildThread(thread)
+	if len(tree) != 2 {
+		t.Fatalf("Expected 2 top level comments, got %d", len(tree))
+	}
+	if len(tree[0].Replies) != 1 || tree[0].Replies[0].ID != 3 {
+		t.Errorf("Expected comment 3 to reply to comment 1, got %+v", tree[0].Replies)
+	}
+}
+
+func TestMemoryCommentInvalidParent(t *testing.T) {
+	repo := NewMemoryCommentRepository()
+	ctx := context.Background()
+
+	parent := Comment{PostID: 1, AuthorName: "a", Body: "parent"}
+	if err := repo.Create(ctx, &parent); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	child := Comment{PostID: 2, AuthorName: "b", Body: "child", ParentID: reply(parent.ID)}
+	if err := repo.Create(ctx, &child); err != ErrInvalidParent {
+		t.Errorf("Expected ErrInvalidParent, got %v", err)
+	}
+}
+
+func TestCommentRequiresAuthor(t *testing.T) {
+	repo := NewMemoryCommentRepository()
+
+	comment := Comment{PostID: 1, Body: "anonymous"}
+	if err := repo.Create(context.Background(), &comment); err != ErrMissingAuthor {
+		t.Errorf("Expected ErrMissingAuthor, got %v", err)
+	}
+}
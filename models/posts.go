@@ -1,3 +1,208 @@
 package models
 
-// TODO: Create Posts model
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ik5/go-into/pagination"
+)
+
+// PostStatus is the publishing state of a post
+type PostStatus string
+
+// A list of post statuses
+const (
+	PostDraft            PostStatus = "draft"
+	PostInReview         PostStatus = "in_review"
+	PostChangesRequested PostStatus = "changes_requested"
+	PostApproved         PostStatus = "approved"
+	PostScheduled        PostStatus = "scheduled"
+	PostPublished        PostStatus = "published"
+	PostArchived         PostStatus = "archived"
+)
+
+// ExcerptLength is the maximum length of an excerpt that is generated from the
+// body of a post
+const ExcerptLength = 200
+
+// TopicPostPublished is the outbox topic of a post that was published, its
+// payload is the Post
+const TopicPostPublished = "post.published"
+
+// Errors that are returned by a PostRepository
+var (
+	ErrPostNotFound    = errors.New("post not found")
+	ErrVersionConflict = errors.New("post was modified by someone else")
+	ErrInvalidStatus   = errors.New("invalid post status")
+)
+
+// Post data structure
+type Post struct {
+	ID       uint64 `json:"id" db:"id"`
+	AuthorID uint64 `json:"author_id" db:"author_id"`
+	Title    string `json:"title" db:"title"`
+	Slug     string `json:"slug" db:"slug"`
+	Body     string `json:"body" db:"body"`
+	// BodyHTML is the rendered markdown of Body, it is filled only for
+	// display
+	BodyHTML string `json:"body_html,omitempty" db:"-"`
+	Excerpt  string `json:"excerpt" db:"excerpt"`
+	// ExcerptManual marks an excerpt that was written by hand, otherwise the
+	// excerpt is generated from the body whenever the post is saved
+	ExcerptManual bool         `json:"excerpt_manual" db:"excerpt_manual"`
+	Status        PostStatus   `json:"status" db:"status"`
+	PublishedAt   sql.NullTime `json:"published_at" db:"published_at"`
+	// PublishAt is the time that a scheduled post is published at
+	PublishAt sql.NullTime `json:"publish_at" db:"publish_at"`
+	// Version is increased on every update, and an update is allowed only
+	// for the version that was loaded
+	Version   uint64    `json:"version" db:"version"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PostFilter narrows down a list of posts. Zero values are ignored.
+type PostFilter struct {
+	AuthorID uint64
+	Status   PostStatus
+	// PublishBefore lists posts that are due to be published up to a time
+	PublishBefore time.Time
+	Limit         int
+	Offset        int
+	// Cursor lists the posts after a position instead of Offset, see
+	// Post.Cursor
+	Cursor *pagination.Cursor
+}
+
+// PostRepository is the persistence layer of posts
+type PostRepository interface {
+	// Create stores a new post and generates a unique slug for it
+	Create(ctx context.Context, post *Post) error
+	GetByID(ctx context.Context, id uint64) (Post, error)
+	GetBySlug(ctx context.Context, slug string) (Post, error)
+	// Update stores the post only if its version was not changed since it
+	// was loaded, otherwise ErrVersionConflict is returned
+	Update(ctx context.Context, post *Post) error
+	Delete(ctx context.Context, id uint64) error
+	List(ctx context.Context, filter PostFilter) ([]Post, error)
+	// Revisions returns the revisions of a post, latest first. Every save
+	// of a post stores a revision, see WithEditor.
+	Revisions(ctx context.Context, postID uint64) ([]Revision, error)
+	Revision(ctx context.Context, postID, version uint64) (Revision, error)
+	// SaveTransition stores the post as Update does, and records the
+	// transition of its status at the same transaction
+	SaveTransition(ctx context.Context, post *Post, transition *Transition) error
+	// RecordTransition records a transition that keeps the status of the
+	// post, without storing the post, so its version and revisions do not
+	// change. ErrTransitionChanged is returned if the status of the post is
+	// no longer the From of the transition.
+	RecordTransition(ctx context.Context, transition *Transition) error
+	// Transitions returns the workflow history of a post, oldest first
+	Transitions(ctx context.Context, postID uint64) ([]Transition, error)
+}
+
+// postsKeyset is the order of a list of posts, newest first
+var postsKeyset = pagination.Keyset{KeyColumn: "created_at", IDColumn: "id", Descending: true}
+
+// String implement interface lookup for String to display data type as string
+func (p Post) String() string {
+	return fmt.Sprintf("%d - %s (%s)", p.ID, p.Title, p.Status)
+}
+
+// Cursor returns the position of the post at a list of posts
+func (p Post) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: pagination.TimeKey(p.CreatedAt), ID: p.ID}
+}
+
+// CacheKey identifies the content of a version of the post, for caches of
+// its rendered body
+func (p Post) CacheKey() string {
+	return fmt.Sprintf("post:%d:%d", p.ID, p.Version)
+}
+
+// IsValid returns true if status is one of the known statuses
+func (status PostStatus) IsValid() bool {
+	switch status {
+	case PostDraft, PostInReview, PostChangesRequested, PostApproved,
+		PostScheduled, PostPublished, PostArchived:
+		return true
+	}
+	return false
+}
+
+// editable returns true if the content of a post of status may be changed
+func (status PostStatus) editable() bool {
+	return status == PostDraft || status == PostChangesRequested
+}
+
+// prepare fills the generated fields of a post before it is stored
+func (p *Post) prepare(now time.Time) error {
+	if p.Status == "" {
+		p.Status = PostDraft
+	}
+	if !p.Status.IsValid() {
+		return ErrInvalidStatus
+	}
+	if p.Status == PostPublished && !p.PublishedAt.Valid {
+		p.PublishedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	if p.Excerpt == "" {
+		p.ExcerptManual = false
+	}
+	if !p.ExcerptManual {
+		p.Excerpt = makeExcerpt(p.Body, ExcerptLength)
+	}
+	return nil
+}
+
+// Slugify converts a string to a lower case, URL safe, dash separated string
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	if b.Len() == 0 {
+		return "post"
+	}
+	return b.String()
+}
+
+// nextFreeSlug returns base, or base with the first numeric suffix that is not
+// taken
+func nextFreeSlug(base string, taken func(slug string) bool) string {
+	slug := base
+	for i := 2; taken(slug); i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug
+}
+
+// makeExcerpt cuts body to up to length runes on a word boundary
+func makeExcerpt(body string, length int) string {
+	body = strings.Join(strings.Fields(body), " ")
+	runes := []rune(body)
+	if len(runes) <= length {
+		return body
+	}
+
+	excerpt := string(runes[:length])
+	if i := strings.LastIndex(excerpt, " "); i > 0 {
+		excerpt = excerpt[:i]
+	}
+	return excerpt + "…"
+}
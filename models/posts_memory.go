@@ -0,0 +1,308 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ik5/go-into/pagination"
+)
+
+// MemoryPostRepository is a PostRepository that keeps the posts at memory.
+//
+// It is meant for tests of code that depends on a PostRepository.
+type MemoryPostRepository struct {
+	mtx         sync.Mutex
+	posts       map[uint64]Post
+	revisions   map[uint64][]Revision
+	transitions map[uint64][]Transition
+	nextID      uint64
+	nextRevID   uint64
+	nextTransID uint64
+}
+
+// NewMemoryPostRepository creates an empty MemoryPostRepository
+func NewMemoryPostRepository() *MemoryPostRepository {
+	return &MemoryPostRepository{
+		posts:       make(map[uint64]Post),
+		revisions:   make(map[uint64][]Revision),
+		transitions: make(map[uint64][]Transition),
+		nextID:      1,
+		nextRevID:   1,
+		nextTransID: 1,
+	}
+}
+
+// Create implements PostRepository
+func (repo *MemoryPostRepository) Create(ctx context.Context, post *Post) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	now := time.Now()
+	if err := post.prepare(now); err != nil {
+		return err
+	}
+
+	post.ID = repo.nextID
+	post.Slug = repo.uniqueSlug(post.Slug, post.Title, 0)
+	post.Version = 1
+	post.CreatedAt, post.UpdatedAt = now, now
+
+	repo.nextID++
+	repo.posts[post.ID] = *post
+	repo.addRevision(newRevision(ctx, *post))
+	return nil
+}
+
+// GetByID implements PostRepository
+func (repo *MemoryPostRepository) GetByID(ctx context.Context, id uint64) (Post, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	post, ok := repo.posts[id]
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// GetBySlug implements PostRepository
+func (repo *MemoryPostRepository) GetBySlug(ctx context.Context, slug string) (Post, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	for _, post := range repo.posts {
+		if post.Slug == slug {
+			return post, nil
+		}
+	}
+	return Post{}, ErrPostNotFound
+}
+
+// Update implements PostRepository
+func (repo *MemoryPostRepository) Update(ctx context.Context, post *Post) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	return repo.save(ctx, post)
+}
+
+// SaveTransition implements PostRepository
+func (repo *MemoryPostRepository) SaveTransition(ctx context.Context, post *Post, transition *Transition) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if stored, ok := repo.posts[post.ID]; ok && stored.Status != transition.From {
+		return ErrTransitionChanged
+	}
+	if err := repo.save(ctx, post); err != nil {
+		return err
+	}
+
+	transition.CreatedAt = post.UpdatedAt
+	repo.addTransition(transition)
+	return nil
+}
+
+// RecordTransition implements PostRepository
+func (repo *MemoryPostRepository) RecordTransition(ctx context.Context, transition *Transition) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	stored, ok := repo.posts[transition.PostID]
+	if !ok {
+		return ErrPostNotFound
+	}
+	if stored.Status != transition.From {
+		return ErrTransitionChanged
+	}
+
+	transition.CreatedAt = time.Now()
+	repo.addTransition(transition)
+	return nil
+}
+
+// addTransition stores a transition with the next id
+func (repo *MemoryPostRepository) addTransition(transition *Transition) {
+	transition.ID = repo.nextTransID
+	repo.nextTransID++
+	repo.transitions[transition.PostID] = append(repo.transitions[transition.PostID], *transition)
+}
+
+// save stores an existing post
+func (repo *MemoryPostRepository) save(ctx context.Context, post *Post) error {
+	stored, ok := repo.posts[post.ID]
+	if !ok {
+		return ErrPostNotFound
+	}
+	if stored.Version != post.Version {
+		return ErrVersionConflict
+	}
+
+	if err := post.prepare(time.Now()); err != nil {
+		return err
+	}
+	post.Slug = repo.uniqueSlug(post.Slug, post.Title, post.ID)
+	post.Version++
+	post.CreatedAt = stored.CreatedAt
+	post.UpdatedAt = time.Now()
+
+	repo.posts[post.ID] = *post
+	repo.addRevision(newRevision(ctx, *post))
+	return nil
+}
+
+// Transitions implements PostRepository
+func (repo *MemoryPostRepository) Transitions(ctx context.Context, postID uint64) ([]Transition, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if _, ok := repo.posts[postID]; !ok {
+		return nil, ErrPostNotFound
+	}
+	list := make([]Transition, len(repo.transitions[postID]))
+	copy(list, repo.transitions[postID])
+	return list, nil
+}
+
+// Delete implements PostRepository
+func (repo *MemoryPostRepository) Delete(ctx context.Context, id uint64) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if _, ok := repo.posts[id]; !ok {
+		return ErrPostNotFound
+	}
+	delete(repo.posts, id)
+	delete(repo.revisions, id)
+	delete(repo.transitions, id)
+	return nil
+}
+
+// addRevision stores revision, the revisions of a post are kept oldest first
+func (repo *MemoryPostRepository) addRevision(revision Revision) {
+	revision.ID = repo.nextRevID
+	repo.nextRevID++
+	repo.revisions[revision.PostID] = append(repo.revisions[revision.PostID], revision)
+}
+
+// Revisions implements PostRepository
+func (repo *MemoryPostRepository) Revisions(ctx context.Context, postID uint64) ([]Revision, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if _, ok := repo.posts[postID]; !ok {
+		return nil, ErrPostNotFound
+	}
+	stored := repo.revisions[postID]
+	list := make([]Revision, len(stored))
+	for i, revision := range stored {
+		list[len(stored)-1-i] = revision
+	}
+	return list, nil
+}
+
+// Revision implements PostRepository
+func (repo *MemoryPostRepository) Revision(ctx context.Context, postID, version uint64) (Revision, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	for _, revision := range repo.revisions[postID] {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrRevisionNotFound
+}
+
+// List implements PostRepository, newest posts first
+func (repo *MemoryPostRepository) List(ctx context.Context, filter PostFilter) ([]Post, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	var after time.Time
+	if filter.Cursor != nil {
+		var err error
+		if after, err = filter.Cursor.Time(); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]Post, 0, len(repo.posts))
+	for _, post := range repo.posts {
+		if filter.AuthorID != 0 && post.AuthorID != filter.AuthorID {
+			continue
+		}
+		if filter.Status != "" && post.Status != filter.Status {
+			continue
+		}
+		if !filter.PublishBefore.IsZero() &&
+			(!post.PublishAt.Valid || post.PublishAt.Time.After(filter.PublishBefore)) {
+			continue
+		}
+		if filter.Cursor != nil &&
+			!postsKeyset.Follows(*filter.Cursor, compareTime(post.CreatedAt, after), post.ID) {
+			continue
+		}
+		list = append(list, post)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+
+	start, end := memoryWindow(len(list), filter.Offset, filter.Limit, filter.Cursor)
+	return list[start:end], nil
+}
+
+// uniqueSlug returns a slug that no other post than id is using
+func (repo *MemoryPostRepository) uniqueSlug(slug, title string, id uint64) string {
+	base := slug
+	if base == "" {
+		base = title
+	}
+
+	return nextFreeSlug(Slugify(base), func(candidate string) bool {
+		for _, post := range repo.posts {
+			if post.ID != id && post.Slug == candidate {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// compareTime compares a to b as strings.Compare does
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// memoryWindow returns the range of a page at a sorted list of n items. A
+// backward cursor takes the items right before it, at the end of the list.
+func memoryWindow(n, offset, limit int, cursor *pagination.Cursor) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= n {
+		return n, n
+	}
+	start, end := offset, n
+	if limit > 0 && limit < end-start {
+		if cursor != nil && cursor.Backward {
+			start = end - limit
+		} else {
+			end = start + limit
+		}
+	}
+	return start, end
+}
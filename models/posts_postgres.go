@@ -0,0 +1,366 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/outbox"
+	"github.com/ik5/go-into/pagination"
+)
+
+// constraint of the unique slug at the posts table
+const postsSlugConstraint = "posts_slug_key"
+
+// slugAttempts is the number of times a slug is regenerated when another
+// instance took it between the lookup and the insert
+const slugAttempts = 5
+
+const postColumns = `id, author_id, title, slug, body, excerpt, excerpt_manual,
+	status, published_at, publish_at, version, created_at, updated_at`
+
+// PostgresPostRepository is a PostRepository that is stored at PostgreSQL. A
+// post that is published writes TopicPostPublished to the outbox, at the same
+// transaction.
+type PostgresPostRepository struct {
+	conn *db.Conn
+}
+
+// NewPostgresPostRepository creates a PostRepository over conn
+func NewPostgresPostRepository(conn *db.Conn) *PostgresPostRepository {
+	return &PostgresPostRepository{conn: conn}
+}
+
+// notFound translates sql.ErrNoRows to the not found error of a model
+func notFound(err, notFoundErr error) error {
+	if db.IsNoRows(err) {
+		return notFoundErr
+	}
+	return err
+}
+
+// Create implements PostRepository
+func (repo *PostgresPostRepository) Create(ctx context.Context, post *Post) error {
+	ctx = db.WithQueryLabel(ctx, "posts.create")
+
+	if err := post.prepare(time.Now()); err != nil {
+		return err
+	}
+
+	// the slug lookup must see the latest posts
+	ctx = db.WithPrimary(ctx)
+
+	var err error
+	for attempt := 0; attempt < slugAttempts; attempt++ {
+		post.Slug, err = repo.uniqueSlug(ctx, post.Slug, post.Title, 0)
+		if err != nil {
+			return err
+		}
+
+		err = repo.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+			err := tx.NamedGet(ctx, post,
+				db.InsertQuery("posts", post, "id", "version", "created_at", "updated_at")+
+					` RETURNING id, version, created_at, updated_at`,
+				post,
+			)
+			if err != nil {
+				return err
+			}
+			if err := insertRevision(ctx, tx, newRevision(ctx, *post)); err != nil {
+				return err
+			}
+			if post.Status != PostPublished {
+				return nil
+			}
+			return writePublished(ctx, tx, *post)
+		})
+		if constraint, ok := db.UniqueViolation(err); !ok || constraint != postsSlugConstraint {
+			return err
+		}
+	}
+	return err
+}
+
+// GetByID implements PostRepository
+func (repo *PostgresPostRepository) GetByID(ctx context.Context, id uint64) (Post, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.get_by_id")
+
+	var post Post
+	err := repo.conn.Get(ctx, &post, `SELECT `+postColumns+` FROM posts WHERE id = $1`, id)
+	return post, notFound(err, ErrPostNotFound)
+}
+
+// GetBySlug implements PostRepository
+func (repo *PostgresPostRepository) GetBySlug(ctx context.Context, slug string) (Post, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.get_by_slug")
+
+	var post Post
+	err := repo.conn.Get(ctx, &post, `SELECT `+postColumns+` FROM posts WHERE slug = $1`, slug)
+	return post, notFound(err, ErrPostNotFound)
+}
+
+// Update implements PostRepository
+func (repo *PostgresPostRepository) Update(ctx context.Context, post *Post) error {
+	ctx = db.WithQueryLabel(ctx, "posts.update")
+	return repo.save(ctx, post, nil)
+}
+
+// SaveTransition implements PostRepository
+func (repo *PostgresPostRepository) SaveTransition(ctx context.Context, post *Post, transition *Transition) error {
+	ctx = db.WithQueryLabel(ctx, "posts.save_transition")
+
+	recorded := *transition
+	err := repo.save(ctx, post, func(tx *db.Tx, previous PostStatus, updated Post) error {
+		if previous != recorded.From {
+			return ErrTransitionChanged
+		}
+		recorded.CreatedAt = updated.UpdatedAt
+		return tx.NamedGet(ctx, &recorded.ID,
+			db.InsertQuery("post_transitions", recorded, "id")+` RETURNING id`,
+			recorded,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	*transition = recorded
+	return nil
+}
+
+// RecordTransition implements PostRepository
+func (repo *PostgresPostRepository) RecordTransition(ctx context.Context, transition *Transition) error {
+	ctx = db.WithPrimary(db.WithQueryLabel(ctx, "posts.record_transition"))
+
+	recorded := *transition
+	err := repo.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		// the lock orders the transition with the ones that change the status
+		var status PostStatus
+		err := tx.Get(ctx, &status, `SELECT status FROM posts WHERE id = $1 FOR UPDATE`, recorded.PostID)
+		if err != nil {
+			return notFound(err, ErrPostNotFound)
+		}
+		if status != recorded.From {
+			return ErrTransitionChanged
+		}
+		return tx.NamedGet(ctx, &recorded,
+			db.InsertQuery("post_transitions", recorded, "id", "created_at")+` RETURNING id, created_at`,
+			recorded,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	*transition = recorded
+	return nil
+}
+
+// save updates post at a transaction, and calls record with the status that
+// the post had before, inside the transaction
+func (repo *PostgresPostRepository) save(ctx context.Context, post *Post, record func(tx *db.Tx, previous PostStatus, updated Post) error) error {
+	if err := post.prepare(time.Now()); err != nil {
+		return err
+	}
+
+	ctx = db.WithPrimary(ctx)
+	slug, err := repo.uniqueSlug(ctx, post.Slug, post.Title, post.ID)
+	if err != nil {
+		return err
+	}
+
+	updated := *post
+	updated.Slug = slug
+	err = repo.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		var previous PostStatus
+		err := tx.Get(ctx, &previous, `SELECT status FROM posts WHERE id = $1 FOR UPDATE`, post.ID)
+		if err != nil {
+			return notFound(err, ErrPostNotFound)
+		}
+
+		err = tx.NamedGet(ctx, &updated,
+			`UPDATE posts SET title = :title, slug = :slug, body = :body,
+				excerpt = :excerpt, excerpt_manual = :excerpt_manual, status = :status, published_at = :published_at,
+				publish_at = :publish_at, version = version + 1, updated_at = now()
+			WHERE id = :id AND version = :version
+			RETURNING version, updated_at`,
+			&updated,
+		)
+		if db.IsNoRows(err) {
+			// the post exists, so the version is not the one that was loaded
+			return ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+		if err := insertRevision(ctx, tx, newRevision(ctx, updated)); err != nil {
+			return err
+		}
+		if record != nil {
+			if err := record(tx, previous, updated); err != nil {
+				return err
+			}
+		}
+		if previous == PostPublished || updated.Status != PostPublished {
+			return nil
+		}
+		return writePublished(ctx, tx, updated)
+	})
+	if err != nil {
+		return err
+	}
+	*post = updated
+	return nil
+}
+
+// insertRevision stores a revision inside the transaction of its save
+func insertRevision(ctx context.Context, tx *db.Tx, revision Revision) error {
+	_, err := tx.NamedExec(ctx, db.InsertQuery("post_revisions", revision, "id"), revision)
+	return err
+}
+
+// Revisions implements PostRepository
+func (repo *PostgresPostRepository) Revisions(ctx context.Context, postID uint64) ([]Revision, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.revisions")
+
+	if _, err := repo.GetByID(ctx, postID); err != nil {
+		return nil, err
+	}
+	list := make([]Revision, 0)
+	err := repo.conn.Select(ctx, &list,
+		`SELECT `+revisionColumns+` FROM post_revisions WHERE post_id = $1 ORDER BY version DESC`,
+		postID,
+	)
+	return list, err
+}
+
+// Revision implements PostRepository
+func (repo *PostgresPostRepository) Revision(ctx context.Context, postID, version uint64) (Revision, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.revision")
+
+	var revision Revision
+	err := repo.conn.Get(ctx, &revision,
+		`SELECT `+revisionColumns+` FROM post_revisions WHERE post_id = $1 AND version = $2`,
+		postID, version,
+	)
+	return revision, notFound(err, ErrRevisionNotFound)
+}
+
+// Transitions implements PostRepository
+func (repo *PostgresPostRepository) Transitions(ctx context.Context, postID uint64) ([]Transition, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.transitions")
+
+	if _, err := repo.GetByID(ctx, postID); err != nil {
+		return nil, err
+	}
+	list := make([]Transition, 0)
+	err := repo.conn.Select(ctx, &list,
+		`SELECT `+transitionColumns+` FROM post_transitions WHERE post_id = $1 ORDER BY id`,
+		postID,
+	)
+	return list, err
+}
+
+// writePublished adds the TopicPostPublished event of post to the outbox of
+// tx. The key is unique per version, so a post that is published again
+// emits a new event.
+func writePublished(ctx context.Context, tx *db.Tx, post Post) error {
+	key := fmt.Sprintf("%s:%d:%d", TopicPostPublished, post.ID, post.Version)
+	return outbox.Write(ctx, tx, TopicPostPublished, key, post)
+}
+
+// Delete implements PostRepository
+func (repo *PostgresPostRepository) Delete(ctx context.Context, id uint64) error {
+	ctx = db.WithQueryLabel(ctx, "posts.delete")
+
+	result, err := repo.conn.Exec(ctx, `DELETE FROM posts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+// List implements PostRepository, newest posts first
+func (repo *PostgresPostRepository) List(ctx context.Context, filter PostFilter) ([]Post, error) {
+	ctx = db.WithQueryLabel(ctx, "posts.list")
+
+	where := make([]string, 0, 2)
+	args := make([]interface{}, 0, 4)
+	if filter.AuthorID != 0 {
+		args = append(args, filter.AuthorID)
+		where = append(where, fmt.Sprintf("author_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if !filter.PublishBefore.IsZero() {
+		args = append(args, filter.PublishBefore)
+		where = append(where, fmt.Sprintf("publish_at <= $%d", len(args)))
+	}
+
+	if filter.Cursor != nil {
+		clause, cursorArgs := postsKeyset.Where(*filter.Cursor, len(args)+1)
+		args = append(args, cursorArgs...)
+		where = append(where, clause)
+	}
+
+	query := `SELECT ` + postColumns + ` FROM posts`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY ` + postsKeyset.OrderBy(filter.Cursor)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	list := make([]Post, 0)
+	if err := repo.conn.Select(ctx, &list, query, args...); err != nil {
+		return list, err
+	}
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		pagination.Reverse(list)
+	}
+	return list, nil
+}
+
+// uniqueSlug returns a slug that no other post than id is using
+func (repo *PostgresPostRepository) uniqueSlug(ctx context.Context, slug, title string, id uint64) (string, error) {
+	base := slug
+	if base == "" {
+		base = title
+	}
+	base = Slugify(base)
+
+	// Slugify leaves only letters, digits and dashes, so the LIKE pattern
+	// does not require escaping
+	slugs := make([]string, 0)
+	err := repo.conn.Select(ctx, &slugs,
+		`SELECT slug FROM posts WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id <> $2`,
+		base, id,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	taken := make(map[string]bool, len(slugs))
+	for _, s := range slugs {
+		taken[s] = true
+	}
+
+	return nextFreeSlug(base, func(candidate string) bool {
+		return taken[candidate]
+	}), nil
+}
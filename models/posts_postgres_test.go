@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/db/dbtest"
+	"github.com/lib/pq"
+)
+
+func newPostgresPostTest(t *testing.T) (*PostgresPostRepository, *dbtest.Mock) {
+	sqlDB, mock := dbtest.New(t)
+	return NewPostgresPostRepository(db.NewFromDB(sqlDB)), mock
+}
+
+func TestPostgresPostCreateRetriesSlug(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello-world", 0).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO posts").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: postsSlugConstraint})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello-world", 0).
+		WillReturnRows(dbtest.NewRows("slug").AddRow("hello-world"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO posts").
+		WillReturnRows(dbtest.NewRows("id", "version", "created_at", "updated_at").AddRow(1, 1, now, now))
+	mock.ExpectExec("INSERT INTO post_revisions").WithArgs(1, 1, "Hello World", "Hello", 1, now)
+	mock.ExpectCommit()
+
+	post := Post{AuthorID: 1, Title: "Hello World", Body: "Hello"}
+	if err := repo.Create(context.Background(), &post); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if post.Slug != "hello-world-2" {
+		t.Errorf("Expected hello-world-2, got %s", post.Slug)
+	}
+	if post.ID != 1 || post.Version != 1 {
+		t.Errorf("Expected id 1 and version 1, got %d and %d", post.ID, post.Version)
+	}
+}
+
+func TestPostgresPostUpdateVersionConflict(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("draft"))
+	mock.ExpectQuery("UPDATE posts SET").
+		WillReturnRows(dbtest.NewRows("version", "updated_at"))
+	mock.ExpectRollback()
+
+	post := Post{ID: 4, AuthorID: 1, Title: "Hello", Slug: "hello", Body: "Hello", Version: 2}
+	if err := repo.Update(context.Background(), &post); err != ErrVersionConflict {
+		t.Errorf("Expected %s, got %v", ErrVersionConflict, err)
+	}
+}
+
+func TestPostgresPostUpdateNotFound(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status"))
+	mock.ExpectRollback()
+
+	post := Post{ID: 4, AuthorID: 1, Title: "Hello", Slug: "hello", Body: "Hello", Version: 2}
+	if err := repo.Update(context.Background(), &post); err != ErrPostNotFound {
+		t.Errorf("Expected %s, got %v", ErrPostNotFound, err)
+	}
+}
+
+func TestPostgresPostPublishWritesOutbox(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("draft"))
+	mock.ExpectQuery("UPDATE posts SET").
+		WillReturnRows(dbtest.NewRows("version", "updated_at").AddRow(3, now))
+	mock.ExpectExec("INSERT INTO post_revisions").WithArgs(4, 3, "Hello", "Hello", 9, now)
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs(TopicPostPublished, "post.published:4:3", dbtest.AnyArg)
+	mock.ExpectCommit()
+
+	post := Post{ID: 4, AuthorID: 1, Title: "Hello", Slug: "hello", Body: "Hello",
+		Status: PostPublished, Version: 2}
+	ctx := WithEditor(context.Background(), 9)
+	if err := repo.Update(ctx, &post); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if post.Version != 3 {
+		t.Errorf("Expected version 3, got %d", post.Version)
+	}
+
+	// a post that stays published does not emit the event again
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("published"))
+	mock.ExpectQuery("UPDATE posts SET").
+		WillReturnRows(dbtest.NewRows("version", "updated_at").AddRow(4, now))
+	mock.ExpectExec("INSERT INTO post_revisions").WithArgs(4, 4, "Hello", "Hello", 9, now)
+	mock.ExpectCommit()
+
+	if err := repo.Update(ctx, &post); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestPostgresPostDeleteNotFound(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+
+	mock.ExpectExec("DELETE FROM posts WHERE id = $1").WithArgs(9).
+		WillReturnResult(dbtest.NewResult(0, 0))
+
+	if err := repo.Delete(context.Background(), 9); err != ErrPostNotFound {
+		t.Errorf("Expected %s, got %v", ErrPostNotFound, err)
+	}
+}
+
+func TestPostgresPostSaveTransition(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("in_review"))
+	mock.ExpectQuery("UPDATE posts SET").
+		WillReturnRows(dbtest.NewRows("version", "updated_at").AddRow(3, now))
+	mock.ExpectExec("INSERT INTO post_revisions").WithArgs(4, 3, "Hello", "Hello", 9, now)
+	mock.ExpectQuery("INSERT INTO post_transitions").
+		WithArgs(4, "approve", "in_review", "approved", 9, "", dbtest.AnyArg, now).
+		WillReturnRows(dbtest.NewRows("id").AddRow(12))
+	mock.ExpectCommit()
+
+	post := Post{ID: 4, AuthorID: 1, Title: "Hello", Slug: "hello", Body: "Hello",
+		Status: PostApproved, Version: 2}
+	transition := Transition{PostID: 4, Action: ActionApprove, From: PostInReview,
+		To: PostApproved, ActorID: 9}
+	ctx := WithEditor(context.Background(), 9)
+	if err := repo.SaveTransition(ctx, &post, &transition); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if transition.ID != 12 || post.Version != 3 {
+		t.Errorf("Expected transition 12 and version 3, got %d and %d", transition.ID, post.Version)
+	}
+
+	// another reviewer already moved the post
+	mock.ExpectQuery("SELECT slug FROM posts").WithArgs("hello", 4).
+		WillReturnRows(dbtest.NewRows("slug"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("changes_requested"))
+	mock.ExpectQuery("UPDATE posts SET").
+		WillReturnRows(dbtest.NewRows("version", "updated_at").AddRow(4, now))
+	mock.ExpectExec("INSERT INTO post_revisions").WithArgs(4, 4, "Hello", "Hello", 9, now)
+	mock.ExpectRollback()
+
+	post.Status = PostApproved
+	transition = Transition{PostID: 4, Action: ActionApprove, From: PostInReview,
+		To: PostApproved, ActorID: 9}
+	if err := repo.SaveTransition(ctx, &post, &transition); err != ErrTransitionChanged {
+		t.Errorf("Expected %s, got %v", ErrTransitionChanged, err)
+	}
+	if post.Version != 3 {
+		t.Errorf("Expected the post to stay at version 3, got %d", post.Version)
+	}
+}
+
+func TestPostgresPostRecordTransition(t *testing.T) {
+	repo, mock := newPostgresPostTest(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("in_review"))
+	mock.ExpectQuery("INSERT INTO post_transitions (post_id, action, from_status, to_status, actor_id, comment, publish_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at").
+		WithArgs(4, "comment", "in_review", "in_review", 9, "looks good", dbtest.AnyArg).
+		WillReturnRows(dbtest.NewRows("id", "created_at").AddRow(12, now))
+	mock.ExpectCommit()
+
+	transition := Transition{PostID: 4, Action: ActionComment, From: PostInReview,
+		To: PostInReview, ActorID: 9, Comment: "looks good"}
+	if err := repo.RecordTransition(context.Background(), &transition); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if transition.ID != 12 || !transition.CreatedAt.Equal(now) {
+		t.Errorf("Expected transition 12 at %s, got %d at %s", now, transition.ID, transition.CreatedAt)
+	}
+
+	// another reviewer already moved the post
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM posts WHERE id = $1 FOR UPDATE").WithArgs(4).
+		WillReturnRows(dbtest.NewRows("status").AddRow("approved"))
+	mock.ExpectRollback()
+
+	transition = Transition{PostID: 4, Action: ActionComment, From: PostInReview,
+		To: PostInReview, ActorID: 9, Comment: "looks good"}
+	if err := repo.RecordTransition(context.Background(), &transition); err != ErrTransitionChanged {
+		t.Errorf("Expected %s, got %v", ErrTransitionChanged, err)
+	}
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello World":            "hello-world",
+		"  Go -- is  fun!  ":     "go-is-fun",
+		"Ünïcode Títle":          "ünïcode-títle",
+		"!!!":                    "post",
+		"Already-a-slug-2":       "already-a-slug-2",
+		"Tabs\tand\nnew lines  ": "tabs-and-new-lines",
+	}
+
+	for title, expected := range tests {
+		if slug := Slugify(title); slug != expected {
+			t.Errorf("Slugify(%q) = %q, expected %q", title, slug, expected)
+		}
+	}
+}
+
+func TestMakeExcerpt(t *testing.T) {
+	body := strings.Repeat("word ", 100)
+
+	excerpt := makeExcerpt(body, 22)
+	if excerpt != "word word word word…" {
+		t.Errorf("Unexpected excerpt %q", excerpt)
+	}
+
+	if excerpt := makeExcerpt("short\nbody", 22); excerpt != "short body" {
+		t.Errorf("Unexpected excerpt %q", excerpt)
+	}
+}
+
+func TestMemoryPostExcerpt(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	ctx := context.Background()
+
+	post := Post{Title: "Title", Body: "first body"}
+	if err := repo.Create(ctx, &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	post.Body = "second body"
+	if err := repo.Update(ctx, &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if post.Excerpt != "second body" {
+		t.Errorf("Expected the excerpt to follow the body, got %q", post.Excerpt)
+	}
+
+	post.Excerpt, post.ExcerptManual = "by hand", true
+	post.Body = "third body"
+	if err := repo.Update(ctx, &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if post.Excerpt != "by hand" {
+		t.Errorf("Expected the excerpt that was written by hand, got %q", post.Excerpt)
+	}
+}
+
+func TestMemoryPostUniqueSlug(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	ctx := context.Background()
+
+	slugs := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		post := Post{Title: "Hello World", Body: "body"}
+		if err := repo.Create(ctx, &post); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+		slugs = append(slugs, post.Slug)
+	}
+
+	expected := []string{"hello-world", "hello-world-2", "hello-world-3"}
+	for i := range expected {
+		if slugs[i] != expected[i] {
+			t.Errorf("Expected slug %s, got %s", expected[i], slugs[i])
+		}
+	}
+}
+
+func TestMemoryPostVersionConflict(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	ctx := context.Background()
+
+	post := Post{Title: "Title", Body: "body"}
+	if err := repo.Create(ctx, &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	first, _ := repo.GetByID(ctx, post.ID)
+	second, _ := repo.GetByID(ctx, post.ID)
+
+	first.Status = PostPublished
+	if err := repo.Update(ctx, &first); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if first.Version != 2 {
+		t.Errorf("Expected version 2, got %d", first.Version)
+	}
+	if !first.PublishedAt.Valid {
+		t.Error("Expected published_at to be set on publish")
+	}
+
+	second.Title = "Other title"
+	if err := repo.Update(ctx, &second); err != ErrVersionConflict {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+}
+
+func TestMemoryPostNegativeOffset(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	if err := repo.Create(context.Background(), &Post{Title: "Post"}); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	posts, err := repo.List(context.Background(), PostFilter{Offset: -1, Limit: 5})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if len(posts) != 1 {
+		t.Errorf("Expected a single post, got %d", len(posts))
+	}
+}
+
+func TestMemoryPostInvalidStatus(t *testing.T) {
+	repo := NewMemoryPostRepository()
+
+	post := Post{Title: "Title", Status: "unknown"}
+	if err := repo.Create(context.Background(), &post); err != ErrInvalidStatus {
+		t.Errorf("Expected ErrInvalidStatus, got %v", err)
+	}
+}
+
+func TestMemoryPostListCursor(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	for i := 0; i < 5; i++ {
+		if err := repo.Create(context.Background(), &Post{Title: "Post"}); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+	}
+
+	ids := func(posts []Post) []uint64 {
+		list := make([]uint64, len(posts))
+		for i, post := range posts {
+			list[i] = post.ID
+		}
+		return list
+	}
+
+	first, _ := repo.List(context.Background(), PostFilter{Limit: 2})
+	if got := ids(first); len(got) != 2 || got[0] != 5 || got[1] != 4 {
+		t.Fatalf("Expected posts 5 and 4, got %v", got)
+	}
+
+	cursor := first[1].Cursor()
+	second, _ := repo.List(context.Background(), PostFilter{Limit: 2, Cursor: &cursor})
+	if got := ids(second); len(got) != 2 || got[0] != 3 || got[1] != 2 {
+		t.Fatalf("Expected posts 3 and 2, got %v", got)
+	}
+
+	cursor = second[0].Cursor()
+	cursor.Backward = true
+	back, _ := repo.List(context.Background(), PostFilter{Limit: 1, Cursor: &cursor})
+	if got := ids(back); len(got) != 1 || got[0] != 4 {
+		t.Errorf("Expected post 4 before post 3, got %v", got)
+	}
+}
+
+func TestMemoryPostRevisions(t *testing.T) {
+	repo := NewMemoryPostRepository()
+	ctx := context.Background()
+
+	post := Post{AuthorID: 1, Title: "First", Body: "one"}
+	if err := repo.Create(ctx, &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	post.Title, post.Body = "Second", "two"
+	if err := repo.Update(WithEditor(ctx, 2), &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	restored, err := RestoreRevision(WithEditor(ctx, 3), repo, post.ID, 1)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if restored.Version != 3 || restored.Title != "First" || restored.Body != "one" {
+		t.Errorf("Expected version 3 with the first title and body, got %+v", restored)
+	}
+
+	revisions, err := repo.Revisions(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	editors := []uint64{3, 2, 1}
+	if len(revisions) != len(editors) {
+		t.Fatalf("Expected %d revisions, got %d", len(editors), len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Version != uint64(len(editors)-i) || revision.EditorID != editors[i] {
+			t.Errorf("Expected version %d by %d, got %d by %d",
+				len(editors)-i, editors[i], revision.Version, revision.EditorID)
+		}
+	}
+
+	if _, err := repo.Revision(ctx, post.ID, 9); err != ErrRevisionNotFound {
+		t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+	}
+}
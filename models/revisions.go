@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRevisionNotFound is returned for a version of a post that has no
+// revision
+var ErrRevisionNotFound = errors.New("revision not found")
+
+const revisionColumns = `id, post_id, version, title, body, editor_id, created_at`
+
+// Revision is an immutable copy of a post as it was saved
+type Revision struct {
+	ID     uint64 `json:"id" db:"id"`
+	PostID uint64 `json:"post_id" db:"post_id"`
+	// Version is the version of the post that the revision was saved as
+	Version uint64 `json:"version" db:"version"`
+	Title   string `json:"title" db:"title"`
+	Body    string `json:"body" db:"body"`
+	// EditorID is the user that saved the revision
+	EditorID  uint64    `json:"editor_id" db:"editor_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type editorContextKey struct{}
+
+// WithEditor returns a copy of ctx that saves posts as edited by the user id.
+// Without it, the author of a post is its editor.
+func WithEditor(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, editorContextKey{}, id)
+}
+
+// editorOf returns the editor of ctx, or the author of post
+func editorOf(ctx context.Context, post Post) uint64 {
+	if id, ok := ctx.Value(editorContextKey{}).(uint64); ok && id != 0 {
+		return id
+	}
+	return post.AuthorID
+}
+
+// newRevision returns the revision of a post that was just saved
+func newRevision(ctx context.Context, post Post) Revision {
+	return Revision{
+		PostID:    post.ID,
+		Version:   post.Version,
+		Title:     post.Title,
+		Body:      post.Body,
+		EditorID:  editorOf(ctx, post),
+		CreatedAt: post.UpdatedAt,
+	}
+}
+
+// RestoreRevision saves the title and the body of a revision of a post as
+// its new version, the revisions in between are kept. As CanEdit, a post that
+// is not a draft or waiting for changes is locked.
+func RestoreRevision(ctx context.Context, posts PostRepository, postID, version uint64) (Post, error) {
+	revision, err := posts.Revision(ctx, postID, version)
+	if err != nil {
+		return Post{}, err
+	}
+	post, err := posts.GetByID(ctx, postID)
+	if err != nil {
+		return Post{}, err
+	}
+	if !post.Status.editable() {
+		return post, ErrPostLocked
+	}
+
+	post.Title, post.Body = revision.Title, revision.Body
+	err = posts.Update(ctx, &post)
+	return post, err
+}
@@ -1,29 +1,82 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ik5/go-into/pagination"
 	"github.com/ik5/go-into/types"
 )
 
+// Errors that are returned by a UserRepository
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrDuplicateUsername  = errors.New("username is already taken")
+	ErrDuplicateEmail     = errors.New("email is already taken")
+	ErrMissingCredentials = errors.New("username, email and password are required")
+)
+
 // User data structure
 type User struct {
 	ID          uint64         `json:"id" db:"id"`
 	Roles       types.Role     `json:"roles" db:"roles"`
 	Username    string         `json:"username" db:"username"`
-	Password    string         `json:"password" db:"password"`
+	Password    string         `json:"-" db:"password"`
 	Email       string         `json:"email" db:"email"`
 	Name        sql.NullString `json:"name" db:"name"`
 	IconAddress sql.NullString `json:"icon_address,omitempty" db:"icon_address"`
-	Enabled     bool           `json:"-" db:"enabled"`
-	Deleted     bool           `json:"-" db:"deleted"`
-	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+	Enabled     bool           `json:"enabled" db:"enabled"`
+	Deleted     bool           `json:"deleted" db:"deleted"`
+	// MustResetPassword marks that the user must choose a new password on the
+	// next login
+	MustResetPassword bool      `json:"must_reset_password" db:"must_reset_password"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// UserFilter narrows down a list of users. Zero values are ignored.
+type UserFilter struct {
+	// Search matches part of the username, email or name (case-insensitive)
+	Search string
+	// Enabled and Deleted filter by the user flags when not nil
+	Enabled *bool
+	Deleted *bool
+	// Roles filters users that hold all of the given roles
+	Roles  types.Role
+	Limit  int
+	Offset int
+	// Cursor lists the users after a position instead of Offset, see
+	// User.Cursor
+	Cursor *pagination.Cursor
+}
+
+// UserRepository is the persistence layer of users.
+//
+// Users are soft deleted: lookups still return a deleted user, so it can be
+// restored and its username and email stay reserved, while List hides deleted
+// users unless they are asked for.
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	GetByID(ctx context.Context, id uint64) (User, error)
+	// GetByUsername and GetByEmail are case-insensitive
+	GetByUsername(ctx context.Context, username string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	// Update stores all fields of the user, and sets UpdatedAt
+	Update(ctx context.Context, user *User) error
+	SoftDelete(ctx context.Context, id uint64) error
+	Restore(ctx context.Context, id uint64) error
+	List(ctx context.Context, filter UserFilter) ([]User, error)
 }
 
+// usersKeyset is the order of a list of users, by username
+var usersKeyset = pagination.Keyset{KeyColumn: "lower(username)", IDColumn: "id"}
+
 // NullUser is a representation for a user struct that can be null at db level
 type NullUser struct {
 	User  User
@@ -41,21 +94,121 @@ func (u User) String() string {
 	return fmt.Sprintf("%d - %s (%s)", u.ID, u.Email, u.Username)
 }
 
+// Cursor returns the position of the user at a list of users
+func (u User) Cursor() pagination.Cursor {
+	return pagination.Cursor{Key: strings.ToLower(u.Username), ID: u.ID}
+}
+
+// prepare validates a new user and fills its defaults
+func (u *User) prepare() error {
+	if u.Username == "" || u.Email == "" || u.Password == "" {
+		return ErrMissingCredentials
+	}
+	if u.Roles == 0 {
+		u.Roles = types.RoleNone
+	}
+	return nil
+}
+
 // Scan implements the Scanner interface.
+//
+// A user is scanned from a JSON object, so a LEFT JOIN can return an optional
+// user as a single column, for example:
+//
+//	SELECT posts.id, row_to_json(reviewer)
+//	FROM posts LEFT JOIN users AS reviewer ON reviewer.id = posts.reviewer_id
+//
+// A NULL column, or a JSON null, is an invalid NullUser.
 func (nu *NullUser) Scan(value interface{}) error {
-	if value == nil {
-		nu.User, nu.Valid = User{}, false
+	nu.User, nu.Valid = User{}, false
+
+	data, err := jsonColumn(value)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var row *userRow
+	if err := json.Unmarshal(data, &row); err != nil {
+		return fmt.Errorf("unable to scan user: %s", err)
+	}
+	if row == nil {
 		return nil
 	}
-	nu.Valid = true
-	nu.User = value.(User)
+
+	nu.User, nu.Valid = row.user(), true
 	return nil
 }
 
-// Value implements the driver Valuer interface.
-func (nu *NullUser) Value() (driver.Value, error) {
+// Value implements the driver Valuer interface, the user is stored as the
+// same JSON object that Scan reads.
+func (nu NullUser) Value() (driver.Value, error) {
 	if !nu.Valid {
 		return nil, nil
 	}
-	return nu.User, nil
+	return json.Marshal(newUserRow(nu.User))
+}
+
+// Scan implements the Scanner interface, the users are scanned from a JSON
+// array of objects, for example the result of json_agg(). NULL elements are
+// scanned as invalid users.
+func (users *Users) Scan(value interface{}) error {
+	*users = nil
+
+	data, err := jsonColumn(value)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("unable to scan users: %s", err)
+	}
+
+	list := make(Users, len(rows))
+	for i, row := range rows {
+		if err := list[i].Scan([]byte(row)); err != nil {
+			return err
+		}
+	}
+	*users = list
+	return nil
+}
+
+// Value implements the driver Valuer interface
+func (users Users) Value() (driver.Value, error) {
+	if users == nil {
+		return nil, nil
+	}
+
+	rows := make([]*userRow, len(users))
+	for i, nu := range users {
+		if nu.Valid {
+			rows[i] = newUserRow(nu.User)
+		}
+	}
+	return json.Marshal(rows)
+}
+
+// Valid returns only the users that are not null
+func (users Users) Valid() []User {
+	list := make([]User, 0, len(users))
+	for _, nu := range users {
+		if nu.Valid {
+			list = append(list, nu.User)
+		}
+	}
+	return list
+}
+
+// jsonColumn returns the content of a JSON column, or nil for NULL
+func jsonColumn(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("unsupported data type %T for a JSON column", value)
 }
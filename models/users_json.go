@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ik5/go-into/types"
+)
+
+// dbTimeLayouts are the layouts that PostgreSQL uses for timestamps at JSON,
+// with and without a time zone
+var dbTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// dbTime is a timestamp that is encoded to JSON by PostgreSQL
+type dbTime time.Time
+
+// userRow is a row of the users table as encoded by row_to_json(), the keys
+// are the column names, and nullable columns are JSON null
+type userRow struct {
+	ID                uint64  `json:"id"`
+	Roles             uint64  `json:"roles"`
+	Username          string  `json:"username"`
+	Password          string  `json:"password"`
+	Email             string  `json:"email"`
+	Name              *string `json:"name"`
+	IconAddress       *string `json:"icon_address"`
+	Enabled           bool    `json:"enabled"`
+	Deleted           bool    `json:"deleted"`
+	MustResetPassword bool    `json:"must_reset_password"`
+	CreatedAt         dbTime  `json:"created_at"`
+	UpdatedAt         dbTime  `json:"updated_at"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *dbTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	for _, layout := range dbTimeLayouts {
+		parsed, err := time.Parse(layout, s)
+		if err == nil {
+			*t = dbTime(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamp %q", s)
+}
+
+// MarshalJSON implements json.Marshaler
+func (t dbTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).Format(time.RFC3339Nano))
+}
+
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+func stringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func newUserRow(user User) *userRow {
+	return &userRow{
+		ID:                user.ID,
+		Roles:             uint64(user.Roles),
+		Username:          user.Username,
+		Password:          user.Password,
+		Email:             user.Email,
+		Name:              stringPtr(user.Name),
+		IconAddress:       stringPtr(user.IconAddress),
+		Enabled:           user.Enabled,
+		Deleted:           user.Deleted,
+		MustResetPassword: user.MustResetPassword,
+		CreatedAt:         dbTime(user.CreatedAt),
+		UpdatedAt:         dbTime(user.UpdatedAt),
+	}
+}
+
+func (row userRow) user() User {
+	return User{
+		ID:                row.ID,
+		Roles:             types.Role(row.Roles),
+		Username:          row.Username,
+		Password:          row.Password,
+		Email:             row.Email,
+		Name:              nullString(row.Name),
+		IconAddress:       nullString(row.IconAddress),
+		Enabled:           row.Enabled,
+		Deleted:           row.Deleted,
+		MustResetPassword: row.MustResetPassword,
+		CreatedAt:         time.Time(row.CreatedAt),
+		UpdatedAt:         time.Time(row.UpdatedAt),
+	}
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository is a UserRepository that keeps the users at memory.
+//
+// It is meant for tests of code that depends on a UserRepository.
+type MemoryUserRepository struct {
+	mtx    sync.Mutex
+	users  map[uint64]User
+	nextID uint64
+}
+
+// NewMemoryUserRepository creates an empty MemoryUserRepository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:  make(map[uint64]User),
+		nextID: 1,
+	}
+}
+
+// duplicate returns a domain error if another user than id uses the username
+// or the email of user
+func (repo *MemoryUserRepository) duplicate(user *User) error {
+	for _, other := range repo.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if strings.EqualFold(other.Username, user.Username) {
+			return ErrDuplicateUsername
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return ErrDuplicateEmail
+		}
+	}
+	return nil
+}
+
+// Create implements UserRepository
+func (repo *MemoryUserRepository) Create(ctx context.Context, user *User) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	if err := user.prepare(); err != nil {
+		return err
+	}
+	user.ID = 0
+	if err := repo.duplicate(user); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.ID = repo.nextID
+	user.CreatedAt, user.UpdatedAt = now, now
+
+	repo.nextID++
+	repo.users[user.ID] = *user
+	return nil
+}
+
+// GetByID implements UserRepository
+func (repo *MemoryUserRepository) GetByID(ctx context.Context, id uint64) (User, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	user, ok := repo.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (repo *MemoryUserRepository) find(match func(User) bool) (User, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	for _, user := range repo.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+// GetByUsername implements UserRepository
+func (repo *MemoryUserRepository) GetByUsername(ctx context.Context, username string) (User, error) {
+	return repo.find(func(user User) bool {
+		return strings.EqualFold(user.Username, username)
+	})
+}
+
+// GetByEmail implements UserRepository
+func (repo *MemoryUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	return repo.find(func(user User) bool {
+		return strings.EqualFold(user.Email, email)
+	})
+}
+
+// Update implements UserRepository
+func (repo *MemoryUserRepository) Update(ctx context.Context, user *User) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	stored, ok := repo.users[user.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := repo.duplicate(user); err != nil {
+		return err
+	}
+
+	user.CreatedAt = stored.CreatedAt
+	user.UpdatedAt = time.Now()
+	repo.users[user.ID] = *user
+	return nil
+}
+
+func (repo *MemoryUserRepository) setDeleted(id uint64, deleted bool) error {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	user, ok := repo.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	user.Deleted = deleted
+	user.UpdatedAt = time.Now()
+	repo.users[id] = user
+	return nil
+}
+
+// SoftDelete implements UserRepository
+func (repo *MemoryUserRepository) SoftDelete(ctx context.Context, id uint64) error {
+	return repo.setDeleted(id, true)
+}
+
+// Restore implements UserRepository
+func (repo *MemoryUserRepository) Restore(ctx context.Context, id uint64) error {
+	return repo.setDeleted(id, false)
+}
+
+// List implements UserRepository, ordered by username
+func (repo *MemoryUserRepository) List(ctx context.Context, filter UserFilter) ([]User, error) {
+	defer repo.mtx.Unlock()
+	repo.mtx.Lock()
+
+	search := strings.ToLower(filter.Search)
+	deleted := filter.Deleted != nil && *filter.Deleted
+
+	list := make([]User, 0, len(repo.users))
+	for _, user := range repo.users {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(user.Username), search) &&
+			!strings.Contains(strings.ToLower(user.Email), search) &&
+			!strings.Contains(strings.ToLower(user.Name.String), search) {
+			continue
+		}
+		if filter.Enabled != nil && user.Enabled != *filter.Enabled {
+			continue
+		}
+		if user.Deleted != deleted {
+			continue
+		}
+		if !user.Roles.Has(filter.Roles) {
+			continue
+		}
+		if filter.Cursor != nil && !usersKeyset.Follows(*filter.Cursor,
+			strings.Compare(strings.ToLower(user.Username), filter.Cursor.Key), user.ID) {
+			continue
+		}
+		list = append(list, user)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := strings.ToLower(list[i].Username), strings.ToLower(list[j].Username)
+		if a != b {
+			return a < b
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	start, end := memoryWindow(len(list), filter.Offset, filter.Limit, filter.Cursor)
+	return list[start:end], nil
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/pagination"
+)
+
+// unique indexes of the users table, they are on lower() of the columns so
+// the uniqueness is case-insensitive
+const (
+	usersUsernameConstraint = "users_username_key"
+	usersEmailConstraint    = "users_email_key"
+)
+
+const userColumns = `id, roles, username, password, email, name, icon_address,
+	enabled, deleted, must_reset_password, created_at, updated_at`
+
+// PostgresUserRepository is a UserRepository that is stored at PostgreSQL
+type PostgresUserRepository struct {
+	conn *db.Conn
+}
+
+// NewPostgresUserRepository creates a UserRepository over conn
+func NewPostgresUserRepository(conn *db.Conn) *PostgresUserRepository {
+	return &PostgresUserRepository{conn: conn}
+}
+
+// userError translates unique violations to the domain errors
+func userError(err error) error {
+	constraint, ok := db.UniqueViolation(err)
+	if !ok {
+		return err
+	}
+
+	switch constraint {
+	case usersUsernameConstraint:
+		return ErrDuplicateUsername
+	case usersEmailConstraint:
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+// userArgs returns the named arguments of the columns of user, where the
+// password hash and the email are redacted at the hooks of the connection
+func userArgs(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                  user.ID,
+		"roles":               user.Roles,
+		"username":            user.Username,
+		"password":            db.Secret{Arg: user.Password},
+		"email":               db.Secret{Arg: user.Email},
+		"name":                user.Name,
+		"icon_address":        user.IconAddress,
+		"enabled":             user.Enabled,
+		"deleted":             user.Deleted,
+		"must_reset_password": user.MustResetPassword,
+	}
+}
+
+// Create implements UserRepository
+func (repo *PostgresUserRepository) Create(ctx context.Context, user *User) error {
+	ctx = db.WithQueryLabel(ctx, "users.create")
+
+	if err := user.prepare(); err != nil {
+		return err
+	}
+
+	err := repo.conn.NamedGet(ctx, user,
+		db.InsertQuery("users", user, "id", "created_at", "updated_at")+
+			` RETURNING id, created_at, updated_at`,
+		userArgs(user),
+	)
+	return userError(err)
+}
+
+// get returns the single user that matches where
+func (repo *PostgresUserRepository) get(ctx context.Context, where string, args ...interface{}) (User, error) {
+	var user User
+	err := repo.conn.Get(ctx, &user, `SELECT `+userColumns+` FROM users WHERE `+where, args...)
+	return user, notFound(err, ErrUserNotFound)
+}
+
+// GetByID implements UserRepository
+func (repo *PostgresUserRepository) GetByID(ctx context.Context, id uint64) (User, error) {
+	ctx = db.WithQueryLabel(ctx, "users.get_by_id")
+	return repo.get(ctx, `id = $1`, id)
+}
+
+// GetByUsername implements UserRepository
+func (repo *PostgresUserRepository) GetByUsername(ctx context.Context, username string) (User, error) {
+	ctx = db.WithQueryLabel(ctx, "users.get_by_username")
+	return repo.get(ctx, `lower(username) = lower($1)`, username)
+}
+
+// GetByEmail implements UserRepository
+func (repo *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	ctx = db.WithQueryLabel(ctx, "users.get_by_email")
+	return repo.get(ctx, `lower(email) = lower($1)`, db.Secret{Arg: email})
+}
+
+// Update implements UserRepository
+func (repo *PostgresUserRepository) Update(ctx context.Context, user *User) error {
+	ctx = db.WithQueryLabel(ctx, "users.update")
+
+	err := repo.conn.NamedGet(ctx, user,
+		`UPDATE users SET roles = :roles, username = :username,
+			password = :password, email = :email, name = :name,
+			icon_address = :icon_address, enabled = :enabled, deleted = :deleted,
+			must_reset_password = :must_reset_password, updated_at = now()
+		WHERE id = :id
+		RETURNING updated_at`,
+		userArgs(user),
+	)
+	return userError(notFound(err, ErrUserNotFound))
+}
+
+func (repo *PostgresUserRepository) setDeleted(ctx context.Context, id uint64, deleted bool) error {
+	result, err := repo.conn.Exec(ctx,
+		`UPDATE users SET deleted = $1, updated_at = now() WHERE id = $2`,
+		deleted, id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SoftDelete implements UserRepository
+func (repo *PostgresUserRepository) SoftDelete(ctx context.Context, id uint64) error {
+	ctx = db.WithQueryLabel(ctx, "users.soft_delete")
+	return repo.setDeleted(ctx, id, true)
+}
+
+// Restore implements UserRepository
+func (repo *PostgresUserRepository) Restore(ctx context.Context, id uint64) error {
+	ctx = db.WithQueryLabel(ctx, "users.restore")
+	return repo.setDeleted(ctx, id, false)
+}
+
+// List implements UserRepository, ordered by username
+func (repo *PostgresUserRepository) List(ctx context.Context, filter UserFilter) ([]User, error) {
+	ctx = db.WithQueryLabel(ctx, "users.list")
+
+	where := make([]string, 0, 4)
+	args := make([]interface{}, 0, 6)
+
+	if filter.Search != "" {
+		// the search may be an email
+		args = append(args, db.Secret{Arg: "%" + escapeLike(filter.Search) + "%"})
+		where = append(where, fmt.Sprintf(
+			"(username ILIKE $%[1]d OR email ILIKE $%[1]d OR name ILIKE $%[1]d)", len(args),
+		))
+	}
+	if filter.Enabled != nil {
+		args = append(args, *filter.Enabled)
+		where = append(where, fmt.Sprintf("enabled = $%d", len(args)))
+	}
+	deleted := false
+	if filter.Deleted != nil {
+		deleted = *filter.Deleted
+	}
+	args = append(args, deleted)
+	where = append(where, fmt.Sprintf("deleted = $%d", len(args)))
+	if filter.Roles != 0 {
+		args = append(args, uint64(filter.Roles))
+		where = append(where, fmt.Sprintf("roles & $%[1]d = $%[1]d", len(args)))
+	}
+
+	if filter.Cursor != nil {
+		clause, cursorArgs := usersKeyset.Where(*filter.Cursor, len(args)+1)
+		args = append(args, cursorArgs...)
+		where = append(where, clause)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE ` +
+		strings.Join(where, " AND ") + ` ORDER BY ` + usersKeyset.OrderBy(filter.Cursor)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	list := make([]User, 0)
+	if err := repo.conn.Select(ctx, &list, query, args...); err != nil {
+		return list, err
+	}
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		pagination.Reverse(list)
+	}
+	return list, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/db/dbtest"
+	"github.com/ik5/go-into/pagination"
+	"github.com/lib/pq"
+)
+
+func newPostgresUserTest(t *testing.T) (*PostgresUserRepository, *dbtest.Mock) {
+	sqlDB, mock := dbtest.New(t)
+	return NewPostgresUserRepository(db.NewFromDB(sqlDB)), mock
+}
+
+func TestPostgresUserCreateDuplicateEmail(t *testing.T) {
+	repo, mock := newPostgresUserTest(t)
+
+	mock.ExpectQuery("INSERT INTO users").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: usersEmailConstraint})
+
+	user := User{Username: "root", Email: "root@example.com", Password: "hash"}
+	if err := repo.Create(context.Background(), &user); err != ErrDuplicateEmail {
+		t.Errorf("Expected %s, got %v", ErrDuplicateEmail, err)
+	}
+}
+
+func TestPostgresUserGetByUsernameNotFound(t *testing.T) {
+	repo, mock := newPostgresUserTest(t)
+
+	mock.ExpectQuery("WHERE lower(username) = lower($1)").WithArgs("Root")
+
+	if _, err := repo.GetByUsername(context.Background(), "Root"); err != ErrUserNotFound {
+		t.Errorf("Expected %s, got %v", ErrUserNotFound, err)
+	}
+}
+
+func TestPostgresUserListFilter(t *testing.T) {
+	repo, mock := newPostgresUserTest(t)
+
+	enabled := true
+	mock.ExpectQuery("WHERE (username ILIKE $1 OR email ILIKE $1 OR name ILIKE $1) "+
+		"AND enabled = $2 AND deleted = $3 ORDER BY lower(username) ASC, id ASC LIMIT $4").
+		WithArgs(`%50\%%`, true, false, 10).
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(1, "root"))
+
+	users, err := repo.List(context.Background(), UserFilter{Search: "50%", Enabled: &enabled, Limit: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(users) != 1 || users[0].Username != "root" {
+		t.Errorf("Expected root, got %+v", users)
+	}
+}
+
+func TestPostgresUserListCursor(t *testing.T) {
+	repo, mock := newPostgresUserTest(t)
+
+	cursor := &pagination.Cursor{Key: "root", ID: 1, Backward: true}
+	mock.ExpectQuery("WHERE deleted = $1 AND (lower(username), id) < ($2, $3) "+
+		"ORDER BY lower(username) DESC, id DESC LIMIT $4").
+		WithArgs(false, "root", 1, 3).
+		WillReturnRows(dbtest.NewRows("id", "username").AddRow(3, "editor").AddRow(2, "admin"))
+
+	users, err := repo.List(context.Background(), UserFilter{Cursor: cursor, Limit: 3})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(users) != 2 || users[0].Username != "admin" || users[1].Username != "editor" {
+		t.Errorf("Expected admin and editor at the order of the list, got %+v", users)
+	}
+}
+
+// argsHook records the arguments of the queries
+type argsHook struct {
+	args [][]interface{}
+}
+
+func (h *argsHook) Before(ctx context.Context, event *db.QueryEvent) context.Context {
+	h.args = append(h.args, event.Args)
+	return ctx
+}
+
+func (h *argsHook) After(context.Context, *db.QueryEvent) {}
+
+func TestPostgresUserRedactsSecrets(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := db.NewFromDB(sqlDB)
+	hook := &argsHook{}
+	conn.AddHook(hook)
+	repo := NewPostgresUserRepository(conn)
+
+	mock.ExpectQuery("UPDATE users SET").
+		WillReturnRows(dbtest.NewRows("updated_at").AddRow(time.Now()))
+
+	user := User{ID: 1, Username: "root", Email: "root@example.com", Password: "hash"}
+	if err := repo.Update(context.Background(), &user); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(hook.args) != 1 {
+		t.Fatalf("Expected a single query, got %d", len(hook.args))
+	}
+	for _, arg := range hook.args[0] {
+		if arg == "hash" || arg == "root@example.com" {
+			t.Errorf("Expected %v to be redacted, got %v", arg, hook.args[0])
+		}
+	}
+}
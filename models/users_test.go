@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ik5/go-into/types"
+)
+
+func newTestUsers(t *testing.T) *MemoryUserRepository {
+	repo := NewMemoryUserRepository()
+	for _, user := range []User{
+		{Username: "Alice", Email: "alice@example.com", Password: "x", Roles: types.RoleEditor},
+		{Username: "bob", Email: "Bob@Example.com", Password: "x"},
+	} {
+		if err := repo.Create(context.Background(), &user); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+	}
+	return repo
+}
+
+func TestMemoryUserCaseInsensitiveLookup(t *testing.T) {
+	repo := newTestUsers(t)
+	ctx := context.Background()
+
+	if user, err := repo.GetByUsername(ctx, "alice"); err != nil || user.ID != 1 {
+		t.Errorf("Expected alice, got %+v, %v", user, err)
+	}
+	if user, err := repo.GetByEmail(ctx, "bob@example.COM"); err != nil || user.ID != 2 {
+		t.Errorf("Expected bob, got %+v, %v", user, err)
+	}
+	if _, err := repo.GetByUsername(ctx, "carol"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestMemoryUserDuplicates(t *testing.T) {
+	repo := newTestUsers(t)
+	ctx := context.Background()
+
+	user := User{Username: "ALICE", Email: "other@example.com", Password: "x"}
+	if err := repo.Create(ctx, &user); err != ErrDuplicateUsername {
+		t.Errorf("Expected ErrDuplicateUsername, got %v", err)
+	}
+
+	user = User{Username: "carol", Email: "BOB@example.com", Password: "x"}
+	if err := repo.Create(ctx, &user); err != ErrDuplicateEmail {
+		t.Errorf("Expected ErrDuplicateEmail, got %v", err)
+	}
+}
+
+func TestMemoryUserSoftDelete(t *testing.T) {
+	repo := newTestUsers(t)
+	ctx := context.Background()
+
+	if err := repo.SoftDelete(ctx, 1); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	list, _ := repo.List(ctx, UserFilter{})
+	if len(list) != 1 || list[0].Username != "bob" {
+		t.Errorf("Expected only bob to be listed, got %+v", list)
+	}
+
+	deleted := true
+	list, _ = repo.List(ctx, UserFilter{Deleted: &deleted})
+	if len(list) != 1 || list[0].Username != "Alice" {
+		t.Errorf("Expected only Alice to be listed as deleted, got %+v", list)
+	}
+
+	if err := repo.Restore(ctx, 1); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if user, _ := repo.GetByID(ctx, 1); user.Deleted {
+		t.Error("Expected user to be restored")
+	}
+}
+
+func TestMemoryUserDefaultRole(t *testing.T) {
+	repo := newTestUsers(t)
+
+	user, _ := repo.GetByID(context.Background(), 2)
+	if user.Roles != types.RoleNone {
+		t.Errorf("Expected RoleNone, got %d", user.Roles)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if escaped := escapeLike(`50%_off\`); escaped != `50\%\_off\\` {
+		t.Errorf("Unexpected escaped pattern %s", escaped)
+	}
+}
+
+// row fixtures as returned by row_to_json() and json_agg() of PostgreSQL
+const (
+	userRowFixture = `{"id":7,"roles":14,"username":"reviewer","password":"0001$31$28",
+		"email":"reviewer@example.com","name":"Re Viewer","icon_address":null,
+		"enabled":true,"deleted":false,"must_reset_password":false,
+		"created_at":"2019-07-01T10:00:00.123456+03:00",
+		"updated_at":"2019-07-02T11:30:00"}`
+	usersRowFixture = `[` + userRowFixture + `, null]`
+)
+
+func TestNullUserScanRow(t *testing.T) {
+	for _, value := range []interface{}{[]byte(userRowFixture), userRowFixture} {
+		var nu NullUser
+		if err := nu.Scan(value); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+		if !nu.Valid {
+			t.Fatal("Expected a valid user")
+		}
+
+		user := nu.User
+		if user.ID != 7 || user.Username != "reviewer" || user.Roles != types.Role(14) {
+			t.Errorf("Unexpected user %+v", user)
+		}
+		if !user.Name.Valid || user.Name.String != "Re Viewer" {
+			t.Errorf("Unexpected name %+v", user.Name)
+		}
+		if user.IconAddress.Valid {
+			t.Errorf("Expected null icon address, got %+v", user.IconAddress)
+		}
+		if user.CreatedAt.Hour() != 10 || user.UpdatedAt.Minute() != 30 {
+			t.Errorf("Unexpected timestamps %s, %s", user.CreatedAt, user.UpdatedAt)
+		}
+	}
+}
+
+func TestNullUserScanNull(t *testing.T) {
+	for _, value := range []interface{}{nil, []byte("null")} {
+		nu := NullUser{Valid: true}
+		if err := nu.Scan(value); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+		if nu.Valid {
+			t.Errorf("Expected %v to be scanned as invalid user", value)
+		}
+	}
+}
+
+func TestNullUserScanInvalid(t *testing.T) {
+	var nu NullUser
+	if err := nu.Scan(int64(7)); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+	if err := nu.Scan([]byte(`{"id": "seven"}`)); err == nil {
+		t.Error("Expected an error for an invalid row")
+	}
+}
+
+func TestNullUserValue(t *testing.T) {
+	var nu NullUser
+	if err := nu.Scan(userRowFixture); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	value, err := nu.Value()
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	var scanned NullUser
+	if err := scanned.Scan(value); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if !scanned.User.CreatedAt.Equal(nu.User.CreatedAt) || scanned.User.Email != nu.User.Email {
+		t.Errorf("Expected %+v, got %+v", nu.User, scanned.User)
+	}
+
+	if value, _ := (NullUser{}).Value(); value != nil {
+		t.Errorf("Expected nil value for invalid user, got %v", value)
+	}
+}
+
+func TestUsersScanRows(t *testing.T) {
+	var users Users
+	if err := users.Scan([]byte(usersRowFixture)); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	if len(users) != 2 || !users[0].Valid || users[1].Valid {
+		t.Fatalf("Unexpected users %+v", users)
+	}
+	if valid := users.Valid(); len(valid) != 1 || valid[0].ID != 7 {
+		t.Errorf("Unexpected valid users %+v", valid)
+	}
+
+	if err := users.Scan(nil); err != nil || users != nil {
+		t.Errorf("Expected nil users, got %+v, %v", users, err)
+	}
+}
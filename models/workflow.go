@@ -0,0 +1,262 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ik5/go-into/types"
+)
+
+// Action is a step of the editorial workflow of posts.
+//
+// Writers with RoleCreate submit their drafts to review, reviewers with
+// RoleReview approve them or request changes, and publishers with RolePublish
+// publish approved posts at once or schedule them to a future time.
+type Action string
+
+// A list of workflow actions
+const (
+	ActionSubmit         Action = "submit"
+	ActionWithdraw       Action = "withdraw"
+	ActionComment        Action = "comment"
+	ActionApprove        Action = "approve"
+	ActionRequestChanges Action = "request_changes"
+	ActionPublish        Action = "publish"
+	ActionSchedule       Action = "schedule"
+	ActionUnschedule     Action = "unschedule"
+	ActionArchive        Action = "archive"
+)
+
+const transitionColumns = `id, post_id, action, from_status, to_status, actor_id,
+	comment, publish_at, created_at`
+
+// Errors that are returned by the workflow
+var (
+	ErrUnknownAction     = errors.New("unknown workflow action")
+	ErrActionNotAllowed  = errors.New("action is not allowed at the status of the post")
+	ErrActionForbidden   = errors.New("action requires roles that the user does not hold")
+	ErrCommentRequired   = errors.New("action requires a comment")
+	ErrInvalidPublishAt  = errors.New("publish time must be in the future")
+	ErrPostLocked        = errors.New("post is locked for editing by the workflow")
+	ErrTransitionChanged = errors.New("post status was changed by someone else")
+)
+
+// rule is the transition of an action, and who may perform it
+type rule struct {
+	from  []PostStatus
+	to    PostStatus
+	roles types.Role
+	// own allows only the author of the post, or an editor
+	own bool
+	// comment requires a comment
+	comment bool
+}
+
+// workflow is the state machine of posts. An action without to keeps the
+// status of the post.
+var workflow = map[Action]rule{
+	ActionSubmit: {
+		from: []PostStatus{PostDraft, PostChangesRequested}, to: PostInReview,
+		roles: types.RoleCreate, own: true,
+	},
+	ActionWithdraw: {
+		from: []PostStatus{PostInReview, PostChangesRequested}, to: PostDraft,
+		roles: types.RoleCreate, own: true,
+	},
+	ActionComment: {
+		from:  []PostStatus{PostInReview, PostChangesRequested, PostApproved},
+		roles: types.RoleReview, comment: true,
+	},
+	ActionApprove: {
+		from: []PostStatus{PostInReview}, to: PostApproved,
+		roles: types.RoleReview,
+	},
+	ActionRequestChanges: {
+		from: []PostStatus{PostInReview, PostApproved}, to: PostChangesRequested,
+		roles: types.RoleReview, comment: true,
+	},
+	ActionPublish: {
+		from: []PostStatus{PostApproved, PostScheduled}, to: PostPublished,
+		roles: types.RolePublish,
+	},
+	ActionSchedule: {
+		from: []PostStatus{PostApproved, PostScheduled}, to: PostScheduled,
+		roles: types.RolePublish,
+	},
+	ActionUnschedule: {
+		from: []PostStatus{PostScheduled}, to: PostApproved,
+		roles: types.RolePublish,
+	},
+	ActionArchive: {
+		from: []PostStatus{PostPublished}, to: PostArchived,
+		roles: types.RolePublish,
+	},
+}
+
+// Transition is a recorded step of a post at the workflow
+type Transition struct {
+	ID     uint64     `json:"id" db:"id"`
+	PostID uint64     `json:"post_id" db:"post_id"`
+	Action Action     `json:"action" db:"action"`
+	From   PostStatus `json:"from" db:"from_status"`
+	To     PostStatus `json:"to" db:"to_status"`
+	// ActorID is the user that performed the action, 0 for the scheduler
+	ActorID   uint64       `json:"actor_id" db:"actor_id"`
+	Comment   string       `json:"comment" db:"comment"`
+	PublishAt sql.NullTime `json:"publish_at" db:"publish_at"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+}
+
+// ActionRequest is an action that a user performs on a post
+type ActionRequest struct {
+	Action  Action
+	Comment string
+	// PublishAt is the time of ActionSchedule
+	PublishAt time.Time
+}
+
+// IsValid returns true if action is one of the known actions
+func (action Action) IsValid() bool {
+	_, ok := workflow[action]
+	return ok
+}
+
+// allows returns nil if user may perform the rule on post
+func (r rule) allows(post Post, user User) error {
+	if !user.Roles.Has(r.roles) {
+		return ErrActionForbidden
+	}
+	if r.own && post.AuthorID != user.ID && !user.Roles.Has(types.RoleEdit) {
+		return ErrActionForbidden
+	}
+	for _, status := range r.from {
+		if post.Status == status {
+			return nil
+		}
+	}
+	return ErrActionNotAllowed
+}
+
+// AllowedActions returns the actions that user may perform on post
+func AllowedActions(post Post, user User) []Action {
+	actions := make([]Action, 0, len(workflow))
+	for _, action := range []Action{
+		ActionSubmit, ActionWithdraw, ActionComment, ActionApprove,
+		ActionRequestChanges, ActionPublish, ActionSchedule, ActionUnschedule,
+		ActionArchive,
+	} {
+		if workflow[action].allows(post, user) == nil {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// CanEdit returns nil if user may change the content of post. Writers edit
+// their own posts and editors any post, only until they are submitted, or
+// after changes were requested, so the content that is reviewed is the one
+// that is published.
+func CanEdit(post Post, user User) error {
+	if !user.Roles.Has(types.RoleEdit) &&
+		(!user.Roles.Has(types.RoleCreate) || post.AuthorID != user.ID) {
+		return ErrActionForbidden
+	}
+	if !post.Status.editable() {
+		return ErrPostLocked
+	}
+	return nil
+}
+
+// PerformAction moves a post through the workflow on behalf of user, and
+// records the transition
+func PerformAction(ctx context.Context, posts PostRepository, postID uint64, user User, request ActionRequest) (Post, Transition, error) {
+	r, ok := workflow[request.Action]
+	if !ok {
+		return Post{}, Transition{}, ErrUnknownAction
+	}
+	if r.comment && request.Comment == "" {
+		return Post{}, Transition{}, ErrCommentRequired
+	}
+	if request.Action == ActionSchedule && !request.PublishAt.After(time.Now()) {
+		return Post{}, Transition{}, ErrInvalidPublishAt
+	}
+
+	post, err := posts.GetByID(ctx, postID)
+	if err != nil {
+		return Post{}, Transition{}, err
+	}
+	if err := r.allows(post, user); err != nil {
+		return post, Transition{}, err
+	}
+
+	transition := Transition{
+		Action:  request.Action,
+		ActorID: user.ID,
+		Comment: request.Comment,
+	}
+	if request.Action == ActionSchedule {
+		transition.PublishAt = sql.NullTime{Time: request.PublishAt, Valid: true}
+	}
+	err = saveTransition(WithEditor(ctx, user.ID), posts, &post, r, &transition)
+	return post, transition, err
+}
+
+// PublishDue publishes the scheduled posts that their publish time passed
+// until now, and returns how many were published. A post that was changed
+// meanwhile is left to the next run. onPublish, if not nil, is called with
+// every post before and after it was published.
+func PublishDue(ctx context.Context, posts PostRepository, now time.Time,
+	onPublish func(before, after Post)) (int, error) {
+	due, err := posts.List(ctx, PostFilter{Status: PostScheduled, PublishBefore: now})
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, post := range due {
+		before := post
+		// the post appears as published at the time that it was scheduled to
+		post.PublishedAt = post.PublishAt
+		transition := Transition{Action: ActionPublish, Comment: "scheduled"}
+		err := saveTransition(ctx, posts, &post, workflow[ActionPublish], &transition)
+		if err == ErrVersionConflict || err == ErrTransitionChanged {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		published++
+		if onPublish != nil {
+			onPublish(before, post)
+		}
+	}
+	return published, nil
+}
+
+// saveTransition applies the rule to post and stores it with transition. A
+// rule without a status only records the transition, and leaves the post as
+// it is.
+func saveTransition(ctx context.Context, posts PostRepository, post *Post, r rule, transition *Transition) error {
+	transition.PostID = post.ID
+	transition.From = post.Status
+	transition.To = post.Status
+	if r.to == "" {
+		return posts.RecordTransition(ctx, transition)
+	}
+	transition.To = r.to
+
+	updated := *post
+	updated.Status = transition.To
+	updated.PublishAt = transition.PublishAt
+	if transition.To != PostScheduled {
+		updated.PublishAt = sql.NullTime{}
+	}
+
+	if err := posts.SaveTransition(ctx, &updated, transition); err != nil {
+		return err
+	}
+	*post = updated
+	return nil
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/types"
+)
+
+var (
+	workflowWriter    = User{ID: 1, Roles: types.RoleCreate}
+	workflowReviewer  = User{ID: 2, Roles: types.RoleReview}
+	workflowPublisher = User{ID: 3, Roles: types.RolePublish}
+)
+
+func newWorkflowTest(t *testing.T) (*MemoryPostRepository, Post) {
+	posts := NewMemoryPostRepository()
+	post := Post{AuthorID: workflowWriter.ID, Title: "Hello", Body: "Hello"}
+	if err := posts.Create(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+	return posts, post
+}
+
+func TestWorkflowReview(t *testing.T) {
+	posts, post := newWorkflowTest(t)
+	ctx := context.Background()
+
+	steps := []struct {
+		user    User
+		request ActionRequest
+		status  PostStatus
+	}{
+		{workflowWriter, ActionRequest{Action: ActionSubmit}, PostInReview},
+		{workflowReviewer, ActionRequest{Action: ActionRequestChanges, Comment: "too short"}, PostChangesRequested},
+		{workflowWriter, ActionRequest{Action: ActionSubmit}, PostInReview},
+		{workflowReviewer, ActionRequest{Action: ActionComment, Comment: "better"}, PostInReview},
+		{workflowReviewer, ActionRequest{Action: ActionApprove}, PostApproved},
+		{workflowPublisher, ActionRequest{Action: ActionPublish}, PostPublished},
+		{workflowPublisher, ActionRequest{Action: ActionArchive}, PostArchived},
+	}
+	for _, step := range steps {
+		updated, _, err := PerformAction(ctx, posts, post.ID, step.user, step.request)
+		if err != nil {
+			t.Fatalf("Unexpected error of %s: %s", step.request.Action, err)
+		}
+		if updated.Status != step.status {
+			t.Fatalf("Expected %s after %s, got %s", step.status, step.request.Action, updated.Status)
+		}
+	}
+
+	transitions, err := posts.Transitions(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(transitions) != len(steps) {
+		t.Fatalf("Expected %d transitions, got %d", len(steps), len(transitions))
+	}
+	if transitions[1].Comment != "too short" || transitions[1].ActorID != workflowReviewer.ID ||
+		transitions[1].From != PostInReview {
+		t.Errorf("Expected the request for changes of the reviewer, got %+v", transitions[1])
+	}
+}
+
+func TestWorkflowCommentKeepsPost(t *testing.T) {
+	posts, post := newWorkflowTest(t)
+	ctx := context.Background()
+
+	submitted, _, err := PerformAction(ctx, posts, post.ID, workflowWriter, ActionRequest{Action: ActionSubmit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	revisions, _ := posts.Revisions(ctx, post.ID)
+
+	commented, transition, err := PerformAction(ctx, posts, post.ID, workflowReviewer,
+		ActionRequest{Action: ActionComment, Comment: "looks good"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if commented.Version != submitted.Version {
+		t.Errorf("Expected version %d, got %d", submitted.Version, commented.Version)
+	}
+	if transition.ID == 0 || transition.From != PostInReview || transition.To != PostInReview ||
+		transition.Comment != "looks good" {
+		t.Errorf("Expected the comment to be recorded, got %+v", transition)
+	}
+
+	stored, _ := posts.GetByID(ctx, post.ID)
+	if stored.Version != submitted.Version || !stored.UpdatedAt.Equal(submitted.UpdatedAt) {
+		t.Errorf("Expected the post to stay at version %d, got %d", submitted.Version, stored.Version)
+	}
+	if after, _ := posts.Revisions(ctx, post.ID); len(after) != len(revisions) {
+		t.Errorf("Expected %d revisions, got %d", len(revisions), len(after))
+	}
+
+	// an edit that was loaded before the comment is still saved
+	if err := posts.Update(ctx, &submitted); err != nil {
+		t.Errorf("Expected the edit to be saved, got %v", err)
+	}
+}
+
+func TestWorkflowRejectedActions(t *testing.T) {
+	posts, post := newWorkflowTest(t)
+	ctx := context.Background()
+	other := User{ID: 9, Roles: types.RoleCreate}
+
+	tests := []struct {
+		user    User
+		request ActionRequest
+		err     error
+	}{
+		{workflowWriter, ActionRequest{Action: "delete"}, ErrUnknownAction},
+		{other, ActionRequest{Action: ActionSubmit}, ErrActionForbidden},
+		{workflowWriter, ActionRequest{Action: ActionApprove}, ErrActionForbidden},
+		{workflowReviewer, ActionRequest{Action: ActionApprove}, ErrActionNotAllowed},
+		{workflowReviewer, ActionRequest{Action: ActionRequestChanges}, ErrCommentRequired},
+		{workflowPublisher, ActionRequest{Action: ActionPublish}, ErrActionNotAllowed},
+		{workflowPublisher, ActionRequest{Action: ActionSchedule, PublishAt: time.Now().Add(-time.Minute)},
+			ErrInvalidPublishAt},
+	}
+	for _, test := range tests {
+		_, _, err := PerformAction(ctx, posts, post.ID, test.user, test.request)
+		if err != test.err {
+			t.Errorf("Expected %v for %s by %d, got %v", test.err, test.request.Action, test.user.ID, err)
+		}
+	}
+
+	if transitions, _ := posts.Transitions(ctx, post.ID); len(transitions) != 0 {
+		t.Errorf("Expected no transitions, got %+v", transitions)
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	posts, post := newWorkflowTest(t)
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		user    User
+		request ActionRequest
+	}{
+		{workflowWriter, ActionRequest{Action: ActionSubmit}},
+		{workflowReviewer, ActionRequest{Action: ActionApprove}},
+		{workflowPublisher, ActionRequest{Action: ActionSchedule, PublishAt: time.Now().Add(time.Hour)}},
+	} {
+		if _, _, err := PerformAction(ctx, posts, post.ID, step.user, step.request); err != nil {
+			t.Fatalf("Unexpected error of %s: %s", step.request.Action, err)
+		}
+	}
+
+	var publishes []Post
+	onPublish := func(before, after Post) {
+		if before.Status != PostScheduled || after.Status != PostPublished {
+			t.Errorf("Expected a scheduled post to be published, got %s and %s", before.Status, after.Status)
+		}
+		publishes = append(publishes, after)
+	}
+
+	published, err := PublishDue(ctx, posts, time.Now(), onPublish)
+	if err != nil || published != 0 {
+		t.Fatalf("Expected nothing to publish, got %d and %v", published, err)
+	}
+
+	published, err = PublishDue(ctx, posts, time.Now().Add(2*time.Hour), onPublish)
+	if err != nil || published != 1 {
+		t.Fatalf("Expected 1 post to be published, got %d and %v", published, err)
+	}
+	if len(publishes) != 1 || publishes[0].ID != post.ID {
+		t.Errorf("Expected onPublish of post %d, got %+v", post.ID, publishes)
+	}
+
+	post, _ = posts.GetByID(ctx, post.ID)
+	if post.Status != PostPublished || post.PublishAt.Valid {
+		t.Errorf("Expected a published post without publish time, got %+v", post)
+	}
+	if !post.PublishedAt.Valid || post.PublishedAt.Time.Before(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected the post to be published at the scheduled time, got %v", post.PublishedAt)
+	}
+
+	transitions, _ := posts.Transitions(ctx, post.ID)
+	last := transitions[len(transitions)-1]
+	if last.Action != ActionPublish || last.From != PostScheduled || last.ActorID != 0 {
+		t.Errorf("Expected the scheduler to publish the post, got %+v", last)
+	}
+}
+
+func TestCanEdit(t *testing.T) {
+	post := Post{AuthorID: workflowWriter.ID, Status: PostDraft}
+	if err := CanEdit(post, workflowWriter); err != nil {
+		t.Errorf("Expected the author to edit a draft, got %s", err)
+	}
+	if err := CanEdit(post, User{ID: 9, Roles: types.RoleCreate}); err != ErrActionForbidden {
+		t.Errorf("Expected %s, got %v", ErrActionForbidden, err)
+	}
+
+	if err := CanEdit(post, User{ID: 9, Roles: types.RoleEditor}); err != nil {
+		t.Errorf("Expected an editor to edit the draft of another user, got %s", err)
+	}
+
+	for _, status := range []PostStatus{PostInReview, PostApproved, PostScheduled, PostPublished} {
+		post.Status = status
+		if err := CanEdit(post, workflowWriter); err != ErrPostLocked {
+			t.Errorf("Expected %s for the author at %s, got %v", ErrPostLocked, status, err)
+		}
+		if err := CanEdit(post, User{ID: 9, Roles: types.RoleEditor}); err != ErrPostLocked {
+			t.Errorf("Expected %s for an editor at %s, got %v", ErrPostLocked, status, err)
+		}
+	}
+}
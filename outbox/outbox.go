@@ -0,0 +1,95 @@
+/*
+Package outbox is a transactional outbox of domain events, that are stored at
+the outbox table.
+
+An event is written at the same transaction as the change that it describes,
+so it exists exactly when the change is committed:
+
+	err := conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		if _, err := tx.Exec(ctx, `UPDATE posts SET status = 'published' ...`); err != nil {
+			return err
+		}
+		return outbox.Write(ctx, tx, "post.published", key, post)
+	})
+
+A Relay dispatches the committed events to the subscribers of their topic, in
+the order of the transactions that wrote them, and of their writes at the same
+transaction. The delivery is at least once: an event whose delivery failed is
+delivered again, to all of the subscribers of its topic, so subscribers must
+use the Key of the event to ignore duplicates. An event that failed
+RelayOptions.MaxAttempts times is parked, and is not delivered until Unpark.
+
+A long running transaction holds back the events that were committed after it
+started, the relay reports ErrStalled to RelayOptions.OnError once an event
+waited longer than RelayOptions.StallTimeout.
+*/
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ik5/go-into/db"
+)
+
+// Channel is notified when events are committed
+const Channel = "outbox"
+
+const eventColumns = `id, topic, key, payload, attempts, last_error, created_at, dispatched_at,
+	parked_at`
+
+// Event is a row of the outbox table
+type Event struct {
+	ID    uint64 `json:"id" db:"id"`
+	Topic string `json:"topic" db:"topic"`
+	// Key is the idempotency key of the event
+	Key          string          `json:"key" db:"key"`
+	Payload      json.RawMessage `json:"payload" db:"payload"`
+	Attempts     int             `json:"attempts" db:"attempts"`
+	LastError    string          `json:"last_error" db:"last_error"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	DispatchedAt sql.NullTime    `json:"dispatched_at" db:"dispatched_at"`
+	// ParkedAt is set when the event failed too many times
+	ParkedAt sql.NullTime `json:"parked_at" db:"parked_at"`
+}
+
+// Decode decodes the payload of the event into v
+func (event Event) Decode(v interface{}) error {
+	return json.Unmarshal(event.Payload, v)
+}
+
+// Write adds an event of topic to the outbox, payload is encoded as JSON.
+// q should be the transaction of the change that the event describes.
+//
+// An event with a key that was already written is ignored, an empty key is
+// replaced with a random one.
+func Write(ctx context.Context, q db.Querier, topic, key string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		if key, err = randomKey(); err != nil {
+			return err
+		}
+	}
+
+	_, err = q.Exec(ctx,
+		`INSERT INTO outbox (topic, key, payload) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING`,
+		topic, key, string(data),
+	)
+	return err
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
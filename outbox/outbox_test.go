@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/db/dbtest"
+	"github.com/lib/pq"
+)
+
+func newTestRelay(t *testing.T) (*Relay, *dbtest.Mock) {
+	sqlDB, mock := dbtest.New(t)
+	return NewRelay(db.NewFromDB(sqlDB), nil), mock
+}
+
+// expectEvents expects a locked dispatch that claims the events of ids
+func expectEvents(mock *dbtest.Mock, ids ...uint64) {
+	expectFailedEvents(mock, 0, ids...)
+}
+
+// expectFailedEvents expects a claim of the events of ids that failed
+// attempts times
+func expectFailedEvents(mock *dbtest.Mock, attempts int, ids ...uint64) {
+	expectClaim(mock, attempts, ids...)
+	mock.ExpectCommit()
+}
+
+// expectClaim expects the claim of the events of ids until its commit
+func expectClaim(mock *dbtest.Mock, attempts int, ids ...uint64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock($1)").WithArgs(relayLockKey).
+		WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS (SELECT 1 FROM outbox").
+		WillReturnRows(dbtest.NewRows("exists").AddRow(false))
+
+	rows := dbtest.NewRows("id", "topic", "key", "payload", "attempts", "last_error",
+		"created_at", "dispatched_at", "parked_at")
+	for _, id := range ids {
+		rows.AddRow(id, "post.published", "key", []byte(`{"id":1}`), attempts, "", time.Now(), nil, nil)
+	}
+	mock.ExpectQuery("WHERE dispatched_at IS NULL AND parked_at IS NULL " +
+		"AND txid < txid_snapshot_xmin(txid_current_snapshot()) ORDER BY txid, id LIMIT $1").
+		WithArgs(DefaultBatchSize).WillReturnRows(rows)
+	if len(ids) > 0 {
+		mock.ExpectExec("UPDATE outbox SET claimed_until = now()").
+			WithArgs(dbtest.AnyArg, DefaultClaimTimeout.Milliseconds())
+	}
+}
+
+// expectMark expects the mark of a dispatch
+func expectMark(mock *dbtest.Mock) {
+	mock.ExpectExec("SET claimed_until = NULL, dispatched_at = CASE WHEN id = ANY($2) THEN now() END").
+		WithArgs(dbtest.AnyArg, dbtest.AnyArg)
+	mock.ExpectCommit()
+}
+
+func TestWrite(t *testing.T) {
+	sqlDB, mock := dbtest.New(t)
+	conn := db.NewFromDB(sqlDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO outbox (topic, key, payload) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING").
+		WithArgs("post.published", "post.published:1:2", `{"id":1}`)
+	mock.ExpectCommit()
+
+	err := conn.WithTx(context.Background(), nil, func(tx *db.Tx) error {
+		return Write(context.Background(), tx, "post.published", "post.published:1:2", map[string]int{"id": 1})
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDispatchInOrder(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	var delivered []uint64
+	relay.Subscribe("post.published", SubscriberFunc(func(ctx context.Context, event Event) error {
+		if event.ID == 2 {
+			return errors.New("index is down")
+		}
+		delivered = append(delivered, event.ID)
+		return nil
+	}))
+
+	expectEvents(mock, 1, 2, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec("SET attempts = attempts + 1").WithArgs(2, "index is down", false)
+	expectMark(mock)
+
+	dispatched, err := relay.Dispatch(context.Background())
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) || deliveryErr.Event.ID != 2 {
+		t.Errorf("Expected the delivery error of event 2, got %v", err)
+	}
+	if dispatched != 1 || len(delivered) != 1 || delivered[0] != 1 {
+		t.Errorf("Expected only event 1 to be dispatched, got %d and %v", dispatched, delivered)
+	}
+}
+
+func TestDispatchParksAfterMaxAttempts(t *testing.T) {
+	relay, mock := newTestRelay(t)
+	relay.Subscribe("post.published", SubscriberFunc(func(context.Context, Event) error {
+		return errors.New("index is down")
+	}))
+
+	expectFailedEvents(mock, DefaultMaxAttempts-1, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("SET attempts = attempts + 1").WithArgs(1, "index is down", true)
+	expectMark(mock)
+
+	_, err := relay.Dispatch(context.Background())
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) || !deliveryErr.Parked {
+		t.Errorf("Expected the event to be parked, got %v", err)
+	}
+
+	mock.ExpectExec("SET parked_at = NULL, attempts = 0 WHERE id = $1 AND parked_at IS NOT NULL").
+		WithArgs(1).WillReturnResult(dbtest.NewResult(0, 1))
+	if err := relay.Unpark(context.Background(), 1); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	mock.ExpectExec("SET parked_at = NULL").WithArgs(2).WillReturnResult(dbtest.NewResult(0, 0))
+	if err := relay.Unpark(context.Background(), 2); err != ErrNotParked {
+		t.Errorf("Expected ErrNotParked, got %v", err)
+	}
+}
+
+func TestDispatchWithoutSubscribers(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	expectEvents(mock, 1, 2)
+	mock.ExpectBegin()
+	expectMark(mock)
+
+	if dispatched, err := relay.Dispatch(context.Background()); dispatched != 2 || err != nil {
+		t.Errorf("Expected 2 dispatched events, got %d and %v", dispatched, err)
+	}
+}
+
+func TestDispatchLockedByAnotherRelay(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock($1)").
+		WillReturnRows(dbtest.NewRows("locked").AddRow(false))
+	mock.ExpectCommit()
+
+	if dispatched, err := relay.Dispatch(context.Background()); dispatched != 0 || err != nil {
+		t.Errorf("Expected nothing to be dispatched, got %d and %v", dispatched, err)
+	}
+}
+
+func TestDispatchClaimedByAnotherRelay(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock($1)").
+		WillReturnRows(dbtest.NewRows("locked").AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS (SELECT 1 FROM outbox").
+		WillReturnRows(dbtest.NewRows("exists").AddRow(true))
+	mock.ExpectCommit()
+
+	if dispatched, err := relay.Dispatch(context.Background()); dispatched != 0 || err != nil {
+		t.Errorf("Expected nothing to be dispatched, got %d and %v", dispatched, err)
+	}
+}
+
+func TestDispatchRetriedTransactionsDeliverOnce(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	var delivered []uint64
+	relay.Subscribe("post.published", SubscriberFunc(func(ctx context.Context, event Event) error {
+		delivered = append(delivered, event.ID)
+		return nil
+	}))
+
+	serialization := &pq.Error{Code: "40001"}
+	expectClaim(mock, 0, 1, 2)
+	mock.ExpectCommit().WillReturnError(serialization)
+	expectEvents(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("SET claimed_until = NULL").WillReturnError(serialization)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectMark(mock)
+
+	dispatched, err := relay.Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if dispatched != 2 || len(delivered) != 2 || delivered[0] != 1 || delivered[1] != 2 {
+		t.Errorf("Expected events 1 and 2 to be delivered once, got %d and %v", dispatched, delivered)
+	}
+}
+
+func TestDispatchStalled(t *testing.T) {
+	relay, mock := newTestRelay(t)
+
+	expectClaim(mock, 0)
+	mock.ExpectQuery("SELECT COALESCE(min(created_at) < now()").
+		WithArgs(DefaultStallTimeout.Milliseconds()).
+		WillReturnRows(dbtest.NewRows("stalled").AddRow(true))
+	mock.ExpectCommit()
+
+	if _, err := relay.Dispatch(context.Background()); err != ErrStalled {
+		t.Errorf("Expected ErrStalled, got %v", err)
+	}
+}
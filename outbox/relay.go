@@ -0,0 +1,415 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/jobs"
+	"github.com/lib/pq"
+)
+
+// Defaults of a Relay
+const (
+	DefaultBatchSize     = 100
+	DefaultPollInterval  = 5 * time.Second
+	DefaultRetryInterval = 5 * time.Second
+	DefaultMaxAttempts   = 10
+	DefaultClaimTimeout  = 5 * time.Minute
+	DefaultStallTimeout  = 5 * time.Minute
+	// DefaultRetention is how long dispatched events are kept
+	DefaultRetention = 7 * 24 * time.Hour
+	purgeInterval    = time.Hour
+)
+
+// Errors of a Relay
+var (
+	// ErrNotParked is returned by Unpark for an event that does not exist,
+	// or is not parked
+	ErrNotParked = errors.New("the event is not parked")
+	// ErrStalled is returned by Dispatch when no event could be read while
+	// an event waited longer than the StallTimeout, see Dispatch
+	ErrStalled = errors.New("outbox: events wait behind a long running transaction")
+)
+
+// relayLockKey makes a single relay among the instances dispatch at a time,
+// so the events are delivered in order
+var relayLockKey = db.LockKey("go-into/outbox")
+
+// Subscriber receives the events of a topic
+type Subscriber interface {
+	Deliver(ctx context.Context, event Event) error
+}
+
+// SubscriberFunc is a function that implements Subscriber
+type SubscriberFunc func(ctx context.Context, event Event) error
+
+// Deliver implements Subscriber
+func (fn SubscriberFunc) Deliver(ctx context.Context, event Event) error {
+	return fn(ctx, event)
+}
+
+// DeliveryError is the error of a subscriber of an event, the event is
+// delivered again on the next dispatch unless it was parked
+type DeliveryError struct {
+	Event Event
+	Err   error
+	// Parked is true when the event reached the MaxAttempts and was parked
+	Parked bool
+}
+
+func (e *DeliveryError) Error() string {
+	if e.Parked {
+		return fmt.Sprintf("delivery of event %d (%s) failed and it was parked: %s",
+			e.Event.ID, e.Event.Topic, e.Err)
+	}
+	return fmt.Sprintf("delivery of event %d (%s) failed: %s", e.Event.ID, e.Event.Topic, e.Err)
+}
+
+// Unwrap returns the error of the subscriber
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// RelayOptions are the settings of a Relay, nil uses the defaults
+type RelayOptions struct {
+	// BatchSize is the number of events of a dispatch
+	BatchSize int
+	// PollInterval is how often the outbox is checked when notifications
+	// are not available or were lost
+	PollInterval time.Duration
+	// RetryInterval is the wait after a failed delivery
+	RetryInterval time.Duration
+	// MaxAttempts is the number of failed deliveries that park an event
+	MaxAttempts int
+	// ClaimTimeout is how long a batch is claimed while it is delivered. It
+	// must be longer than the delivery of a batch, as the events of an
+	// expired claim are delivered again.
+	ClaimTimeout time.Duration
+	// StallTimeout is how long an event may wait behind a transaction in
+	// progress before Dispatch returns ErrStalled
+	StallTimeout time.Duration
+	Retention    time.Duration
+	// OnError is called with the errors of the relay and the *DeliveryError
+	// of the subscribers
+	OnError func(err error)
+}
+
+// Relay dispatches the events of the outbox to their subscribers
+type Relay struct {
+	conn    *db.Conn
+	options RelayOptions
+
+	mutex       sync.RWMutex
+	subscribers map[string][]Subscriber
+}
+
+// NewRelay creates a Relay over conn
+func NewRelay(conn *db.Conn, opts *RelayOptions) *Relay {
+	options := RelayOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = DefaultRetryInterval
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+	if options.ClaimTimeout <= 0 {
+		options.ClaimTimeout = DefaultClaimTimeout
+	}
+	if options.StallTimeout <= 0 {
+		options.StallTimeout = DefaultStallTimeout
+	}
+	if options.Retention <= 0 {
+		options.Retention = DefaultRetention
+	}
+
+	return &Relay{
+		conn:        conn,
+		options:     options,
+		subscribers: make(map[string][]Subscriber),
+	}
+}
+
+// Subscribe adds a subscriber of topic, it must be called before Run
+func (relay *Relay) Subscribe(topic string, subscriber Subscriber) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+
+	relay.subscribers[topic] = append(relay.subscribers[topic], subscriber)
+}
+
+// Forward enqueues a job of kind with the payload of every event of topic.
+// The key of the event is the unique key of the job, so an event that is
+// delivered again is not queued twice while its job waits.
+func (relay *Relay) Forward(topic string, queue *jobs.Queue, kind string) {
+	relay.Subscribe(topic, SubscriberFunc(func(ctx context.Context, event Event) error {
+		_, err := queue.Enqueue(ctx, kind, event.Payload, &jobs.EnqueueOptions{UniqueKey: event.Key})
+		if err == jobs.ErrDuplicateJob {
+			return nil
+		}
+		return err
+	}))
+}
+
+// Run dispatches events until ctx is done. It wakes up on the notifications
+// of committed events, and polls when they are not available.
+func (relay *Relay) Run(ctx context.Context) error {
+	var wake <-chan db.Notification
+	listener, err := relay.conn.Listen(&db.ListenerOptions{OnError: relay.options.OnError}, Channel)
+	switch {
+	case err == nil:
+		defer listener.Close()
+		wake = listener.Notifications()
+	case err != db.ErrNoDSN:
+		relay.reportError(err)
+	}
+
+	purge := time.NewTicker(purgeInterval)
+	defer purge.Stop()
+
+	for ctx.Err() == nil {
+		dispatched, err := relay.Dispatch(ctx)
+		wait, notified := relay.options.PollInterval, wake
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			relay.reportError(err)
+			// a failed event blocks the ones after it, notifications must
+			// not retry it right away
+			wait, notified = relay.options.RetryInterval, nil
+		} else if dispatched == relay.options.BatchSize {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case _, ok := <-notified:
+			if !ok {
+				wake = nil
+			}
+		case <-purge.C:
+			if _, err := relay.Purge(ctx); err != nil && ctx.Err() == nil {
+				relay.reportError(err)
+			}
+		}
+		timer.Stop()
+	}
+	return nil
+}
+
+// Dispatch delivers a batch of events in order, and returns the number of
+// events that were dispatched. It stops at the first event that failed and
+// returns its *DeliveryError. Nothing is dispatched while the relay of
+// another instance dispatches.
+//
+// The batch is claimed at one transaction and marked at another, and is
+// delivered between them, so a transaction that is executed again never
+// delivers an event twice.
+//
+// Only the events of transactions that are older than every transaction in
+// progress are read, as a transaction in progress may still commit events
+// that are ordered before the ones that are visible. A long running
+// transaction therefore holds back all of the events that were committed
+// after it started, and ErrStalled is returned once an event waited longer
+// than the StallTimeout.
+func (relay *Relay) Dispatch(ctx context.Context) (int, error) {
+	events, err := relay.claim(ctx)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	var (
+		delivered   = make([]int64, 0, len(events))
+		deliveryErr *DeliveryError
+	)
+	for _, event := range events {
+		if failure := relay.deliver(ctx, event); failure != nil {
+			parked := event.Attempts+1 >= relay.options.MaxAttempts
+			deliveryErr = &DeliveryError{Event: event, Err: failure, Parked: parked}
+			break
+		}
+		delivered = append(delivered, int64(event.ID))
+	}
+
+	if err := relay.mark(ctx, events, delivered, deliveryErr); err != nil {
+		return 0, err
+	}
+	if deliveryErr != nil {
+		return len(delivered), deliveryErr
+	}
+	return len(delivered), nil
+}
+
+// claim returns the next batch of events, and claims them for the
+// ClaimTimeout. No events are returned while another relay holds the lock
+// or a claim.
+func (relay *Relay) claim(ctx context.Context) ([]Event, error) {
+	var (
+		events  []Event
+		stalled bool
+	)
+	err := relay.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		events, stalled = nil, false
+
+		var locked bool
+		if err := tx.Get(ctx, &locked, `SELECT pg_try_advisory_xact_lock($1)`, relayLockKey); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var claimed bool
+		err := tx.Get(ctx, &claimed,
+			`SELECT EXISTS (SELECT 1 FROM outbox
+			WHERE dispatched_at IS NULL AND parked_at IS NULL AND claimed_until > now())`,
+		)
+		if err != nil || claimed {
+			return err
+		}
+
+		list := make([]Event, 0)
+		err = tx.Select(ctx, &list,
+			`SELECT `+eventColumns+` FROM outbox
+			WHERE dispatched_at IS NULL AND parked_at IS NULL
+				AND txid < txid_snapshot_xmin(txid_current_snapshot())
+			ORDER BY txid, id LIMIT $1`,
+			relay.options.BatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return tx.Get(ctx, &stalled,
+				`SELECT COALESCE(min(created_at) < now() - $1::float8 * interval '1 millisecond', false)
+				FROM outbox WHERE dispatched_at IS NULL AND parked_at IS NULL`,
+				relay.options.StallTimeout.Milliseconds(),
+			)
+		}
+
+		ids := make([]int64, 0, len(list))
+		for _, event := range list {
+			ids = append(ids, int64(event.ID))
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE outbox SET claimed_until = now() + $2::float8 * interval '1 millisecond'
+			WHERE id = ANY($1)`,
+			pq.Array(ids), relay.options.ClaimTimeout.Milliseconds(),
+		)
+		events = list
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stalled {
+		return nil, ErrStalled
+	}
+	return events, nil
+}
+
+// mark releases the claim of events, and records the delivered ones and
+// the failure of the delivery
+func (relay *Relay) mark(ctx context.Context, events []Event, delivered []int64, failure *DeliveryError) error {
+	claimed := make([]int64, 0, len(events))
+	for _, event := range events {
+		claimed = append(claimed, int64(event.ID))
+	}
+
+	return relay.conn.WithTx(ctx, nil, func(tx *db.Tx) error {
+		if failure != nil {
+			_, err := tx.Exec(ctx,
+				`UPDATE outbox SET attempts = attempts + 1, last_error = $2,
+					parked_at = CASE WHEN $3 THEN now() END
+				WHERE id = $1`,
+				failure.Event.ID, failure.Err.Error(), failure.Parked,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(ctx,
+			`UPDATE outbox SET claimed_until = NULL,
+				dispatched_at = CASE WHEN id = ANY($2) THEN now() END
+			WHERE id = ANY($1)`,
+			pq.Array(claimed), pq.Array(delivered),
+		)
+		return err
+	})
+}
+
+// Unpark delivers a parked event again on the next dispatch, with its
+// attempts reset. It is delivered out of order, after the events that were
+// dispatched while it was parked.
+func (relay *Relay) Unpark(ctx context.Context, id uint64) error {
+	result, err := relay.conn.Exec(ctx,
+		`UPDATE outbox SET parked_at = NULL, attempts = 0 WHERE id = $1 AND parked_at IS NOT NULL`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotParked
+	}
+	return nil
+}
+
+// deliver hands event to the subscribers of its topic, a panic fails the
+// delivery
+func (relay *Relay) deliver(ctx context.Context, event Event) (err error) {
+	relay.mutex.RLock()
+	subscribers := relay.subscribers[event.Topic]
+	relay.mutex.RUnlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("subscriber panicked: %v", p)
+		}
+	}()
+	for _, subscriber := range subscribers {
+		if err := subscriber.Deliver(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge deletes the events that were dispatched before the retention, and
+// returns their number
+func (relay *Relay) Purge(ctx context.Context) (int64, error) {
+	result, err := relay.conn.Exec(ctx,
+		`DELETE FROM outbox WHERE dispatched_at < now() - $1::float8 * interval '1 millisecond'`,
+		relay.options.Retention.Milliseconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (relay *Relay) reportError(err error) {
+	if relay.options.OnError != nil {
+		relay.options.OnError(err)
+	}
+}
@@ -0,0 +1,82 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for a cursor that is malformed, was not
+// signed by the codec, or was created for another list
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Codec encodes cursors into opaque strings that are signed with HMAC-SHA256
+type Codec struct {
+	key []byte
+}
+
+// signedCursor is the payload of an encoded cursor, List binds it to the list
+// that it was created for, as the key of a list is meaningless at another
+type signedCursor struct {
+	List string `json:"l"`
+	Cursor
+}
+
+// NewCodec creates a Codec that signs with key. All instances that serve the
+// same lists must share the key.
+func NewCodec(key []byte) *Codec {
+	return &Codec{key: key}
+}
+
+// NewRandomCodec creates a Codec with a random key, its cursors are valid only
+// at this process
+func NewRandomCodec() *Codec {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return NewCodec(key)
+}
+
+// Encode returns the signed string of cursor of list
+func (codec *Codec) Encode(list string, cursor Cursor) string {
+	// a struct of strings and numbers is always encoded
+	data, _ := json.Marshal(signedCursor{List: list, Cursor: cursor})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(codec.sign(payload))
+}
+
+// Decode returns the cursor of s, or ErrInvalidCursor if s was not encoded
+// for list
+func (codec *Codec) Decode(list, s string) (Cursor, error) {
+	var cursor signedCursor
+
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	payload := s[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(s[i+1:])
+	if err != nil || !hmac.Equal(signature, codec.sign(payload)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.List != list {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cursor.Cursor, nil
+}
+
+func (codec *Codec) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, codec.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,169 @@
+/*
+Package pagination implements keyset (cursor) pagination.
+
+A list is ordered by a sort key and the id, that breaks ties between rows of
+the same key. A page starts right after the row of its cursor, so there is no
+OFFSET to scan and skip, and rows that are added meanwhile do not shift the
+pages:
+
+	WHERE (created_at, id) < ($1, $2) ORDER BY created_at DESC, id DESC
+
+Cursors are handed to the clients as opaque strings that are signed by a
+Codec, so a client can not forge the position of a page.
+*/
+package pagination
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Limits of a page
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Cursor is the position of a page at a list
+type Cursor struct {
+	// Key is the sort key of the row that the page starts after, see TimeKey
+	Key string `json:"k"`
+	ID  uint64 `json:"i"`
+	// Backward marks a cursor of the previous page, that lists the rows
+	// before Key and ID
+	Backward bool `json:"b,omitempty"`
+}
+
+// TimeKey returns the key of a list that is sorted by t
+func TimeKey(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Time returns the key of a list that is sorted by time
+func (cursor Cursor) Time() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, cursor.Key)
+	if err != nil {
+		return t, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// Keyset describes the order of a list
+type Keyset struct {
+	// KeyColumn is the sort key expression, for example "created_at"
+	KeyColumn string
+	// IDColumn breaks ties between rows of the same key, for example "id"
+	IDColumn   string
+	Descending bool
+}
+
+// descending returns the order of the rows in the direction of cursor
+func (keyset Keyset) descending(cursor *Cursor) bool {
+	if cursor != nil && cursor.Backward {
+		return !keyset.Descending
+	}
+	return keyset.Descending
+}
+
+// Where returns the condition of the rows that follow cursor in its
+// direction, with its arguments. The placeholders are numbered from next.
+func (keyset Keyset) Where(cursor Cursor, next int) (string, []interface{}) {
+	operator := ">"
+	if keyset.descending(&cursor) {
+		operator = "<"
+	}
+	clause := fmt.Sprintf("(%s, %s) %s ($%d, $%d)",
+		keyset.KeyColumn, keyset.IDColumn, operator, next, next+1)
+	return clause, []interface{}{cursor.Key, cursor.ID}
+}
+
+// OrderBy returns the ORDER BY expression of the rows in the direction of
+// cursor, nil is the order of the first page
+func (keyset Keyset) OrderBy(cursor *Cursor) string {
+	direction := "ASC"
+	if keyset.descending(cursor) {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("%s %s, %s %s", keyset.KeyColumn, direction, keyset.IDColumn, direction)
+}
+
+// Follows returns true if a row with the key and id comes after cursor in its
+// direction, for lists that are filtered at memory. compare returns the
+// order of key against the key of cursor, as strings.Compare does.
+func (keyset Keyset) Follows(cursor Cursor, compare int, id uint64) bool {
+	if compare == 0 {
+		switch {
+		case id > cursor.ID:
+			compare = 1
+		case id < cursor.ID:
+			compare = -1
+		}
+	}
+	if keyset.descending(&cursor) {
+		return compare < 0
+	}
+	return compare > 0
+}
+
+// Reverse reverses a slice in place. A backward page is fetched in reverse
+// order, and is reversed back to the order of the list.
+func Reverse(slice interface{}) {
+	swap := reflect.Swapper(slice)
+	n := reflect.ValueOf(slice).Len()
+	for i := 0; i < n/2; i++ {
+		swap(i, n-1-i)
+	}
+}
+
+// Page is a request for a page of a list
+type Page struct {
+	Limit int
+	// Cursor is nil for the first page
+	Cursor *Cursor
+}
+
+// Fetch returns the number of rows to fetch for the page, one row more than
+// the limit tells whether there is a page after it
+func (page Page) Fetch() int {
+	return page.Limit + 1
+}
+
+// Window trims the n rows that were fetched for the page, at the order of
+// the list. It returns the range of the rows to return, and the cursors of
+// the next and previous pages, nil when there is no such page. cursorAt
+// returns the cursor of a row.
+func (page Page) Window(n int, cursorAt func(i int) Cursor) (start, end int, next, prev *Cursor) {
+	start, end = 0, n
+	more := n > page.Limit
+	backward := page.Cursor != nil && page.Cursor.Backward
+
+	if more {
+		if backward {
+			// the extra row is before the page
+			start = n - page.Limit
+		} else {
+			end = page.Limit
+		}
+	}
+	if start == end {
+		return start, end, nil, nil
+	}
+
+	// a page that was reached by a cursor has a page on the side it came
+	// from
+	hasNext := (more && !backward) || (page.Cursor != nil && backward)
+	hasPrev := (more && backward) || (page.Cursor != nil && !backward)
+
+	if hasNext {
+		cursor := cursorAt(end - 1)
+		cursor.Backward = false
+		next = &cursor
+	}
+	if hasPrev {
+		cursor := cursorAt(start)
+		cursor.Backward = true
+		prev = &cursor
+	}
+	return start, end, next, prev
+}
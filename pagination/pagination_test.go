@@ -0,0 +1,133 @@
+package pagination
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeysetWhere(t *testing.T) {
+	keyset := Keyset{KeyColumn: "created_at", IDColumn: "id", Descending: true}
+
+	clause, args := keyset.Where(Cursor{Key: "k", ID: 7}, 3)
+	if clause != "(created_at, id) < ($3, $4)" {
+		t.Errorf("Expected a less than clause, got %q", clause)
+	}
+	if len(args) != 2 || args[0] != "k" || args[1] != uint64(7) {
+		t.Errorf("Expected the key and id arguments, got %v", args)
+	}
+
+	clause, _ = keyset.Where(Cursor{Key: "k", ID: 7, Backward: true}, 1)
+	if clause != "(created_at, id) > ($1, $2)" {
+		t.Errorf("Expected a greater than clause backward, got %q", clause)
+	}
+}
+
+func TestKeysetOrderBy(t *testing.T) {
+	keyset := Keyset{KeyColumn: "lower(username)", IDColumn: "id"}
+
+	if order := keyset.OrderBy(nil); order != "lower(username) ASC, id ASC" {
+		t.Errorf("Expected ascending order, got %q", order)
+	}
+	if order := keyset.OrderBy(&Cursor{Backward: true}); order != "lower(username) DESC, id DESC" {
+		t.Errorf("Expected descending order backward, got %q", order)
+	}
+}
+
+func TestKeysetFollows(t *testing.T) {
+	keyset := Keyset{Descending: true}
+	cursor := Cursor{ID: 5}
+
+	tests := []struct {
+		compare int
+		id      uint64
+		follows bool
+	}{
+		{-1, 9, true},
+		{1, 1, false},
+		{0, 4, true},
+		{0, 5, false},
+		{0, 6, false},
+	}
+	for _, test := range tests {
+		if follows := keyset.Follows(cursor, test.compare, test.id); follows != test.follows {
+			t.Errorf("Expected %t for compare %d and id %d, got %t", test.follows, test.compare, test.id, follows)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	list := []int{1, 2, 3, 4, 5}
+	Reverse(list)
+	for i, want := range []int{5, 4, 3, 2, 1} {
+		if list[i] != want {
+			t.Fatalf("Expected %v, got %v", []int{5, 4, 3, 2, 1}, list)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	cursorAt := func(i int) Cursor { return Cursor{ID: uint64(i)} }
+
+	tests := []struct {
+		name       string
+		page       Page
+		n          int
+		start, end int
+		next, prev bool
+	}{
+		{"first page with more", Page{Limit: 2}, 3, 0, 2, true, false},
+		{"only page", Page{Limit: 2}, 2, 0, 2, false, false},
+		{"middle page", Page{Limit: 2, Cursor: &Cursor{}}, 3, 0, 2, true, true},
+		{"last page", Page{Limit: 2, Cursor: &Cursor{}}, 1, 0, 1, false, true},
+		{"backward with more", Page{Limit: 2, Cursor: &Cursor{Backward: true}}, 3, 1, 3, true, true},
+		{"backward to first", Page{Limit: 2, Cursor: &Cursor{Backward: true}}, 2, 0, 2, true, false},
+		{"empty", Page{Limit: 2}, 0, 0, 0, false, false},
+	}
+
+	for _, test := range tests {
+		start, end, next, prev := test.page.Window(test.n, cursorAt)
+		if start != test.start || end != test.end {
+			t.Errorf("%s: expected range %d-%d, got %d-%d", test.name, test.start, test.end, start, end)
+		}
+		if (next != nil) != test.next || (prev != nil) != test.prev {
+			t.Errorf("%s: expected next %t and prev %t, got %v and %v", test.name, test.next, test.prev, next, prev)
+		}
+		if next != nil && (next.Backward || next.ID != uint64(end-1)) {
+			t.Errorf("%s: expected a forward next cursor at %d, got %+v", test.name, end-1, next)
+		}
+		if prev != nil && (!prev.Backward || prev.ID != uint64(start)) {
+			t.Errorf("%s: expected a backward prev cursor at %d, got %+v", test.name, start, prev)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	cursor := Cursor{Key: TimeKey(created), ID: 42, Backward: true}
+
+	encoded := codec.Encode("posts", cursor)
+	decoded, err := codec.Decode("posts", encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != cursor {
+		t.Errorf("Expected %+v, got %+v", cursor, decoded)
+	}
+	if key, err := decoded.Time(); err != nil || !key.Equal(created) {
+		t.Errorf("Expected time %s, got %s (%v)", created, key, err)
+	}
+
+	for _, s := range []string{
+		"",
+		"garbage",
+		encoded[:strings.IndexByte(encoded, '.')] + ".AAAA",
+		NewCodec([]byte("other")).Encode("posts", cursor),
+		codec.Encode("comments", cursor),
+	} {
+		if _, err := codec.Decode("posts", s); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", s, err)
+		}
+	}
+}
@@ -5,4 +5,402 @@ package rest
 	of authentication will be used before routing.
 */
 
-// TODO: create admin routing API
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/crypto"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/types"
+)
+
+// AdminPrefix is the path that all admin routes are placed under
+const AdminPrefix = "/admin/"
+
+// UserStore is the persistence layer that the admin user management requires
+type UserStore interface {
+	GetByID(ctx context.Context, id uint64) (models.User, error)
+	GetByUsername(ctx context.Context, username string) (models.User, error)
+	List(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	Update(ctx context.Context, user *models.User) error
+	SoftDelete(ctx context.Context, id uint64) error
+	Restore(ctx context.Context, id uint64) error
+}
+
+// RegisterAdminRoute write a new admin route with it's handler. The route is
+// a pattern relative to AdminPrefix, and only users that hold all of the given
+// roles are allowed to reach the handler.
+func (rest *REST) RegisterAdminRoute(route, method string, roles types.Role, handler http.HandlerFunc) {
+	defer rest.rwRouter.Unlock()
+	rest.rwRouter.Lock()
+
+	rest.adminRoutes = append(rest.adminRoutes, patternRoute{
+		pattern: parsePattern(AdminPrefix + strings.TrimPrefix(route, "/")),
+		method:  method,
+		roles:   roles,
+		handler: handler,
+	})
+}
+
+// SetAdminRouting places all registered admin routes behind the
+// authentication middleware
+func (rest *REST) SetAdminRouting(lookup middleware.UserLookup) {
+	defer rest.rwRouter.RUnlock()
+	rest.rwRouter.RLock()
+
+	routes := make([]patternRoute, len(rest.adminRoutes))
+	copy(routes, rest.adminRoutes)
+
+	dispatch := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePatternRoutes(routes, w, editorRequest(r), middleware.RequireRoles)
+	})
+	rest.mux.Handle(AdminPrefix, middleware.Authenticate("admin", lookup, rest.auditor.login,
+		middleware.RequirePasswordChange(dispatch)))
+}
+
+// RegisterAdminUserRoutes registers the user management API
+func (rest *REST) RegisterAdminUserRoutes(store UserStore) {
+	users := adminUsers{store: store, pager: rest.pager, auditor: rest.auditor}
+
+	rest.RegisterAdminRoute("/users", "GET", types.RoleManageUser, users.list)
+	rest.RegisterAdminRoute("/users", "POST", types.RoleCreateUser, users.create)
+	rest.RegisterAdminRoute("/users/:id", "GET", types.RoleManageUser, users.get)
+	rest.RegisterAdminRoute("/users/:id", "DELETE", types.RoleDeleteUser,
+		users.byID(audit.ActionUserDelete, store.SoftDelete))
+	rest.RegisterAdminRoute("/users/:id/restore", "POST", types.RoleDeleteUser,
+		users.byID(audit.ActionUserRestore, store.Restore))
+	rest.RegisterAdminRoute("/users/:id/enable", "POST", types.RoleDisableUser, users.setEnabled(true))
+	rest.RegisterAdminRoute("/users/:id/disable", "POST", types.RoleDisableUser, users.setEnabled(false))
+	rest.RegisterAdminRoute("/users/:id/roles", "PUT", types.RoleManageUser, users.setRoles)
+	rest.RegisterAdminRoute("/users/:id/password-reset", "POST", types.RoleManageUser, users.resetPassword)
+	rest.RegisterAdminRoute(strings.TrimPrefix(middleware.PasswordChangePath, AdminPrefix), "PUT", 0,
+		users.changePassword)
+}
+
+type adminUsers struct {
+	store   UserStore
+	pager   *pager
+	auditor *auditor
+}
+
+type createUserRequest struct {
+	Username string     `json:"username"`
+	Password string     `json:"password"`
+	Email    string     `json:"email"`
+	Name     string     `json:"name"`
+	Roles    types.Role `json:"roles"`
+}
+
+type setRolesRequest struct {
+	Roles types.Role `json:"roles"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type passwordResetResponse struct {
+	User              models.User `json:"user"`
+	TemporaryPassword string      `json:"temporary_password"`
+}
+
+func (users adminUsers) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := userFilterFromQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := users.pager.page(r, usersList)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit, filter.Cursor = page.Fetch(), page.Cursor
+
+	list, err := users.store.List(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	start, end, next, prev := page.Window(len(list), func(i int) pagination.Cursor {
+		return list[i].Cursor()
+	})
+	users.pager.write(w, r, usersList, list[start:end], next, prev)
+}
+
+func userFilterFromQuery(r *http.Request) (models.UserFilter, error) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Search: query.Get("q"),
+	}
+
+	for name, field := range map[string]**bool{
+		"enabled": &filter.Enabled,
+		"deleted": &filter.Deleted,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, err
+		}
+		*field = &b
+	}
+
+	if value := query.Get("roles"); value != "" {
+		if err := filter.Roles.Scan(value); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+func (users adminUsers) create(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "username, email and password are required")
+		return
+	}
+	if !grantable(r, req.Roles) {
+		writeError(w, http.StatusForbidden, "roles exceed the roles of the current user")
+		return
+	}
+
+	password, err := crypto.GenPassword(crypto.SCrypt, req.Password, crypto.GenSalt(0))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	user := models.User{
+		Roles:    req.Roles,
+		Username: req.Username,
+		Password: password,
+		Email:    req.Email,
+		Enabled:  true,
+	}
+	if req.Name != "" {
+		user.Name.String, user.Name.Valid = req.Name, true
+	}
+
+	if err := users.store.Create(r.Context(), &user); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	users.auditor.record(r, targetEntry(audit.ActionUserCreate, audit.TargetUser, user.ID, nil, user))
+	writeJSON(w, http.StatusCreated, user)
+}
+
+func (users adminUsers) get(w http.ResponseWriter, r *http.Request) {
+	user, ok := users.load(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (users adminUsers) setEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := users.load(w, r)
+		if !ok {
+			return
+		}
+		if !grantable(r, user.Roles) {
+			writeError(w, http.StatusForbidden, targetForbidden)
+			return
+		}
+
+		action := audit.ActionUserDisable
+		if enabled {
+			action = audit.ActionUserEnable
+		}
+		before := user
+		user.Enabled = enabled
+		users.update(w, r, action, before, &user)
+	}
+}
+
+func (users adminUsers) setRoles(w http.ResponseWriter, r *http.Request) {
+	var req setRolesRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !grantable(r, req.Roles) {
+		writeError(w, http.StatusForbidden, "roles exceed the roles of the current user")
+		return
+	}
+
+	user, ok := users.load(w, r)
+	if !ok {
+		return
+	}
+	if actor, _ := middleware.CurrentUser(r.Context()); actor.ID == user.ID {
+		writeError(w, http.StatusForbidden, "users may not change their own roles")
+		return
+	}
+	if !grantable(r, user.Roles) {
+		writeError(w, http.StatusForbidden, targetForbidden)
+		return
+	}
+
+	before := user
+	user.Roles = req.Roles
+	users.update(w, r, audit.ActionRolesChange, before, &user)
+}
+
+// targetForbidden is the error of an action on a user that holds roles the
+// current user does not hold
+const targetForbidden = "user holds roles that the current user does not hold"
+
+// grantable returns true if the current user holds all of roles, so no user
+// may grant roles that it does not hold, nor act on a user that holds them.
+// RoleNone marks a user without roles, and is always allowed.
+func grantable(r *http.Request, roles types.Role) bool {
+	actor, ok := middleware.CurrentUser(r.Context())
+	return ok && actor.Roles.Has(roles&^types.RoleNone)
+}
+
+// resetPassword replaces the password of a user with a temporary one that is
+// returned only once, and forces the user to choose a new password
+func (users adminUsers) resetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := users.load(w, r)
+	if !ok {
+		return
+	}
+	if !grantable(r, user.Roles) {
+		writeError(w, http.StatusForbidden, targetForbidden)
+		return
+	}
+	before := user
+
+	temporary := hex.EncodeToString(crypto.GenSalt(12))
+	password, err := crypto.GenPassword(crypto.SCrypt, temporary, crypto.GenSalt(0))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	user.Password = password
+	user.MustResetPassword = true
+	if err := users.store.Update(r.Context(), &user); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	users.auditor.record(r, targetEntry(audit.ActionPasswordReset, audit.TargetUser, user.ID, before, user))
+
+	writeJSON(w, http.StatusOK, passwordResetResponse{
+		User:              user,
+		TemporaryPassword: temporary,
+	})
+}
+
+// changePassword replaces the password of the current user, and is the only
+// route that a user that must reset its password may reach
+func (users adminUsers) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "new password is required")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		writeError(w, http.StatusBadRequest, "new password must differ from the current one")
+		return
+	}
+
+	actor, _ := middleware.CurrentUser(r.Context())
+	user, err := users.store.GetByID(r.Context(), actor.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if valid, err := crypto.IsValidPassword(req.CurrentPassword, user.Password); err != nil || !valid {
+		writeError(w, http.StatusForbidden, "current password is invalid")
+		return
+	}
+	before := user
+
+	password, err := crypto.GenPassword(crypto.SCrypt, req.NewPassword, crypto.GenSalt(0))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	user.Password = password
+	user.MustResetPassword = false
+	users.update(w, r, audit.ActionPasswordChange, before, &user)
+}
+
+// load returns the user of the id path parameter, or writes the error and
+// returns false
+func (users adminUsers) load(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, err := strconv.ParseUint(Param(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return models.User{}, false
+	}
+
+	user, err := users.store.GetByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// update stores a user that action changed from before
+func (users adminUsers) update(w http.ResponseWriter, r *http.Request, action string, before models.User, user *models.User) {
+	if err := users.store.Update(r.Context(), user); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	users.auditor.record(r, targetEntry(action, audit.TargetUser, user.ID, before, *user))
+	writeJSON(w, http.StatusOK, user)
+}
+
+// byID returns a handler that executes fn on the id path parameter, records
+// it as action and returns the user after it
+func (users adminUsers) byID(action string, fn func(context.Context, uint64) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		before, ok := users.load(w, r)
+		if !ok {
+			return
+		}
+		if !grantable(r, before.Roles) {
+			writeError(w, http.StatusForbidden, targetForbidden)
+			return
+		}
+
+		if err := fn(r.Context(), before.ID); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		user, ok := users.load(w, r)
+		if !ok {
+			return
+		}
+		users.auditor.record(r, targetEntry(action, audit.TargetUser, user.ID, before, user))
+		writeJSON(w, http.StatusOK, user)
+	}
+}
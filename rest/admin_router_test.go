@@ -0,0 +1,291 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/crypto"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/types"
+)
+
+const adminPassword = "secret"
+
+// newTestUserStore returns a store with a root and an editor users, both using
+// adminPassword
+func newTestUserStore(t *testing.T) *models.MemoryUserRepository {
+	password, err := crypto.GenPassword(crypto.PBKDF2, adminPassword, crypto.GenSalt(0))
+	if err != nil {
+		t.Fatalf("Unable to generate password: %s", err)
+	}
+
+	store := models.NewMemoryUserRepository()
+	for _, user := range []models.User{
+		{Username: "root", Email: "root@example.com", Password: password, Roles: types.RoleRoot, Enabled: true},
+		{Username: "editor", Email: "editor@example.com", Password: password, Roles: types.RoleEditor, Enabled: true},
+	} {
+		if err := store.Create(context.Background(), &user); err != nil {
+			t.Fatalf("Unable to create user: %s", err)
+		}
+	}
+	return store
+}
+
+func newAdminTest(t *testing.T) (*REST, *models.MemoryUserRepository) {
+	store := newTestUserStore(t)
+
+	rest := InitREST("", 0)
+	rest.RegisterAdminUserRoutes(store)
+	rest.SetAdminRouting(store.GetByUsername)
+	return rest, store
+}
+
+func adminRequest(rest *REST, username, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if username != "" {
+		r.SetBasicAuth(username, adminPassword)
+	}
+	w := httptest.NewRecorder()
+	rest.mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestRoutePatternMatch(t *testing.T) {
+	pattern := parsePattern("/admin/users/:id/roles")
+
+	params, ok := pattern.match("/admin/users/12/roles")
+	if !ok {
+		t.Fatal("Expected pattern to match")
+	}
+	if params["id"] != "12" {
+		t.Errorf("Expected id of 12, got %q", params["id"])
+	}
+
+	for _, path := range []string{"/admin/users/12", "/admin/users//roles", "/admin/posts/12/roles"} {
+		if _, ok := pattern.match(path); ok {
+			t.Errorf("Expected %s not to match", path)
+		}
+	}
+}
+
+func TestAdminRequiresAuthentication(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "", "GET", "/admin/users", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAdminRequiresRoles(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "editor", "GET", "/admin/users", "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "GET", "/admin/users?q=edit", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var response struct {
+		Data []models.User `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	users := response.Data
+	if len(users) != 1 || users[0].Username != "editor" {
+		t.Errorf("Expected only editor, got %+v", users)
+	}
+	if strings.Contains(w.Body.String(), `"password"`) {
+		t.Error("Password must not be exposed")
+	}
+}
+
+func TestAdminDisableAndDeleteUser(t *testing.T) {
+	rest, store := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "POST", "/admin/users/2/disable", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if user, _ := store.GetByID(context.Background(), 2); user.Enabled {
+		t.Error("Expected user to be disabled")
+	}
+
+	w = adminRequest(rest, "root", "DELETE", "/admin/users/2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if user, _ := store.GetByID(context.Background(), 2); !user.Deleted {
+		t.Error("Expected user to be deleted")
+	}
+}
+
+func TestAdminSetRoles(t *testing.T) {
+	rest, store := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "PUT", "/admin/users/2/roles", `{"roles": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if user, _ := store.GetByID(context.Background(), 2); user.Roles != types.RoleCreate {
+		t.Errorf("Expected roles of %d, got %d", types.RoleCreate, user.Roles)
+	}
+}
+
+func TestAdminRolesEscalation(t *testing.T) {
+	rest, store := newAdminTest(t)
+
+	root, _ := store.GetByUsername(context.Background(), "root")
+	manager := models.User{Username: "manager", Email: "manager@example.com", Password: root.Password,
+		Roles: types.RoleCreateUser | types.RoleManageUser | types.RoleEditor, Enabled: true}
+	if err := store.Create(context.Background(), &manager); err != nil {
+		t.Fatalf("Unable to create user: %s", err)
+	}
+
+	tests := []struct {
+		method, path, body string
+		expected           int
+	}{
+		{"PUT", "/admin/users/2/roles", `{"roles": 1023}`, http.StatusForbidden},
+		{"PUT", "/admin/users/3/roles", `{"roles": 128}`, http.StatusForbidden},
+		{"POST", "/admin/users", `{"username": "a", "email": "a@example.com", "password": "p", "roles": 1022}`, http.StatusForbidden},
+		{"POST", "/admin/users", `{"username": "b", "email": "b@example.com", "password": "p", "roles": 4}`, http.StatusCreated},
+		{"PUT", "/admin/users/2/roles", `{"roles": 4}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		w := adminRequest(rest, "manager", test.method, test.path, test.body)
+		if w.Code != test.expected {
+			t.Errorf("Expected %s %s %s to return %d, got %d: %s",
+				test.method, test.path, test.body, test.expected, w.Code, w.Body)
+		}
+	}
+
+	if user, _ := store.GetByID(context.Background(), manager.ID); user.Roles != manager.Roles {
+		t.Errorf("Expected the roles of manager to stay %d, got %d", manager.Roles, user.Roles)
+	}
+	w := adminRequest(rest, "root", "PUT", "/admin/users/1/roles", `{"roles": 2}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected root not to change its own roles, got %d", w.Code)
+	}
+}
+
+func TestAdminTargetRoles(t *testing.T) {
+	rest, store := newAdminTest(t)
+
+	root, _ := store.GetByUsername(context.Background(), "root")
+	admin := models.User{Username: "admin", Email: "admin@example.com", Password: root.Password,
+		Roles: types.RoleManageUser | types.RoleDisableUser | types.RoleDeleteUser | types.RoleEditor, Enabled: true}
+	if err := store.Create(context.Background(), &admin); err != nil {
+		t.Fatalf("Unable to create user: %s", err)
+	}
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{"POST", "/admin/users/1/password-reset", ""},
+		{"PUT", "/admin/users/1/roles", `{"roles": 4}`},
+		{"POST", "/admin/users/1/disable", ""},
+		{"POST", "/admin/users/1/enable", ""},
+		{"DELETE", "/admin/users/1", ""},
+		{"POST", "/admin/users/1/restore", ""},
+	}
+	for _, test := range tests {
+		w := adminRequest(rest, "admin", test.method, test.path, test.body)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected %s %s to return %d, got %d: %s",
+				test.method, test.path, http.StatusForbidden, w.Code, w.Body)
+		}
+	}
+
+	user, _ := store.GetByID(context.Background(), 1)
+	if user.Password != root.Password || user.Roles != root.Roles || !user.Enabled || user.Deleted {
+		t.Errorf("Expected root to stay unchanged, got %+v", user)
+	}
+
+	if w := adminRequest(rest, "admin", "POST", "/admin/users/2/disable", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected %d for a target with fewer roles, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
+
+func TestAdminForcedPasswordChange(t *testing.T) {
+	rest, store := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "POST", "/admin/users/1/password-reset", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var reset passwordResetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &reset); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+
+	request := func(password, method, path, body string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("root", password)
+		w := httptest.NewRecorder()
+		rest.mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request(reset.TemporaryPassword, "GET", "/admin/users", ""); code != http.StatusForbidden {
+		t.Errorf("Expected %d before the password is changed, got %d", http.StatusForbidden, code)
+	}
+	code := request(reset.TemporaryPassword, "PUT", "/admin/password",
+		`{"current_password": "wrong", "new_password": "changed"}`)
+	if code != http.StatusForbidden {
+		t.Errorf("Expected %d for a wrong current password, got %d", http.StatusForbidden, code)
+	}
+	code = request(reset.TemporaryPassword, "PUT", "/admin/password",
+		`{"current_password": "`+reset.TemporaryPassword+`", "new_password": "changed"}`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, code)
+	}
+
+	if user, _ := store.GetByID(context.Background(), 1); user.MustResetPassword {
+		t.Error("Expected the reset to be done")
+	}
+	if code := request("changed", "GET", "/admin/users", ""); code != http.StatusOK {
+		t.Errorf("Expected %d after the password was changed, got %d", http.StatusOK, code)
+	}
+}
+
+func TestAdminUnknownUser(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "GET", "/admin/users/42", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestAdminWrongMethod(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "PATCH", "/admin/users/2", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestAdminCreateDuplicateUser(t *testing.T) {
+	rest, _ := newAdminTest(t)
+
+	w := adminRequest(rest, "root", "POST", "/admin/users",
+		`{"username": "Editor", "email": "other@example.com", "password": "pass"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+}
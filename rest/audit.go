@@ -0,0 +1,267 @@
+package rest
+
+/*
+	Audit of the administrative and security actions of the API, and the
+	admin routes that query the audit log.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/types"
+)
+
+// Basic authentication logs in on every request, recording all of the logins
+// would flood the log, so a login of a user from the same IP is recorded once
+// per interval
+const (
+	// loginAuditInterval is how often a successful login is recorded
+	loginAuditInterval = time.Hour
+	// failureAuditInterval is how often a failed login is recorded, with
+	// the number of the failures that were skipped since the last record
+	failureAuditInterval = time.Minute
+)
+
+// auditor records the actions of the handlers, it does nothing until an
+// audit log is set
+type auditor struct {
+	mutex    sync.Mutex
+	log      audit.Log
+	logins   map[string]*loginWindow
+	failures map[string]*loginWindow
+}
+
+// loginWindow is the interval of the last recorded login of a key
+type loginWindow struct {
+	start time.Time
+	// skipped is the number of the logins that were not recorded since
+	skipped int
+}
+
+// newAuditor creates an auditor without an audit log
+func newAuditor() *auditor {
+	return &auditor{
+		logins:   make(map[string]*loginWindow),
+		failures: make(map[string]*loginWindow),
+	}
+}
+
+// SetAuditLog records the administrative and security actions at auditLog.
+// It must be called before Serve.
+func (rest *REST) SetAuditLog(auditLog audit.Log) {
+	defer rest.auditor.mutex.Unlock()
+	rest.auditor.mutex.Lock()
+
+	rest.auditor.log = auditLog
+}
+
+// record adds entry with the authenticated user of r as its actor. The action
+// already took place, so a failure is logged instead of failing the request.
+func (a *auditor) record(r *http.Request, entry audit.Entry) {
+	a.mutex.Lock()
+	auditLog := a.log
+	a.mutex.Unlock()
+	if auditLog == nil {
+		return
+	}
+
+	if user, ok := middleware.CurrentUser(r.Context()); ok && entry.ActorID == 0 {
+		entry.ActorID, entry.Actor = user.ID, user.Username
+	}
+	if err := auditLog.Record(r.Context(), &entry); err != nil {
+		log.Printf("audit: unable to record %s of %s %s: %s", entry.Action, entry.TargetType, entry.TargetID, err)
+	}
+}
+
+// login is the middleware.LoginObserver of the authenticated routes
+func (a *auditor) login(r *http.Request, username string, user models.User, err error) {
+	entry := audit.Entry{Actor: username, TargetType: audit.TargetUser}
+	ip := audit.SourceFrom(r.Context()).IP
+	if err != nil {
+		key := fmt.Sprintf("%s/%s", strings.ToLower(username), ip)
+		first, skipped := a.firstLogin(a.failures, key, failureAuditInterval, time.Now())
+		if !first {
+			return
+		}
+		entry.Action = audit.ActionLoginFailure
+		entry.Diff, _ = json.Marshal(map[string]interface{}{"reason": loginFailureReason(err), "skipped": skipped})
+		a.record(r, entry)
+		return
+	}
+
+	key := fmt.Sprintf("%d/%s", user.ID, ip)
+	if first, _ := a.firstLogin(a.logins, key, loginAuditInterval, time.Now()); !first {
+		return
+	}
+	entry.Action = audit.ActionLoginSuccess
+	entry.ActorID, entry.TargetID = user.ID, strconv.FormatUint(user.ID, 10)
+	a.record(r, entry)
+}
+
+// loginFailureReason returns the reason code of a failed login, so no text
+// of the error, such as one of the database, reaches the audit log
+func loginFailureReason(err error) string {
+	switch {
+	case errors.Is(err, middleware.ErrUnknownUser):
+		return "unknown_user"
+	case errors.Is(err, middleware.ErrUserDisabled):
+		return "disabled"
+	case errors.Is(err, middleware.ErrInvalidCredentials):
+		return "bad_credentials"
+	default:
+		return "error"
+	}
+}
+
+// firstLogin returns true for the first login of key at windows during
+// interval, with the number of the logins that were skipped at the previous
+// interval of key
+func (a *auditor) firstLogin(windows map[string]*loginWindow, key string, interval time.Duration,
+	now time.Time) (bool, int) {
+	defer a.mutex.Unlock()
+	a.mutex.Lock()
+
+	if a.log == nil {
+		return false, 0
+	}
+
+	window, ok := windows[key]
+	if ok && now.Sub(window.start) < interval {
+		window.skipped++
+		return false, 0
+	}
+	skipped := 0
+	if ok {
+		skipped = window.skipped
+	}
+	for k, w := range windows {
+		if now.Sub(w.start) >= interval {
+			delete(windows, k)
+		}
+	}
+	windows[key] = &loginWindow{start: now}
+	return true, skipped
+}
+
+// targetEntry returns an entry of action on a target, with the diff between
+// before and after
+func targetEntry(action, targetType string, id uint64, before, after interface{}) audit.Entry {
+	diff, err := audit.Diff(before, after)
+	if err != nil {
+		diff, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return audit.Entry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(id, 10),
+		Diff:       diff,
+	}
+}
+
+// RegisterAdminAuditRoutes registers the query API of the audit log
+func (rest *REST) RegisterAdminAuditRoutes(auditLog audit.Log) {
+	handlers := auditHandlers{log: auditLog, pager: rest.pager}
+
+	rest.RegisterAdminRoute("/audit", "GET", types.RoleAdmin, handlers.list)
+	rest.RegisterAdminRoute("/audit/verify", "GET", types.RoleAdmin, handlers.verify)
+}
+
+type auditHandlers struct {
+	log   audit.Log
+	pager *pager
+}
+
+type verifyResponse struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// BrokenAt is the first entry that does not match the chain
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+}
+
+// list returns a page of the audit log, latest first
+func (handlers auditHandlers) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := handlers.pager.page(r, auditList)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit, filter.Cursor = page.Fetch(), page.Cursor
+
+	entries, err := handlers.log.List(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	start, end, next, prev := page.Window(len(entries), func(i int) pagination.Cursor {
+		return entries[i].Cursor()
+	})
+	handlers.pager.write(w, r, auditList, entries[start:end], next, prev)
+}
+
+func auditFilterFromQuery(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	if value := query.Get("actor_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid actor id")
+		}
+		filter.ActorID = id
+	}
+
+	for name, field := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s time, RFC 3339 is expected", name)
+		}
+		*field = t
+	}
+
+	return filter, nil
+}
+
+// verify checks the hash chain of the whole log
+func (handlers auditHandlers) verify(w http.ResponseWriter, r *http.Request) {
+	err := handlers.log.Verify(r.Context())
+	if err == nil {
+		writeJSON(w, http.StatusOK, verifyResponse{Valid: true})
+		return
+	}
+
+	chainErr, ok := err.(*audit.ChainError)
+	if !ok {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusConflict, verifyResponse{Error: chainErr.Error(), BrokenAt: chainErr.ID})
+}
@@ -0,0 +1,193 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ik5/go-into/audit"
+)
+
+func newAuditTest(t *testing.T) (*REST, *audit.MemoryLog) {
+	store := newTestUserStore(t)
+	auditLog := audit.NewMemoryLog()
+
+	rest := InitREST("", 0)
+	rest.SetAuditLog(auditLog)
+	rest.RegisterAdminAuditRoutes(auditLog)
+	rest.RegisterAdminUserRoutes(store)
+	rest.SetAdminRouting(store.GetByUsername)
+	return rest, auditLog
+}
+
+func auditEntries(t *testing.T, auditLog *audit.MemoryLog, action string) []audit.Entry {
+	entries, err := auditLog.List(context.Background(), audit.Filter{Action: action})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAuditRolesChange(t *testing.T) {
+	rest, auditLog := newAuditTest(t)
+
+	w := adminRequest(rest, "root", "PUT", "/admin/users/2/roles", `{"roles": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	entries := auditEntries(t, auditLog, audit.ActionRolesChange)
+	if len(entries) != 1 {
+		t.Fatalf("Expected a single roles change, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.ActorID != 1 || entry.Actor != "root" || entry.TargetID != "2" {
+		t.Errorf("Expected root to change user 2, got %+v", entry)
+	}
+	var diff map[string]audit.Change
+	if err := json.Unmarshal(entry.Diff, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if diff["roles"].After != float64(2) {
+		t.Errorf("Expected the roles to change to 2, got %s", entry.Diff)
+	}
+}
+
+func TestAuditLogins(t *testing.T) {
+	rest, auditLog := newAuditTest(t)
+
+	r := httptest.NewRequest("GET", "/admin/users/1", nil)
+	r.SetBasicAuth("root", "wrong")
+	w := httptest.NewRecorder()
+	rest.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	failures := auditEntries(t, auditLog, audit.ActionLoginFailure)
+	if len(failures) != 1 || failures[0].Actor != "root" || failures[0].ActorID != 0 {
+		t.Fatalf("Expected an anonymous failure of root, got %+v", failures)
+	}
+	if failures[0].RequestID == "" || failures[0].RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("Expected the request id of the response, got %q", failures[0].RequestID)
+	}
+
+	for i := 0; i < 3; i++ {
+		adminRequest(rest, "root", "GET", "/admin/users/1", "")
+	}
+	if logins := auditEntries(t, auditLog, audit.ActionLoginSuccess); len(logins) != 1 {
+		t.Errorf("Expected a single login to be recorded, got %d", len(logins))
+	}
+}
+
+func TestAuditLoginFailuresThrottled(t *testing.T) {
+	rest, auditLog := newAuditTest(t)
+
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest("GET", "/admin/users/1", nil)
+		r.SetBasicAuth("root", "wrong")
+		rest.handler().ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if failures := auditEntries(t, auditLog, audit.ActionLoginFailure); len(failures) != 1 {
+		t.Errorf("Expected a single failure to be recorded, got %d", len(failures))
+	}
+
+	// the skipped failures are counted at the record of the next interval
+	if len(rest.auditor.failures) != 1 {
+		t.Fatalf("Expected a single window of failures, got %d", len(rest.auditor.failures))
+	}
+	var key string
+	for key = range rest.auditor.failures {
+	}
+	first, skipped := rest.auditor.firstLogin(rest.auditor.failures, key, failureAuditInterval,
+		time.Now().Add(failureAuditInterval))
+	if !first || skipped != 4 {
+		t.Errorf("Expected a record with 4 skipped failures, got %t and %d", first, skipped)
+	}
+}
+
+func TestAuditLoginFailureReasons(t *testing.T) {
+	store := newTestUserStore(t)
+	disabled, _ := store.GetByUsername(context.Background(), "editor")
+	disabled.Enabled = false
+	if err := store.Update(context.Background(), &disabled); err != nil {
+		t.Fatalf("Unable to disable user: %s", err)
+	}
+
+	auditLog := audit.NewMemoryLog()
+	rest := InitREST("", 0)
+	rest.SetAuditLog(auditLog)
+	rest.RegisterAdminUserRoutes(store)
+	rest.SetAdminRouting(store.GetByUsername)
+
+	tests := []struct {
+		username, password, reason string
+	}{
+		{"root", "wrong", "bad_credentials"},
+		{"nobody", adminPassword, "unknown_user"},
+		{"editor", adminPassword, "disabled"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/admin/users/1", nil)
+		r.SetBasicAuth(test.username, test.password)
+		rest.handler().ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	failures := auditEntries(t, auditLog, audit.ActionLoginFailure)
+	if len(failures) != len(tests) {
+		t.Fatalf("Expected %d failures, got %d", len(tests), len(failures))
+	}
+	for _, test := range tests {
+		found := false
+		for _, entry := range failures {
+			var diff map[string]interface{}
+			if err := json.Unmarshal(entry.Diff, &diff); err != nil {
+				t.Fatalf("Unable to decode diff: %s", err)
+			}
+			if entry.Actor == test.username {
+				found = true
+				if diff["reason"] != test.reason {
+					t.Errorf("Expected the reason of %s to be %s, got %v", test.username, test.reason, diff["reason"])
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected a failure of %s", test.username)
+		}
+	}
+}
+
+func TestAuditListAndVerify(t *testing.T) {
+	rest, _ := newAuditTest(t)
+
+	adminRequest(rest, "root", "POST", "/admin/users/2/disable", "")
+	adminRequest(rest, "root", "DELETE", "/admin/users/2", "")
+
+	w := adminRequest(rest, "root", "GET", "/admin/audit?target_type=user&target_id=2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var response struct {
+		Data []audit.Entry `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if len(response.Data) != 2 || response.Data[0].Action != audit.ActionUserDelete ||
+		response.Data[1].Action != audit.ActionUserDisable {
+		t.Errorf("Expected the delete and the disable of user 2, got %+v", response.Data)
+	}
+
+	w = adminRequest(rest, "root", "GET", "/admin/audit?since=yesterday", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = adminRequest(rest, "root", "GET", "/admin/audit/verify", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
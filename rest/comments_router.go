@@ -0,0 +1,210 @@
+package rest
+
+/*
+	Comments REST allows guests and users to comment on published posts, and
+	reviewers to moderate the comments.
+*/
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/types"
+)
+
+// RegisterCommentRoutes registers the comments API of posts, and the
+// moderation API under the admin routes
+func (rest *REST) RegisterCommentRoutes(comments models.CommentRepository, posts models.PostRepository) {
+	handlers := commentHandlers{comments: comments, posts: posts, pager: rest.pager, markup: rest.markup}
+
+	rest.RegisterPostRoute("/:id/comments", "GET", 0, handlers.thread)
+	rest.RegisterPostRoute("/:id/comments", "POST", 0, handlers.create)
+
+	rest.RegisterAdminRoute("/comments", "GET", types.RoleReview, handlers.list)
+	rest.RegisterAdminRoute("/comments/:id/state", "PUT", types.RoleReview, handlers.moderate)
+}
+
+type commentHandlers struct {
+	comments models.CommentRepository
+	posts    models.PostRepository
+	pager    *pager
+	markup   *renderers
+}
+
+type createCommentRequest struct {
+	ParentID    uint64 `json:"parent_id"`
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email"`
+	Body        string `json:"body"`
+}
+
+type moderateCommentRequest struct {
+	State models.CommentState `json:"state"`
+}
+
+// publishedPost returns the post of the id path parameter if it is published,
+// or writes the error and returns false
+func (handlers commentHandlers) publishedPost(w http.ResponseWriter, r *http.Request) (models.Post, bool) {
+	id, err := strconv.ParseUint(Param(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return models.Post{}, false
+	}
+
+	post, err := handlers.posts.GetByID(r.Context(), id)
+	if err == nil && post.Status != models.PostPublished {
+		err = models.ErrPostNotFound
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return models.Post{}, false
+	}
+	return post, true
+}
+
+// thread returns the approved comments of a post as a tree. Deleted comments
+// are kept without their content, so their replies stay in place.
+func (handlers commentHandlers) thread(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.publishedPost(w, r)
+	if !ok {
+		return
+	}
+
+	comments, err := handlers.comments.Thread(r.Context(), post.ID,
+		models.CommentApproved, models.CommentDeleted,
+	)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	for i := range comments {
+		if comments[i].State == models.CommentDeleted {
+			comments[i].Body = ""
+			comments[i].AuthorName = ""
+			comments[i].AuthorID = sql.NullInt64{}
+		}
+		handlers.markup.comment(&comments[i])
+	}
+
+	writeJSON(w, http.StatusOK, models.BuildThread(comments))
+}
+
+// create adds a comment by the authenticated user, or by a guest. Comments of
+// guests wait for moderation.
+func (handlers commentHandlers) create(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.publishedPost(w, r)
+	if !ok {
+		return
+	}
+
+	var req createCommentRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		writeError(w, http.StatusBadRequest, "body is required")
+		return
+	}
+
+	comment := models.Comment{
+		PostID:      post.ID,
+		AuthorName:  req.AuthorName,
+		AuthorEmail: req.AuthorEmail,
+		Body:        req.Body,
+		State:       models.CommentPending,
+	}
+	if req.ParentID != 0 {
+		comment.ParentID = sql.NullInt64{Int64: int64(req.ParentID), Valid: true}
+	}
+	if user, ok := middleware.CurrentUser(r.Context()); ok {
+		comment.AuthorID = sql.NullInt64{Int64: int64(user.ID), Valid: true}
+		comment.AuthorName = user.Username
+		comment.AuthorEmail = user.Email
+		comment.State = models.CommentApproved
+	}
+
+	if err := handlers.comments.Create(r.Context(), &comment); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	handlers.markup.comment(&comment)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+// list returns a page of comments for moderation, filtered by state and
+// post_id
+func (handlers commentHandlers) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.CommentFilter{
+		State: models.CommentState(query.Get("state")),
+	}
+	if filter.State != "" && !filter.State.IsValid() {
+		writeStoreError(w, models.ErrInvalidState)
+		return
+	}
+
+	if value := query.Get("post_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid post id")
+			return
+		}
+		filter.PostID = id
+	}
+
+	page, err := handlers.pager.page(r, commentsList)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit, filter.Cursor = page.Fetch(), page.Cursor
+
+	comments, err := handlers.comments.List(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	start, end, next, prev := page.Window(len(comments), func(i int) pagination.Cursor {
+		return comments[i].Cursor()
+	})
+	for i := start; i < end; i++ {
+		handlers.markup.comment(&comments[i])
+	}
+	handlers.pager.write(w, r, commentsList, comments[start:end], next, prev)
+}
+
+// moderate changes the state of a comment
+func (handlers commentHandlers) moderate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(Param(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid comment id")
+		return
+	}
+
+	var req moderateCommentRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := handlers.comments.SetState(r.Context(), id, req.State); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	comment, err := handlers.comments.GetByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	handlers.markup.comment(&comment)
+	writeJSON(w, http.StatusOK, comment)
+}
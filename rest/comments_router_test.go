@@ -0,0 +1,121 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ik5/go-into/models"
+)
+
+func newCommentsTest(t *testing.T) (*REST, *models.MemoryCommentRepository, models.Post) {
+	store := newTestUserStore(t)
+
+	posts := models.NewMemoryPostRepository()
+	post := models.Post{Title: "Hello", Body: "World", Status: models.PostPublished}
+	if err := posts.Create(context.Background(), &post); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	comments := models.NewMemoryCommentRepository()
+	rest := InitREST("", 0)
+	rest.RegisterCommentRoutes(comments, posts)
+	rest.SetAdminRouting(store.GetByUsername)
+	rest.SetPostRouting(store.GetByUsername)
+
+	return rest, comments, post
+}
+
+func TestGuestCommentWaitsForModeration(t *testing.T) {
+	rest, comments, _ := newCommentsTest(t)
+
+	w := adminRequest(rest, "", "POST", "/posts/1/comments", `{"author_name": "guest", "body": "Nice"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	comment, err := comments.GetByID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if comment.State != models.CommentPending {
+		t.Errorf("Expected pending comment, got %s", comment.State)
+	}
+
+	w = adminRequest(rest, "", "GET", "/posts/1/comments", "")
+	var thread []models.Comment
+	if err := json.Unmarshal(w.Body.Bytes(), &thread); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if len(thread) != 0 {
+		t.Errorf("Expected pending comment not to be visible, got %+v", thread)
+	}
+}
+
+func TestUserCommentIsApproved(t *testing.T) {
+	rest, comments, _ := newCommentsTest(t)
+
+	w := adminRequest(rest, "editor", "POST", "/posts/1/comments", `{"body": "Nice"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	comment, _ := comments.GetByID(context.Background(), 1)
+	if comment.State != models.CommentApproved || comment.AuthorName != "editor" {
+		t.Errorf("Expected approved comment by editor, got %+v", comment)
+	}
+}
+
+func TestCommentOnUnknownPost(t *testing.T) {
+	rest, _, _ := newCommentsTest(t)
+
+	w := adminRequest(rest, "", "POST", "/posts/2/comments", `{"author_name": "guest", "body": "Nice"}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestModerateComment(t *testing.T) {
+	rest, comments, post := newCommentsTest(t)
+
+	comment := models.Comment{PostID: post.ID, AuthorName: "guest", Body: "Nice"}
+	if err := comments.Create(context.Background(), &comment); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	w := adminRequest(rest, "root", "PUT", "/admin/comments/1/state", `{"state": "approved"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	w = adminRequest(rest, "root", "PUT", "/admin/comments/1/state", `{"state": "unknown"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestCommentBodyIsRendered(t *testing.T) {
+	rest, _, _ := newCommentsTest(t)
+
+	body := `{"body": "**Nice** <script>alert(1)</script> [site](javascript:alert(1)) https://example.com"}`
+	w := adminRequest(rest, "editor", "POST", "/posts/1/comments", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	w = adminRequest(rest, "", "GET", "/posts/1/comments", "")
+	var thread []models.Comment
+	if err := json.Unmarshal(w.Body.Bytes(), &thread); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if len(thread) != 1 {
+		t.Fatalf("Expected a single comment, got %+v", thread)
+	}
+
+	expected := "<p><strong>Nice</strong> &lt;script&gt;alert(1)&lt;/script&gt; site " +
+		`<a href="https://example.com" rel="nofollow ugc noopener">https://example.com</a></p>` + "\n"
+	if thread[0].BodyHTML != expected {
+		t.Errorf("Expected %q, got %q", expected, thread[0].BodyHTML)
+	}
+}
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"github.com/ik5/go-into/markup"
+	"github.com/ik5/go-into/models"
+)
+
+// renderers write the HTML of the markdown bodies of posts and comments
+type renderers struct {
+	posts    *markup.Renderer
+	comments *markup.Renderer
+}
+
+func newRenderers() *renderers {
+	return &renderers{
+		posts:    markup.New(nil),
+		comments: markup.New(&markup.Options{Policy: markup.CommentPolicy()}),
+	}
+}
+
+// SetMarkup sets the renderers of posts and comments, so their cache is
+// shared with other pages. A nil renderer keeps the default one. It must be
+// called before Serve.
+func (rest *REST) SetMarkup(posts, comments *markup.Renderer) {
+	if posts != nil {
+		rest.markup.posts = posts
+	}
+	if comments != nil {
+		rest.markup.comments = comments
+	}
+}
+
+// post fills the HTML of the body of a post
+func (r *renderers) post(post *models.Post) {
+	post.BodyHTML = r.posts.RenderCached(post.CacheKey(), post.Body)
+}
+
+// comment fills the HTML of the body of a comment, the body of a deleted
+// comment is already cleared
+func (r *renderers) comment(comment *models.Comment) {
+	if comment.Body == "" {
+		comment.BodyHTML = ""
+		return
+	}
+	comment.BodyHTML = r.comments.RenderCached(comment.CacheKey(), comment.Body)
+}
@@ -0,0 +1,119 @@
+package rest
+
+/*
+	HTTP metrics of the REST server. Requests are labelled by the pattern of
+	the route that served them, so the number of series does not grow with
+	the ids at the paths.
+*/
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ik5/go-into/metrics"
+	"github.com/ik5/go-into/rest/middleware"
+)
+
+// unmatchedRoute labels the requests that no route served
+const unmatchedRoute = "unmatched"
+
+type routeContextKey struct{}
+
+// httpMetrics holds the metrics of the requests
+type httpMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
+}
+
+// EnableMetrics exports the metrics of the requests at registry. It must be
+// called before Serve.
+func (rest *REST) EnableMetrics(registry *metrics.Registry) {
+	rest.metrics = &httpMetrics{
+		requests: metrics.NewCounterVec("http_requests_total",
+			"HTTP requests by method, route and status code.", "method", "route", "code"),
+		duration: metrics.NewHistogramVec("http_request_duration_seconds",
+			"Latency of the HTTP requests by method and route.", nil, "method", "route"),
+		inFlight: metrics.NewGaugeVec("http_requests_in_flight",
+			"HTTP requests that are being served."),
+	}
+	registry.MustRegister(rest.metrics.requests, rest.metrics.duration, rest.metrics.inFlight)
+}
+
+// handler returns the handler of the server, wrapped with the source of the
+// requests and the metrics when they are enabled
+func (rest *REST) handler() http.Handler {
+	handler := middleware.RequestSource(rest.mux)
+	if rest.metrics == nil {
+		return handler
+	}
+	return rest.metrics.middleware(handler)
+}
+
+// setRoute records the route pattern that serves r for the metrics
+func setRoute(r *http.Request, route string) {
+	if holder, ok := r.Context().Value(routeContextKey{}).(*string); ok {
+		*holder = route
+	}
+}
+
+// statusRecorder keeps the status code that was written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(b []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(b)
+}
+
+func (m *httpMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inFlight := m.inFlight.With()
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		route := unmatchedRoute
+		r = r.WithContext(context.WithValue(r.Context(), routeContextKey{}, &route))
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		method := methodLabel(r.Method)
+		m.requests.With(method, route, strconv.Itoa(status)).Inc()
+		m.duration.With(method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// String returns the route of the pattern, as it was registered
+func (pattern routePattern) String() string {
+	return "/" + strings.Join(pattern, "/")
+}
+
+// methodLabel limits the methods at the labels to the known ones
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
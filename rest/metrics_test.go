@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/metrics"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	rest, _ := newAdminTest(t)
+	registry := metrics.NewRegistry()
+	rest.EnableMetrics(registry)
+
+	for _, path := range []string{"/admin/users/1", "/admin/users/2", "/admin/nothing"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("root", adminPassword)
+		rest.handler().ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	var b strings.Builder
+	if err := registry.WriteText(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	text := b.String()
+
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/admin/users/:id",code="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/admin/users/:id"} 2`,
+		`http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Expected the line %s, got:\n%s", line, text)
+		}
+	}
+}
+
+func TestMethodLabel(t *testing.T) {
+	if label := methodLabel(http.MethodPut); label != http.MethodPut {
+		t.Errorf("Expected %s, got %s", http.MethodPut, label)
+	}
+	if label := methodLabel("BREW"); label != "OTHER" {
+		t.Errorf("Expected OTHER, got %s", label)
+	}
+}
@@ -1,3 +1,145 @@
 package middleware
 
-// TODO: create authentication middleware
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ik5/go-into/crypto"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/types"
+)
+
+// UserLookup returns the user that belongs to a given username
+type UserLookup func(ctx context.Context, username string) (models.User, error)
+
+// Errors of a failed authentication, that are passed to a LoginObserver. The
+// client is not told which of them failed its request.
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUnknownUser        = errors.New("unknown username")
+	ErrUserDisabled       = errors.New("user is disabled or deleted")
+)
+
+// PasswordChangePath is the only path that a user that must reset its
+// password may reach, see RequirePasswordChange
+const PasswordChangePath = "/admin/password"
+
+// LoginObserver is called on every request that provides credentials, with
+// the authenticated user, or with the reason the authentication failed
+type LoginObserver func(r *http.Request, username string, user models.User, err error)
+
+// context keys are of their own type, so no other package can collide with them
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+)
+
+// WithUser returns a copy of ctx that holds the authenticated user
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// CurrentUser returns the authenticated user of a request context, or false
+// if the request was not authenticated
+func CurrentUser(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(models.User)
+	return user, ok
+}
+
+// Authenticate validates HTTP basic authentication credentials against the
+// users provided by lookup.
+//
+// Only enabled users that are not deleted are allowed to pass, and the user is
+// stored in the request context for the next handler. observe may be nil.
+func Authenticate(realm string, lookup UserLookup, observe LoginObserver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username == "" {
+			unauthorized(w, realm)
+			return
+		}
+
+		user, err := authenticateUser(r.Context(), lookup, username, password)
+		if observe != nil {
+			observe(r, username, user, err)
+		}
+		if err != nil {
+			unauthorized(w, realm)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// OptionalAuthenticate works as Authenticate for requests that provide
+// credentials, and passes requests without credentials on as anonymous
+func OptionalAuthenticate(realm string, lookup UserLookup, observe LoginObserver, next http.Handler) http.Handler {
+	authenticated := Authenticate(realm, lookup, observe, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter, realm string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func authenticateUser(ctx context.Context, lookup UserLookup, username, password string) (models.User, error) {
+	user, err := lookup(ctx, username)
+	if err == models.ErrUserNotFound {
+		return models.User{}, ErrUnknownUser
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	if !user.Enabled || user.Deleted {
+		return models.User{}, ErrUserDisabled
+	}
+
+	valid, err := crypto.IsValidPassword(password, user.Password)
+	if err != nil || !valid {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// RequirePasswordChange allows authenticated users that must reset their
+// password to reach only PasswordChangePath, so a temporary password is good
+// for nothing else
+func RequirePasswordChange(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := CurrentUser(r.Context())
+		if ok && user.MustResetPassword && r.URL.Path != PasswordChangePath {
+			http.Error(w, "password must be changed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRoles allows only authenticated users that hold all of the given
+// roles to reach next
+func RequireRoles(roles types.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := CurrentUser(r.Context())
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if !user.Roles.Has(roles) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
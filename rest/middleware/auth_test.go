@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ik5/go-into/crypto"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/types"
+)
+
+const testPassword = "secret"
+
+// testLookup returns a lookup of an enabled, a disabled and a user that must
+// reset its password, all using testPassword
+func testLookup(t *testing.T) UserLookup {
+	password, err := crypto.GenPassword(crypto.PBKDF2, testPassword, crypto.GenSalt(0))
+	if err != nil {
+		t.Fatalf("Unable to generate password: %s", err)
+	}
+
+	users := map[string]models.User{
+		"enabled":  {ID: 1, Username: "enabled", Password: password, Roles: types.RoleEditor, Enabled: true},
+		"disabled": {ID: 2, Username: "disabled", Password: password, Roles: types.RoleEditor},
+		"reset":    {ID: 3, Username: "reset", Password: password, Roles: types.RoleEditor, Enabled: true, MustResetPassword: true},
+	}
+	return func(ctx context.Context, username string) (models.User, error) {
+		user, ok := users[username]
+		if !ok {
+			return models.User{}, models.ErrUserNotFound
+		}
+		return user, nil
+	}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLoginObserver(t *testing.T) {
+	tests := []struct {
+		username, password string
+		code               int
+		err                error
+	}{
+		{"enabled", testPassword, http.StatusOK, nil},
+		{"enabled", "wrong", http.StatusUnauthorized, ErrInvalidCredentials},
+		{"unknown", testPassword, http.StatusUnauthorized, ErrUnknownUser},
+		{"disabled", testPassword, http.StatusUnauthorized, ErrUserDisabled},
+	}
+
+	for _, test := range tests {
+		var observed error
+		calls := 0
+		observe := func(r *http.Request, username string, user models.User, err error) {
+			calls++
+			observed = err
+			if username != test.username {
+				t.Errorf("Expected username %q, got %q", test.username, username)
+			}
+			if err == nil && user.Username != test.username {
+				t.Errorf("Expected user %q, got %q", test.username, user.Username)
+			}
+		}
+
+		handler := Authenticate("test", testLookup(t), observe, http.HandlerFunc(okHandler))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(test.username, test.password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Expected %s to return %d, got %d", test.username, test.code, w.Code)
+		}
+		if calls != 1 {
+			t.Errorf("Expected the observer to be called once, got %d", calls)
+		}
+		if observed != test.err {
+			t.Errorf("Expected %s to be observed with %v, got %v", test.username, test.err, observed)
+		}
+	}
+
+	calls := 0
+	observe := func(*http.Request, string, models.User, error) { calls++ }
+	w := httptest.NewRecorder()
+	Authenticate("test", testLookup(t), observe, http.HandlerFunc(okHandler)).
+		ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without credentials, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no observation without credentials, got %d", calls)
+	}
+}
+
+func TestRequireRoles(t *testing.T) {
+	tests := []struct {
+		user  *models.User
+		roles types.Role
+		code  int
+	}{
+		{nil, types.RoleEdit, http.StatusUnauthorized},
+		{&models.User{Roles: types.RoleEditor}, types.RoleEdit, http.StatusOK},
+		{&models.User{Roles: types.RoleEditor}, types.RoleEdit | types.RoleReview, http.StatusOK},
+		{&models.User{Roles: types.RoleEditor}, types.RoleEdit | types.RoleDelete, http.StatusForbidden},
+		{&models.User{Roles: types.RoleNone}, 0, http.StatusOK},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.user != nil {
+			r = r.WithContext(WithUser(r.Context(), *test.user))
+		}
+		w := httptest.NewRecorder()
+		RequireRoles(test.roles, http.HandlerFunc(okHandler)).ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Expected test %d to return %d, got %d", i, test.code, w.Code)
+		}
+	}
+}
+
+func TestRequirePasswordChange(t *testing.T) {
+	tests := []struct {
+		user *models.User
+		path string
+		code int
+	}{
+		{nil, "/admin/users", http.StatusOK},
+		{&models.User{}, "/admin/users", http.StatusOK},
+		{&models.User{MustResetPassword: true}, "/admin/users", http.StatusForbidden},
+		{&models.User{MustResetPassword: true}, "/admin/password/", http.StatusForbidden},
+		{&models.User{MustResetPassword: true}, PasswordChangePath, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", test.path, nil)
+		if test.user != nil {
+			r = r.WithContext(WithUser(r.Context(), *test.user))
+		}
+		w := httptest.NewRecorder()
+		RequirePasswordChange(http.HandlerFunc(okHandler)).ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Expected %s to return %d, got %d", test.path, test.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"github.com/ik5/go-into/audit"
+)
+
+// RequestIDHeader carries the id of a request, from the client or a proxy
+// before the server, and back at the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a request id that was sent by the client
+const maxRequestIDLength = 64
+
+// RequestSource stores the audit.Source of the request at its context. The
+// request id of the client is kept when it is valid, otherwise a new one is
+// generated. The IP is the peer of the connection, as forwarding headers
+// can be forged by the client.
+func RequestSource(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx := audit.WithSource(r.Context(), audit.Source{IP: ip, RequestID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID allows only short printable ids, so they are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package rest
+
+/*
+	Cursor pagination of the list endpoints. A list is returned in an envelope
+	with the cursors of the next and the previous pages, that are also linked
+	at the Link header:
+
+		{"data": [...], "next": "eyJrIjoi...", "prev": null}
+		Link: </posts/?cursor=eyJrIjoi...&limit=20>; rel="next"
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ik5/go-into/pagination"
+)
+
+// listResponse is the envelope of a page of a list
+type listResponse struct {
+	Data interface{} `json:"data"`
+	Next *string     `json:"next"`
+	Prev *string     `json:"prev"`
+}
+
+// Names of the lists, that their cursors are bound to
+const (
+	postsList    = "posts"
+	commentsList = "comments"
+	usersList    = "users"
+	auditList    = "audit"
+)
+
+// pager reads the pages that are asked for, and writes them with signed
+// cursors
+type pager struct {
+	codec *pagination.Codec
+}
+
+// SetCursorSecret sets the key that signs the cursors of the lists. All
+// instances that serve the same clients must share it, otherwise a random key
+// is used and the cursors are valid only at the instance that created them.
+func (rest *REST) SetCursorSecret(secret []byte) {
+	rest.pager.codec = pagination.NewCodec(secret)
+}
+
+// page returns the page of list at the limit and cursor query parameters
+func (p *pager) page(r *http.Request, list string) (pagination.Page, error) {
+	query := r.URL.Query()
+	page := pagination.Page{Limit: pagination.DefaultLimit}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return page, errors.New("invalid limit")
+		}
+		if limit > pagination.MaxLimit {
+			limit = pagination.MaxLimit
+		}
+		page.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := p.codec.Decode(list, value)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = &cursor
+	}
+	return page, nil
+}
+
+// write writes data, the rows of a page of list, with the cursors around it
+func (p *pager) write(w http.ResponseWriter, r *http.Request, list string, data interface{},
+	next, prev *pagination.Cursor) {
+	response := listResponse{Data: data}
+	for _, link := range []struct {
+		rel    string
+		cursor *pagination.Cursor
+		field  **string
+	}{
+		{"next", next, &response.Next},
+		{"prev", prev, &response.Prev},
+	} {
+		if link.cursor == nil {
+			continue
+		}
+		encoded := p.codec.Encode(list, *link.cursor)
+		*link.field = &encoded
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="%s"`, pageURL(r, encoded), link.rel))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// pageURL returns the address of the request at the page of cursor
+func pageURL(r *http.Request, cursor string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", cursor)
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,137 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+)
+
+type postsPage struct {
+	Data []models.Post `json:"data"`
+	Next *string       `json:"next"`
+	Prev *string       `json:"prev"`
+}
+
+func newPostsTest(t *testing.T, count int) *REST {
+	store := newTestUserStore(t)
+	posts := models.NewMemoryPostRepository()
+	for i := 0; i < count; i++ {
+		post := models.Post{Title: "Post", Status: models.PostPublished}
+		if err := posts.Create(context.Background(), &post); err != nil {
+			t.Fatalf("Unexpected err: %s", err)
+		}
+	}
+	draft := models.Post{Title: "Draft"}
+	if err := posts.Create(context.Background(), &draft); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	rest := InitREST("", 0)
+	rest.RegisterPostRoutes(posts)
+	rest.SetPostRouting(store.GetByUsername)
+	return rest
+}
+
+func getPostsPage(t *testing.T, rest *REST, path string) (postsPage, http.Header) {
+	t.Helper()
+
+	w := adminRequest(rest, "", "GET", path, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var page postsPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	return page, w.Header()
+}
+
+func postIDs(posts []models.Post) []uint64 {
+	ids := make([]uint64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+func TestPostsListPages(t *testing.T) {
+	rest := newPostsTest(t, 5)
+
+	first, header := getPostsPage(t, rest, "/posts/?limit=2")
+	if ids := postIDs(first.Data); len(ids) != 2 || ids[0] != 5 || ids[1] != 4 {
+		t.Fatalf("Expected posts 5 and 4, got %v", ids)
+	}
+	if first.Next == nil || first.Prev != nil {
+		t.Fatalf("Expected only a next cursor, got %v and %v", first.Next, first.Prev)
+	}
+	link := header.Get("Link")
+	if !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "cursor="+url.QueryEscape(*first.Next)) {
+		t.Errorf("Expected a next link with the cursor, got %q", link)
+	}
+
+	second, _ := getPostsPage(t, rest, "/posts/?limit=2&cursor="+url.QueryEscape(*first.Next))
+	if ids := postIDs(second.Data); len(ids) != 2 || ids[0] != 3 || ids[1] != 2 {
+		t.Fatalf("Expected posts 3 and 2, got %v", ids)
+	}
+	if second.Next == nil || second.Prev == nil {
+		t.Fatal("Expected both cursors at a middle page")
+	}
+
+	last, header := getPostsPage(t, rest, "/posts/?limit=2&cursor="+url.QueryEscape(*second.Next))
+	if ids := postIDs(last.Data); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("Expected the draft to be hidden and post 1 to be last, got %v", ids)
+	}
+	if last.Next != nil || strings.Contains(header.Get("Link"), `rel="next"`) {
+		t.Error("Expected no next page after the last one")
+	}
+
+	back, _ := getPostsPage(t, rest, "/posts/?limit=2&cursor="+url.QueryEscape(*second.Prev))
+	if ids := postIDs(back.Data); len(ids) != 2 || ids[0] != 5 || ids[1] != 4 {
+		t.Errorf("Expected the first page again, got %v", ids)
+	}
+	if back.Prev != nil {
+		t.Error("Expected no previous page before the first one")
+	}
+}
+
+func TestPostsListInvalidCursor(t *testing.T) {
+	rest := newPostsTest(t, 1)
+
+	// a cursor that is signed with the same key, for another list
+	other := rest.pager.codec.Encode(commentsList, pagination.Cursor{Key: "key", ID: 1})
+
+	for _, path := range []string{
+		"/posts/?cursor=forged",
+		"/posts/?limit=-1",
+		"/posts/?cursor=" + url.QueryEscape(other),
+	} {
+		w := adminRequest(rest, "", "GET", path, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %d for %s, got %d", http.StatusBadRequest, path, w.Code)
+		}
+	}
+}
+
+func TestCursorsSignedBySecret(t *testing.T) {
+	rest := newPostsTest(t, 3)
+	rest.SetCursorSecret([]byte("shared"))
+	first, _ := getPostsPage(t, rest, "/posts/?limit=1")
+	path := "/posts/?limit=1&cursor=" + url.QueryEscape(*first.Next)
+
+	shared := newPostsTest(t, 3)
+	shared.SetCursorSecret([]byte("shared"))
+	if w := adminRequest(shared, "", "GET", path, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected %d with the same secret, got %d", http.StatusOK, w.Code)
+	}
+
+	other := newPostsTest(t, 3)
+	if w := adminRequest(other, "", "GET", path, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d with another secret, got %d", http.StatusBadRequest, w.Code)
+	}
+}
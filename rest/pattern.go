@@ -0,0 +1,86 @@
+package rest
+
+/*
+	Pattern matching for routes that holds parameters as part of the path, for
+	example: /admin/users/:id/roles
+*/
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ik5/go-into/types"
+)
+
+// routePattern holds the segments of a route, a segment that starts with ':'
+// matches any value and captures it under the name that follows the colon.
+type routePattern []string
+
+type paramsContextKey struct{}
+
+func parsePattern(route string) routePattern {
+	return strings.Split(strings.Trim(route, "/"), "/")
+}
+
+// match checks if path fits the pattern and returns the captured parameters
+func (pattern routePattern) match(path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != len(pattern) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range pattern {
+		if strings.HasPrefix(segment, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func withParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsContextKey{}, params))
+}
+
+// Param returns a path parameter that was captured by a route pattern, or an
+// empty string if there is no such parameter
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsContextKey{}).(map[string]string)
+	return params[name]
+}
+
+// servePatternRoutes dispatches a request to the first route that matches both
+// the path and the method. A path that matches only with a different method
+// returns 405.
+func servePatternRoutes(routes []patternRoute, w http.ResponseWriter, r *http.Request, guard func(types.Role, http.Handler) http.Handler) {
+	pathFound := false
+	for _, route := range routes {
+		params, ok := route.pattern.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		pathFound = true
+		if route.method != r.Method {
+			continue
+		}
+
+		setRoute(r, route.pattern.String())
+		guard(route.roles, route.handler).ServeHTTP(w, withParams(r, params))
+		return
+	}
+
+	if pathFound {
+		writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+}
@@ -1,7 +1,166 @@
 package rest
 
 /*
-	Pattern matching for posts based routing
+	Pattern matching for posts based routing.
+
+	Posts routes are open for guests, while authenticated users may act on
+	behalf of their roles.
 */
 
-// TODO: create pattern matching for posts routing
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/types"
+)
+
+// PostsPrefix is the path that all posts routes are placed under
+const PostsPrefix = "/posts/"
+
+// RegisterPostRoute write a new posts route with it's handler. The route is a
+// pattern relative to PostsPrefix. When roles is not 0, only authenticated
+// users that hold all of the roles are allowed to reach the handler.
+func (rest *REST) RegisterPostRoute(route, method string, roles types.Role, handler http.HandlerFunc) {
+	defer rest.rwRouter.Unlock()
+	rest.rwRouter.Lock()
+
+	rest.postRoutes = append(rest.postRoutes, patternRoute{
+		pattern: parsePattern(PostsPrefix + strings.TrimPrefix(route, "/")),
+		method:  method,
+		roles:   roles,
+		handler: handler,
+	})
+}
+
+// SetPostRouting places all registered posts routes behind the optional
+// authentication middleware
+func (rest *REST) SetPostRouting(lookup middleware.UserLookup) {
+	defer rest.rwRouter.RUnlock()
+	rest.rwRouter.RLock()
+
+	routes := make([]patternRoute, len(rest.postRoutes))
+	copy(routes, rest.postRoutes)
+
+	dispatch := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePatternRoutes(routes, w, editorRequest(r), guestOrRoles)
+	})
+	rest.mux.Handle(PostsPrefix, middleware.OptionalAuthenticate("posts", lookup, rest.auditor.login,
+		middleware.RequirePasswordChange(dispatch)))
+}
+
+// guestOrRoles requires roles only for routes that declare them
+func guestOrRoles(roles types.Role, next http.Handler) http.Handler {
+	if roles == 0 {
+		return next
+	}
+	return middleware.RequireRoles(roles, next)
+}
+
+// RegisterPostRoutes registers the public posts API, and the workflow,
+// publishing and revisions API under the admin routes
+func (rest *REST) RegisterPostRoutes(posts models.PostRepository) {
+	handlers := postHandlers{posts: posts, pager: rest.pager, auditor: rest.auditor, markup: rest.markup}
+
+	rest.RegisterPostRoute("/", "GET", 0, handlers.list)
+
+	rest.RegisterAdminRoute("/posts/:id/publish", "POST", types.RolePublish, handlers.publish)
+	rest.RegisterAdminRoute("/posts/:id", "DELETE", types.RoleDelete, handlers.delete)
+	rest.registerWorkflowRoutes(handlers)
+	rest.registerRevisionRoutes(posts)
+}
+
+type postHandlers struct {
+	posts   models.PostRepository
+	pager   *pager
+	auditor *auditor
+	markup  *renderers
+}
+
+// list returns a page of the published posts with their rendered body, newest
+// first, optionally of a single author
+func (handlers postHandlers) list(w http.ResponseWriter, r *http.Request) {
+	filter := models.PostFilter{Status: models.PostPublished}
+	if value := r.URL.Query().Get("author_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid author id")
+			return
+		}
+		filter.AuthorID = id
+	}
+
+	page, err := handlers.pager.page(r, postsList)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit, filter.Cursor = page.Fetch(), page.Cursor
+
+	posts, err := handlers.posts.List(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	start, end, next, prev := page.Window(len(posts), func(i int) pagination.Cursor {
+		return posts[i].Cursor()
+	})
+	for i := start; i < end; i++ {
+		handlers.markup.post(&posts[i])
+	}
+	handlers.pager.write(w, r, postsList, posts[start:end], next, prev)
+}
+
+// load returns the post of the id path parameter, or writes the error and
+// returns false
+func (handlers postHandlers) load(w http.ResponseWriter, r *http.Request) (models.Post, bool) {
+	id, err := strconv.ParseUint(Param(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return models.Post{}, false
+	}
+
+	post, err := handlers.posts.GetByID(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return models.Post{}, false
+	}
+	return post, true
+}
+
+// publish makes an approved or a scheduled post public at once
+func (handlers postHandlers) publish(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.load(w, r)
+	if !ok {
+		return
+	}
+	if post.Status == models.PostPublished {
+		writeJSON(w, http.StatusOK, post)
+		return
+	}
+
+	post, _, ok = handlers.performAction(w, r, post.ID, models.ActionRequest{Action: models.ActionPublish})
+	if ok {
+		writeJSON(w, http.StatusOK, post)
+	}
+}
+
+// delete removes a post
+func (handlers postHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.load(w, r)
+	if !ok {
+		return
+	}
+
+	if err := handlers.posts.Delete(r.Context(), post.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	handlers.auditor.record(r, targetEntry(audit.ActionPostDelete, audit.TargetPost, post.ID, post, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
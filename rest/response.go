@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ik5/go-into/db"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/pagination"
+)
+
+// storeErrorStatus maps the errors of the models repositories to HTTP
+// statuses. It is a list, since errors are matched with errors.Is, so an error
+// that a repository wrapped is still found.
+var storeErrorStatus = []struct {
+	err    error
+	status int
+}{
+	{models.ErrUserNotFound, http.StatusNotFound},
+	{models.ErrDuplicateUsername, http.StatusConflict},
+	{models.ErrDuplicateEmail, http.StatusConflict},
+	{models.ErrMissingCredentials, http.StatusBadRequest},
+	{models.ErrPostNotFound, http.StatusNotFound},
+	{models.ErrRevisionNotFound, http.StatusNotFound},
+	{models.ErrVersionConflict, http.StatusConflict},
+	{models.ErrInvalidStatus, http.StatusBadRequest},
+	{models.ErrCommentNotFound, http.StatusNotFound},
+	{models.ErrInvalidState, http.StatusBadRequest},
+	{models.ErrInvalidParent, http.StatusBadRequest},
+	{models.ErrMissingAuthor, http.StatusBadRequest},
+	{models.ErrUnknownAction, http.StatusBadRequest},
+	{models.ErrActionNotAllowed, http.StatusConflict},
+	{models.ErrActionForbidden, http.StatusForbidden},
+	{models.ErrCommentRequired, http.StatusBadRequest},
+	{models.ErrInvalidPublishAt, http.StatusBadRequest},
+	{models.ErrPostLocked, http.StatusConflict},
+	{models.ErrTransitionChanged, http.StatusConflict},
+	{pagination.ErrInvalidCursor, http.StatusBadRequest},
+}
+
+// errorResponse is the body returned on every failed API request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeStoreError writes an error that was returned by a repository
+func writeStoreError(w http.ResponseWriter, err error) {
+	for _, known := range storeErrorStatus {
+		if errors.Is(err, known.err) {
+			writeError(w, known.status, known.err.Error())
+			return
+		}
+	}
+	if db.IsNoRows(err) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeInternalError(w, err)
+}
+
+// writeInternalError logs an unexpected error, and writes a generic message
+// so no details of the database or the driver reach the client
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.Printf("rest: internal error: %s", err)
+	writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/models"
+)
+
+func TestWriteStoreError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeStoreError(w, models.ErrPostNotFound)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), models.ErrPostNotFound.Error()) {
+		t.Errorf("Expected %d with the error, got %d: %s", http.StatusNotFound, w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	writeStoreError(w, fmt.Errorf("posts: restore 12: %w", models.ErrPostLocked))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d for a wrapped error, got %d", http.StatusConflict, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "restore 12") {
+		t.Errorf("Expected only the known error to be written, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	writeStoreError(w, errors.New(`pq: relation "posts" does not exist`))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "pq:") {
+		t.Errorf("Expected the error to stay at the server, got %s", w.Body)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+
+	"github.com/ik5/go-into/pagination"
 )
 
 // InitREST initialize a new REST service
@@ -19,6 +21,9 @@ func InitREST(address string, port uint16) *REST {
 		ctx:        ctx,
 		cancelFunc: cancelFunc,
 		srv:        &http.Server{},
+		pager:      &pager{codec: pagination.NewRandomCodec()},
+		auditor:    newAuditor(),
+		markup:     newRenderers(),
 	}
 }
 
@@ -36,13 +41,13 @@ func (rest *REST) Stop() error {
 // Serve an HTTP server
 func (rest *REST) Serve() error {
 	rest.srv.Addr = fmt.Sprintf("%s:%d", rest.address, rest.port)
-	rest.srv.Handler = rest.mux
+	rest.srv.Handler = rest.handler()
 	return rest.srv.ListenAndServe()
 }
 
 // ServeTLS start a TLS server
 func (rest *REST) ServeTLS(certFile, keyFile string) error {
 	rest.srv.Addr = fmt.Sprintf("%s:%d", rest.address, rest.port)
-	rest.srv.Handler = rest.mux
+	rest.srv.Handler = rest.handler()
 	return rest.srv.ListenAndServeTLS(certFile, keyFile)
 }
@@ -0,0 +1,175 @@
+package rest
+
+/*
+	Revisions of posts, that reviewers use to see what changed before a post
+	is published, and editors use to restore an older version.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/textdiff"
+	"github.com/ik5/go-into/types"
+)
+
+// diffContext is the number of unchanged lines around the changes of a
+// unified diff
+const diffContext = 3
+
+// registerRevisionRoutes registers the revisions API under the admin routes
+func (rest *REST) registerRevisionRoutes(posts models.PostRepository) {
+	handlers := revisionHandlers{posts: posts}
+
+	rest.RegisterAdminRoute("/posts/:id/revisions", "GET", types.RoleReview, handlers.list)
+	rest.RegisterAdminRoute("/posts/:id/revisions/:version", "GET", types.RoleReview, handlers.get)
+	rest.RegisterAdminRoute("/posts/:id/revisions/:version/restore", "POST", types.RoleEdit, handlers.restore)
+	rest.RegisterAdminRoute("/posts/:id/diff", "GET", types.RoleReview, handlers.diff)
+}
+
+type revisionHandlers struct {
+	posts models.PostRepository
+}
+
+// revisionDiffResponse is the line level diff between two revisions
+type revisionDiffResponse struct {
+	From  uint64          `json:"from"`
+	To    uint64          `json:"to"`
+	Title []textdiff.Line `json:"title"`
+	Body  []textdiff.Line `json:"body"`
+	// Unified is the diff of the body in the unified format
+	Unified string `json:"unified"`
+}
+
+// editorRequest saves the posts of r as edited by its authenticated user
+func editorRequest(r *http.Request) *http.Request {
+	user, ok := middleware.CurrentUser(r.Context())
+	if !ok {
+		return r
+	}
+	return r.WithContext(models.WithEditor(r.Context(), user.ID))
+}
+
+// uintParam returns a numeric path parameter, or writes the error and returns
+// false
+func uintParam(w http.ResponseWriter, r *http.Request, name string) (uint64, bool) {
+	value, err := strconv.ParseUint(Param(r, name), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+		return 0, false
+	}
+	return value, true
+}
+
+// list returns the revisions of a post, latest first
+func (handlers revisionHandlers) list(w http.ResponseWriter, r *http.Request) {
+	id, ok := uintParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	revisions, err := handlers.posts.Revisions(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+func (handlers revisionHandlers) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := uintParam(w, r, "id")
+	if !ok {
+		return
+	}
+	version, ok := uintParam(w, r, "version")
+	if !ok {
+		return
+	}
+
+	revision, err := handlers.posts.Revision(r.Context(), id, version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revision)
+}
+
+// restore saves an older revision as the new version of the post
+func (handlers revisionHandlers) restore(w http.ResponseWriter, r *http.Request) {
+	id, ok := uintParam(w, r, "id")
+	if !ok {
+		return
+	}
+	version, ok := uintParam(w, r, "version")
+	if !ok {
+		return
+	}
+
+	post, err := models.RestoreRevision(r.Context(), handlers.posts, id, version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// diff compares the revisions of the from and to query parameters. to is the
+// current version by default, and from is the version before to.
+func (handlers revisionHandlers) diff(w http.ResponseWriter, r *http.Request) {
+	id, ok := uintParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var from, to uint64
+	for name, field := range map[string]*uint64{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		version, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || version == 0 {
+			writeError(w, http.StatusBadRequest, "invalid "+name+" version")
+			return
+		}
+		*field = version
+	}
+
+	if to == 0 {
+		post, err := handlers.posts.GetByID(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		to = post.Version
+	}
+	if from == 0 {
+		from = to - 1
+		if from == 0 {
+			from = to
+		}
+	}
+
+	older, err := handlers.posts.Revision(r.Context(), id, from)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	newer, err := handlers.posts.Revision(r.Context(), id, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	body := textdiff.Lines(older.Body, newer.Body)
+	writeJSON(w, http.StatusOK, revisionDiffResponse{
+		From:    from,
+		To:      to,
+		Title:   textdiff.Lines(older.Title, newer.Title),
+		Body:    body,
+		Unified: textdiff.Unified(body, fmt.Sprintf("v%d", from), fmt.Sprintf("v%d", to), diffContext),
+	})
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/textdiff"
+)
+
+func newRevisionsTest(t *testing.T) (*REST, *models.MemoryPostRepository) {
+	store := newTestUserStore(t)
+	posts := models.NewMemoryPostRepository()
+
+	post := models.Post{AuthorID: 1, Title: "Hello", Body: "one\ntwo\nthree"}
+	if err := posts.Create(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+	post.Body = "one\n2\nthree"
+	if err := posts.Update(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+
+	rest := InitREST("", 0)
+	rest.RegisterPostRoutes(posts)
+	rest.SetAdminRouting(store.GetByUsername)
+	return rest, posts
+}
+
+func TestRevisionsDiff(t *testing.T) {
+	rest, _ := newRevisionsTest(t)
+
+	w := adminRequest(rest, "editor", "GET", "/admin/posts/1/diff", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	var response revisionDiffResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if response.From != 1 || response.To != 2 {
+		t.Errorf("Expected the diff of versions 1 and 2, got %d and %d", response.From, response.To)
+	}
+	if textdiff.Changed(response.Title) {
+		t.Errorf("Expected the title to stay, got %+v", response.Title)
+	}
+	if !strings.Contains(response.Unified, "-two\n+2\n") {
+		t.Errorf("Expected two to be replaced with 2, got:\n%s", response.Unified)
+	}
+
+	w = adminRequest(rest, "editor", "GET", "/admin/posts/1/diff?from=1&to=7", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRevisionsRestore(t *testing.T) {
+	rest, posts := newRevisionsTest(t)
+
+	w := adminRequest(rest, "editor", "POST", "/admin/posts/1/revisions/1/restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	post, _ := posts.GetByID(context.Background(), 1)
+	if post.Version != 3 || post.Body != "one\ntwo\nthree" {
+		t.Errorf("Expected version 3 with the first body, got %+v", post)
+	}
+
+	w = adminRequest(rest, "editor", "GET", "/admin/posts/1/revisions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var revisions []models.Revision
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if len(revisions) != 3 || revisions[0].Version != 3 || revisions[0].EditorID != 2 {
+		t.Errorf("Expected 3 revisions, the latest by the editor, got %+v", revisions)
+	}
+
+	post.Status = models.PostPublished
+	if err := posts.Update(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+	w = adminRequest(rest, "editor", "POST", "/admin/posts/1/revisions/2/restore", "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d of a published post, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"sync"
+
+	"github.com/ik5/go-into/types"
 )
 
 type routeAndMethod struct {
@@ -11,16 +13,31 @@ type routeAndMethod struct {
 	Method string
 }
 
+// patternRoute is a route that is matched by a pattern, and is allowed only
+// for users that hold all of roles
+type patternRoute struct {
+	pattern routePattern
+	method  string
+	roles   types.Role
+	handler http.HandlerFunc
+}
+
 // REST holds content to control the rest server
 type REST struct {
 	address string
 	port    uint16
 
-	routing  map[routeAndMethod]http.HandlerFunc
-	rwRouter *sync.RWMutex
+	routing     map[routeAndMethod]http.HandlerFunc
+	adminRoutes []patternRoute
+	postRoutes  []patternRoute
+	rwRouter    *sync.RWMutex
 
 	mux        *http.ServeMux
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 	srv        *http.Server
+	metrics    *httpMetrics
+	pager      *pager
+	auditor    *auditor
+	markup     *renderers
 }
@@ -41,6 +41,7 @@ func (rest *REST) SetUserRouting() {
 				http.NotFound(w, r)
 				return
 			}
+			setRoute(r, route.Route)
 			handler(w, r)
 		})
 	}
@@ -0,0 +1,189 @@
+package rest
+
+/*
+	The editorial workflow of posts: writers create drafts and submit them,
+	reviewers approve them or request changes, and publishers publish them
+	at once or at a scheduled time. The roles of every action are checked by
+	models.PerformAction, so the routes only require authentication.
+*/
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ik5/go-into/audit"
+	"github.com/ik5/go-into/models"
+	"github.com/ik5/go-into/rest/middleware"
+	"github.com/ik5/go-into/types"
+)
+
+// registerWorkflowRoutes registers the workflow API under the admin routes
+func (rest *REST) registerWorkflowRoutes(handlers postHandlers) {
+	rest.RegisterAdminRoute("/posts", "POST", types.RoleCreate, handlers.create)
+	rest.RegisterAdminRoute("/posts/:id", "PUT", 0, handlers.edit)
+	rest.RegisterAdminRoute("/posts/:id/actions", "GET", 0, handlers.actions)
+	rest.RegisterAdminRoute("/posts/:id/actions", "POST", 0, handlers.perform)
+	rest.RegisterAdminRoute("/posts/:id/transitions", "GET", 0, handlers.transitions)
+}
+
+// postContentRequest is the content of a post that its writers set
+type postContentRequest struct {
+	Title   string `json:"title"`
+	Slug    string `json:"slug"`
+	Body    string `json:"body"`
+	Excerpt string `json:"excerpt"`
+	// Version is the version that was loaded, required for an edit
+	Version uint64 `json:"version"`
+}
+
+// actionRequest is a workflow action on a post
+type actionRequest struct {
+	Action  models.Action `json:"action"`
+	Comment string        `json:"comment"`
+	// PublishAt is the time of a schedule action
+	PublishAt time.Time `json:"publish_at"`
+}
+
+// actionResponse is a post after a workflow action
+type actionResponse struct {
+	Post       models.Post       `json:"post"`
+	Transition models.Transition `json:"transition"`
+}
+
+// create stores a new draft of the authenticated user
+func (handlers postHandlers) create(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+
+	var request postContentRequest
+	if err := readJSON(r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if request.Title == "" {
+		writeError(w, http.StatusBadRequest, "title is required")
+		return
+	}
+
+	post := models.Post{
+		AuthorID: user.ID,
+		Title:    request.Title,
+		Slug:     request.Slug,
+		Body:     request.Body,
+		Excerpt:  request.Excerpt,
+		// an empty excerpt is generated from the body
+		ExcerptManual: request.Excerpt != "",
+		Status:        models.PostDraft,
+	}
+	if err := handlers.posts.Create(r.Context(), &post); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, post)
+}
+
+// edit changes the content of a post, when the workflow allows the user to
+func (handlers postHandlers) edit(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.load(w, r)
+	if !ok {
+		return
+	}
+	user, _ := middleware.CurrentUser(r.Context())
+	if err := models.CanEdit(post, user); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var request postContentRequest
+	if err := readJSON(r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if request.Title == "" || request.Version == 0 {
+		writeError(w, http.StatusBadRequest, "title and version are required")
+		return
+	}
+
+	post.Title, post.Body, post.Excerpt = request.Title, request.Body, request.Excerpt
+	post.ExcerptManual = request.Excerpt != ""
+	post.Version = request.Version
+	if request.Slug != "" {
+		post.Slug = request.Slug
+	}
+	if err := handlers.posts.Update(r.Context(), &post); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// actions returns the workflow actions that the user may perform on a post
+func (handlers postHandlers) actions(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.load(w, r)
+	if !ok {
+		return
+	}
+	user, _ := middleware.CurrentUser(r.Context())
+	writeJSON(w, http.StatusOK, models.AllowedActions(post, user))
+}
+
+// perform moves a post through the workflow
+func (handlers postHandlers) perform(w http.ResponseWriter, r *http.Request) {
+	id, ok := uintParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var request actionRequest
+	if err := readJSON(r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	post, transition, ok := handlers.performAction(w, r, id, models.ActionRequest{
+		Action:    request.Action,
+		Comment:   request.Comment,
+		PublishAt: request.PublishAt,
+	})
+	if ok {
+		writeJSON(w, http.StatusOK, actionResponse{Post: post, Transition: transition})
+	}
+}
+
+// performAction performs an action of the authenticated user, or writes the
+// error and returns false
+func (handlers postHandlers) performAction(w http.ResponseWriter, r *http.Request, id uint64, request models.ActionRequest) (models.Post, models.Transition, bool) {
+	user, _ := middleware.CurrentUser(r.Context())
+
+	post, transition, err := models.PerformAction(r.Context(), handlers.posts, id, user, request)
+	if err != nil {
+		writeStoreError(w, err)
+		return post, transition, false
+	}
+	if transition.To == models.PostPublished {
+		before := post
+		before.Status = transition.From
+		handlers.auditor.record(r, targetEntry(audit.ActionPostPublish, audit.TargetPost, post.ID, before, post))
+	}
+	return post, transition, true
+}
+
+// transitions returns the workflow history of a post, with the comments of
+// its reviewers. Writers see only the history of their own posts.
+func (handlers postHandlers) transitions(w http.ResponseWriter, r *http.Request) {
+	post, ok := handlers.load(w, r)
+	if !ok {
+		return
+	}
+	user, _ := middleware.CurrentUser(r.Context())
+	if post.AuthorID != user.ID && !user.Roles.Has(types.RoleReview) {
+		writeStoreError(w, models.ErrActionForbidden)
+		return
+	}
+
+	transitions, err := handlers.posts.Transitions(r.Context(), post.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, transitions)
+}
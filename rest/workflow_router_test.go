@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ik5/go-into/models"
+)
+
+func newWorkflowTest(t *testing.T) (*REST, *models.MemoryPostRepository) {
+	store := newTestUserStore(t)
+	posts := models.NewMemoryPostRepository()
+
+	rest := InitREST("", 0)
+	rest.RegisterPostRoutes(posts)
+	rest.SetAdminRouting(store.GetByUsername)
+	return rest, posts
+}
+
+func TestWorkflowRoutes(t *testing.T) {
+	rest, posts := newWorkflowTest(t)
+
+	w := adminRequest(rest, "editor", "POST", "/admin/posts", `{"title":"Hello"}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d for a user without RoleCreate, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w = adminRequest(rest, "root", "POST", "/admin/posts", `{"title":"Hello","body":"one"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	steps := []struct {
+		user, body string
+		code       int
+		status     models.PostStatus
+	}{
+		{"editor", `{"action":"approve"}`, http.StatusConflict, models.PostDraft},
+		{"root", `{"action":"submit"}`, http.StatusOK, models.PostInReview},
+		{"editor", `{"action":"request_changes"}`, http.StatusBadRequest, models.PostInReview},
+		{"editor", `{"action":"request_changes","comment":"more"}`, http.StatusOK, models.PostChangesRequested},
+		{"root", `{"action":"submit"}`, http.StatusOK, models.PostInReview},
+		{"editor", `{"action":"approve"}`, http.StatusOK, models.PostApproved},
+		{"editor", `{"action":"schedule","publish_at":"2001-01-01T00:00:00Z"}`,
+			http.StatusBadRequest, models.PostApproved},
+	}
+	for _, step := range steps {
+		w = adminRequest(rest, step.user, "POST", "/admin/posts/1/actions", step.body)
+		if w.Code != step.code {
+			t.Fatalf("Expected %d for %s, got %d: %s", step.code, step.body, w.Code, w.Body)
+		}
+		post, _ := posts.GetByID(context.Background(), 1)
+		if post.Status != step.status {
+			t.Fatalf("Expected %s after %s, got %s", step.status, step.body, post.Status)
+		}
+	}
+
+	w = adminRequest(rest, "editor", "POST", "/admin/posts/1/publish", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	w = adminRequest(rest, "editor", "GET", "/admin/posts/1/transitions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var transitions []models.Transition
+	if err := json.Unmarshal(w.Body.Bytes(), &transitions); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if len(transitions) != 5 {
+		t.Fatalf("Expected 5 transitions, got %+v", transitions)
+	}
+	if transitions[1].Comment != "more" || transitions[4].To != models.PostPublished {
+		t.Errorf("Expected the review comment and the publishing, got %+v", transitions)
+	}
+}
+
+func TestWorkflowEdit(t *testing.T) {
+	rest, posts := newWorkflowTest(t)
+
+	post := models.Post{AuthorID: 1, Title: "Hello", Status: models.PostChangesRequested}
+	if err := posts.Create(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+
+	// editors may fix the post of another user that waits for changes
+	w := adminRequest(rest, "editor", "PUT", "/admin/posts/1", `{"title":"Hi","version":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	w = adminRequest(rest, "editor", "PUT", "/admin/posts/1", `{"title":"Hi","version":1}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d for an old version, got %d", http.StatusConflict, w.Code)
+	}
+
+	// the content that was submitted to review is the one that is published
+	post, _ = posts.GetByID(context.Background(), 1)
+	post.Status = models.PostInReview
+	if err := posts.Update(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+	w = adminRequest(rest, "editor", "PUT", "/admin/posts/1", `{"title":"Changed","version":3}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), models.ErrPostLocked.Error()) {
+		t.Errorf("Expected %d of a locked post, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+}
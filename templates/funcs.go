@@ -0,0 +1,77 @@
+package templates
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// builtinFuncs returns the functions that every template may use:
+//
+//	date      formats a time.Time, *time.Time or sql.NullTime with a layout,
+//	          a zero or a null time is written as an empty string
+//	markdown  renders markdown to sanitised HTML, with an optional cache key
+//	url       builds a path from escaped segments under the BaseURL
+func (m *Manager) builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		"date":     formatDate,
+		"markdown": m.markdown,
+		"url":      m.url,
+	}
+}
+
+// formatDate is used in a pipeline, such as {{ .CreatedAt | date "02-01-2006" }}
+func formatDate(layout string, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v != nil {
+			t = *v
+		}
+	case sql.NullTime:
+		if v.Valid {
+			t = v.Time
+		}
+	case nil:
+	default:
+		return "", fmt.Errorf("date of unsupported type %T", value)
+	}
+
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Local().Format(layout), nil
+}
+
+// markdown renders src once per key when a key is given, such as
+// {{ markdown .Body .CacheKey }}
+func (m *Manager) markdown(src string, key ...string) (template.HTML, error) {
+	if len(key) > 1 {
+		return "", fmt.Errorf("markdown expects a single cache key, got %d", len(key))
+	}
+	// the renderer escapes the source and writes only allowed elements
+	if len(key) == 1 && key[0] != "" {
+		return template.HTML(m.options.Markup.RenderCached(key[0], src)), nil
+	}
+	return template.HTML(m.options.Markup.Render(src)), nil
+}
+
+// url joins the segments as a path under the BaseURL, such as
+// {{ url "posts" .Slug }}
+func (m *Manager) url(segments ...interface{}) string {
+	escaped := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		value := strings.Trim(fmt.Sprint(segment), "/")
+		for _, part := range strings.Split(value, "/") {
+			if part != "" {
+				escaped = append(escaped, url.PathEscape(part))
+			}
+		}
+	}
+	return strings.TrimSuffix(m.options.BaseURL, "/") + "/" + strings.Join(escaped, "/")
+}
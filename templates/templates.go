@@ -0,0 +1,310 @@
+// Package templates loads the HTML templates of the site from a file system,
+// such as a directory or an embed.FS, and parses them once.
+//
+// The files are organised by their directory:
+//
+//	layouts/*.html   the skeletons of pages, named "layouts/<name>"
+//	partials/*.html  shared fragments, named "partials/<name>"
+//	**/*.html        pages, named by their path without the extension
+//
+// Every page is parsed with all layouts and partials, so a page may override
+// the blocks of a layout. A page that only defines blocks is rendered with
+// the default layout, while a page with content of its own is rendered as is,
+// and may invoke a layout explicitly with {{ template "layouts/<name>" . }}.
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"time"
+
+	"github.com/ik5/go-into/markup"
+)
+
+// A list of the defaults of Options
+const (
+	DefaultLayout        = "base"
+	DefaultWatchInterval = time.Second
+)
+
+// A list of the directories of layouts and partials
+const (
+	layoutsDir  = "layouts"
+	partialsDir = "partials"
+	extension   = ".html"
+)
+
+// ErrUnknownPage is returned when rendering a page that does not exist
+var ErrUnknownPage = errors.New("unknown template page")
+
+// Options of a Manager
+type Options struct {
+	// Layout is the layout of pages that only define blocks, DefaultLayout by
+	// default
+	Layout string
+	// Funcs are added to the functions of the templates, and replace the
+	// built in functions of the same name
+	Funcs template.FuncMap
+	// Markup renders the markdown function, markup.New(nil) by default
+	Markup *markup.Renderer
+	// BaseURL is the prefix of the URLs of the url function
+	BaseURL string
+	// OnError is called when reloading the templates fails while watching
+	OnError func(err error)
+}
+
+// Manager holds the parsed pages of a file system. It is safe for concurrent
+// use, and a reload replaces the pages only when all of them were parsed.
+type Manager struct {
+	fsys    fs.FS
+	options Options
+	funcs   template.FuncMap
+
+	mutex sync.RWMutex
+	pages map[string]page
+	// stamp is the state of the files that the pages were parsed from
+	stamp string
+}
+
+// page is the set of templates of a page, and the template that renders it
+type page struct {
+	set   *template.Template
+	entry string
+}
+
+// New parses the templates of fsys, nil options uses the defaults
+func New(fsys fs.FS, options *Options) (*Manager, error) {
+	m := &Manager{fsys: fsys}
+	if options != nil {
+		m.options = *options
+	}
+	if m.options.Layout == "" {
+		m.options.Layout = DefaultLayout
+	}
+	if m.options.Markup == nil {
+		m.options.Markup = markup.New(nil)
+	}
+
+	m.funcs = m.builtinFuncs()
+	for name, fn := range m.options.Funcs {
+		m.funcs[name] = fn
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload parses all templates again. On error the previous pages are kept.
+func (m *Manager) Reload() error {
+	stamp, err := m.currentStamp()
+	if err != nil {
+		return err
+	}
+	pages, err := m.parse()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.pages = pages
+	m.stamp = stamp
+	m.mutex.Unlock()
+	return nil
+}
+
+// Pages returns the names of the pages, sorted
+func (m *Manager) Pages() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := make([]string, 0, len(m.pages))
+	for name := range m.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render writes the page of name with data to w. Nothing is written when the
+// execution fails.
+func (m *Manager) Render(w io.Writer, name string, data interface{}) error {
+	m.mutex.RLock()
+	p, ok := m.pages[name]
+	m.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPage, name)
+	}
+
+	var out bytes.Buffer
+	if err := p.set.ExecuteTemplate(&out, p.entry, data); err != nil {
+		return err
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// Watch reloads the templates whenever their files change, until ctx is
+// done. It is meant for development, as an embed.FS never changes.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		stamp, err := m.currentStamp()
+		if err != nil {
+			m.reportError(err)
+			continue
+		}
+		m.mutex.RLock()
+		changed := stamp != m.stamp
+		m.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := m.Reload(); err != nil {
+			m.reportError(err)
+			// the broken files are not parsed again until they change
+			m.mutex.Lock()
+			m.stamp = stamp
+			m.mutex.Unlock()
+		}
+	}
+}
+
+func (m *Manager) reportError(err error) {
+	if m.options.OnError != nil {
+		m.options.OnError(err)
+	}
+}
+
+// files returns the paths of all templates of the file system, sorted
+func (m *Manager) files() ([]string, error) {
+	files := make([]string, 0)
+	err := fs.WalkDir(m.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && path.Ext(name) == extension {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// currentStamp returns the paths, sizes and modification times of the
+// templates, that change whenever a template is added, removed or written
+func (m *Manager) currentStamp() (string, error) {
+	files, err := m.files()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, name := range files {
+		info, err := fs.Stat(m.fsys, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// parse returns the pages of the file system by name, every page is a set
+// of its own with all layouts and partials
+func (m *Manager) parse() (map[string]page, error) {
+	files, err := m.files()
+	if err != nil {
+		return nil, err
+	}
+
+	base := template.New("").Funcs(m.funcs)
+	pageFiles := make([]string, 0)
+	for _, name := range files {
+		if strings.HasPrefix(name, layoutsDir+"/") || strings.HasPrefix(name, partialsDir+"/") {
+			if err := m.parseFile(base, name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pageFiles = append(pageFiles, name)
+	}
+
+	layout := layoutsDir + "/" + m.options.Layout
+	pages := make(map[string]page, len(pageFiles))
+	for _, file := range pageFiles {
+		set, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if err := m.parseFile(set, file); err != nil {
+			return nil, err
+		}
+
+		name := templateName(file)
+		p := page{set: set, entry: name}
+		if onlyDefines(set.Lookup(name)) {
+			if set.Lookup(layout) == nil {
+				return nil, fmt.Errorf("page %s requires the missing layout %s", name, m.options.Layout)
+			}
+			p.entry = layout
+		}
+		pages[name] = p
+	}
+	return pages, nil
+}
+
+// parseFile adds the template of a file to set
+func (m *Manager) parseFile(set *template.Template, name string) error {
+	content, err := fs.ReadFile(m.fsys, name)
+	if err != nil {
+		return err
+	}
+	if _, err := set.New(templateName(name)).Parse(string(content)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// templateName returns the name of the template of a file
+func templateName(file string) string {
+	return strings.TrimSuffix(file, extension)
+}
+
+// onlyDefines returns true if t has no content besides the templates that it
+// defines, and white space
+func onlyDefines(t *template.Template) bool {
+	if t == nil || t.Tree == nil {
+		return true
+	}
+	for _, node := range t.Tree.Root.Nodes {
+		text, ok := node.(*parse.TextNode)
+		if !ok || len(bytes.TrimSpace(text.Text)) > 0 {
+			return false
+		}
+	}
+	return true
+}
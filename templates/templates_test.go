@@ -0,0 +1,218 @@
+package templates
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/base.html": {Data: []byte(
+			`<title>{{ block "title" . }}Site{{ end }}</title>` +
+				`{{ template "partials/nav" . }}<main>{{ block "content" . }}{{ end }}</main>`)},
+		"partials/nav.html": {Data: []byte(`<nav><a href="{{ url "posts" .Slug }}">{{ .Name }}</a></nav>`)},
+		"index.html": {Data: []byte(
+			`{{ define "title" }}Home{{ end }}` + "\n" +
+				`{{ define "content" }}{{ markdown .Body }}{{ end }}` + "\n")},
+		"posts/show.html": {Data: []byte(
+			`{{ define "content" }}{{ .Name }} at {{ .Date | date "2006-01-02" }}{{ end }}`)},
+		"feed.html": {Data: []byte(`<feed>{{ .Name }}</feed>`)},
+	}
+}
+
+type pageData struct {
+	Name string
+	Slug string
+	Body string
+	Date sql.NullTime
+}
+
+func render(t *testing.T, m *Manager, name string, data interface{}) string {
+	t.Helper()
+	var out strings.Builder
+	if err := m.Render(&out, name, data); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	return out.String()
+}
+
+func TestRenderPages(t *testing.T) {
+	m, err := New(testFiles(), &Options{BaseURL: "https://example.com/"})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	expectedPages := "feed,index,posts/show"
+	if pages := strings.Join(m.Pages(), ","); pages != expectedPages {
+		t.Errorf("Expected pages %s, got %s", expectedPages, pages)
+	}
+
+	date := time.Date(2020, 5, 17, 12, 0, 0, 0, time.Local)
+	data := pageData{
+		Name: "<b>",
+		Slug: "a b",
+		Body: "**hi** <script>",
+		Date: sql.NullTime{Time: date, Valid: true},
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{
+			name: "index",
+			expected: `<title>Home</title><nav><a href="https://example.com/posts/a%20b">&lt;b&gt;</a></nav>` +
+				"<main><p><strong>hi</strong> &lt;script&gt;</p>\n</main>",
+		},
+		{
+			name: "posts/show",
+			expected: `<title>Site</title><nav><a href="https://example.com/posts/a%20b">&lt;b&gt;</a></nav>` +
+				`<main>&lt;b&gt; at 2020-05-17</main>`,
+		},
+		{
+			name:     "feed",
+			expected: `<feed>&lt;b&gt;</feed>`,
+		},
+	}
+	for _, test := range tests {
+		if out := render(t, m, test.name, data); out != test.expected {
+			t.Errorf("Expected %s to render %q, got %q", test.name, test.expected, out)
+		}
+	}
+
+	err = m.Render(&strings.Builder{}, "missing", data)
+	if !errors.Is(err, ErrUnknownPage) {
+		t.Errorf("Expected %v, got %v", ErrUnknownPage, err)
+	}
+}
+
+func TestRenderFailureWritesNothing(t *testing.T) {
+	files := testFiles()
+	files["broken.html"] = &fstest.MapFile{Data: []byte(`before {{ .Missing }}`)}
+	m, err := New(files, nil)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	var out strings.Builder
+	if err := m.Render(&out, "broken", pageData{}); err == nil {
+		t.Error("Expected an error of a missing field")
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got %q", out.String())
+	}
+}
+
+func TestCustomFuncs(t *testing.T) {
+	files := fstest.MapFS{
+		"layouts/base.html": {Data: []byte(`{{ block "content" . }}{{ end }}`)},
+		"page.html":         {Data: []byte(`{{ define "content" }}{{ shout . }} {{ url "x" }}{{ end }}`)},
+	}
+	m, err := New(files, &Options{Funcs: template.FuncMap{
+		"shout": strings.ToUpper,
+		"url":   func(string) string { return "/custom" },
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	expected := "HELLO /custom"
+	if out := render(t, m, "page", "hello"); out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestMissingLayout(t *testing.T) {
+	files := fstest.MapFS{
+		"page.html": {Data: []byte(`{{ define "content" }}x{{ end }}`)},
+	}
+	if _, err := New(files, nil); err == nil {
+		t.Error("Expected an error of a missing layout")
+	}
+}
+
+func TestReloadKeepsPagesOnError(t *testing.T) {
+	files := testFiles()
+	m, err := New(files, nil)
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	files["feed.html"] = &fstest.MapFile{Data: []byte(`<rss>{{ .Name }}</rss>`)}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+	if out := render(t, m, "feed", pageData{Name: "a"}); out != "<rss>a</rss>" {
+		t.Errorf("Expected the reloaded feed, got %q", out)
+	}
+
+	files["feed.html"] = &fstest.MapFile{Data: []byte(`{{ if }}`)}
+	if err := m.Reload(); err == nil {
+		t.Fatal("Expected a parse error")
+	}
+	if out := render(t, m, "feed", pageData{Name: "a"}); out != "<rss>a</rss>" {
+		t.Errorf("Expected the previous feed to be kept, got %q", out)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	files := testFiles()
+	var mutex sync.Mutex
+	errs := make(chan error, 10)
+	m, err := New(lockedFS{files: files, mutex: &mutex}, &Options{
+		OnError: func(err error) { errs <- err },
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Watch(ctx, time.Millisecond) }()
+
+	mutex.Lock()
+	files["feed.html"] = &fstest.MapFile{Data: []byte(`<rss>{{ .Name }}</rss>`), ModTime: time.Now()}
+	mutex.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for render(t, m, "feed", pageData{Name: "a"}) != "<rss>a</rss>" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the change to be reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mutex.Lock()
+	files["feed.html"] = &fstest.MapFile{Data: []byte(`{{ if }}`), ModTime: time.Now().Add(time.Second)}
+	mutex.Unlock()
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the parse error to be reported")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+// lockedFS guards a map file system that a test changes while it is watched
+type lockedFS struct {
+	files fstest.MapFS
+	mutex *sync.Mutex
+}
+
+func (l lockedFS) Open(name string) (fs.File, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.files.Open(name)
+}
@@ -0,0 +1,200 @@
+/*
+Package textdiff compares texts line by line, with the longest common
+subsequence of their lines.
+*/
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is the operation of a line at a diff
+type Op string
+
+// Operations of a line
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// maxCells bounds the table of the longest common subsequence. Texts that
+// are larger after their common prefix and suffix are compared as a whole
+// deletion and insertion.
+const maxCells = 4 << 20
+
+// Line is a line of a diff. Old and New are the line numbers, starting at 1,
+// at the old and the new texts, 0 when the line is not there.
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+	Old  int    `json:"old,omitempty"`
+	New  int    `json:"new,omitempty"`
+}
+
+// Lines returns the diff that turns oldText into newText
+func Lines(oldText, newText string) []Line {
+	a, b := split(oldText), split(newText)
+
+	// the common prefix and suffix are left out of the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]Line, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		diff = append(diff, Line{Op: Equal, Text: a[i], Old: i + 1, New: i + 1})
+	}
+	diff = appendMiddle(diff, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix)
+	for i := suffix; i > 0; i-- {
+		diff = append(diff, Line{Op: Equal, Text: a[len(a)-i], Old: len(a) - i + 1, New: len(b) - i + 1})
+	}
+	return diff
+}
+
+// appendMiddle appends the diff of a and b, that start after offset lines
+func appendMiddle(diff []Line, a, b []string, offset int) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 || n*m > maxCells {
+		for i, text := range a {
+			diff = append(diff, Line{Op: Delete, Text: text, Old: offset + i + 1})
+		}
+		for j, text := range b {
+			diff = append(diff, Line{Op: Insert, Text: text, New: offset + j + 1})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			diff = append(diff, Line{Op: Equal, Text: a[i], Old: offset + i + 1, New: offset + j + 1})
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, Line{Op: Delete, Text: a[i], Old: offset + i + 1})
+			i++
+		default:
+			diff = append(diff, Line{Op: Insert, Text: b[j], New: offset + j + 1})
+			j++
+		}
+	}
+	return diff
+}
+
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+}
+
+// Changed returns true if diff holds a line that is not equal
+func Changed(diff []Line) bool {
+	for _, line := range diff {
+		if line.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Unified formats diff in the unified format, with context lines around the
+// changes, and the names of the texts at the header
+func Unified(diff []Line, oldName, newName string, context int) string {
+	if !Changed(diff) {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(diff); {
+		// a hunk starts context lines before the next change
+		first := start
+		for first < len(diff) && diff[first].Op == Equal {
+			first++
+		}
+		if first == len(diff) {
+			break
+		}
+		from := first - context
+		if from < start {
+			from = start
+		}
+
+		// and ends once more than twice the context lines are equal
+		to, equal := first, 0
+		for to < len(diff) && equal <= 2*context {
+			if diff[to].Op == Equal {
+				equal++
+			} else {
+				equal = 0
+			}
+			to++
+		}
+		if equal > context {
+			to -= equal - context
+		}
+
+		writeHunk(&b, diff[from:to])
+		start = to
+	}
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, hunk []Line) {
+	oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+	for _, line := range hunk {
+		if line.Op != Insert {
+			if oldStart == 0 {
+				oldStart = line.Old
+			}
+			oldCount++
+		}
+		if line.Op != Delete {
+			if newStart == 0 {
+				newStart = line.New
+			}
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+
+	for _, line := range hunk {
+		prefix := " "
+		switch line.Op {
+		case Insert:
+			prefix = "+"
+		case Delete:
+			prefix = "-"
+		}
+		b.WriteString(prefix + line.Text + "\n")
+	}
+}
@@ -0,0 +1,82 @@
+package textdiff
+
+import (
+	"testing"
+)
+
+func ops(diff []Line) string {
+	s := ""
+	for _, line := range diff {
+		switch line.Op {
+		case Equal:
+			s += "="
+		case Insert:
+			s += "+"
+		case Delete:
+			s += "-"
+		}
+	}
+	return s
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		old, new string
+		ops      string
+	}{
+		{"a\nb\nc", "a\nb\nc", "==="},
+		{"a\nb\nc", "a\nx\nc", "=-+="},
+		{"a\nb\nc\nd", "a\nc\nd\ne", "=-==+"},
+		{"", "a\nb", "++"},
+		{"a\nb\n", "", "--"},
+		{"a\r\nb", "a\nb", "=="},
+		{"x\na\ny\nb\nz", "a\nq\nb", "-=-+=-"},
+	}
+
+	for _, test := range tests {
+		if got := ops(Lines(test.old, test.new)); got != test.ops {
+			t.Errorf("Expected %s for %q to %q, got %s", test.ops, test.old, test.new, got)
+		}
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	diff := Lines("a\nb\nc", "a\nc\nd")
+	expected := []Line{
+		{Op: Equal, Text: "a", Old: 1, New: 1},
+		{Op: Delete, Text: "b", Old: 2},
+		{Op: Equal, Text: "c", Old: 3, New: 2},
+		{Op: Insert, Text: "d", New: 3},
+	}
+	if len(diff) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, diff)
+	}
+	for i := range expected {
+		if diff[i] != expected[i] {
+			t.Errorf("Expected %+v at line %d, got %+v", expected[i], i, diff[i])
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	diff := Lines("1\n2\n3\n4\n5\n6\n7\n8\n9", "1\n2\nthree\n4\n5\n6\n7\n8\nnine")
+	expected := `--- v1
++++ v2
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+@@ -8,2 +8,2 @@
+ 8
+-9
++nine
+`
+	if got := Unified(diff, "v1", "v2", 1); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if got := Unified(Lines("a", "a"), "v1", "v2", 3); got != "" {
+		t.Errorf("Expected no diff of equal texts, got %q", got)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Package tracing records spans in the shape of OpenTelemetry, without the
+dependency on it.
+
+A Tracer starts spans, that take their parent from the context, and hands
+the finished spans to an Exporter. MemoryExporter keeps the last spans in
+the process.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation of a trace
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	// Err is the error that the operation ended with, if any
+	Err string
+
+	tracer *Tracer
+	mutex  sync.Mutex
+	ended  bool
+}
+
+// Exporter receives the finished spans
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer starts spans and exports them when they end
+type Tracer struct {
+	exporter Exporter
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// NewTracer creates a Tracer that exports to exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts a span named name. The span is a child of the span of ctx,
+// and the returned context carries the new span.
+func (tracer *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	span := &Span{
+		SpanID:     newID(8),
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		tracer:     tracer,
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = newID(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the current span of ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttribute sets an attribute of the span
+func (span *Span) SetAttribute(key, value string) {
+	span.mutex.Lock()
+	span.Attributes[key] = value
+	span.mutex.Unlock()
+}
+
+// SetError records err as the error of the span, a nil err is ignored
+func (span *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.Err = err.Error()
+	span.mutex.Unlock()
+}
+
+// Duration returns how long the span took, or is taking so far
+func (span *Span) Duration() time.Duration {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	if span.ended {
+		return span.End.Sub(span.Start)
+	}
+	return time.Since(span.Start)
+}
+
+// Finish ends the span and exports it, only the first call has an effect
+func (span *Span) Finish() {
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.End = time.Now()
+	span.mutex.Unlock()
+
+	if span.tracer != nil && span.tracer.exporter != nil {
+		span.tracer.exporter.Export(span)
+	}
+}
+
+// newID returns a random hex id of size bytes
+func newID(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// MemoryExporter keeps the last finished spans in memory
+type MemoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+	limit int
+}
+
+// NewMemoryExporter creates a MemoryExporter that keeps up to limit spans,
+// dropping the oldest ones. A limit <= 0 keeps all spans.
+func NewMemoryExporter(limit int) *MemoryExporter {
+	return &MemoryExporter{limit: limit}
+}
+
+// Export implements Exporter
+func (exporter *MemoryExporter) Export(span *Span) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.spans = append(exporter.spans, span)
+	if exporter.limit > 0 && len(exporter.spans) > exporter.limit {
+		exporter.spans = exporter.spans[len(exporter.spans)-exporter.limit:]
+	}
+}
+
+// Spans returns the kept spans, oldest first
+func (exporter *MemoryExporter) Spans() []*Span {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	spans := make([]*Span, len(exporter.spans))
+	copy(spans, exporter.spans)
+	return spans
+}
+
+// Reset drops the kept spans
+func (exporter *MemoryExporter) Reset() {
+	exporter.mutex.Lock()
+	exporter.spans = nil
+	exporter.mutex.Unlock()
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStartRootSpan(t *testing.T) {
+	tracer := NewTracer(NewMemoryExporter(0))
+
+	ctx, span := tracer.Start(nil, "root")
+	if span.ParentID != "" {
+		t.Errorf("Expected no parent, got %s", span.ParentID)
+	}
+	if len(span.TraceID) != 32 || len(span.SpanID) != 16 {
+		t.Errorf("Expected 16 and 8 byte ids, got %s and %s", span.TraceID, span.SpanID)
+	}
+	if FromContext(ctx) != span {
+		t.Error("Expected the context to carry the span")
+	}
+}
+
+func TestStartChildSpan(t *testing.T) {
+	tracer := NewTracer(NewMemoryExporter(0))
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+
+	if child.TraceID != parent.TraceID {
+		t.Errorf("Expected trace %s, got %s", parent.TraceID, child.TraceID)
+	}
+	if child.ParentID != parent.SpanID {
+		t.Errorf("Expected parent %s, got %s", parent.SpanID, child.ParentID)
+	}
+}
+
+func TestFinishExportsOnce(t *testing.T) {
+	exporter := NewMemoryExporter(0)
+	tracer := NewTracer(exporter)
+
+	_, span := tracer.Start(context.Background(), "query")
+	span.SetError(nil)
+	span.SetError(errors.New("timeout"))
+	span.Finish()
+	span.Finish()
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Err != "timeout" {
+		t.Errorf("Expected timeout, got %q", spans[0].Err)
+	}
+	if spans[0].End.Before(spans[0].Start) {
+		t.Error("Expected the end after the start")
+	}
+}
+
+func TestMemoryExporterLimit(t *testing.T) {
+	exporter := NewMemoryExporter(2)
+	tracer := NewTracer(exporter)
+
+	for _, name := range []string{"first", "second", "third"} {
+		_, span := tracer.Start(context.Background(), name)
+		span.Finish()
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0].Name != "second" || spans[1].Name != "third" {
+		t.Errorf("Expected the last 2 spans, got %d", len(spans))
+	}
+
+	exporter.Reset()
+	if len(exporter.Spans()) != 0 {
+		t.Error("Expected no spans after Reset")
+	}
+}
@@ -29,7 +29,7 @@ const (
 	RoleEditor Role = RoleEdit | RoleReview | RolePublish
 	RoleCRUD   Role = RoleEditor | RoleCreate | RoleDelete
 	RoleAdmin  Role = RoleCreate | RoleManageUser | RoleDisableUser
-	RoleRoot   Role = RoleCRUD | RoleAdmin | RoleDeleteUser
+	RoleRoot   Role = RoleCRUD | RoleAdmin | RoleCreateUser | RoleDeleteUser
 )
 
 // Scan implements the Scanner interface
@@ -72,3 +72,8 @@ func (r *Role) Scan(value interface{}) error {
 func (r *Role) Value() (driver.Value, error) {
 	return uint64(*r), nil
 }
+
+// Has returns true if all of the given roles are set
+func (r Role) Has(roles Role) bool {
+	return r&roles == roles
+}